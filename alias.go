@@ -0,0 +1,136 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrNotAlias is returned, if a harvest directory is not an alias.
+	ErrNotAlias = errors.New("not an alias")
+	// ErrDirExists is returned, if an alias or a rebound cache would replace
+	// an existing directory.
+	ErrDirExists = errors.New("directory exists")
+)
+
+// Alias makes the parameters of alias resolve to the cache directory of
+// target, so logically identical harvests share a cache, e.g. after a set was
+// renamed or for an endpoint, where set "all" and no set are the same. The
+// alias is a symbolic link in BaseDir, named like the directory of alias and
+// pointing to the directory of target. Harvests through an alias share
+// files, state and lock with the target. Listings skip aliases.
+func Alias(alias, target *Harvest) error {
+	dst := target.Dir()
+	if fi, err := os.Lstat(dst); err != nil {
+		return err
+	} else if fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("cannot alias to another alias: %s", dst)
+	}
+	link := alias.Dir()
+	if link == dst {
+		return nil
+	}
+	if fi, err := os.Lstat(link); err == nil {
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if v, err := os.Readlink(link); err == nil && v == filepath.Base(dst) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrDirExists, link)
+	}
+	log.Printf("aliasing %s to %s", link, dst)
+	return os.Symlink(filepath.Base(dst), link)
+}
+
+// RemoveAlias removes the alias of a harvest, the cache it points to is kept.
+func RemoveAlias(alias *Harvest) error {
+	link := alias.Dir()
+	fi, err := os.Lstat(link)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%w: %s", ErrNotAlias, link)
+	}
+	return os.Remove(link)
+}
+
+// ListAliases returns the aliases in a base directory, e.g. BaseDir, mapped
+// to the directories they point to.
+func ListAliases(baseDir string) (map[string]string, error) {
+	entries, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string)
+	for _, e := range entries {
+		if e.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		link := filepath.Join(baseDir, e.Name())
+		v, err := os.Readlink(link)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(v) {
+			v = filepath.Join(baseDir, v)
+		}
+		aliases[link] = v
+	}
+	return aliases, nil
+}
+
+// Rebind moves the cache of the harvest to the directory of other parameters,
+// e.g. a new set, format or base URL, and updates the harvest info file, so
+// incremental harvesting continues with the new parameters. With keepAlias,
+// the old parameters still resolve to the cache. The harvest must not run
+// and must have no unfinished finalize.
+func (h *Harvest) Rebind(to *Harvest, keepAlias bool) error {
+	src, dst := h.Dir(), to.Dir()
+	if src == dst {
+		return nil
+	}
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("cannot rebind an alias, remove it and alias again: %s", src)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%w: %s", ErrDirExists, dst)
+	}
+	l, err := h.lock(context.Background())
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(h.journalPath()); err == nil {
+		l.unlock()
+		return fmt.Errorf("unfinished finalize in %s, run a harvest first", src)
+	}
+	log.Printf("moving %s to %s", src, dst)
+	err = os.Rename(src, dst)
+	l.unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dst, LockFilename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// the info file still names the old parameters
+	if err := os.Remove(filepath.Join(dst, HarvestInfoFilename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := to.writeInfo(); err != nil {
+		return err
+	}
+	if keepAlias {
+		return Alias(h, to)
+	}
+	return nil
+}
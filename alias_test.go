@@ -0,0 +1,71 @@
+package metha
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-alias-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	target := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := target.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	chunk := filepath.Join(target.Dir(), "2016-01-31-00000000.xml.gz")
+	if err := ioutil.WriteFile(chunk, []byte("..."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	alias := &Harvest{BaseURL: target.BaseURL, Format: "oai_dc", Set: "all"}
+	if err := Alias(alias, target); err != nil {
+		t.Fatal(err)
+	}
+	if err := Alias(alias, target); err != nil {
+		t.Errorf("aliasing again: %v", err)
+	}
+	if got := len(alias.Files()); got != 1 {
+		t.Errorf("got %d files through alias, want 1", got)
+	}
+	if harvests, err := ListHarvests(dir); err != nil || len(harvests) != 1 {
+		t.Errorf("got %v, %v, want the target only", harvests, err)
+	}
+	if aliases, err := ListAliases(dir); err != nil || aliases[alias.Dir()] != target.Dir() {
+		t.Errorf("got %v, %v, want alias to %s", aliases, err, target.Dir())
+	}
+	if err := RemoveAlias(target); !errors.Is(err, ErrNotAlias) {
+		t.Errorf("got %v, want ErrNotAlias", err)
+	}
+	if err := RemoveAlias(alias); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(chunk); err != nil {
+		t.Errorf("removing alias touched the cache: %v", err)
+	}
+
+	// move the cache to another set, the old parameters still work
+	moved := &Harvest{BaseURL: target.BaseURL, Format: "oai_dc", Set: "phys"}
+	if err := target.Rebind(moved, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(moved.Files()); got != 1 {
+		t.Errorf("got %d files after rebind, want 1", got)
+	}
+	if got := len(target.Files()); got != 1 {
+		t.Errorf("got %d files through old parameters, want 1", got)
+	}
+	info, err := ReadHarvestInfo(moved.Dir())
+	if err != nil || info.Set != "phys" {
+		t.Errorf("got %+v, %v, want info with new set", info, err)
+	}
+	if err := alias.Rebind(moved, false); err == nil {
+		t.Errorf("rebind of a missing cache succeeded")
+	}
+}
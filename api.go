@@ -0,0 +1,255 @@
+package metha
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CacheAPI is a read-only HTTP handler, that describes the harvests in
+// BaseDir as JSON, e.g. for tools, that need to know the state of a cache
+// without access to the machine. It can be mounted in any mux, e.g. with
+// http.StripPrefix("/api", NewCacheAPI()). Harvests are identified by their
+// directory name. Routes:
+//
+//	/harvests                          all harvests
+//	/harvests/{id}                     harvest info, last run, resume point,
+//	                                   error report of a failing harvest
+//	/harvests/{id}/files               cached files with size and time
+//	/harvests/{id}/stats               record counts, see Stats
+//	/harvests/{id}/record?identifier=  latest version of a record, with
+//	                                   at=2016-01-31 the version on that
+//	                                   day from the version store
+type CacheAPI struct{}
+
+// NewCacheAPI returns a handler for the harvests in BaseDir.
+func NewCacheAPI() *CacheAPI {
+	return &CacheAPI{}
+}
+
+// errHarvestNotFound is returned for unknown harvest ids.
+var errHarvestNotFound = errors.New("harvest not found")
+
+// apiHarvest is a harvest with its id.
+type apiHarvest struct {
+	ID string `json:"id"`
+	HarvestInfo
+}
+
+// apiHarvestDetail adds the last run and where the next run continues.
+type apiHarvestDetail struct {
+	apiHarvest
+	LastRun     *RunManifest `json:"last_run,omitempty"`
+	ResumePoint *ResumePoint `json:"resume_point,omitempty"`
+	Quota       *QuotaState  `json:"quota,omitempty"`
+	LastError   *ErrorReport `json:"last_error,omitempty"`
+}
+
+// apiFile is a cached file, Name is relative to the harvest directory.
+type apiFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// ServeHTTP routes a request.
+func (a *CacheAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "harvests" || len(parts) > 3 {
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if len(parts) == 1 {
+		a.harvests(w)
+		return
+	}
+	h, err := apiHarvestFromID(parts[1])
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	if len(parts) == 2 {
+		a.harvest(w, parts[1], h)
+		return
+	}
+	switch parts[2] {
+	case "files":
+		a.files(w, h)
+	case "stats":
+		stats, err := h.Stats()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, stats)
+	case "record":
+		a.record(w, h, r.URL.Query().Get("identifier"), r.URL.Query().Get("at"))
+	default:
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// apiHarvestFromID returns the harvest of a directory name in BaseDir.
+func apiHarvestFromID(id string) (*Harvest, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, errHarvestNotFound
+	}
+	if fi, err := os.Stat(filepath.Join(BaseDir, id)); err != nil || !fi.IsDir() {
+		return nil, errHarvestNotFound
+	}
+	h, err := HarvestFromDir(id)
+	if err != nil {
+		return nil, errHarvestNotFound
+	}
+	return h, nil
+}
+
+// harvests lists all harvests.
+func (a *CacheAPI) harvests(w http.ResponseWriter) {
+	infos, err := ListHarvests(BaseDir)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	result := make([]apiHarvest, 0, len(infos))
+	for _, info := range infos {
+		result = append(result, apiHarvest{ID: filepath.Base(info.Dir), HarvestInfo: info})
+	}
+	writeJSON(w, result)
+}
+
+// harvest describes a single harvest.
+func (a *CacheAPI) harvest(w http.ResponseWriter, id string, h *Harvest) {
+	info, err := ReadHarvestInfo(h.Dir())
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	detail := apiHarvestDetail{apiHarvest: apiHarvest{ID: id, HarvestInfo: *info}}
+	if runs, err := h.Runs(); err == nil && len(runs) > 0 {
+		detail.LastRun = &runs[len(runs)-1]
+	}
+	if detail.ResumePoint, err = h.ResumePoint(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if detail.Quota, err = h.QuotaState(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if detail.LastError, err = h.ErrorReport(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, detail)
+}
+
+// files lists the cached files of a harvest.
+func (a *CacheAPI) files(w http.ResponseWriter, h *Harvest) {
+	files := make([]apiFile, 0)
+	for _, filename := range h.cacheFiles() {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(h.Dir(), filename)
+		if err != nil {
+			continue
+		}
+		files = append(files, apiFile{Name: filepath.ToSlash(rel), Size: fi.Size(), Modified: fi.ModTime()})
+	}
+	writeJSON(w, files)
+}
+
+// record looks up the latest version of a record or the version at a date.
+func (a *CacheAPI) record(w http.ResponseWriter, h *Harvest, identifier, at string) {
+	if identifier == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("identifier required"))
+		return
+	}
+	var (
+		rec *Record
+		err error
+	)
+	if at != "" {
+		rec, err = h.RecordOn(identifier, at)
+	} else {
+		rec, err = h.latestRecord(identifier)
+	}
+	switch {
+	case errors.Is(err, ErrInvalidDate):
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, ErrNoVersion):
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if rec == nil {
+		writeAPIError(w, http.StatusNotFound, errors.New("record not found"))
+		return
+	}
+	writeJSON(w, rec)
+}
+
+// latestRecord returns the latest cached version of a record or nil. The
+// header index is used, if there is one, otherwise all files are read.
+func (h *Harvest) latestRecord(identifier string) (*Record, error) {
+	if h.hasHeaderIndex() {
+		idx, err := h.OpenHeaderIndex()
+		if err != nil {
+			return nil, err
+		}
+		e, ok := idx.Lookup(identifier)
+		if !ok {
+			return nil, nil
+		}
+		return idx.Record(e)
+	}
+	var latest *Record
+	for _, filename := range h.cacheFiles() {
+		err := eachRecordPosition(filename, func(_ int, rec Record) error {
+			if strings.TrimSpace(rec.Header.Identifier) != identifier {
+				return nil
+			}
+			if latest == nil || rec.Header.DateStamp >= latest.Header.DateStamp {
+				r := rec
+				latest = &r
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// writeJSON writes a value as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
+
+// writeAPIError writes an error as JSON.
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
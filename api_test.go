@@ -0,0 +1,95 @@
+package metha
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-api-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	for name, doc := range map[string]string{
+		"2016-01-31-00000000.xml": `<OAI-PMH><ListRecords><record><header><identifier>oai:x/1</identifier><datestamp>2016-01-10</datestamp></header></record></ListRecords></OAI-PMH>`,
+		"2016-02-29-00000000.xml": `<OAI-PMH><ListRecords><record><header><identifier>oai:x/1</identifier><datestamp>2016-02-10</datestamp></header></record></ListRecords></OAI-PMH>`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(h.Dir(), name), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(http.StripPrefix("/api", NewCacheAPI()))
+	defer srv.Close()
+
+	get := func(path string, status int, v interface{}) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: got status %d, want %d", path, resp.StatusCode, status)
+			return
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+
+	var harvests []struct {
+		ID      string `json:"id"`
+		BaseURL string `json:"baseURL"`
+		Files   int    `json:"files"`
+	}
+	get("/api/harvests", http.StatusOK, &harvests)
+	if len(harvests) != 1 || harvests[0].ID != filepath.Base(h.Dir()) || harvests[0].BaseURL != h.BaseURL || harvests[0].Files != 2 {
+		t.Fatalf("got %+v", harvests)
+	}
+	id := harvests[0].ID
+
+	var files []struct {
+		Name string `json:"name"`
+	}
+	get("/api/harvests/"+id+"/files", http.StatusOK, &files)
+	if len(files) != 2 || files[0].Name != "2016-01-31-00000000.xml" {
+		t.Errorf("got files %+v", files)
+	}
+	var stats Stats
+	get("/api/harvests/"+id+"/stats", http.StatusOK, &stats)
+	if stats.Records != 2 {
+		t.Errorf("got %d records, want 2", stats.Records)
+	}
+	var rec Record
+	get("/api/harvests/"+id+"/record?identifier="+url.QueryEscape("oai:x/1"), http.StatusOK, &rec)
+	if rec.Header.DateStamp != "2016-02-10" {
+		t.Errorf("got datestamp %s, want the latest version", rec.Header.DateStamp)
+	}
+	get("/api/harvests/"+id, http.StatusOK, nil)
+	get("/api/harvests/"+id+"/record?identifier=missing", http.StatusNotFound, nil)
+	get("/api/harvests/..", http.StatusNotFound, nil)
+	get("/api/harvests/unknown/files", http.StatusNotFound, nil)
+
+	resp, err := http.Post(srv.URL+"/api/harvests", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for POST, want 405", resp.StatusCode)
+	}
+}
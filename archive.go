@@ -0,0 +1,219 @@
+package metha
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFormat is the container format of an archive.
+type ArchiveFormat string
+
+// Supported archive formats.
+const (
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+	ArchiveZip   ArchiveFormat = "zip"
+)
+
+// ParseArchiveFormat returns the archive format for a name or a filename,
+// e.g. "zip" or "snapshot.tar.gz".
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+	switch {
+	case s == "tar.gz" || s == "tgz" || strings.HasSuffix(s, ".tar.gz") || strings.HasSuffix(s, ".tgz"):
+		return ArchiveTarGz, nil
+	case s == "zip" || strings.HasSuffix(s, ".zip"):
+		return ArchiveZip, nil
+	}
+	return "", fmt.Errorf("unknown archive format: %s", s)
+}
+
+// ArchiveManifestFilename is the name of the manifest, that is the first
+// entry of each archive.
+const ArchiveManifestFilename = "MANIFEST.json"
+
+// archiveTime is the modification time of all entries, so an archive only
+// depends on the content of the cache.
+var archiveTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ArchiveManifest describes the content of an archive. Names are relative to
+// the directory of the harvest in the archive.
+type ArchiveManifest struct {
+	Endpoint string        `json:"endpoint"`
+	Format   string        `json:"format"`
+	Set      string        `json:"set,omitempty"`
+	Version  string        `json:"version"`
+	Files    []ArchiveFile `json:"files"`
+}
+
+// ArchiveFile is a single file of an archive.
+type ArchiveFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	// where to read the file from, local or cold storage
+	source string
+}
+
+// archiveFiles returns the files of a snapshot: cached files, including
+// those in cold storage, and the metadata of the harvest, sorted by name.
+func (h *Harvest) archiveFiles() ([]ArchiveFile, error) {
+	var files []ArchiveFile
+	for _, filename := range h.cacheFiles() {
+		rel, err := filepath.Rel(h.Dir(), strings.TrimSuffix(filename, StubSuffix))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, ArchiveFile{Name: filepath.ToSlash(rel), source: filename})
+	}
+	for _, name := range []string{HarvestInfoFilename, IdentifyFilename, ManifestFilename, HeaderIndexFilename} {
+		filename := filepath.Join(h.Dir(), name)
+		if _, err := os.Stat(filename); err == nil {
+			files = append(files, ArchiveFile{Name: name, source: filename})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	for i := range files {
+		f, err := OpenChunk(files[i].source)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.New()
+		n, err := io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[i].Size, files[i].SHA256 = n, hex.EncodeToString(hash.Sum(nil))
+	}
+	return files, nil
+}
+
+// Archive writes a snapshot of the harvest as a single tar.gz or zip file,
+// e.g. to hand it to a partner. Entries are placed in a directory named
+// after the harvest, in name order, with fixed timestamps and a manifest
+// listing sizes and checksums first, so the same cache yields the same
+// archive.
+func (h *Harvest) Archive(w io.Writer, format ArchiveFormat) error {
+	files, err := h.archiveFiles()
+	if err != nil {
+		return err
+	}
+	manifest := ArchiveManifest{
+		Endpoint: h.BaseURL,
+		Format:   h.Format,
+		Set:      h.Set,
+		Version:  Version,
+		Files:    files,
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	prefix := h.readableName()
+	switch format {
+	case ArchiveTarGz:
+		return writeTarGz(w, prefix, b, files)
+	case ArchiveZip:
+		return writeZip(w, prefix, b, files)
+	}
+	return fmt.Errorf("unknown archive format: %s", format)
+}
+
+// copyFile copies a file of the archive and checks, that it did not change
+// since the manifest was written.
+func copyFile(w io.Writer, f ArchiveFile) error {
+	r, err := OpenChunk(f.source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), io.LimitReader(r, f.Size+1))
+	if err != nil {
+		return err
+	}
+	if n != f.Size || hex.EncodeToString(hash.Sum(nil)) != f.SHA256 {
+		return fmt.Errorf("%s changed while archiving", f.Name)
+	}
+	return nil
+}
+
+func writeTarGz(w io.Writer, prefix string, manifest []byte, files []ArchiveFile) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	header := func(name string, size int64) *tar.Header {
+		return &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(prefix, name),
+			Size:     size,
+			Mode:     0644,
+			ModTime:  archiveTime,
+			Format:   tar.FormatPAX,
+		}
+	}
+	if err := tw.WriteHeader(header(ArchiveManifestFilename, int64(len(manifest)))); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(header(f.Name, f.Size)); err != nil {
+			return err
+		}
+		if err := copyFile(tw, f); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeZip(w io.Writer, prefix string, manifest []byte, files []ArchiveFile) error {
+	zw := zip.NewWriter(w)
+	create := func(name string, method uint16) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{
+			Name:     path.Join(prefix, name),
+			Method:   method,
+			Modified: archiveTime,
+		})
+	}
+	mw, err := create(ArchiveManifestFilename, zip.Deflate)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(manifest); err != nil {
+		return err
+	}
+	for _, f := range files {
+		// cached files are compressed already
+		method := zip.Deflate
+		if compressionFromFilename(f.Name) != CompressionNone {
+			method = zip.Store
+		}
+		fw, err := create(f.Name, method)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(fw, f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
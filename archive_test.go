@@ -0,0 +1,100 @@
+package metha
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"2016-02-29-00000000.xml", "2016/01/2016-01-31-00000000.xml"} {
+		filename := filepath.Join(h.Dir(), name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(`<OAI-PMH></OAI-PMH>`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var first bytes.Buffer
+	if err := h.Archive(&first, ArchiveTarGz); err != nil {
+		t.Fatal(err)
+	}
+	// modification times do not matter
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(h.Dir(), "2016-02-29-00000000.xml"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	var second bytes.Buffer
+	if err := h.Archive(&second, ArchiveTarGz); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("archives differ")
+	}
+
+	zr, err := gzip.NewReader(&first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	var names []string
+	var manifest ArchiveManifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, path.Base(hdr.Name))
+		if path.Base(hdr.Name) == ArchiveManifestFilename {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// sorted by name, including the shard directories
+	want := "[MANIFEST.json 2016-02-29-00000000.xml 2016-01-31-00000000.xml harvest.json]"
+	if fmt.Sprint(names) != want {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	if len(manifest.Files) != 3 || manifest.Files[1].Name != "2016/01/2016-01-31-00000000.xml" || manifest.Files[1].Size != 19 {
+		t.Errorf("got manifest %+v", manifest)
+	}
+
+	var buf bytes.Buffer
+	if err := h.Archive(&buf, ArchiveZip); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.File) != 4 {
+		t.Errorf("got %d zip entries, want 4", len(r.File))
+	}
+}
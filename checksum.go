@@ -0,0 +1,161 @@
+package metha
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChecksumSuffix is appended to the name of a cached file to get the name of
+// its SHA-256 sidecar. Sidecars use the sha256sum format, so copies can be
+// checked with sha256sum -c as well.
+const ChecksumSuffix = ".sha256"
+
+// writeChecksum atomically writes a sidecar for filename.
+func writeChecksum(filename string, sum []byte) error {
+	line := fmt.Sprintf("%x  %s\n", sum, filepath.Base(filename))
+	tmp := filename + ChecksumSuffix + "-tmp"
+	if err := ioutil.WriteFile(tmp, []byte(line), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename+ChecksumSuffix)
+}
+
+// readChecksum returns the checksum from the sidecar of filename.
+func readChecksum(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename + ChecksumSuffix)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s%s: empty checksum file", filename, ChecksumSuffix)
+	}
+	return hex.DecodeString(fields[0])
+}
+
+// checksum computes the SHA-256 of a reader.
+func checksum(r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// fileChecksum computes the SHA-256 of a cached file. For files in cold
+// storage, the stored content is used.
+func fileChecksum(filename string) ([]byte, error) {
+	r, err := OpenChunk(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return checksum(r)
+}
+
+// WriteChecksum computes and writes the sidecar for a cached file.
+func WriteChecksum(filename string) error {
+	sum, err := fileChecksum(filename)
+	if err != nil {
+		return err
+	}
+	return writeChecksum(strings.TrimSuffix(filename, StubSuffix), sum)
+}
+
+// checksumPath returns the sidecar name for a cached file or stub.
+func checksumPath(filename string) string {
+	return strings.TrimSuffix(filename, StubSuffix) + ChecksumSuffix
+}
+
+// removeChecksum removes the sidecar of filename, if any.
+func removeChecksum(filename string) error {
+	if err := os.Remove(checksumPath(filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// FsckResult lists files with missing or wrong checksums and checksums
+// without files.
+type FsckResult struct {
+	Dir     string      `json:"dir"`
+	Files   int         `json:"files"`
+	Checked int         `json:"checked"`
+	Errors  []FileError `json:"errors,omitempty"`
+}
+
+// OK returns true, if all files have a matching checksum.
+func (r *FsckResult) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// Fsck verifies all cached files, including those in cold storage, against
+// their checksums. It detects bit rot and partial copies, e.g. after
+// replicating a cache to another machine.
+func (h *Harvest) Fsck() (*FsckResult, error) {
+	result := &FsckResult{Dir: h.Dir()}
+	files := append(h.Files(), h.ColdFiles()...)
+	result.Files = len(files)
+
+	known := make(map[string]bool)
+	for _, filename := range files {
+		known[checksumPath(filename)] = true
+
+		want, err := readChecksum(strings.TrimSuffix(filename, StubSuffix))
+		if os.IsNotExist(err) {
+			result.Errors = append(result.Errors, FileError{Path: filename, Kind: "checksum", Err: "missing checksum"})
+			continue
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, FileError{Path: filename, Kind: "checksum", Err: err.Error()})
+			continue
+		}
+		got, err := fileChecksum(filename)
+		if err != nil {
+			result.Errors = append(result.Errors, FileError{Path: filename, Kind: "io", Err: err.Error()})
+			continue
+		}
+		result.Checked++
+		if !bytes.Equal(got, want) {
+			result.Errors = append(result.Errors, FileError{
+				Path: filename,
+				Kind: "checksum",
+				Err:  fmt.Sprintf("checksum mismatch, got %x, want %x", got, want),
+			})
+		}
+	}
+	for _, sidecar := range h.glob("*" + ChecksumSuffix) {
+		if !known[sidecar] {
+			result.Errors = append(result.Errors, FileError{
+				Path: strings.TrimSuffix(sidecar, ChecksumSuffix),
+				Kind: "checksum",
+				Err:  "file missing",
+			})
+		}
+	}
+	return result, nil
+}
+
+// WriteChecksums writes sidecars for all files, that do not have one yet,
+// e.g. for caches created by older versions. Returns the number of sidecars
+// written.
+func (h *Harvest) WriteChecksums() (int, error) {
+	var n int
+	for _, filename := range append(h.Files(), h.ColdFiles()...) {
+		if _, err := os.Stat(checksumPath(filename)); err == nil {
+			continue
+		}
+		if err := WriteChecksum(filename); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
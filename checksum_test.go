@@ -0,0 +1,75 @@
+package metha
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-fsck-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, name := range []string{"2016-01-31-00000000.xml.gz", "2016-02-29-00000000.xml.gz"} {
+		src := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(src, []byte("<OAI-PMH></OAI-PMH>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		dst := filepath.Join(h.Dir(), name)
+		if err := MoveAndCompress(src, dst); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, dst)
+	}
+
+	var cases = []struct {
+		about  string
+		modify func() error
+		errors int
+	}{
+		{"intact", func() error { return nil }, 0},
+		{"bit rot", func() error { return ioutil.WriteFile(files[0], []byte("xxx"), 0644) }, 1},
+		{"missing file", func() error { return os.Remove(files[1]) }, 2},
+		{"missing checksum", func() error { return os.Remove(files[0] + ChecksumSuffix) }, 2},
+	}
+	for _, c := range cases {
+		if err := c.modify(); err != nil {
+			t.Fatal(err)
+		}
+		result, err := h.Fsck()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Errors) != c.errors {
+			t.Errorf("%s: got %v, want %d errors", c.about, result.Errors, c.errors)
+		}
+	}
+
+	n, err := h.WriteChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d checksums written, want 1", n)
+	}
+	if err := os.Remove(files[1] + ChecksumSuffix); err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() {
+		t.Errorf("got %v, want no errors", result.Errors)
+	}
+}
@@ -1,9 +1,14 @@
 package metha
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,8 +17,6 @@ import (
 	"net/url"
 	"strings"
 	"time"
-
-	"github.com/sethgrid/pester"
 )
 
 const (
@@ -46,29 +49,40 @@ var (
 		"\u001E", "", "\u001F", "")
 )
 
+// ErrResponseTooLarge is returned, if a response exceeds MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("response too large")
+
 // HTTPError saves details of an HTTP error.
 type HTTPError struct {
 	URL          *url.URL
 	StatusCode   int
 	RequestError error
+	RequestID    string
 }
 
 // Error prints the error message.
 func (e HTTPError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("failed with %s on %s (request %s): %v", http.StatusText(e.StatusCode), e.URL, e.RequestID, e.RequestError)
+	}
 	return fmt.Sprintf("failed with %s on %s: %v", http.StatusText(e.StatusCode), e.URL, e.RequestError)
 }
 
 // CreateDoer will return http request clients with specific timeout and retry
-// properties.
+// properties. Retries use the default retry policy.
 func CreateDoer(timeout time.Duration, retries int) Doer {
 	if timeout == 0 && retries == 0 {
 		return http.DefaultClient
 	}
-	c := pester.New()
-	c.Timeout = timeout
-	c.MaxRetries = retries
-	c.Backoff = pester.ExponentialBackoff
-	return c
+	policy := DefaultRetryPolicy
+	policy.MaxAttempts = retries
+	return CreateRetryDoer(timeout, policy)
+}
+
+// CreateRetryDoer returns a http request client with a timeout and a retry
+// policy.
+func CreateRetryDoer(timeout time.Duration, policy RetryPolicy) Doer {
+	return &RetryDoer{Client: &http.Client{Timeout: timeout}, Policy: policy}
 }
 
 // CreateClient creates a client with timeout and retry properties.
@@ -84,6 +98,21 @@ type Doer interface {
 // Client can execute requests.
 type Client struct {
 	Doer Doer
+	// MaxResponseBytes limits the size of a decompressed response body, if
+	// positive. Larger responses fail with ErrResponseTooLarge.
+	MaxResponseBytes int64
+	// DisableCompression stops asking for gzip or deflate compressed
+	// responses.
+	DisableCompression bool
+	// UserAgent is sent with each request, defaults to DefaultUserAgent.
+	UserAgent string
+	// Sanitize repairs broken encodings, the number of repairs is added to
+	// Repairs, if not nil.
+	Sanitize Sanitize
+	Repairs  *RepairCounts
+	// Middleware is applied in order to each request before it is sent. The
+	// request is changed in place.
+	Middleware []RequestMiddleware
 }
 
 // Do is a shortcut for DefaultClient.Do.
@@ -107,50 +136,201 @@ func maybeCompressed(r io.Reader) (io.ReadCloser, error) {
 // Do executes a single OAIRequest. ResumptionToken handling must happen in the
 // caller. Only Identify and GetRecord requests will return a complete response.
 func (c *Client) Do(r *Request) (*Response, error) {
-	link, err := r.URL()
+	return c.DoContext(context.Background(), r)
+}
+
+// DoContext is like Do, but the request is cancelled with the context.
+func (c *Client) DoContext(ctx context.Context, r *Request) (*Response, error) {
+	reader, err := c.Stream(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	log.Println(link)
+	defer reader.Close()
+
+	dec := xml.NewDecoder(reader)
+	dec.Strict = false
+
+	var response Response
+	if err := dec.Decode(&response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
 
-	req, err := http.NewRequest("GET", link.String(), nil)
+// send executes the HTTP request and checks the status code.
+func (c *Client) send(ctx context.Context, r *Request) (*http.Response, error) {
+	if err := applyMiddleware(r, c.Middleware); err != nil {
+		return nil, err
+	}
+	link, err := r.URL()
 	if err != nil {
 		return nil, err
 	}
+	id := newRequestID()
+	log.Printf("%s [%s]", link, id)
+
+	var req *http.Request
+	if r.UsePost {
+		v, err := r.Values()
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequest("POST", r.BaseURL, strings.NewReader(v.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequest("GET", link.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req = req.WithContext(ctx)
+	userAgent := c.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(RequestIDHeader, id)
+	if !c.DisableCompression {
+		// the response is decompressed in Stream
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
 
 	resp, err := c.Doer.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode >= 400 {
-		return nil, HTTPError{URL: link, RequestError: err, StatusCode: resp.StatusCode}
+		resp.Body.Close()
+		return nil, HTTPError{URL: link, RequestError: err, StatusCode: resp.StatusCode, RequestID: id}
 	}
-	defer resp.Body.Close()
-
-	var reader = resp.Body
+	if c.MaxResponseBytes > 0 && resp.ContentLength > c.MaxResponseBytes {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes on %s (request %s)", ErrResponseTooLarge, resp.ContentLength, link, id)
+	}
+	return resp, nil
+}
 
-	// detect compressed response
-	reader, err = maybeCompressed(reader)
+// Stream executes a request and returns the response body without buffering
+// it. Compressed responses are detected and control characters are removed,
+// if the request asks for it. The caller must close the reader.
+func (c *Client) Stream(ctx context.Context, r *Request) (io.ReadCloser, error) {
+	body, encoding, err := c.streamEncoded(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close()
+	reader, err := c.decodeBody(body, encoding, r)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return readCloser{Reader: reader, Closer: body}, nil
+}
 
-	if r.CleanBeforeDecode {
-		// remove some chars, that the XML decoder will complain about
-		b, err := ioutil.ReadAll(reader)
+// streamEncoded executes a request and returns the body as sent, together
+// with its content encoding.
+func (c *Client) streamEncoded(ctx context.Context, r *Request) (io.ReadCloser, string, error) {
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Body, strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))), nil
+}
+
+// decodeBody decompresses a body according to its content encoding. Gzip
+// content, that is not advertised as such, is detected, too.
+func (c *Client) decodeBody(body io.Reader, encoding string, r *Request) (io.Reader, error) {
+	var reader io.Reader = body
+	switch encoding {
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(body)
 		if err != nil {
 			return nil, err
 		}
-		reader = ioutil.NopCloser(strings.NewReader(ControlCharReplacer.Replace(string(b))))
+		reader = gr
+	case "deflate":
+		// should be zlib, but some servers send raw deflate
+		br := bufio.NewReader(body)
+		if b, err := br.Peek(2); err == nil && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			reader = zr
+		} else {
+			reader = flate.NewReader(br)
+		}
+	}
+	br := bufio.NewReader(reader)
+	reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("decompress-on-the-fly")
+		reader = gr
+	}
+	if c.MaxResponseBytes > 0 {
+		reader = &limitedReader{r: reader, n: c.MaxResponseBytes}
+	}
+	if !c.Sanitize.IsZero() {
+		reader = newSanitizer(reader, c.Sanitize, c.Repairs)
+	}
+	if r.CleanBeforeDecode {
+		reader = controlCharFilter{reader}
 	}
+	return reader, nil
+}
 
-	dec := xml.NewDecoder(reader)
-	dec.Strict = false
+// limitedReader fails with ErrResponseTooLarge after more than n bytes.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
 
-	var response Response
-	if err := dec.Decode(&response); err != nil {
-		return nil, err
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// readCloser combines a reader with the closer of another reader.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// controlCharFilter removes the same chars as the ControlCharReplacer from a
+// stream. These bytes cannot be part of multibyte UTF-8 sequences.
+type controlCharFilter struct {
+	r io.Reader
+}
+
+func (f controlCharFilter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		j := 0
+		for _, b := range p[:n] {
+			if b < 0x20 && b != 0x00 && b != '\n' && b != '\r' {
+				continue
+			}
+			p[j] = b
+			j++
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
 	}
-	return &response, nil
 }
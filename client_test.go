@@ -0,0 +1,175 @@
+package metha
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxResponseBytes(t *testing.T) {
+	doc := `<OAI-PMH><ListRecords><record><header><identifier>1</identifier></header><metadata>` +
+		strings.Repeat("x", 1000) + `</metadata></record></ListRecords></OAI-PMH>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("set") == "gzip" {
+			// compressed and without content length
+			gw := gzip.NewWriter(w)
+			gw.Write([]byte(doc))
+			gw.Close()
+			return
+		}
+		w.Write([]byte(doc))
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		set string
+		max int64
+		err error
+	}{
+		{"plain", 0, nil},
+		{"plain", 2000, nil},
+		{"plain", 500, ErrResponseTooLarge},
+		{"gzip", 2000, nil},
+		{"gzip", 500, ErrResponseTooLarge},
+	}
+	for _, c := range cases {
+		client := Client{Doer: http.DefaultClient, MaxResponseBytes: c.max}
+		req := &Request{BaseURL: srv.URL, Verb: "ListRecords", MetadataPrefix: "oai_dc", Set: c.set}
+		resp, err := client.DoContext(context.Background(), req)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s %d: got %v, want %v", c.set, c.max, err, c.err)
+			continue
+		}
+		if err == nil && len(resp.ListRecords.Records) != 1 {
+			t.Errorf("%s %d: got %d records, want 1", c.set, c.max, len(resp.ListRecords.Records))
+		}
+	}
+}
+
+func TestContentEncoding(t *testing.T) {
+	doc := `<OAI-PMH><ListRecords><record><header><identifier>1</identifier></header></record></ListRecords></OAI-PMH>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte(doc))
+			return
+		}
+		switch set := r.URL.Query().Get("set"); set {
+		case "gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			gw := gzip.NewWriter(w)
+			gw.Write([]byte(doc))
+			gw.Close()
+		case "deflate", "raw-deflate":
+			w.Header().Set("Content-Encoding", "deflate")
+			var fw io.WriteCloser
+			if set == "deflate" {
+				fw = zlib.NewWriter(w)
+			} else {
+				fw, _ = flate.NewWriter(w, flate.DefaultCompression)
+			}
+			fw.Write([]byte(doc))
+			fw.Close()
+		default:
+			w.Write([]byte(doc))
+		}
+	}))
+	defer srv.Close()
+
+	for _, set := range []string{"plain", "gzip", "deflate", "raw-deflate"} {
+		client := Client{Doer: http.DefaultClient}
+		req := &Request{BaseURL: srv.URL, Verb: "ListRecords", MetadataPrefix: "oai_dc", Set: set}
+		resp, err := client.DoContext(context.Background(), req)
+		if err != nil {
+			t.Fatalf("%s: %v", set, err)
+		}
+		if len(resp.ListRecords.Records) != 1 {
+			t.Errorf("%s: got %d records, want 1", set, len(resp.ListRecords.Records))
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var cases = []struct {
+		contact string
+		want    string
+	}{
+		{"", "metha/" + Version + " (+https://github.com/miku/metha)"},
+		{"ops@example.org", "metha/" + Version + " (+https://github.com/miku/metha; mailto:ops@example.org)"},
+		{"https://example.org/harvest", "metha/" + Version + " (+https://github.com/miku/metha; https://example.org/harvest)"},
+	}
+	for _, c := range cases {
+		if got := UserAgent(c.contact); got != c.want {
+			t.Errorf("UserAgent(%q): got %q, want %q", c.contact, got, c.want)
+		}
+	}
+
+	var ids []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("User-Agent"); got != "x/1.0" {
+			t.Errorf("got user agent %q", got)
+		}
+		ids = append(ids, r.Header.Get(RequestIDHeader))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	client := Client{Doer: http.DefaultClient, UserAgent: "x/1.0"}
+	for i := 0; i < 2; i++ {
+		_, err := client.Do(&Request{BaseURL: srv.URL, Verb: "Identify"})
+		var herr HTTPError
+		if !errors.As(err, &herr) || herr.RequestID == "" || herr.RequestID != ids[i] {
+			t.Errorf("got %v, want an HTTP error with request id %s", err, ids[i])
+		}
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Errorf("got request ids %v, want two distinct ids", ids)
+	}
+}
+
+func TestRequestMiddleware(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		io.WriteString(w, `<OAI-PMH><ListRecords><resumptionToken>t1</resumptionToken></ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	errDenied := errors.New("denied")
+	client := Client{Doer: http.DefaultClient, Middleware: []RequestMiddleware{
+		SetParam("apikey", "secret"),
+		func(r *Request) error {
+			if r.Set == "private" {
+				return errDenied
+			}
+			return nil
+		},
+	}}
+	if _, err := client.Do(&Request{BaseURL: srv.URL, Verb: "ListRecords", MetadataPrefix: "oai_dc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(&Request{BaseURL: srv.URL, Verb: "ListRecords", ResumptionToken: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Do(&Request{BaseURL: srv.URL, Verb: "ListRecords", MetadataPrefix: "oai_dc", Set: "private"}); err != errDenied {
+		t.Errorf("got %v, want %v", err, errDenied)
+	}
+	want := []string{
+		"apikey=secret&metadataPrefix=oai_dc&verb=ListRecords",
+		"apikey=secret&resumptionToken=t1&verb=ListRecords",
+	}
+	if len(queries) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(queries), len(want))
+	}
+	for i := range want {
+		if queries[i] != want[i] {
+			t.Errorf("got %s, want %s", queries[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	version := flag.Bool("v", false, "show version")
+	toURL := flag.String("to-url", "", "endpoint of the target, defaults to the endpoint")
+	toFormat := flag.String("to-format", "", "format of the target, defaults to -format")
+	toSet := flag.String("to-set", "", "set of the target")
+	move := flag.Bool("move", false, "move the cache to the target parameters, instead of aliasing")
+	noAlias := flag.Bool("no-alias", false, "with -move, do not keep the old parameters as an alias")
+	remove := flag.Bool("rm", false, "remove the alias of the endpoint, format and set")
+	list := flag.Bool("l", false, "list aliases")
+	readable := flag.Bool("readable", false, "use readable directory names")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if *list {
+		aliases, err := metha.ListAliases(metha.BaseDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var links []string
+		for link := range aliases {
+			links = append(links, link)
+		}
+		sort.Strings(links)
+		for _, link := range links {
+			fmt.Printf("%s\t%s\n", link, aliases[link])
+		}
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	var naming metha.Naming
+	if *readable {
+		naming = metha.NamingReadable
+	}
+	harvest := &metha.Harvest{
+		BaseURL: metha.PrependSchema(flag.Arg(0)),
+		Format:  *format,
+		Set:     *set,
+		Naming:  naming,
+	}
+	if *remove {
+		if err := metha.RemoveAlias(harvest); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	target := &metha.Harvest{
+		BaseURL: harvest.BaseURL,
+		Format:  harvest.Format,
+		Set:     *toSet,
+		Naming:  naming,
+	}
+	if *toURL != "" {
+		target.BaseURL = metha.PrependSchema(*toURL)
+	}
+	if *toFormat != "" {
+		target.Format = *toFormat
+	}
+	if *move {
+		if err := harvest.Rebind(target, !*noAlias); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := metha.Alias(harvest, target); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(target.Dir())
+}
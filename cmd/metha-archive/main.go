@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	version := flag.Bool("v", false, "show version")
+	output := flag.String("o", "", "write archive to this file instead of stdout, type is taken from the extension")
+	archiveType := flag.String("type", "", "archive type, tar.gz or zip, defaults to tar.gz")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	harvest := metha.Harvest{
+		BaseURL: metha.PrependSchema(flag.Arg(0)),
+		Format:  *format,
+		Set:     *set,
+	}
+	if _, err := os.Stat(harvest.Dir()); err != nil {
+		log.Fatal(err)
+	}
+
+	name := *archiveType
+	if name == "" {
+		name = *output
+	}
+	if name == "" {
+		name = string(metha.ArchiveTarGz)
+	}
+	af, err := metha.ParseArchiveFormat(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := harvest.Archive(bw, af); err != nil {
+		log.Fatal(err)
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,16 +1,11 @@
 package main
 
 import (
-	"encoding/xml"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
-
-	gzip "github.com/klauspost/pgzip"
 
 	"github.com/miku/metha"
 )
@@ -23,7 +18,13 @@ func main() {
 	from := flag.String("from", "", "ignore records before this date")
 	until := flag.String("until", "", "ignore records after this date")
 
+	origin := flag.String("origin", "", "only records an aggregator harvested from this base URL, read from its partition, if any")
+
 	root := flag.String("root", "", "root element to wrap records into")
+	transform := flag.String("transform", "", "comma separated transforms: strip (metadata only, drops about sections), provenance (add source attributes), ascii (escape non-ASCII characters)")
+
+	dedup := flag.Bool("dedup", false, "emit each record version (identifier and datestamp) only once")
+	dedupIndex := flag.String("dedup-index", "", "keep seen records in this file, to skip records emitted by earlier runs, implies -dedup")
 
 	flag.Parse()
 
@@ -38,63 +39,64 @@ func main() {
 
 	baseURL := metha.PrependSchema(flag.Arg(0))
 
-	harvest := metha.Harvest{
+	harvest := &metha.Harvest{
 		BaseURL: baseURL,
 		Format:  *format,
 		Set:     *set,
 	}
 
-	files, err := ioutil.ReadDir(harvest.Dir())
-	if err != nil {
+	if _, err := os.Stat(harvest.Dir()); err != nil {
 		log.Fatal(err)
 	}
 
-	if *root != "" {
-		fmt.Printf(`<%s xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">\n`, *root)
-		defer fmt.Printf("</%s>\n", *root)
-	}
+	exporter := metha.Exporter{Harvest: harvest, Root: *root, Origin: *origin}
 
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".xml.gz") {
-			continue
+	if *from != "" {
+		// files are named after the end of their interval
+		files := append(harvest.Files(), harvest.ColdFiles()...)
+		if partition := harvest.OriginFiles(*origin); *origin != "" && len(partition) > 0 {
+			files = partition
 		}
-
-		if *from != "" && file.Name() < *from {
-			continue
+		exporter.Files = []string{}
+		for _, f := range files {
+			if filepath.Base(f) >= *from {
+				exporter.Files = append(exporter.Files, f)
+			}
 		}
+		exporter.Transforms = append(exporter.Transforms, metha.DropRecords(func(rec metha.Record) bool {
+			return rec.Header.DateStamp < *from
+		}))
+	}
+	if *until != "" {
+		exporter.Transforms = append(exporter.Transforms, metha.DropRecords(func(rec metha.Record) bool {
+			return rec.Header.DateStamp > *until
+		}))
+	}
 
-		abspath := filepath.Join(harvest.Dir(), file.Name())
+	transforms, err := metha.ParseTransforms(harvest, *transform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	exporter.Transforms = append(exporter.Transforms, transforms...)
 
-		fi, err := os.Open(abspath)
+	// dedup last, so dropped records are not marked as seen
+	switch {
+	case *dedupIndex != "":
+		seen, err := metha.OpenSeenIndex(*dedupIndex)
 		if err != nil {
 			log.Fatal(err)
 		}
-		r, err := gzip.NewReader(fi)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		dec := xml.NewDecoder(r)
-		dec.Strict = false
-
-		var resp metha.Response
-		if err := dec.Decode(&resp); err != nil {
-			log.Fatal(err)
-		}
-
-		for _, rec := range resp.ListRecords.Records {
-			if *from != "" && rec.Header.DateStamp < *from {
-				continue
-			}
-			if *until != "" && rec.Header.DateStamp > *until {
-				continue
-			}
-
-			b, err := xml.Marshal(rec)
-			if err != nil {
+		defer func() {
+			if err := seen.Close(); err != nil {
 				log.Fatal(err)
 			}
-			fmt.Println(string(b))
-		}
+		}()
+		exporter.Transforms = append(exporter.Transforms, metha.Dedup(seen))
+	case *dedup:
+		exporter.Transforms = append(exporter.Transforms, metha.Dedup(metha.NewSeenIndex()))
+	}
+
+	if _, err := exporter.Export(os.Stdout); err != nil {
+		log.Fatal(err)
 	}
 }
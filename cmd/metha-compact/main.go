@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	all := flag.Bool("all", false, "merge the whole harvest into a single file, instead of one file per month")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	baseURL := metha.PrependSchema(flag.Arg(0))
+
+	harvest := metha.Harvest{
+		BaseURL: baseURL,
+		Format:  *format,
+		Set:     *set,
+	}
+
+	mode := metha.CompactMonthly
+	if *all {
+		mode = metha.CompactAll
+	}
+
+	files, err := harvest.Compact(mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, fn := range files {
+		fmt.Println(fn)
+	}
+}
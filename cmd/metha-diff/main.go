@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+// snapshotDir returns the directory itself or the directory of an endpoint.
+func snapshotDir(arg, format, set string) string {
+	if fi, err := os.Stat(arg); err == nil && fi.IsDir() {
+		return arg
+	}
+	harvest := metha.Harvest{
+		BaseURL: metha.PrependSchema(arg),
+		Format:  format,
+		Set:     set,
+	}
+	return harvest.Dir()
+}
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	version := flag.Bool("v", false, "show version")
+	untilA := flag.String("a-until", "", "compare the first snapshot as of this day")
+	untilB := flag.String("b-until", "", "compare the second snapshot as of this day")
+	summary := flag.Bool("summary", false, "only print the counts")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint or directory required")
+	}
+
+	a := metha.Snapshot{Dir: snapshotDir(flag.Arg(0), *format, *set), Until: *untilA}
+	b := metha.Snapshot{Dir: a.Dir, Until: *untilB}
+	if flag.NArg() > 1 {
+		b.Dir = snapshotDir(flag.Arg(1), *format, *set)
+	}
+	for _, s := range []metha.Snapshot{a, b} {
+		if _, err := os.Stat(s.Dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	bw := bufio.NewWriter(os.Stdout)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+
+	var fn func(metha.DiffEntry) error
+	if !*summary {
+		fn = func(e metha.DiffEntry) error { return enc.Encode(e) }
+	}
+	result, err := metha.Diff(a, b, fn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *summary {
+		if err := enc.Encode(result); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Printf("%d added, %d changed, %d deleted, %d unchanged",
+			result.Added, result.Changed, result.Deleted, result.Unchanged)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	chunks := flag.Bool("chunks", false, "verify all cached files, slow")
+	network := flag.Bool("network", false, "check, whether harvested endpoints are reachable")
+	minFree := flag.Uint64("min-free", 1024, "warn, if less than this many MB are free")
+	asJSON := flag.Bool("json", false, "print findings as JSON lines")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	opts := metha.DefaultDoctorOptions
+	opts.MinFreeBytes = *minFree << 20
+	opts.Chunks = *chunks
+	opts.Endpoints = *network
+
+	findings := metha.Doctor(context.Background(), opts)
+
+	var failed bool
+	enc := json.NewEncoder(os.Stdout)
+	for _, f := range findings {
+		if f.Severity != metha.SeverityInfo {
+			failed = true
+		}
+		if *asJSON {
+			if err := enc.Encode(f); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		fmt.Println(f)
+	}
+	if !*asJSON && !failed {
+		fmt.Printf("%s looks fine\n", metha.BaseDir)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
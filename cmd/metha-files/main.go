@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/miku/metha"
 )
@@ -13,6 +14,8 @@ func main() {
 	format := flag.String("format", "oai_dc", "metadata format")
 	set := flag.String("set", "", "set name")
 	version := flag.Bool("v", false, "show version")
+	since := flag.String("since", "", "only files added by runs finished after this time (2006-01-02 or RFC3339)")
+	last := flag.Bool("last", false, "only files added by the last run")
 
 	flag.Parse()
 
@@ -33,7 +36,34 @@ func main() {
 		Set:     *set,
 	}
 
-	for _, fn := range harvest.Files() {
+	files := harvest.Files()
+
+	switch {
+	case *last:
+		runs, err := harvest.Runs()
+		if err != nil {
+			log.Fatal(err)
+		}
+		files = nil
+		if len(runs) > 0 {
+			t := runs[len(runs)-1].Started
+			if files, err = harvest.NewFilesSince(t); err != nil {
+				log.Fatal(err)
+			}
+		}
+	case *since != "":
+		t, err := time.Parse(time.RFC3339, *since)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", *since); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if files, err = harvest.NewFilesSince(t); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, fn := range files {
 		fmt.Println(fn)
 	}
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	graphml := flag.Bool("graphml", false, "write GraphML instead of a CSV edge list")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	baseURL := metha.PrependSchema(flag.Arg(0))
+
+	harvest := metha.Harvest{
+		BaseURL: baseURL,
+		Format:  *format,
+		Set:     *set,
+	}
+
+	g, err := harvest.Graph()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if *graphml {
+		err = g.WriteGraphML(w)
+	} else {
+		err = g.WriteCSV(w)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -12,6 +12,9 @@ import (
 
 func main() {
 	version := flag.Bool("v", false, "show version")
+	cached := flag.Bool("cached", false, "show the cached identify response of a harvest, without network access")
+	format := flag.String("format", "oai_dc", "metadata format of the harvest, with -cached")
+	set := flag.String("set", "", "set of the harvest, with -cached")
 
 	flag.Parse()
 
@@ -25,6 +28,18 @@ func main() {
 	}
 
 	baseURL := metha.PrependSchema(flag.Arg(0))
+
+	if *cached {
+		h, err := metha.OpenHarvest(baseURL, *format, *set)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"identify": h.Identify}); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	repo := metha.Repository{BaseURL: baseURL}
 
 	m := make(map[string]interface{})
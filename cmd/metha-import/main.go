@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] ENDPOINT FILE [FILE ...]\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() < 2 {
+		log.Fatal("endpoint and at least one file required")
+	}
+
+	baseURL := metha.PrependSchema(flag.Arg(0))
+
+	harvest := metha.Harvest{
+		BaseURL: baseURL,
+		Format:  *format,
+		Set:     *set,
+	}
+
+	files, err := harvest.Import(flag.Args()[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("imported %d files into %s", len(files), harvest.Dir())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	rebuild := flag.Bool("rebuild", false, "create the index from all cached files")
+	id := flag.String("id", "", "print the latest version of the record with this identifier")
+	deleted := flag.Bool("deleted", false, "list identifiers of deleted records")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	harvest := &metha.Harvest{
+		BaseURL: metha.PrependSchema(flag.Arg(0)),
+		Format:  *format,
+		Set:     *set,
+	}
+	if _, err := os.Stat(harvest.Dir()); err != nil {
+		log.Fatal(err)
+	}
+
+	if *rebuild {
+		if err := harvest.RebuildHeaderIndex(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	index, err := harvest.OpenHeaderIndex()
+	if os.IsNotExist(err) {
+		log.Fatal("no header index, create one with -rebuild or harvest with -index")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case *id != "":
+		e, ok := index.Lookup(*id)
+		if !ok {
+			log.Fatalf("%s not found", *id)
+		}
+		rec, err := index.Record(e)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := xml.Marshal(rec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+	case *deleted:
+		err := index.Each(func(e metha.HeaderEntry) error {
+			if e.Deleted {
+				fmt.Println(e.Identifier)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fmt.Printf("%d records\n", index.Len())
+	}
+}
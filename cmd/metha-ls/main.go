@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"strings"
+	"os"
+	"path/filepath"
 
 	"github.com/miku/metha"
 )
@@ -20,28 +20,46 @@ func ellipsis(s string, length int) string {
 
 func main() {
 	showAll := flag.Bool("a", false, "show full path")
+	long := flag.Bool("l", false, "show number of files, size and first and last date")
+	asJSON := flag.Bool("json", false, "one JSON object per harvest")
+	errorReports := flag.Bool("errors", false, "one JSON error report per failing harvest")
 	flag.Parse()
 
-	files, err := ioutil.ReadDir(metha.BaseDir)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	for _, file := range files {
-		b, err := base64.RawURLEncoding.DecodeString(file.Name())
+	if *errorReports {
+		reports, err := metha.ListErrorReports(metha.BaseDir)
 		if err != nil {
 			log.Fatal(err)
 		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range reports {
+			if err := enc.Encode(r); err != nil {
+				log.Fatal(err)
+			}
+		}
+		os.Exit(0)
+	}
 
-		parts := strings.SplitN(string(b), "#", 3)
-		if len(parts) < 3 {
+	harvests, err := metha.ListHarvests(metha.BaseDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, h := range harvests {
+		if *asJSON {
+			if err := enc.Encode(h); err != nil {
+				log.Fatal(err)
+			}
 			continue
 		}
-		if *showAll {
-			fmt.Printf("%s\t%s\n", file.Name(), strings.Join(parts, "\t"))
+		name := filepath.Base(h.Dir)
+		if !*showAll {
+			name = ellipsis(name, 35)
+		}
+		if *long {
+			fmt.Printf("%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", name, h.Files, h.Bytes, h.First, h.Last, h.Set, h.Format, h.BaseURL)
 		} else {
-			fmt.Printf("%s\t%s\n", ellipsis(file.Name(), 35), strings.Join(parts, "\t"))
+			fmt.Printf("%s\t%s\t%s\t%s\n", name, h.Set, h.Format, h.BaseURL)
 		}
-
 	}
 }
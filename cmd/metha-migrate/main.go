@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	version := flag.Bool("v", false, "show version")
+	shard := flag.Bool("shard", false, "place files into YYYY/MM subdirectories")
+	naming := flag.String("naming", "base64", "naming of the harvest directories: base64 or readable")
+	compression := flag.String("compression", "gzip", "compression of cached files: gzip, zstd or none")
+	move := flag.Bool("move", false, "remove each source harvest after it has been copied and verified")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] SRC [DST]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Copies all harvests from base directory SRC into DST. Without DST,\n")
+		fmt.Fprintf(os.Stderr, "harvests are converted in place, which requires -move.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("source directory required")
+	}
+	src, dst := flag.Arg(0), flag.Arg(0)
+	if flag.NArg() > 1 {
+		dst = flag.Arg(1)
+	}
+	if src == dst && !*move {
+		log.Fatal("converting in place requires -move")
+	}
+
+	n, err := metha.ParseNaming(*naming)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := metha.ParseCompression(*compression)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts := metha.MigrateOptions{Naming: n, Compression: c, Shard: *shard, Move: *move}
+	result, err := metha.MigrateCache(src, dst, opts)
+	if result != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if e := enc.Encode(result); e != nil {
+			log.Fatal(e)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	scheme := flag.String("scheme", "uuid", "identifier scheme: uuid, sha1 or sha256")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	baseURL := metha.PrependSchema(flag.Arg(0))
+
+	harvest := metha.Harvest{
+		BaseURL: baseURL,
+		Format:  *format,
+		Set:     *set,
+	}
+
+	minter, err := metha.NewMinter(*scheme)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if err := harvest.WriteMapping(w, minter); err != nil {
+		log.Fatal(err)
+	}
+}
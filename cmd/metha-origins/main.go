@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	rebuild := flag.Bool("rebuild", false, "partition all cached files by origin again")
+	files := flag.String("files", "", "list the files of the partition of this origin base URL")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	harvest := &metha.Harvest{
+		BaseURL: metha.PrependSchema(flag.Arg(0)),
+		Format:  *format,
+		Set:     *set,
+	}
+	if _, err := os.Stat(harvest.Dir()); err != nil {
+		log.Fatal(err)
+	}
+
+	if *rebuild {
+		if err := harvest.RebuildOrigins(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *files != "" {
+		for _, filename := range harvest.OriginFiles(*files) {
+			fmt.Println(filename)
+		}
+		return
+	}
+
+	origins, err := harvest.Origins()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, origin := range origins {
+		fmt.Printf("%s\t%d\t%s\n", origin, len(harvest.OriginFiles(origin)), harvest.OriginDir(origin))
+	}
+}
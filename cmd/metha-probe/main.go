@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print report as JSON")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+	report, err := metha.Probe(metha.PrependSchema(flag.Arg(0)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		fmt.Print(report)
+	}
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
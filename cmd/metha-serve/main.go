@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	addr := flag.String("addr", "localhost:8000", "address to listen on")
+	pageSize := flag.Int("page-size", metha.DefaultServePageSize, "records per response")
+	refresh := flag.Duration("refresh", 0, "index the cache again at this interval, e.g. 1h")
+	api := flag.Bool("api", false, "also serve all harvests of the cache as JSON under /api/")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	harvest := &metha.Harvest{
+		BaseURL: metha.PrependSchema(flag.Arg(0)),
+		Format:  *format,
+		Set:     *set,
+	}
+	if _, err := os.Stat(harvest.Dir()); err != nil {
+		log.Fatal(err)
+	}
+
+	server, err := metha.NewServer(harvest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.PageSize = *pageSize
+
+	if *refresh > 0 {
+		go func() {
+			for range time.Tick(*refresh) {
+				if err := server.Refresh(); err != nil {
+					log.Printf("refresh failed: %s", err)
+				}
+			}
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", server)
+	if *api {
+		mux.Handle("/api/", http.StripPrefix("/api", metha.NewCacheAPI()))
+		log.Printf("serving cache API on http://%s/api/harvests", *addr)
+	}
+	log.Printf("serving %s on http://%s", harvest.Dir(), *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -1,28 +1,136 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/miku/metha"
 )
 
+// runConfig harvests all endpoints from a config file or only the named ones,
+// returns the exit code.
+func runConfig(filename string, names []string) int {
+	config, err := metha.ReadConfig(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	selected := make(map[string]bool)
+	for _, name := range names {
+		selected[name] = true
+	}
+	var code int
+	for _, e := range config.Endpoints {
+		if len(selected) > 0 && !selected[e.Name] && !selected[e.URL] {
+			continue
+		}
+		harvest, err := config.Harvest(e)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("harvesting %s (%s)", e.Name, harvest.BaseURL)
+		switch err := harvest.Run(); err {
+		case nil, metha.ErrAlreadySynced:
+		case context.Canceled:
+			log.Println(err)
+			return 1
+		default:
+			log.Printf("%s: %s", harvest.BaseURL, err)
+			code = 1
+		}
+	}
+	return code
+}
+
 func main() {
 
-	format := flag.String("format", "oai_dc", "metadata format")
-	set := flag.String("set", "", "set name")
+	format := flag.String("format", "oai_dc", "metadata format, comma separated to harvest several formats in one run")
+	fallback := flag.String("fallback", "", "comma separated formats to try, if the endpoint does not support format")
+	set := flag.String("set", "", "set name, comma separated to harvest several sets in one run")
+	setInclude := flag.String("set-include", "", "comma separated patterns of sets to harvest, e.g. 'physics:*' or '/^phys/', lists the sets, if -set is empty")
+	setExclude := flag.String("set-exclude", "", "comma separated patterns of sets to skip, e.g. 'embargoed-*', lists the sets, if -set is empty")
 	showDir := flag.Bool("dir", false, "show target directory")
 	maxRequests := flag.Int("max", 1048576, "maximum number of token loops")
+	maxRecords := flag.Int("max-records", 0, "stop after this many records, 0 means no limit")
+	maxBytes := flag.Int64("max-bytes", 0, "stop after downloading this many bytes, 0 means no limit")
+	maxTotalRequests := flag.Int("max-total-requests", 0, "stop after this many requests in total, 0 means no limit")
 	disableSelectiveHarvesting := flag.Bool("no-intervals", false, "harvest in one go, for funny endpoints")
+	resumeWindow := flag.Duration("resume-window", 0, "with -no-intervals, continue an unfinished harvest within this duration, e.g. 24h")
+	autoDisableSelective := flag.Bool("auto-no-intervals", false, "harvest in one go, if the endpoint seems to ignore from and until")
 	ignoreHTTPErrors := flag.Bool("ignore-http-errors", false, "do not stop on HTTP errors, just skip to the next interval")
+	usePost := flag.Bool("post", false, "send requests with POST, for very long resumption tokens")
 	suppressFormatParameter := flag.Bool("suppress-format-parameter", false, "do not send format parameter")
 	version := flag.Bool("v", false, "show version")
 	daily := flag.Bool("daily", false, "use daily intervals for harvesting")
+	adaptive := flag.Bool("adaptive", false, "split intervals in halves, down to single days, if the endpoint fails on them")
 	from := flag.String("from", "", "set the start date, format: 2006-01-02, use only if you do not want the endpoints earliest date")
+	until := flag.String("until", "", "harvest only records up to this date, format: 2006-01-02")
+
+	progress := flag.Bool("progress", false, "log progress and estimated time remaining")
+	retries := flag.Int("retries", metha.DefaultMaxRetries, "maximum number of attempts for a failed request")
+	shard := flag.Bool("shard", false, "place files into YYYY/MM subdirectories")
+	naming := flag.String("naming", "base64", "naming of the harvest directory: base64 or readable")
+	compression := flag.String("compression", "gzip", "compression of cached files: gzip, zstd or none")
+	postChunk := flag.String("post-chunk", "", "command to run after each file is moved into place, {} is replaced by the path")
+	postRun := flag.String("post-run", "", "command to run after a successful harvest")
+	followMoved := flag.Bool("follow-moved", false, "switch to the new URL and move the cache, if the endpoint moved permanently")
+	userAgent := flag.String("user-agent", "", "user agent to send, defaults to metha and its version")
+	contact := flag.String("contact", "", "URL or email of the operator, added to the user agent")
+	validate := flag.String("validate", "", "check responses against the OAI-PMH and oai_dc schema rules: flag, quarantine or reject")
+	noQuirks := flag.Bool("no-quirks", false, "do not apply the known workarounds for problem endpoints")
+	sanitize := flag.String("sanitize", "", "repair broken responses, comma separated: utf8, control, entities, latin1 or all")
+	webhook := flag.String("webhook", "", "post start, interval, error and finish events as JSON to this URL")
+	stream := flag.Bool("stream", false, "write responses to disk as they arrive, for very large records")
+	reharvest := flag.String("reharvest", "", "purge and harvest again a date range, e.g. 2016-03-01,2016-03-31")
+	skipDeleted := flag.Bool("skip-deleted", false, "do not keep deleted records")
+	idPattern := flag.String("id-pattern", "", "only keep records with identifiers matching this regular expression")
+	index := flag.Bool("index", false, "maintain an index of record headers, see metha-index")
+	versions := flag.Bool("versions", false, "keep every record version in a content addressed store, see metha-versions")
+	partitionOrigins := flag.Bool("partition-origins", false, "sort records of an aggregator into a directory per origin, see metha-origins")
+	origin := flag.String("origin", "", "with -reharvest, replace only the records of this origin base URL in its partition")
+	keepCompressed := flag.Bool("keep-compressed", false, "store gzip compressed responses as sent, without compressing them again")
+	raw := flag.Bool("raw", false, "keep responses byte for byte as received, e.g. for legal deposit")
+	maxRecordSize := flag.Int64("max-record-size", 0, "skip records larger than this many bytes, implies -stream")
+	hostRate := flag.Float64("host-rate", 0, "limit requests per second to the endpoint host, shared by all harvests, 0 means no limit")
+	hostBurst := flag.Int("host-burst", 1, "requests allowed in a burst, with -host-rate")
+	maxResponseBytes := flag.Int64("max-response-bytes", 0, "fail on responses larger than this many bytes")
+
+	record := flag.String("record", "", "record all requests and responses to this file")
+	simulate := flag.String("simulate", "", "replay a recorded endpoint instead of sending requests")
+	speed := flag.Float64("speed", 1, "replay speed, when simulating")
 
 	logFile := flag.String("log", "", "filename to log to")
+	configFile := flag.String("config", "", "harvest endpoints from a config file, optionally only the named ones")
+	httpCache := flag.Bool("http-cache", false, "send conditional requests and skip unchanged intervals, if the endpoint supports it")
+	debug := flag.Bool("debug", false, "log full request URLs, response status and latency of each request")
+	debugDump := flag.Bool("debug-dump", false, "like -debug, and dump failing responses with headers and raw body into the debug directory of the harvest")
+	delay := flag.Duration("delay", 0, "minimum time between two requests")
+	maxBandwidth := flag.Int64("max-bandwidth", 0, "pause between requests to download at most this many bytes per second on average, 0 means no limit")
+	window := flag.String("window", "", "only send requests during this time of day, e.g. 22:00-06:00, in the given -timezone")
+	requestTimeout := flag.Duration("request-timeout", 0, "give up and retry a request after this long, default 5m")
+	stallTimeout := flag.Duration("stall-timeout", 0, "give up and retry a request, if no bytes arrive for this long, e.g. 1m")
+	lockWait := flag.Duration("lock-wait", 0, "wait this long for another metha-sync harvesting the same endpoint, format and set")
+	quotaOn := flag.String("quota-on", "", "status codes and OAI error codes or messages of an exhausted quota, e.g. 429,quota exceeded, defaults to 429,509")
+	quotaPause := flag.Duration("quota-pause", 0, "pause this long after a quota error, instead of failing, e.g. 12h")
+	quotaResumeAt := flag.String("quota-resume-at", "", "pause until this time of day after a quota error, e.g. 00:05, in the given -timezone")
+	onError := flag.String("on-error", "", "reaction to OAI error codes, e.g. noSetHierarchy=ignore,badResumptionToken=retry")
+	tokenRestarts := flag.Int("token-restarts", metha.DefaultTokenRestarts, "restart an interval this many times after an expired resumption token")
+	timezone := flag.String("timezone", "UTC", "time zone of interval boundaries, e.g. Europe/Berlin")
+	params := flag.String("params", "", "additional parameters to send with each request, e.g. apikey=secret&x=1")
+	granularity := flag.String("granularity", "", "use this granularity instead of the advertised one, YYYY-MM-DD or YYYY-MM-DDThh:mm:ssZ")
+	overlap := flag.Duration("overlap", 0, "start each interval earlier by this duration, e.g. 1h")
+	lagDays := flag.Int("lag-days", 0, "harvest this many days before the last harvest again, skipping cached records")
+	parallel := flag.Int("parallel", 1, "number of intervals to download concurrently")
+	plan := flag.Bool("plan", false, "show intervals and estimated requests, without harvesting")
 
 	flag.Parse()
 
@@ -31,20 +139,37 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *configFile != "" {
+		os.Exit(runConfig(*configFile, flag.Args()))
+	}
+
 	if flag.NArg() == 0 {
 		log.Fatal("endpoint required")
 	}
 
 	baseURL := metha.PrependSchema(flag.Arg(0))
 
+	n, err := metha.ParseNaming(*naming)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	formats := strings.Split(*format, ",")
+	sets := strings.Split(*set, ",")
+
 	if *showDir {
 		// showDir only needs these parameters
-		harvest := metha.Harvest{
-			BaseURL: baseURL,
-			Format:  *format,
-			Set:     *set,
+		for _, f := range formats {
+			for _, s := range sets {
+				harvest := metha.Harvest{
+					BaseURL: baseURL,
+					Format:  f,
+					Set:     s,
+					Naming:  n,
+				}
+				fmt.Println(harvest.Dir())
+			}
 		}
-		fmt.Println(harvest.Dir())
 		os.Exit(0)
 	}
 
@@ -57,29 +182,228 @@ func main() {
 
 	}
 
-	// NewHarvest ensures the endpoint is sane, before we start
-	harvest, err := metha.NewHarvest(baseURL)
+	c, err := metha.ParseCompression(*compression)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	harvest.From = *from
-	harvest.Format = *format
-	harvest.Set = *set
+	var transport http.RoundTripper
+	switch {
+	case *simulate != "":
+		f, err := os.Open(*simulate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		profile, err := metha.LoadProfile(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		transport = metha.NewSimulatedTransport(profile, *speed)
+		// simulated files must not end up in the real cache
+		metha.BaseDir, err = ioutil.TempDir("", "metha-simulate-")
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("simulating into %s", metha.BaseDir)
+	case *record != "":
+		f, err := os.Create(*record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		transport = metha.NewRecordingTransport(nil, f)
+	}
+
+	// Identify ensures the endpoint is sane, before we start; format and set
+	// locate the cache, in case it needs to move along with the endpoint
+	harvest := &metha.Harvest{
+		BaseURL:     baseURL,
+		Format:      formats[0],
+		Set:         sets[0],
+		Naming:      n,
+		Transport:   transport,
+		UsePost:     *usePost,
+		FollowMoved: *followMoved,
+		From:        *from,
+		Until:       *until,
+
+		ForceGranularity: *granularity,
+	}
+	if *params != "" {
+		v, err := url.ParseQuery(*params)
+		if err != nil {
+			log.Fatal(err)
+		}
+		harvest.RequestMiddleware = append(harvest.RequestMiddleware, metha.SetParams(v))
+	}
+	if len(formats) > 1 {
+		harvest.Formats = formats
+	}
+	if len(sets) > 1 {
+		harvest.Sets = sets
+	}
+	if *setInclude != "" {
+		harvest.SetInclude = strings.Split(*setInclude, ",")
+	}
+	if *setExclude != "" {
+		harvest.SetExclude = strings.Split(*setExclude, ",")
+	}
+	if *fallback != "" {
+		harvest.FormatFallbacks = strings.Split(*fallback, ",")
+	}
+	if harvest.ErrorActions, err = metha.ParseErrorActions(*onError); err != nil {
+		log.Fatal(err)
+	}
+	if harvest.Validate, err = metha.ParseValidationAction(*validate); err != nil {
+		log.Fatal(err)
+	}
+	if harvest.Sanitize, err = metha.ParseSanitize(*sanitize); err != nil {
+		log.Fatal(err)
+	}
 	harvest.MaxRequests = *maxRequests
+	harvest.MaxRecords = *maxRecords
+	harvest.TokenRestarts = *tokenRestarts
+	harvest.MaxBytes = *maxBytes
+	harvest.MaxTotalRequests = *maxTotalRequests
 	harvest.CleanBeforeDecode = true
 	harvest.DisableSelectiveHarvesting = *disableSelectiveHarvesting
+	harvest.AutoDisableSelective = *autoDisableSelective
 	harvest.MaxEmptyResponses = 10
 	harvest.IgnoreHTTPErrors = *ignoreHTTPErrors
 	harvest.SuppressFormatParameter = *suppressFormatParameter
+	harvest.UsePost = *usePost
 	harvest.DailyInterval = *daily
+	harvest.AdaptiveIntervals = *adaptive
+	harvest.Delay = *delay
+	harvest.MaxBandwidth = *maxBandwidth
+	if *window != "" {
+		if harvest.Window, err = metha.ParseWindow(*window); err != nil {
+			log.Fatal(err)
+		}
+	}
+	harvest.RequestTimeout = *requestTimeout
+	harvest.StallTimeout = *stallTimeout
+	harvest.LockWait = *lockWait
+	harvest.Quota.StatusCodes, harvest.Quota.Errors = metha.ParseQuotaErrors(*quotaOn)
+	harvest.Quota.Pause = *quotaPause
+	harvest.Quota.ResumeAt = *quotaResumeAt
+	if err := harvest.Quota.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	harvest.ResumeWindow = *resumeWindow
+	harvest.Parallel = *parallel
+	harvest.Overlap = *overlap
+	harvest.LagDays = *lagDays
+	if harvest.Timezone, err = time.LoadLocation(*timezone); err != nil {
+		log.Fatal(err)
+	}
+	harvest.HTTPCache = *httpCache
+	harvest.Debug = *debug
+	harvest.DebugDump = *debugDump
+	harvest.Compression = c
+	harvest.Naming = n
+	harvest.Shard = *shard
+	harvest.StreamResponses = *stream
+	harvest.RawMode = *raw
+	harvest.KeepCompressed = *keepCompressed
+	harvest.HeaderIndex = *index
+	harvest.VersionStore = *versions
+	harvest.PartitionByOrigin = *partitionOrigins
+	var filters []metha.RecordFilter
+	if *skipDeleted {
+		filters = append(filters, metha.NotDeleted)
+	}
+	if *idPattern != "" {
+		re, err := regexp.Compile(*idPattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		filters = append(filters, metha.IdentifierMatches(re))
+	}
+	if len(filters) > 0 {
+		harvest.RecordFilter = metha.AllFilters(filters...)
+	}
+	harvest.MaxRecordSize = *maxRecordSize
+	harvest.MaxResponseBytes = *maxResponseBytes
+	if *hostRate > 0 {
+		harvest.HostLimiter = metha.NewSharedHostLimiter(filepath.Join(metha.BaseDir, metha.RateLimitDir), *hostRate, *hostBurst)
+	}
+	if *progress {
+		harvest.Progress = func(p metha.ProgressInfo) {
+			log.Printf("progress: interval %d/%d, %d records, %.1f%%, %s remaining",
+				p.IntervalIndex+1, p.IntervalCount, p.TotalRecords, p.Fraction*100,
+				p.Remaining.Truncate(time.Second))
+		}
+	}
+	harvest.RetryPolicy = metha.DefaultRetryPolicy
+	harvest.RetryPolicy.MaxAttempts = *retries
+	harvest.PostChunkCommand = *postChunk
+	harvest.PostRunCommand = *postRun
+	switch {
+	case *userAgent != "":
+		harvest.UserAgent = *userAgent
+	case *contact != "":
+		harvest.UserAgent = metha.UserAgent(*contact)
+	}
+	if *webhook != "" {
+		harvest.OnEvent = metha.WebhookEvents(*webhook)
+	}
+	if !*noQuirks {
+		harvest.ApplyQuirks()
+	}
+
+	// fail early on dates, before any request; Identify is sent with the
+	// complete configuration, since it may move the caches of all formats
+	// and sets to a new base URL
+	if err := harvest.ValidateDates(); err != nil {
+		log.Fatal(err)
+	}
+	previousURL := harvest.BaseURL
+	if err := harvest.IdentifyContext(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if !*noQuirks && harvest.BaseURL != previousURL {
+		harvest.ApplyQuirks()
+	}
 
 	log.Printf("harvest: %+v", harvest)
 
+	if *plan {
+		p, err := harvest.Plan()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, iv := range p.Intervals {
+			fmt.Println(iv)
+		}
+		fmt.Printf("dir: %s\nintervals: %d\nrecords: %d\nrequests: %d\nduration: %s\n",
+			p.Dir, len(p.Intervals), p.Records, p.Requests, p.Duration.Truncate(time.Second))
+		os.Exit(0)
+	}
+
+	if *reharvest != "" {
+		parts := strings.Split(*reharvest, ",")
+		if len(parts) != 2 {
+			log.Fatal("reharvest range must be FROM,UNTIL")
+		}
+		if *origin != "" {
+			if err := harvest.ReharvestOrigin(*origin, parts[0], parts[1]); err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(0)
+		}
+		if err := harvest.ReharvestRange(parts[0], parts[1]); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
 	if err := harvest.Run(); err != nil {
-		if err == metha.ErrAlreadySynced {
+		switch err {
+		case metha.ErrAlreadySynced, context.Canceled:
 			log.Println(err)
-		} else {
+		default:
 			log.Fatal(err)
 		}
 	}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	cold := flag.String("cold", "", "cold storage directory")
+	months := flag.Int("months", 24, "move files older than this number of months")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+	if *cold == "" {
+		log.Fatal("cold storage directory required")
+	}
+
+	baseURL := metha.PrependSchema(flag.Arg(0))
+
+	harvest := metha.Harvest{
+		BaseURL: baseURL,
+		Format:  *format,
+		Set:     *set,
+	}
+
+	stubs, err := harvest.Tier(metha.DirColdStore{Dir: *cold}, *months)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, fn := range stubs {
+		fmt.Println(fn)
+	}
+}
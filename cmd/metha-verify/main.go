@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	version := flag.Bool("v", false, "show version")
+	fsck := flag.Bool("fsck", false, "verify files against their checksums instead")
+	writeChecksums := flag.Bool("write-checksums", false, "write missing checksums, e.g. for caches created by older versions")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	baseURL := metha.PrependSchema(flag.Arg(0))
+
+	harvest := metha.Harvest{
+		BaseURL: baseURL,
+		Format:  *format,
+		Set:     *set,
+	}
+
+	switch {
+	case *writeChecksums:
+		n, err := harvest.WriteChecksums()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%d checksums written", n)
+	case *fsck:
+		result, err := harvest.Fsck()
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		if !result.OK() {
+			os.Exit(1)
+		}
+	default:
+		result, err := harvest.Verify()
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		if !result.OK() {
+			os.Exit(1)
+		}
+	}
+}
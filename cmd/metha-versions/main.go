@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/metha"
+)
+
+func main() {
+	format := flag.String("format", "oai_dc", "metadata format")
+	set := flag.String("set", "", "set name")
+	rebuild := flag.Bool("rebuild", false, "add the versions of all cached files to the version store")
+	id := flag.String("id", "", "list the versions of the record with this identifier")
+	at := flag.String("at", "", "print the record with -id as it looked on this date, e.g. 2016-01-31")
+	version := flag.Bool("v", false, "show version")
+
+	flag.Parse()
+
+	if *version {
+		fmt.Println(metha.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() == 0 {
+		log.Fatal("endpoint required")
+	}
+
+	harvest := &metha.Harvest{
+		BaseURL: metha.PrependSchema(flag.Arg(0)),
+		Format:  *format,
+		Set:     *set,
+	}
+	if _, err := os.Stat(harvest.Dir()); err != nil {
+		log.Fatal(err)
+	}
+
+	if *rebuild {
+		if err := harvest.RebuildVersions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *id == "" {
+		if !*rebuild {
+			log.Fatal("identifier required, use -id")
+		}
+		os.Exit(0)
+	}
+
+	if *at != "" {
+		rec, err := harvest.RecordOn(*id, *at)
+		if errors.Is(err, metha.ErrNoVersion) {
+			log.Fatalf("%s did not exist on %s", *id, *at)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := xml.Marshal(rec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	versions, err := harvest.RecordVersions(*id)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(versions) == 0 {
+		log.Fatalf("%s not found", *id)
+	}
+	for _, v := range versions {
+		status := v.Hash
+		if v.Deleted {
+			status = "deleted"
+		}
+		fmt.Printf("%s\t%s\n", v.DateStamp, status)
+	}
+}
@@ -0,0 +1,385 @@
+package metha
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CompactMode determines, which files are merged together.
+type CompactMode int
+
+const (
+	// CompactMonthly merges all files of a month.
+	CompactMonthly CompactMode = iota
+	// CompactAll merges all files of a harvest into a single file.
+	CompactAll
+)
+
+// IndexSuffix is appended to a compacted file to name its index.
+const IndexSuffix = ".idx"
+
+const (
+	// compactedSuffix marks originals, while the merged file is moved into
+	// place.
+	compactedSuffix = ".compacted"
+	// compactTempSuffix marks the merged file and its index, while written.
+	compactTempSuffix = "-tmp-compact"
+)
+
+// IndexEntry locates a record inside a compacted file. Each original file
+// becomes a separate gzip member or zstd frame, Offset and Length describe
+// that member.
+type IndexEntry struct {
+	Identifier string
+	DateStamp  string
+	Filename   string
+	Offset     int64
+	Length     int64
+}
+
+// Record reads the member from the compacted file and returns the record.
+func (e IndexEntry) Record() (*Record, error) {
+	f, err := os.Open(e.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := decompressReader(io.NewSectionReader(f, e.Offset, e.Length), compressionFromFilename(e.Filename))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+
+	for {
+		var resp Response
+		if err := dec.Decode(&resp); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		for _, rec := range resp.ListRecords.Records {
+			if rec.Header.Identifier == e.Identifier {
+				return &rec, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("record %s not found in %s", e.Identifier, e.Filename)
+}
+
+// ReadIndex reads the index of a compacted file.
+func ReadIndex(filename string) ([]IndexEntry, error) {
+	f, err := os.Open(filename + IndexSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []IndexEntry
+	br := bufio.NewScanner(f)
+	for br.Scan() {
+		fields := strings.Split(br.Text(), "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid index line: %s", br.Text())
+		}
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, IndexEntry{
+			Identifier: fields[0],
+			DateStamp:  fields[1],
+			Filename:   filename,
+			Offset:     offset,
+			Length:     length,
+		})
+	}
+	return entries, br.Err()
+}
+
+// compactGroups groups files by month or puts them all into a single group.
+// Files with different compression are never grouped together.
+func compactGroups(files []string, mode CompactMode) [][]string {
+	byKey := make(map[string][]string)
+	var keys []string
+	sort.Strings(files)
+	for _, fn := range files {
+		m := chunkPattern.FindStringSubmatch(filepath.Base(fn))
+		if len(m) < 2 {
+			continue
+		}
+		k := string(compressionFromFilename(fn))
+		if mode == CompactMonthly {
+			k = m[1][:7] + k
+		}
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], fn)
+	}
+	sort.Strings(keys)
+	var groups [][]string
+	for _, k := range keys {
+		groups = append(groups, byKey[k])
+	}
+	return groups
+}
+
+// Compact merges cached files into fewer, larger files. The gzip members or
+// zstd frames of the original files are concatenated, so no recompression is
+// necessary and each original file stays addressable by offset. The merged file is named
+// after the latest date of its group, so the last harvested date stays the
+// same. An index of identifiers and member offsets is written next to it.
+// Returns the names of the compacted files. Like a run, a compaction holds
+// the lock of the harvest directory, an interrupted compaction is completed
+// or undone on the next run, see recoverCompact.
+func (h *Harvest) Compact(mode CompactMode) ([]string, error) {
+	l, err := h.lock(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer l.unlock()
+	if err := h.recover(); err != nil {
+		return nil, err
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	var compacted []string
+	for _, group := range compactGroups(h.Files(), mode) {
+		if len(group) < 2 {
+			continue
+		}
+		dst, err := compactGroup(group)
+		if err != nil {
+			return compacted, err
+		}
+		compacted = append(compacted, dst)
+	}
+	// records moved to other files and positions
+	if len(compacted) > 0 && h.hasHeaderIndex() {
+		return compacted, h.RebuildHeaderIndex()
+	}
+	return compacted, nil
+}
+
+// compactGroup merges a sorted list of files into a single file. Originals are
+// first renamed to *.compacted, then the merged file and its index are moved
+// into place and the originals are removed.
+func compactGroup(group []string) (string, error) {
+	last := filepath.Base(group[len(group)-1])
+	date := chunkPattern.FindStringSubmatch(last)[1]
+	ext := compressionFromFilename(last).Extension()
+	dst := filepath.Join(filepath.Dir(group[len(group)-1]), fmt.Sprintf("%s-%08d.xml%s", date, 0, ext))
+	tmp := dst + compactTempSuffix
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	idx, err := os.Create(tmp + IndexSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer idx.Close()
+
+	w := bufio.NewWriter(idx)
+	var offset int64
+	for _, fn := range group {
+		entries, n, err := appendMember(f, fn)
+		if err != nil {
+			return "", err
+		}
+		for _, e := range entries {
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", e.Identifier, e.DateStamp, offset+e.Offset, e.Length); err != nil {
+				return "", err
+			}
+		}
+		offset += n
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+	// open files cannot be renamed on windows
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := idx.Close(); err != nil {
+		return "", err
+	}
+	for _, fn := range group {
+		if err := os.Rename(fn, fn+compactedSuffix); err != nil {
+			return "", err
+		}
+	}
+	sum, err := fileChecksum(tmp)
+	if err != nil {
+		return "", err
+	}
+	if err := writeChecksum(dst, sum); err != nil {
+		return "", err
+	}
+	// the merged file is in place, once the temporary file is gone
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp+IndexSuffix, dst+IndexSuffix); err != nil {
+		return "", err
+	}
+	for _, fn := range group {
+		if err := removeCompacted(fn, dst); err != nil {
+			return "", err
+		}
+	}
+	return dst, nil
+}
+
+// removeCompacted removes an original, after its group was merged into dst.
+func removeCompacted(fn, dst string) error {
+	if err := os.Remove(fn + compactedSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if fn == dst {
+		return nil
+	}
+	if err := removeChecksum(fn); err != nil {
+		return err
+	}
+	// index of a previously compacted file
+	if err := os.Remove(fn + IndexSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// recoverCompact completes or undoes an interrupted compaction. As long as
+// the merged file is not in place, the originals are restored and partial
+// merged files removed, otherwise the originals are removed.
+func (h *Harvest) recoverCompact() error {
+	originals := h.glob("*" + compactedSuffix)
+	var merged []string
+	for _, tmp := range h.glob("*" + compactTempSuffix) {
+		merged = append(merged, strings.TrimSuffix(tmp, compactTempSuffix))
+	}
+	if len(merged) > 0 {
+		for _, fn := range originals {
+			orig := strings.TrimSuffix(fn, compactedSuffix)
+			if err := os.Rename(fn, orig); err != nil {
+				return err
+			}
+			// the checksum may have been replaced by the one of the merged file
+			sum, err := fileChecksum(orig)
+			if err != nil {
+				return err
+			}
+			if err := writeChecksum(orig, sum); err != nil {
+				return err
+			}
+		}
+		for _, dst := range merged {
+			for _, tmp := range []string{dst + compactTempSuffix, dst + compactTempSuffix + IndexSuffix} {
+				if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+		}
+		if len(originals) > 0 {
+			log.Printf("restored %d files of an interrupted compaction", len(originals))
+		}
+		return nil
+	}
+	for _, tmp := range h.glob("*" + compactTempSuffix + IndexSuffix) {
+		dst := strings.TrimSuffix(tmp, compactTempSuffix+IndexSuffix)
+		if err := os.Rename(tmp, dst+IndexSuffix); err != nil {
+			return err
+		}
+	}
+	for _, fn := range originals {
+		orig := strings.TrimSuffix(fn, compactedSuffix)
+		dst := ""
+		if _, err := os.Stat(orig); err == nil {
+			// the merged file took the name of the original
+			dst = orig
+		}
+		if err := removeCompacted(orig, dst); err != nil {
+			return err
+		}
+	}
+	if len(originals) > 0 {
+		log.Printf("completed an interrupted compaction, removed %d files", len(originals))
+	}
+	return nil
+}
+
+// appendMember copies a compressed file to w and returns the record headers found in
+// it, together with the number of bytes written. Offsets of the entries are
+// relative to the start of the file. If the file has been compacted before,
+// its index is reused.
+func appendMember(w io.Writer, filename string) ([]IndexEntry, int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	if entries, err := ReadIndex(filename); err == nil {
+		n, err := io.Copy(w, f)
+		return entries, n, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r, err := decompressReader(f, compressionFromFilename(filename))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	var entries []IndexEntry
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	for {
+		var resp Response
+		if err := dec.Decode(&resp); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		for _, rec := range resp.ListRecords.Records {
+			entries = append(entries, IndexEntry{
+				Identifier: rec.Header.Identifier,
+				DateStamp:  rec.Header.DateStamp,
+				Length:     fi.Size(),
+			})
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(w, f)
+	return entries, n, err
+}
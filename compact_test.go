@@ -0,0 +1,228 @@
+package metha
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-compact-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	// file and identifiers of its records
+	files := []struct {
+		name string
+		ids  []string
+	}{
+		{"2016-01-10-00000000.xml.gz", []string{"a"}},
+		{"2016-01-20-00000000.xml.gz", []string{"b", "c"}},
+		{"2016-02-05-00000000.xml.gz", []string{"d"}},
+		{"2016-02-15-00000000.xml.gz", []string{"e"}},
+		{"2016-02-20-00000000.xml.zst", []string{"f"}},
+	}
+	var cases = []struct {
+		about     string
+		modes     []CompactMode
+		compacted []string
+		files     []string
+	}{
+		{"monthly", []CompactMode{CompactMonthly},
+			[]string{"2016-01-20-00000000.xml.gz", "2016-02-15-00000000.xml.gz"},
+			[]string{"2016-01-20-00000000.xml.gz", "2016-02-15-00000000.xml.gz", "2016-02-20-00000000.xml.zst"}},
+		{"all", []CompactMode{CompactAll},
+			[]string{"2016-02-15-00000000.xml.gz"},
+			[]string{"2016-02-15-00000000.xml.gz", "2016-02-20-00000000.xml.zst"}},
+		{"compacted files are compacted again", []CompactMode{CompactMonthly, CompactAll},
+			[]string{"2016-02-15-00000000.xml.gz"},
+			[]string{"2016-02-15-00000000.xml.gz", "2016-02-20-00000000.xml.zst"}},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			var doc strings.Builder
+			doc.WriteString(`<OAI-PMH><ListRecords>`)
+			for _, id := range f.ids {
+				doc.WriteString(`<record><header><identifier>` + id + `</identifier><datestamp>` + f.name[:10] + `</datestamp></header></record>`)
+			}
+			doc.WriteString(`</ListRecords></OAI-PMH>`)
+			src := filepath.Join(dir, "src.xml")
+			if err := ioutil.WriteFile(src, []byte(doc.String()), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := MoveAndCompress(src, filepath.Join(h.Dir(), f.name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var compacted []string
+		for _, mode := range c.modes {
+			if compacted, err = h.Compact(mode); err != nil {
+				t.Fatalf("%s: %v", c.about, err)
+			}
+		}
+		var got []string
+		for _, fn := range compacted {
+			got = append(got, filepath.Base(fn))
+		}
+		if strings.Join(got, " ") != strings.Join(c.compacted, " ") {
+			t.Errorf("%s: compacted %v, want %v", c.about, got, c.compacted)
+		}
+		got = nil
+		for _, fn := range h.Files() {
+			got = append(got, filepath.Base(fn))
+		}
+		if strings.Join(got, " ") != strings.Join(c.files, " ") {
+			t.Errorf("%s: got files %v, want %v", c.about, got, c.files)
+		}
+		if rest, _ := filepath.Glob(filepath.Join(h.Dir(), "*compact*")); len(rest) > 0 {
+			t.Errorf("%s: left over %v", c.about, rest)
+		}
+		// all records are still there, in order
+		var ids []string
+		if err := h.EachRecord(func(rec Record) error {
+			ids = append(ids, rec.Header.Identifier)
+			return nil
+		}); err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if strings.Join(ids, " ") != "a b c d e f" {
+			t.Errorf("%s: got records %v", c.about, ids)
+		}
+		// and addressable through the index
+		var indexed int
+		for _, fn := range compacted {
+			entries, err := ReadIndex(fn)
+			if err != nil {
+				t.Fatalf("%s: %v", c.about, err)
+			}
+			for _, e := range entries {
+				rec, err := e.Record()
+				if err != nil || rec.Header.Identifier != e.Identifier || rec.Header.DateStamp != e.DateStamp {
+					t.Errorf("%s: index entry %s: got %v, %v", c.about, e.Identifier, rec, err)
+				}
+				indexed++
+			}
+		}
+		if want := len(ids) - 1; indexed != want {
+			t.Errorf("%s: got %d indexed records, want %d", c.about, indexed, want)
+		}
+		if result, err := h.Fsck(); err != nil || !result.OK() {
+			t.Errorf("%s: checksums do not match: %v %+v", c.about, err, result)
+		}
+	}
+}
+
+func TestCompactRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-compact-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	names := []string{"2016-01-10-00000000.xml.gz", "2016-01-20-00000000.xml.gz"}
+	var cases = []struct {
+		about string
+		// merged tells, whether the merged file was moved into place
+		merged bool
+		files  []string
+	}{
+		{"merged file not in place", false, names},
+		{"merged file in place", true, names[1:]},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		originals := make(map[string][]byte)
+		for i, name := range names {
+			doc := `<OAI-PMH><ListRecords><record><header><identifier>` + string('a'+rune(i)) +
+				`</identifier><datestamp>` + name[:10] + `</datestamp></header></record></ListRecords></OAI-PMH>`
+			src := filepath.Join(dir, "src.xml")
+			if err := ioutil.WriteFile(src, []byte(doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fn := filepath.Join(h.Dir(), name)
+			if err := MoveAndCompress(src, fn); err != nil {
+				t.Fatal(err)
+			}
+			if originals[fn], err = ioutil.ReadFile(fn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dst := filepath.Join(h.Dir(), names[1])
+		if c.merged {
+			// interrupted after the merged file was moved into place
+			if _, err := h.Compact(CompactMonthly); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(dst+IndexSuffix, dst+compactTempSuffix+IndexSuffix); err != nil {
+				t.Fatal(err)
+			}
+			for fn, b := range originals {
+				if err := ioutil.WriteFile(fn+compactedSuffix, b, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writeChecksum(filepath.Join(h.Dir(), names[0]), []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			// interrupted after the originals were renamed
+			for fn := range originals {
+				if err := os.Rename(fn, fn+compactedSuffix); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, fn := range []string{dst + compactTempSuffix, dst + compactTempSuffix + IndexSuffix} {
+				if err := ioutil.WriteFile(fn, []byte("partial"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writeChecksum(dst, []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := h.recover(); err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		var got []string
+		for _, fn := range h.Files() {
+			got = append(got, filepath.Base(fn))
+		}
+		if strings.Join(got, " ") != strings.Join(c.files, " ") {
+			t.Errorf("%s: got files %v, want %v", c.about, got, c.files)
+		}
+		if rest, _ := filepath.Glob(filepath.Join(h.Dir(), "*compact*")); len(rest) > 0 {
+			t.Errorf("%s: left over %v", c.about, rest)
+		}
+		var ids []string
+		if err := h.EachRecord(func(rec Record) error {
+			ids = append(ids, rec.Header.Identifier)
+			return nil
+		}); err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if strings.Join(ids, " ") != "a b" {
+			t.Errorf("%s: got records %v", c.about, ids)
+		}
+		if result, err := h.Fsck(); err != nil || !result.OK() {
+			t.Errorf("%s: checksums do not match: %v %+v", c.about, err, result)
+		}
+		if _, err := ReadIndex(dst); c.merged && err != nil {
+			t.Errorf("%s: index of merged file: %v", c.about, err)
+		}
+	}
+}
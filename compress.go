@@ -0,0 +1,193 @@
+package metha
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
+)
+
+// Compression of cached files.
+type Compression string
+
+const (
+	// CompressionGzip is the default.
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd uses zstandard, smaller and faster to decompress.
+	CompressionZstd Compression = "zstd"
+	// CompressionNone stores plain XML.
+	CompressionNone Compression = "none"
+)
+
+// ParseCompression returns the compression for a name, empty string means gzip.
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case "", CompressionGzip:
+		return CompressionGzip, nil
+	case CompressionZstd, CompressionNone:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compression: %s", s)
+}
+
+// Extension returns the filename extension for cached files.
+func (c Compression) Extension() string {
+	switch c {
+	case CompressionZstd:
+		return ".zst"
+	case CompressionNone:
+		return ""
+	}
+	return ".gz"
+}
+
+// chunkExtensions are the extensions of all cached files.
+var chunkExtensions = []string{".xml.gz", ".xml.zst", ".xml"}
+
+// IsChunk returns true, if the filename looks like a cached file, compressed
+// or not, or a stub of a file in cold storage.
+func IsChunk(filename string) bool {
+	return fnPattern.MatchString(filename)
+}
+
+// compressionFromFilename determines compression by extension, stubs of
+// files in cold storage are handled as well.
+func compressionFromFilename(filename string) Compression {
+	filename = strings.TrimSuffix(filename, StubSuffix)
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		return CompressionGzip
+	case strings.HasSuffix(filename, ".zst"):
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// compressWriter wraps a writer with a compressor.
+func compressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	}
+	return gzip.NewWriter(w), nil
+}
+
+// decompressReader wraps a reader with a decompressor. Concatenated streams are
+// read as one.
+func decompressReader(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case CompressionZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case CompressionNone:
+		return ioutil.NopCloser(r), nil
+	}
+	return gzip.NewReader(r)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// chunkReader decompresses and closes the underlying file.
+type chunkReader struct {
+	io.ReadCloser
+	file io.Closer
+}
+
+func (r chunkReader) Close() error {
+	if err := r.ReadCloser.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// NewChunkReader returns a reader over the decompressed content of a cached
+// file, which may be in cold storage. Compression is detected by extension.
+func NewChunkReader(filename string) (io.ReadCloser, error) {
+	f, err := OpenChunk(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompressReader(f, compressionFromFilename(filename))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return chunkReader{ReadCloser: r, file: f}, nil
+}
+
+// MoveAndCompress will move src to dst, compressing in the process. The
+// compression is chosen by the extension of dst. The source is only removed
+// after dst has been completely written. A checksum sidecar is written for dst.
+// Gzip compressed sources are copied as they are to gzip destinations.
+func MoveAndCompress(src, dst string) error {
+	tmp := dst + newTempSuffix("-tmp-")
+	sum, err := compressFile(src, tmp, compressionFromFilename(dst))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := writeChecksum(dst, sum); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// compressFile writes src to dst with a compression and syncs dst to disk.
+// It returns the SHA-256 of dst. The source is kept.
+func compressFile(src, dst string, c Compression) ([]byte, error) {
+	f, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ff, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+
+	hash := sha256.New()
+	br := bufio.NewReader(ff)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b && c == CompressionGzip {
+		// already compressed, e.g. kept as sent by the server
+		c = CompressionNone
+	}
+	w, err := compressWriter(io.MultiWriter(f, hash), c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, br); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	// open files cannot be renamed or removed on windows
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
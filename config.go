@@ -0,0 +1,373 @@
+package metha
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// EndpointConfig holds the options of a single endpoint in a config file.
+// Unset values are taken from the defaults section. Boolean options are
+// pointers, so a default can be switched off per endpoint.
+type EndpointConfig struct {
+	Name                       string   `yaml:"name"`
+	URL                        string   `yaml:"url"`
+	Format                     string   `yaml:"format"`
+	FormatFallbacks            []string `yaml:"format-fallbacks"`
+	Formats                    []string `yaml:"formats"`
+	Set                        string   `yaml:"set"`
+	Sets                       []string `yaml:"sets"`
+	SetInclude                 []string `yaml:"set-include"`
+	SetExclude                 []string `yaml:"set-exclude"`
+	From                       string   `yaml:"from"`
+	Until                      string   `yaml:"until"`
+	MaxRequests                int      `yaml:"max-requests"`
+	MaxEmptyResponses          int      `yaml:"max-empty-responses"`
+	MaxRecords                 int      `yaml:"max-records"`
+	MaxBytes                   int64    `yaml:"max-bytes"`
+	MaxTotalRequests           int      `yaml:"max-total-requests"`
+	Retries                    int      `yaml:"retries"`
+	TokenRestarts              *int     `yaml:"token-restarts"`
+	Delay                      string   `yaml:"delay"`
+	Window                     string   `yaml:"window"`
+	MaxBandwidth               int64    `yaml:"max-bandwidth"`
+	LockWait                   string   `yaml:"lock-wait"`
+	ResumeWindow               string   `yaml:"resume-window"`
+	QuotaOn                    string   `yaml:"quota-on"`
+	QuotaPause                 string   `yaml:"quota-pause"`
+	QuotaResumeAt              string   `yaml:"quota-resume-at"`
+	Timezone                   string   `yaml:"timezone"`
+	Granularity                string   `yaml:"granularity"`
+	Overlap                    string   `yaml:"overlap"`
+	RequestTimeout             string   `yaml:"request-timeout"`
+	StallTimeout               string   `yaml:"stall-timeout"`
+	LagDays                    int      `yaml:"lag-days"`
+	Compression                string   `yaml:"compression"`
+	Naming                     string   `yaml:"naming"`
+	Shard                      *bool    `yaml:"shard"`
+	Daily                      *bool    `yaml:"daily"`
+	DisableSelectiveHarvesting *bool    `yaml:"no-intervals"`
+	AutoDisableSelective       *bool    `yaml:"auto-no-intervals"`
+	AdaptiveIntervals          *bool    `yaml:"adaptive-intervals"`
+	SuppressFormatParameter    *bool    `yaml:"suppress-format-parameter"`
+	UsePost                    *bool    `yaml:"post"`
+	IgnoreHTTPErrors           *bool    `yaml:"ignore-http-errors"`
+	CleanBeforeDecode          *bool    `yaml:"clean-before-decode"`
+	StreamResponses            *bool    `yaml:"stream"`
+	RawMode                    *bool    `yaml:"raw"`
+	KeepCompressed             *bool    `yaml:"keep-compressed"`
+	HeaderIndex                *bool    `yaml:"index"`
+	VersionStore               *bool    `yaml:"versions"`
+	PartitionByOrigin          *bool    `yaml:"partition-origins"`
+	SkipDeleted                *bool    `yaml:"skip-deleted"`
+	IdentifierPattern          string   `yaml:"id-pattern"`
+	HTTPCache                  *bool    `yaml:"http-cache"`
+	FollowMoved                *bool    `yaml:"follow-moved"`
+	Debug                      *bool    `yaml:"debug"`
+	DebugDump                  *bool    `yaml:"debug-dump"`
+	MaxRecordSize              int64    `yaml:"max-record-size"`
+	MaxResponseBytes           int64    `yaml:"max-response-bytes"`
+	HostRate                   float64  `yaml:"host-rate"`
+	HostBurst                  int      `yaml:"host-burst"`
+	Parallel                   int      `yaml:"parallel"`
+	PostChunkCommand           string   `yaml:"post-chunk"`
+	PostRunCommand             string   `yaml:"post-run"`
+	Webhook                    string   `yaml:"webhook"`
+	UserAgent                  string   `yaml:"user-agent"`
+	Contact                    string   `yaml:"contact"`
+	Validate                   string   `yaml:"validate"`
+	Sanitize                   string   `yaml:"sanitize"`
+	Quirks                     *bool    `yaml:"quirks"`
+	// OnError maps OAI error codes to abort, ignore or retry.
+	OnError map[string]string `yaml:"on-error"`
+	// Params are sent with each request, e.g. an API key.
+	Params map[string]string `yaml:"params"`
+}
+
+// Config lists endpoints to harvest, e.g.
+//
+//	defaults:
+//	  format: oai_dc
+//	  delay: 1s
+//	endpoints:
+//	  - name: arxiv
+//	    url: http://export.arxiv.org/oai2
+//	    daily: true
+//	  - name: copac
+//	    url: http://copac.jisc.ac.uk/oai-pmh
+//	    suppress-format-parameter: true
+type Config struct {
+	Defaults  EndpointConfig   `yaml:"defaults"`
+	Endpoints []EndpointConfig `yaml:"endpoints"`
+}
+
+// ReadConfig reads a YAML (or JSON) config file.
+func ReadConfig(filename string) (*Config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	names := make(map[string]bool)
+	for i, e := range c.Endpoints {
+		if e.URL == "" {
+			return nil, fmt.Errorf("%s: endpoint %d: url required", filename, i+1)
+		}
+		if e.Name == "" {
+			continue
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("%s: duplicate endpoint name: %s", filename, e.Name)
+		}
+		names[e.Name] = true
+	}
+	return &c, nil
+}
+
+// LoadConfig reads a config file and returns a harvest for each endpoint.
+// No requests are made, harvests run Identify on their first run.
+func LoadConfig(filename string) ([]*Harvest, error) {
+	c, err := ReadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	var harvests []*Harvest
+	for _, e := range c.Endpoints {
+		h, err := c.Harvest(e)
+		if err != nil {
+			return nil, err
+		}
+		harvests = append(harvests, h)
+	}
+	return harvests, nil
+}
+
+// Harvest returns a harvest for an endpoint, with defaults applied.
+func (c *Config) Harvest(e EndpointConfig) (*Harvest, error) {
+	d := c.Defaults
+	str := func(v, def string) string {
+		if v != "" {
+			return v
+		}
+		return def
+	}
+	num := func(v, def int) int {
+		if v != 0 {
+			return v
+		}
+		return def
+	}
+	flag := func(v, def *bool, fallback bool) bool {
+		switch {
+		case v != nil:
+			return *v
+		case def != nil:
+			return *def
+		}
+		return fallback
+	}
+
+	h := &Harvest{
+		BaseURL:                    PrependSchema(e.URL),
+		Format:                     str(e.Format, str(d.Format, "oai_dc")),
+		FormatFallbacks:            e.FormatFallbacks,
+		Set:                        str(e.Set, d.Set),
+		From:                       str(e.From, d.From),
+		Until:                      str(e.Until, d.Until),
+		MaxRequests:                num(e.MaxRequests, num(d.MaxRequests, 1048576)),
+		MaxEmptyResponses:          num(e.MaxEmptyResponses, num(d.MaxEmptyResponses, 10)),
+		Shard:                      flag(e.Shard, d.Shard, false),
+		DailyInterval:              flag(e.Daily, d.Daily, false),
+		DisableSelectiveHarvesting: flag(e.DisableSelectiveHarvesting, d.DisableSelectiveHarvesting, false),
+		AutoDisableSelective:       flag(e.AutoDisableSelective, d.AutoDisableSelective, false),
+		AdaptiveIntervals:          flag(e.AdaptiveIntervals, d.AdaptiveIntervals, false),
+		SuppressFormatParameter:    flag(e.SuppressFormatParameter, d.SuppressFormatParameter, false),
+		UsePost:                    flag(e.UsePost, d.UsePost, false),
+		IgnoreHTTPErrors:           flag(e.IgnoreHTTPErrors, d.IgnoreHTTPErrors, false),
+		CleanBeforeDecode:          flag(e.CleanBeforeDecode, d.CleanBeforeDecode, true),
+		StreamResponses:            flag(e.StreamResponses, d.StreamResponses, false),
+		RawMode:                    flag(e.RawMode, d.RawMode, false),
+		KeepCompressed:             flag(e.KeepCompressed, d.KeepCompressed, false),
+		HeaderIndex:                flag(e.HeaderIndex, d.HeaderIndex, false),
+		VersionStore:               flag(e.VersionStore, d.VersionStore, false),
+		PartitionByOrigin:          flag(e.PartitionByOrigin, d.PartitionByOrigin, false),
+		HTTPCache:                  flag(e.HTTPCache, d.HTTPCache, false),
+		FollowMoved:                flag(e.FollowMoved, d.FollowMoved, false),
+		Debug:                      flag(e.Debug, d.Debug, false),
+		DebugDump:                  flag(e.DebugDump, d.DebugDump, false),
+		PostChunkCommand:           str(e.PostChunkCommand, d.PostChunkCommand),
+		PostRunCommand:             str(e.PostRunCommand, d.PostRunCommand),
+	}
+	if flag(e.Quirks, d.Quirks, true) {
+		h.ApplyQuirks()
+	}
+	if len(h.FormatFallbacks) == 0 {
+		h.FormatFallbacks = d.FormatFallbacks
+	}
+	h.Formats = e.Formats
+	if len(h.Formats) == 0 {
+		h.Formats = d.Formats
+	}
+	h.Sets = e.Sets
+	h.SetInclude = e.SetInclude
+	if len(h.SetInclude) == 0 {
+		h.SetInclude = d.SetInclude
+	}
+	h.SetExclude = e.SetExclude
+	if len(h.SetExclude) == 0 {
+		h.SetExclude = d.SetExclude
+	}
+	h.MaxRecords = num(e.MaxRecords, d.MaxRecords)
+	h.MaxTotalRequests = num(e.MaxTotalRequests, d.MaxTotalRequests)
+	h.Parallel = num(e.Parallel, d.Parallel)
+	h.LagDays = num(e.LagDays, d.LagDays)
+	switch {
+	case str(e.UserAgent, d.UserAgent) != "":
+		h.UserAgent = str(e.UserAgent, d.UserAgent)
+	case str(e.Contact, d.Contact) != "":
+		h.UserAgent = UserAgent(str(e.Contact, d.Contact))
+	}
+	if link := str(e.Webhook, d.Webhook); link != "" {
+		h.OnEvent = WebhookEvents(link)
+	}
+	switch {
+	case e.TokenRestarts != nil:
+		h.TokenRestarts = *e.TokenRestarts
+	case d.TokenRestarts != nil:
+		h.TokenRestarts = *d.TokenRestarts
+	default:
+		h.TokenRestarts = DefaultTokenRestarts
+	}
+	h.MaxBytes = e.MaxBytes
+	if h.MaxBytes == 0 {
+		h.MaxBytes = d.MaxBytes
+	}
+	h.MaxBandwidth = e.MaxBandwidth
+	if h.MaxBandwidth == 0 {
+		h.MaxBandwidth = d.MaxBandwidth
+	}
+	h.MaxRecordSize = e.MaxRecordSize
+	if h.MaxRecordSize == 0 {
+		h.MaxRecordSize = d.MaxRecordSize
+	}
+	h.MaxResponseBytes = e.MaxResponseBytes
+	if h.MaxResponseBytes == 0 {
+		h.MaxResponseBytes = d.MaxResponseBytes
+	}
+	rate := e.HostRate
+	if rate == 0 {
+		rate = d.HostRate
+	}
+	if rate > 0 {
+		burst := num(e.HostBurst, d.HostBurst)
+		h.HostLimiter = NewSharedHostLimiter(filepath.Join(BaseDir, RateLimitDir), rate, burst)
+	}
+	if retries := num(e.Retries, d.Retries); retries > 0 {
+		h.RetryPolicy = DefaultRetryPolicy
+		h.RetryPolicy.MaxAttempts = retries
+	}
+	var err error
+	if h.Validate, err = ParseValidationAction(str(e.Validate, d.Validate)); err != nil {
+		return nil, fmt.Errorf("%s: %s", e.URL, err)
+	}
+	if h.Sanitize, err = ParseSanitize(str(e.Sanitize, d.Sanitize)); err != nil {
+		return nil, fmt.Errorf("%s: %s", e.URL, err)
+	}
+	for _, m := range []map[string]string{d.OnError, e.OnError} {
+		for code, name := range m {
+			if h.ErrorActions == nil {
+				h.ErrorActions = make(map[string]ErrorAction)
+			}
+			if h.ErrorActions[code], err = ParseErrorAction(name); err != nil {
+				return nil, fmt.Errorf("%s: %s", e.URL, err)
+			}
+		}
+	}
+	if window := str(e.ResumeWindow, d.ResumeWindow); window != "" {
+		if h.ResumeWindow, err = time.ParseDuration(window); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	for _, m := range []map[string]string{d.Params, e.Params} {
+		for key, value := range m {
+			h.RequestMiddleware = append(h.RequestMiddleware, SetParam(key, value))
+		}
+	}
+	h.ForceGranularity = str(e.Granularity, d.Granularity)
+	if err := h.validateGranularity(); err != nil {
+		return nil, fmt.Errorf("%s: %s", e.URL, err)
+	}
+	h.Quota.StatusCodes, h.Quota.Errors = ParseQuotaErrors(str(e.QuotaOn, d.QuotaOn))
+	h.Quota.ResumeAt = str(e.QuotaResumeAt, d.QuotaResumeAt)
+	if err := h.Quota.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", e.URL, err)
+	}
+	if pause := str(e.QuotaPause, d.QuotaPause); pause != "" {
+		if h.Quota.Pause, err = time.ParseDuration(pause); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	if wait := str(e.LockWait, d.LockWait); wait != "" {
+		if h.LockWait, err = time.ParseDuration(wait); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	if delay := str(e.Delay, d.Delay); delay != "" {
+		if h.Delay, err = time.ParseDuration(delay); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	if window := str(e.Window, d.Window); window != "" {
+		if h.Window, err = ParseWindow(window); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	if timeout := str(e.RequestTimeout, d.RequestTimeout); timeout != "" {
+		if h.RequestTimeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	if stall := str(e.StallTimeout, d.StallTimeout); stall != "" {
+		if h.StallTimeout, err = time.ParseDuration(stall); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	if overlap := str(e.Overlap, d.Overlap); overlap != "" {
+		if h.Overlap, err = time.ParseDuration(overlap); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	if tz := str(e.Timezone, d.Timezone); tz != "" {
+		if h.Timezone, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+	}
+	var filters []RecordFilter
+	if flag(e.SkipDeleted, d.SkipDeleted, false) {
+		filters = append(filters, NotDeleted)
+	}
+	if pattern := str(e.IdentifierPattern, d.IdentifierPattern); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", e.URL, err)
+		}
+		filters = append(filters, IdentifierMatches(re))
+	}
+	if len(filters) > 0 {
+		h.RecordFilter = AllFilters(filters...)
+	}
+	if h.Compression, err = ParseCompression(str(e.Compression, d.Compression)); err != nil {
+		return nil, fmt.Errorf("%s: %s", e.URL, err)
+	}
+	if h.Naming, err = ParseNaming(str(e.Naming, d.Naming)); err != nil {
+		return nil, fmt.Errorf("%s: %s", e.URL, err)
+	}
+	return h, nil
+}
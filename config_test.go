@@ -0,0 +1,58 @@
+package metha
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "metha-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+defaults:
+  format: marcxml
+  delay: 2s
+  suppress-format-parameter: true
+endpoints:
+  - name: a
+    url: example.com/oai
+  - name: b
+    url: http://example.org/oai
+    format: oai_dc
+    suppress-format-parameter: false
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	harvests, err := LoadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(harvests) != 2 {
+		t.Fatalf("got %d harvests, want 2", len(harvests))
+	}
+	var cases = []struct {
+		h        *Harvest
+		baseURL  string
+		format   string
+		suppress bool
+	}{
+		{harvests[0], "http://example.com/oai", "marcxml", true},
+		{harvests[1], "http://example.org/oai", "oai_dc", false},
+	}
+	for _, c := range cases {
+		if c.h.BaseURL != c.baseURL || c.h.Format != c.format || c.h.SuppressFormatParameter != c.suppress {
+			t.Errorf("got %s %s %v, want %s %s %v", c.h.BaseURL, c.h.Format,
+				c.h.SuppressFormatParameter, c.baseURL, c.format, c.suppress)
+		}
+		if c.h.Delay != 2*time.Second {
+			t.Errorf("got delay %s, want 2s", c.h.Delay)
+		}
+	}
+}
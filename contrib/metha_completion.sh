@@ -38,3 +38,9 @@ complete -F _metha_endpoints metha-cat
 complete -F _metha_endpoints metha-sync
 complete -F _metha_endpoints metha-id
 complete -F _metha_endpoints metha-files
+complete -F _metha_endpoints metha-verify
+complete -F _metha_endpoints metha-compact
+complete -F _metha_endpoints metha-import
+complete -F _metha_endpoints metha-tier
+complete -F _metha_endpoints metha-graph
+complete -F _metha_endpoints metha-mint
@@ -0,0 +1,89 @@
+package metha
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+// ErrInvalidDate is wrapped by all errors about From and Until.
+var ErrInvalidDate = errors.New("invalid date")
+
+// DateError explains, what is wrong with From or Until and how to fix it.
+type DateError struct {
+	// Field is "from" or "until".
+	Field   string
+	Value   string
+	Problem string
+}
+
+// Error includes the value and a hint.
+func (e *DateError) Error() string {
+	return fmt.Sprintf("%s: %s %q: %s", ErrInvalidDate, e.Field, e.Value, e.Problem)
+}
+
+// Unwrap returns ErrInvalidDate.
+func (e *DateError) Unwrap() error {
+	return ErrInvalidDate
+}
+
+// lenientLayouts are accepted for From and Until, in addition to 2006-01-02.
+// Incomplete dates start at the beginning of the year or month.
+var lenientLayouts = []string{
+	"2006-1-2",
+	"2006/1/2",
+	"20060102",
+	time.RFC3339,
+	"2006-01-02T15:04:05Z",
+	"2006-1",
+	"2006",
+}
+
+// NormalizeDate parses a date in one of a few lenient layouts, e.g. 2020-1-3,
+// 2020/01/03, 20200103 or 2020-01, and returns it as 2006-01-02.
+func NormalizeDate(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range lenientLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("use YYYY-MM-DD, e.g. %s", time.Now().Format("2006-01-02"))
+}
+
+// ValidateDates normalizes From and Until to 2006-01-02 and checks, that From
+// is not after Until and Until is not in the future. ForceGranularity is
+// checked, too. It is called before each run, but can be used to check
+// settings early.
+func (h *Harvest) ValidateDates() error {
+	today := now.New(time.Now().In(h.location())).BeginningOfDay().Format("2006-01-02")
+	if h.From != "" {
+		v, err := NormalizeDate(h.From)
+		if err != nil {
+			return &DateError{Field: "from", Value: h.From, Problem: err.Error()}
+		}
+		if v > today {
+			return &DateError{Field: "from", Value: h.From, Problem: "date is in the future"}
+		}
+		h.From = v
+	}
+	if h.Until != "" {
+		v, err := NormalizeDate(h.Until)
+		if err != nil {
+			return &DateError{Field: "until", Value: h.Until, Problem: err.Error()}
+		}
+		if v > today {
+			return &DateError{Field: "until", Value: h.Until,
+				Problem: fmt.Sprintf("date is in the future, use %s at most", today)}
+		}
+		if h.From != "" && v < h.From {
+			return &DateError{Field: "until", Value: h.Until,
+				Problem: fmt.Sprintf("date is before from %s", h.From)}
+		}
+		h.Until = v
+	}
+	return h.validateGranularity()
+}
@@ -0,0 +1,69 @@
+package metha
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestValidateDates(t *testing.T) {
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	var cases = []struct {
+		from, until         string
+		wantFrom, wantUntil string
+		field               string
+	}{
+		{"", "", "", "", ""},
+		{"2020-01-03", "", "2020-01-03", "", ""},
+		{"2020-1-3", "2020/2/1", "2020-01-03", "2020-02-01", ""},
+		{"20200103", "2020-02", "2020-01-03", "2020-02-01", ""},
+		{" 2020 ", "2020-01-01T10:00:00Z", "2020-01-01", "2020-01-01", ""},
+		{"2020-13-01", "", "", "", "from"},
+		{"yesterday", "", "", "", "from"},
+		{tomorrow, "", "", "", "from"},
+		{"2020-01-03", "2020-01-02", "", "", "until"},
+		{"2020-01-03", tomorrow, "", "", "until"},
+		{"2020-01-03", today, "2020-01-03", today, ""},
+	}
+	for _, c := range cases {
+		h := &Harvest{From: c.from, Until: c.until}
+		err := h.ValidateDates()
+		var derr *DateError
+		switch {
+		case c.field == "" && err != nil:
+			t.Errorf("%q %q: got %v", c.from, c.until, err)
+		case c.field == "" && (h.From != c.wantFrom || h.Until != c.wantUntil):
+			t.Errorf("%q %q: got %q %q, want %q %q", c.from, c.until, h.From, h.Until, c.wantFrom, c.wantUntil)
+		case c.field != "" && !errors.As(err, &derr):
+			t.Errorf("%q %q: got %v, want date error", c.from, c.until, err)
+		case c.field != "" && (derr.Field != c.field || !errors.Is(err, ErrInvalidDate)):
+			t.Errorf("%q %q: got %v, want error about %s", c.from, c.until, err, c.field)
+		}
+	}
+}
+
+func TestUntil(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-until-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := Harvest{
+		Identify: &Identify{Granularity: "YYYY-MM-DD"},
+		From:     "2016-01-01",
+		Until:    "2016-01-15",
+		Started:  time.Date(2016, 2, 1, 12, 0, 0, 0, time.UTC),
+	}
+	iv, err := h.defaultInterval()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.formatDate(iv.End); got != "2016-01-15" {
+		t.Errorf("got %s, want 2016-01-15", got)
+	}
+}
@@ -0,0 +1,144 @@
+package metha
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// DebugDir is the name of the directory inside a harvest directory, that
+// holds dumps of failing responses.
+const DebugDir = "debug"
+
+// oaiErrorPattern finds an OAI error element in a response body.
+var oaiErrorPattern = regexp.MustCompile(`<([A-Za-z0-9_]+:)?error\s+code=`)
+
+// debugTransport logs method, full URL, status and latency of each request,
+// including retries. If Dir is set, failing responses, with an HTTP error
+// status or an OAI error, are dumped there with headers and raw body.
+type debugTransport struct {
+	Transport http.RoundTripper
+	Dir       string
+}
+
+// RoundTrip executes and logs the request.
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	link, form := req.URL.String(), requestForm(req)
+	if form != "" {
+		link = link + " " + form
+	}
+	id := req.Header.Get(RequestIDHeader)
+	started := time.Now()
+	resp, err := transport.RoundTrip(req)
+	elapsed := time.Since(started)
+	if err != nil {
+		log.Printf("debug: %s %s [%s] failed after %s: %v", req.Method, link, id, elapsed, err)
+		return nil, err
+	}
+	log.Printf("debug: %s %s [%s] %s in %s, content length %d",
+		req.Method, link, id, resp.Status, elapsed, resp.ContentLength)
+	if t.Dir == "" {
+		return resp, nil
+	}
+	// the whole body is read, so it can be dumped
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		log.Printf("debug: reading body of %s [%s] failed: %v", link, id, err)
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if resp.StatusCode < 400 && !hasOAIError(body) {
+		return resp, nil
+	}
+	filename, err := t.dump(req, form, resp, body)
+	if err != nil {
+		log.Printf("debug: cannot dump response: %v", err)
+	} else {
+		log.Printf("debug: dumped failing response to %s", filename)
+	}
+	return resp, nil
+}
+
+// dump writes request line, headers, response status, headers and the body
+// as received into a new file in Dir.
+func (t debugTransport) dump(req *http.Request, form string, resp *http.Response, body []byte) (string, error) {
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000")
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		name = name + "-" + id
+	}
+	filename := filepath.Join(t.Dir, name+".txt")
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s\r\n", req.Method, req.URL)
+	req.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	if form != "" {
+		buf.WriteString(form + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	b, err := httputil.DumpResponse(resp, false)
+	if err != nil {
+		return "", err
+	}
+	buf.Write(b)
+	buf.Write(body)
+	return filename, ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// requestForm returns the form of a POST request, without consuming the
+// body, or an empty string.
+func requestForm(req *http.Request) string {
+	if req.Method != "POST" || req.GetBody == nil {
+		return ""
+	}
+	rc, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// hasOAIError reports, whether a response body contains an OAI error.
+// Gzip compressed bodies are decompressed first.
+func hasOAIError(body []byte) bool {
+	var r io.Reader = bytes.NewReader(body)
+	if len(body) > 1 && body[0] == 0x1f && body[1] == 0x8b {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return false
+		}
+		defer gr.Close()
+		r = gr
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil && len(b) == 0 {
+		return false
+	}
+	return oaiErrorPattern.Match(b)
+}
+
+// debugDir returns the directory for dumps of failing responses.
+func (h *Harvest) debugDir() string {
+	return filepath.Join(h.Dir(), DebugDir)
+}
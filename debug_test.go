@@ -0,0 +1,75 @@
+package metha
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "maintenance")
+		case "/oai":
+			fmt.Fprint(w, `<OAI-PMH><error code="badArgument">no</error></OAI-PMH>`)
+		default:
+			fmt.Fprint(w, "<OAI-PMH/>")
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "metha-debug-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := &http.Client{Transport: debugTransport{Dir: dir}}
+	var cases = []struct {
+		path  string
+		dumps int
+	}{
+		{"/ok", 0},
+		{"/fail", 1},
+		{"/oai", 2},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", srv.URL+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(RequestIDHeader, strings.TrimPrefix(c.path, "/"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || len(b) == 0 {
+			t.Errorf("%s: body not passed on: %q, %v", c.path, b, err)
+		}
+		if got := len(MustGlob(filepath.Join(dir, "*.txt"))); got != c.dumps {
+			t.Errorf("%s: got %d dumps, want %d", c.path, got, c.dumps)
+		}
+	}
+	dumps := MustGlob(filepath.Join(dir, "*-fail.txt"))
+	if len(dumps) != 1 {
+		t.Fatalf("got %v, want a dump named after the request id", dumps)
+	}
+	b, err := ioutil.ReadFile(dumps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"GET " + srv.URL + "/fail", "503 Service Unavailable", "maintenance"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("dump misses %q", want)
+		}
+	}
+}
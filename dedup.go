@@ -0,0 +1,125 @@
+package metha
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"io"
+	"os"
+	"strings"
+)
+
+// seenKeySize is the number of bytes of the digest kept per record. 96 bits
+// keep collisions unlikely for billions of records at 12 bytes per key.
+const seenKeySize = 12
+
+type seenKey [seenKeySize]byte
+
+// recordKey derives the key of a record version from identifier and datestamp.
+func recordKey(rec Record) seenKey {
+	h := sha1.New()
+	io.WriteString(h, strings.TrimSpace(rec.Header.Identifier))
+	h.Write([]byte{0})
+	io.WriteString(h, strings.TrimSpace(rec.Header.DateStamp))
+	var k seenKey
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// SeenIndex remembers record versions, keyed by identifier and datestamp. An
+// index backed by a file keeps its keys across runs, e.g. to export only
+// records, that have not been exported before.
+type SeenIndex struct {
+	keys map[seenKey]struct{}
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// NewSeenIndex returns an in-memory index.
+func NewSeenIndex() *SeenIndex {
+	return &SeenIndex{keys: make(map[seenKey]struct{})}
+}
+
+// OpenSeenIndex opens or creates an index file. New keys are appended to the
+// file, a truncated last key (e.g. after a crash) is ignored.
+func OpenSeenIndex(filename string) (*SeenIndex, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idx := NewSeenIndex()
+	br := bufio.NewReader(f)
+	var k seenKey
+	for {
+		if _, err := io.ReadFull(br, k[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return nil, err
+		}
+		idx.keys[k] = struct{}{}
+	}
+	// drop a partial key, so appended keys stay aligned
+	if err := f.Truncate(int64(len(idx.keys) * seenKeySize)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return nil, err
+	}
+	idx.f, idx.w = f, bufio.NewWriter(f)
+	return idx, nil
+}
+
+// Add records a record version and reports whether it was new.
+func (s *SeenIndex) Add(rec Record) (bool, error) {
+	k := recordKey(rec)
+	if _, ok := s.keys[k]; ok {
+		return false, nil
+	}
+	s.keys[k] = struct{}{}
+	if s.w != nil {
+		if _, err := s.w.Write(k[:]); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// Len returns the number of record versions seen.
+func (s *SeenIndex) Len() int {
+	return len(s.keys)
+}
+
+// Close flushes and closes the index file, if any.
+func (s *SeenIndex) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if e := s.f.Close(); err == nil {
+		err = e
+	}
+	s.f, s.w = nil, nil
+	return err
+}
+
+// EachUniqueRecord is like EachRecord, but calls fn only once per identifier
+// and datestamp. Duplicates can occur, when intervals overlap, e.g. after
+// harvests without selective harvesting or due to clock skew on the server.
+// If seen is nil, an in-memory index is used.
+func (h *Harvest) EachUniqueRecord(seen *SeenIndex, fn func(Record) error) error {
+	if seen == nil {
+		seen = NewSeenIndex()
+	}
+	return h.EachRecord(func(rec Record) error {
+		ok, err := seen.Add(rec)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		return fn(rec)
+	})
+}
@@ -0,0 +1,79 @@
+package metha
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeenIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-dedup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "seen")
+
+	rec := func(id, ds string) Record {
+		return Record{Header: Header{Identifier: id, DateStamp: ds}}
+	}
+	var cases = []struct {
+		rec  Record
+		want bool
+	}{
+		{rec("oai:x:1", "2016-01-01"), true},
+		{rec("oai:x:1", "2016-01-01"), false},
+		{rec(" oai:x:1\n", "2016-01-01"), false},
+		{rec("oai:x:1", "2016-01-02"), true},
+		{rec("oai:x:2", "2016-01-01"), true},
+	}
+	idx, err := OpenSeenIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cases {
+		got, err := idx.Add(c.rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%d: got %v, want %v", i, got, c.want)
+		}
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash during a write
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	idx, err = OpenSeenIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != 3 {
+		t.Errorf("got %d keys, want 3", idx.Len())
+	}
+	if ok, _ := idx.Add(rec("oai:x:2", "2016-01-01")); ok {
+		t.Errorf("got new record, want seen")
+	}
+	if ok, _ := idx.Add(rec("oai:x:3", "2016-01-01")); !ok {
+		t.Errorf("got seen record, want new")
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 4*seenKeySize {
+		t.Errorf("got size %d, want %d", fi.Size(), 4*seenKeySize)
+	}
+}
@@ -0,0 +1,185 @@
+package metha
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DiffChange is the kind of difference of a record between two snapshots.
+type DiffChange string
+
+const (
+	DiffAdded   DiffChange = "added"
+	DiffChanged DiffChange = "changed"
+	DiffDeleted DiffChange = "deleted"
+)
+
+// DiffEntry is a record, that differs between two snapshots. A record marked
+// deleted counts as absent.
+type DiffEntry struct {
+	Identifier   string     `json:"identifier"`
+	Change       DiffChange `json:"change"`
+	OldDateStamp string     `json:"old_datestamp,omitempty"`
+	NewDateStamp string     `json:"new_datestamp,omitempty"`
+}
+
+// DiffSummary counts the differences between two snapshots.
+type DiffSummary struct {
+	Added     int `json:"added"`
+	Changed   int `json:"changed"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+}
+
+// InSync returns true, if both snapshots hold the same records.
+func (s *DiffSummary) InSync() bool {
+	return s.Added == 0 && s.Changed == 0 && s.Deleted == 0
+}
+
+// Snapshot is the state of a harvest directory at a point in time.
+type Snapshot struct {
+	Dir string
+	// Until limits the snapshot to record versions with a datestamp up to and
+	// including this day, e.g. 2020-01-31. Empty means all records.
+	Until string
+}
+
+// Snapshot returns the snapshot of the harvest directory up to a day.
+func (h *Harvest) Snapshot(until string) Snapshot {
+	return Snapshot{Dir: h.Dir(), Until: until}
+}
+
+// snapshotRecord is the latest version of a record in a snapshot.
+type snapshotRecord struct {
+	dateStamp string
+	deleted   bool
+	sum       seenKey
+}
+
+// contentKey derives a key from everything, that is compared between
+// snapshots: datestamp, status, sets and metadata.
+func contentKey(rec Record) seenKey {
+	h := sha1.New()
+	io.WriteString(h, strings.TrimSpace(rec.Header.DateStamp))
+	h.Write([]byte{0})
+	io.WriteString(h, rec.Header.Status)
+	h.Write([]byte{0})
+	io.WriteString(h, strings.Join(rec.Header.SetSpec, " "))
+	h.Write([]byte{0})
+	h.Write(bytes.TrimSpace(rec.Metadata.Body))
+	var k seenKey
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// files returns the cached files and stubs of the snapshot directory, in
+// filename order.
+func (s Snapshot) files() []string {
+	var files []string
+	for _, ext := range chunkExtensions {
+		for _, pattern := range []string{"*" + ext, "*" + ext + StubSuffix} {
+			files = append(files, MustGlob(filepath.Join(s.Dir, pattern))...)
+			files = append(files, MustGlob(filepath.Join(s.Dir, shardGlob, pattern))...)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+	return files
+}
+
+// records reads the latest version of each record in the snapshot.
+func (s Snapshot) records() (map[string]snapshotRecord, error) {
+	until := s.Until
+	if until != "" {
+		v, err := NormalizeDate(until)
+		if err != nil {
+			return nil, &DateError{Field: "until", Value: s.Until, Problem: err.Error()}
+		}
+		// datestamps with time sort before the end of the day
+		until = v + "T23:59:59Z"
+	}
+	latest := make(map[string]snapshotRecord)
+	for _, filename := range s.files() {
+		err := eachRecordPosition(filename, func(_ int, rec Record) error {
+			id := strings.TrimSpace(rec.Header.Identifier)
+			ds := strings.TrimSpace(rec.Header.DateStamp)
+			if id == "" || (until != "" && ds > until) {
+				return nil
+			}
+			if prev, ok := latest[id]; ok && prev.dateStamp > ds {
+				return nil
+			}
+			latest[id] = snapshotRecord{
+				dateStamp: ds,
+				deleted:   rec.Header.Status == "deleted",
+				sum:       contentKey(rec),
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// Diff compares two snapshots on the record level, e.g. two mirrors of a
+// cache or one cache at two days. It calls fn for each record, that was
+// added, changed or deleted from a to b, in identifier order, and returns
+// the counts.
+func Diff(a, b Snapshot, fn func(DiffEntry) error) (*DiffSummary, error) {
+	old, err := a.records()
+	if err != nil {
+		return nil, err
+	}
+	cur, err := b.records()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(cur))
+	for id := range cur {
+		ids = append(ids, id)
+	}
+	for id := range old {
+		if _, ok := cur[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var summary DiffSummary
+	for _, id := range ids {
+		o, inOld := old[id]
+		c, inCur := cur[id]
+		inOld = inOld && !o.deleted
+		inCur = inCur && !c.deleted
+		entry := DiffEntry{Identifier: id, OldDateStamp: o.dateStamp, NewDateStamp: c.dateStamp}
+		switch {
+		case !inOld && !inCur:
+			continue
+		case !inOld:
+			entry.Change = DiffAdded
+			summary.Added++
+		case !inCur:
+			entry.Change = DiffDeleted
+			summary.Deleted++
+		case o.sum != c.sum:
+			entry.Change = DiffChanged
+			summary.Changed++
+		default:
+			summary.Unchanged++
+			continue
+		}
+		if fn != nil {
+			if err := fn(entry); err != nil {
+				return &summary, err
+			}
+		}
+	}
+	return &summary, nil
+}
@@ -0,0 +1,70 @@
+package metha
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-diff-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	record := `<record><header%s><identifier>%s</identifier><datestamp>%s</datestamp></header><metadata>%s</metadata></record>`
+	write := func(sub, name string, records ...string) string {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		doc := `<OAI-PMH><ListRecords>`
+		for _, r := range records {
+			doc += r
+		}
+		doc += `</ListRecords></OAI-PMH>`
+		if err := ioutil.WriteFile(filepath.Join(dir, sub, name), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filepath.Join(dir, sub)
+	}
+	a := write("a", "2016-01-31-00000000.xml",
+		fmt.Sprintf(record, "", "same", "2016-01-10", "x"),
+		fmt.Sprintf(record, "", "changed", "2016-01-10", "x"),
+		fmt.Sprintf(record, "", "gone", "2016-01-10", "x"))
+	b := write("b", "2016-01-31-00000000.xml",
+		fmt.Sprintf(record, "", "same", "2016-01-10", " x "),
+		fmt.Sprintf(record, "", "changed", "2016-01-10", "y"),
+		fmt.Sprintf(record, "", "new", "2016-01-20", "x"))
+	write("b", "2016-02-29-00000000.xml",
+		fmt.Sprintf(record, "", "changed", "2016-02-01", "x"),
+		fmt.Sprintf(record, ` status="deleted"`, "same", "2016-02-02", ""))
+
+	var tests = []struct {
+		a, b Snapshot
+		want string
+	}{
+		{Snapshot{Dir: a}, Snapshot{Dir: a}, "[] {0 0 0 3}"},
+		{Snapshot{Dir: a}, Snapshot{Dir: b, Until: "2016-01-31"},
+			"[changed/changed deleted/gone added/new] {1 1 1 1}"},
+		{Snapshot{Dir: a}, Snapshot{Dir: b},
+			"[changed/changed deleted/gone added/new deleted/same] {1 1 2 0}"},
+		{Snapshot{Dir: b, Until: "2016-01-31"}, Snapshot{Dir: b},
+			"[changed/changed deleted/same] {0 1 1 1}"},
+	}
+	for _, test := range tests {
+		var entries []string
+		summary, err := Diff(test.a, test.b, func(e DiffEntry) error {
+			entries = append(entries, string(e.Change)+"/"+e.Identifier)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%v %v", entries, *summary); got != test.want {
+			t.Errorf("Diff(%v, %v), got %s, want %s", test.a, test.b, got, test.want)
+		}
+	}
+}
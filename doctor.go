@@ -0,0 +1,189 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Severity of a doctor finding.
+type Severity string
+
+// Severities, from harmless to broken.
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Finding is a single problem found by Doctor, together with a suggested fix.
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path,omitempty"`
+	Problem  string   `json:"problem"`
+	Fix      string   `json:"fix,omitempty"`
+}
+
+// String formats a finding for humans.
+func (f Finding) String() string {
+	s := fmt.Sprintf("[%s] %s: %s", f.Severity, f.Check, f.Problem)
+	if f.Path != "" {
+		s += fmt.Sprintf(" (%s)", f.Path)
+	}
+	if f.Fix != "" {
+		s += "\n\tfix: " + f.Fix
+	}
+	return s
+}
+
+// DoctorOptions configures the checks. Verifying chunks reads every cached
+// file and checking endpoints sends an Identify request to each harvested
+// endpoint, both are off by default.
+type DoctorOptions struct {
+	MinFreeBytes uint64
+	StaleAfter   time.Duration
+	Chunks       bool
+	Endpoints    bool
+}
+
+// DefaultDoctorOptions warns below 1G of free space and about temporary files
+// older than a day.
+var DefaultDoctorOptions = DoctorOptions{
+	MinFreeBytes: 1 << 30,
+	StaleAfter:   24 * time.Hour,
+}
+
+// Doctor checks BaseDir and all harvests in it and returns any problems found.
+func Doctor(ctx context.Context, opts DoctorOptions) []Finding {
+	var findings []Finding
+	add := func(f Finding) {
+		findings = append(findings, f)
+	}
+
+	fi, err := os.Stat(BaseDir)
+	switch {
+	case os.IsNotExist(err):
+		add(Finding{Check: "basedir", Severity: SeverityInfo, Path: BaseDir,
+			Problem: "does not exist", Fix: "it is created by the first harvest"})
+		return findings
+	case err != nil:
+		add(Finding{Check: "basedir", Severity: SeverityError, Path: BaseDir, Problem: err.Error()})
+		return findings
+	case !fi.IsDir():
+		add(Finding{Check: "basedir", Severity: SeverityError, Path: BaseDir,
+			Problem: "not a directory", Fix: "move the file or set METHA_DIR"})
+		return findings
+	}
+
+	if f, err := ioutil.TempFile(BaseDir, ".doctor-"); err != nil {
+		add(Finding{Check: "basedir", Severity: SeverityError, Path: BaseDir,
+			Problem: "not writable: " + err.Error(), Fix: "fix permissions or set METHA_DIR"})
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if free, err := diskFree(BaseDir); err != nil {
+		add(Finding{Check: "diskspace", Severity: SeverityInfo, Path: BaseDir, Problem: err.Error()})
+	} else if free < opts.MinFreeBytes {
+		add(Finding{Check: "diskspace", Severity: SeverityWarning, Path: BaseDir,
+			Problem: fmt.Sprintf("only %d MB free", free>>20),
+			Fix:     "free up space, compact (metha-compact) or tier (metha-tier) old files"})
+	}
+
+	entries, err := ioutil.ReadDir(BaseDir)
+	if err != nil {
+		add(Finding{Check: "basedir", Severity: SeverityError, Path: BaseDir, Problem: err.Error()})
+		return findings
+	}
+	endpoints := make(map[string]bool)
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == RateLimitDir {
+			continue
+		}
+		dir := filepath.Join(BaseDir, e.Name())
+		h, err := HarvestFromDir(e.Name())
+		if err != nil {
+			add(Finding{Check: "harvest", Severity: SeverityInfo, Path: dir,
+				Problem: "unknown directory", Fix: "remove it, if it is not needed"})
+			continue
+		}
+		findings = append(findings, h.doctor(opts)...)
+		if opts.Endpoints && !endpoints[h.BaseURL] {
+			endpoints[h.BaseURL] = true
+			if err := h.IdentifyContext(ctx); err != nil {
+				add(Finding{Check: "endpoint", Severity: SeverityWarning, Path: h.BaseURL,
+					Problem: "unreachable: " + err.Error(),
+					Fix:     "check network access and whether the endpoint moved"})
+			}
+		}
+	}
+	return findings
+}
+
+// doctor checks a single harvest directory.
+func (h *Harvest) doctor(opts DoctorOptions) []Finding {
+	var findings []Finding
+	add := func(f Finding) {
+		findings = append(findings, f)
+	}
+
+	for _, fn := range h.temporaryFiles() {
+		fi, err := os.Stat(fn)
+		if err != nil || time.Since(fi.ModTime()) < opts.StaleAfter {
+			continue
+		}
+		add(Finding{Check: "tempfile", Severity: SeverityWarning, Path: fn,
+			Problem: fmt.Sprintf("stale temporary file from %s", fi.ModTime().Format(time.RFC3339)),
+			Fix:     "remove it; metha-sync removes these files on the next run"})
+	}
+	if _, err := os.Stat(h.journalPath()); err == nil {
+		add(Finding{Check: "journal", Severity: SeverityWarning, Path: h.journalPath(),
+			Problem: "interrupted finalize", Fix: "run metha-sync again, it completes or discards the batch"})
+	}
+	for _, fn := range h.glob("*.compacted") {
+		add(Finding{Check: "compact", Severity: SeverityWarning, Path: fn,
+			Problem: "left over from an interrupted compaction",
+			Fix:     "run metha-sync again, it completes or undoes the compaction"})
+	}
+	for _, fn := range h.glob("*" + PurgedSuffix) {
+		add(Finding{Check: "reharvest", Severity: SeverityWarning, Path: fn,
+			Problem: "left over from an interrupted re-harvest",
+			Fix:     "remove the " + PurgedSuffix + " suffix and run the re-harvest again"})
+	}
+	for _, fn := range h.glob("*" + IndexSuffix) {
+		base := strings.TrimSuffix(fn, IndexSuffix)
+		if _, err := os.Stat(base); os.IsNotExist(err) {
+			if _, err := os.Stat(base + StubSuffix); err == nil {
+				continue
+			}
+			add(Finding{Check: "index", Severity: SeverityWarning, Path: fn,
+				Problem: "index without data file", Fix: "remove it"})
+		}
+	}
+	if opts.Chunks {
+		result, err := h.Verify()
+		if err != nil {
+			add(Finding{Check: "chunk", Severity: SeverityError, Path: h.Dir(), Problem: err.Error()})
+			return findings
+		}
+		for _, fe := range result.Errors {
+			fix := "harvest the range again with metha-sync -reharvest"
+			if groups := chunkPattern.FindStringSubmatch(filepath.Base(fe.Path)); len(groups) > 1 {
+				fix = fmt.Sprintf("metha-sync -format %s", h.Format)
+				if h.Set != "" {
+					fix += " -set " + shellQuote(h.Set)
+				}
+				fix += fmt.Sprintf(" -reharvest %s,%s %s", groups[1], groups[1], h.BaseURL)
+			}
+			add(Finding{Check: "chunk", Severity: SeverityError, Path: fe.Path,
+				Problem: fmt.Sprintf("%s: %s", fe.Kind, fe.Err), Fix: fix})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,47 @@
+package metha
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDoctor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-doctor-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(h.Dir(), "2016-01-31-00000000.xml-tmp-1")
+	if err := ioutil.WriteFile(tmp, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(tmp, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.writeJournal(&Journal{Suffix: "-tmp-1", State: JournalCommit}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultDoctorOptions
+	opts.MinFreeBytes = 0
+	checks := make(map[string]bool)
+	for _, f := range Doctor(context.Background(), opts) {
+		checks[f.Check] = true
+	}
+	for _, want := range []string{"tempfile", "journal"} {
+		if !checks[want] {
+			t.Errorf("missing finding: %s", want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package metha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrorReportFilename is the name of the report of the last failed run in the
+// harvest directory. It is removed after a successful run, so its presence
+// marks a failing harvest.
+const ErrorReportFilename = "error.json"
+
+// Kinds of failures in an error report.
+const (
+	FailureHTTP    = "http"
+	FailureOAI     = "oai"
+	FailureStalled = "stalled"
+	FailureTimeout = "timeout"
+	FailureNetwork = "network"
+	FailureOther   = "other"
+)
+
+// ErrorReport describes a failed run for triage across many endpoints,
+// without parsing logs. Request holds the arguments of the failing request,
+// if the run failed during an interval; parameters added with Params or
+// RequestMiddleware are left out, since they may hold secrets.
+type ErrorReport struct {
+	Endpoint     string         `json:"endpoint"`
+	Format       string         `json:"format"`
+	Set          string         `json:"set,omitempty"`
+	Dir          string         `json:"dir"`
+	Kind         string         `json:"kind"`
+	Error        string         `json:"error"`
+	HTTPStatus   int            `json:"httpStatus,omitempty"`
+	OAIErrorCode string         `json:"oaiErrorCode,omitempty"`
+	OAIMessage   string         `json:"oaiMessage,omitempty"`
+	Request      *ReportRequest `json:"request,omitempty"`
+	Interval     *Interval      `json:"interval,omitempty"`
+	// RequestIndex is the position of the request within the interval.
+	RequestIndex int       `json:"requestIndex"`
+	Requests     int       `json:"requests"`
+	Records      int       `json:"records"`
+	Version      string    `json:"version"`
+	Started      time.Time `json:"started"`
+	Failed       time.Time `json:"failed"`
+}
+
+// ReportRequest are the OAI arguments of a request.
+type ReportRequest struct {
+	Verb            string `json:"verb"`
+	MetadataPrefix  string `json:"metadataPrefix,omitempty"`
+	Set             string `json:"set,omitempty"`
+	From            string `json:"from,omitempty"`
+	Until           string `json:"until,omitempty"`
+	ResumptionToken string `json:"resumptionToken,omitempty"`
+	UsePost         bool   `json:"post,omitempty"`
+}
+
+// failedRequest is the request in progress, when an interval failed.
+type failedRequest struct {
+	req   *Request
+	iv    Interval
+	index int
+}
+
+// noteFailure remembers the request in progress of a failed interval, the
+// latest failure wins.
+func (h *Harvest) noteFailure(req *Request, iv Interval, index int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.progress.failure = &failedRequest{req: req, iv: iv, index: index}
+}
+
+// failureKind classifies an error for the report.
+func failureKind(err error) string {
+	var (
+		he HTTPError
+		oe OAIError
+		ne net.Error
+	)
+	switch {
+	case errors.As(err, &he):
+		return FailureHTTP
+	case errors.As(err, &oe):
+		return FailureOAI
+	case errors.Is(err, ErrStalled):
+		return FailureStalled
+	case errors.Is(err, context.DeadlineExceeded):
+		return FailureTimeout
+	case errors.As(err, &ne) && ne.Timeout():
+		return FailureTimeout
+	case errors.As(err, &ne):
+		return FailureNetwork
+	default:
+		return FailureOther
+	}
+}
+
+// redactQuery returns the message of an error without the query of request
+// URLs, which may contain parameters like API keys. The arguments of the
+// failing request are reported separately.
+func redactQuery(err error) string {
+	msg := err.Error()
+	var (
+		he HTTPError
+		ue *url.Error
+	)
+	if errors.As(err, &he) && he.URL != nil && he.URL.RawQuery != "" {
+		u := *he.URL
+		u.RawQuery = ""
+		msg = strings.Replace(msg, he.URL.String(), u.String(), -1)
+	}
+	if errors.As(err, &ue) {
+		if u, e := url.Parse(ue.URL); e == nil && u.RawQuery != "" {
+			u.RawQuery = ""
+			msg = strings.Replace(msg, ue.URL, u.String(), -1)
+		}
+	}
+	return msg
+}
+
+// newErrorReport describes a failed run from the state of the current run.
+func (h *Harvest) newErrorReport(runErr error) *ErrorReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := &ErrorReport{
+		Endpoint: h.BaseURL,
+		Format:   h.Format,
+		Set:      h.Set,
+		Dir:      h.Dir(),
+		Kind:     failureKind(runErr),
+		Error:    redactQuery(runErr),
+		Requests: h.progress.requests,
+		Records:  h.progress.totalRecords,
+		Version:  Version,
+		Started:  h.Started,
+		Failed:   time.Now(),
+	}
+	var (
+		he HTTPError
+		oe OAIError
+	)
+	if errors.As(runErr, &he) {
+		r.HTTPStatus = he.StatusCode
+	}
+	if errors.As(runErr, &oe) {
+		r.OAIErrorCode, r.OAIMessage = oe.Code, oe.Message
+	}
+	if f := h.progress.failure; f != nil {
+		iv := f.iv
+		r.Interval = &iv
+		r.RequestIndex = f.index
+		if f.req != nil {
+			r.Request = &ReportRequest{
+				Verb:            f.req.Verb,
+				MetadataPrefix:  f.req.MetadataPrefix,
+				Set:             f.req.Set,
+				From:            f.req.From,
+				Until:           f.req.Until,
+				ResumptionToken: f.req.ResumptionToken,
+				UsePost:         f.req.UsePost,
+			}
+		}
+	}
+	return r
+}
+
+// errorReportPath returns the path to the error report.
+func (h *Harvest) errorReportPath() string {
+	return filepath.Join(h.Dir(), ErrorReportFilename)
+}
+
+// writeErrorReport replaces the error report after a failed run. Without a
+// harvest directory, e.g. if Identify failed on the first run, there is no
+// report.
+func (h *Harvest) writeErrorReport(runErr error) error {
+	if _, err := os.Stat(h.Dir()); os.IsNotExist(err) {
+		return nil
+	}
+	b, err := json.MarshalIndent(h.newErrorReport(runErr), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := h.errorReportPath() + newTempSuffix("-tmp-")
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, h.errorReportPath())
+}
+
+// removeErrorReport removes the report after a successful run.
+func (h *Harvest) removeErrorReport() error {
+	if err := os.Remove(h.errorReportPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ErrorReport returns the report of the last failed run, or nil, if the last
+// run succeeded.
+func (h *Harvest) ErrorReport() (*ErrorReport, error) {
+	return ReadErrorReport(h.Dir())
+}
+
+// ReadErrorReport reads the error report in a harvest directory, or nil.
+func ReadErrorReport(dir string) (*ErrorReport, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ErrorReportFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r ErrorReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListErrorReports returns the error reports of all failing harvests in a
+// directory, e.g. BaseDir, to aggregate failure causes.
+func ListErrorReports(dir string) ([]ErrorReport, error) {
+	infos, err := ListHarvests(dir)
+	if err != nil {
+		return nil, err
+	}
+	var reports []ErrorReport
+	for _, info := range infos {
+		r, err := ReadErrorReport(info.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			reports = append(reports, *r)
+		}
+	}
+	return reports, nil
+}
@@ -0,0 +1,158 @@
+package metha
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestErrorReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-errorreport-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	var mode string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity><earliestDatestamp>2016-01-01</earliestDatestamp></Identify></OAI-PMH>`)
+		case q.Get("resumptionToken") == "" && q.Get("apikey") != "":
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record><resumptionToken>t1</resumptionToken></ListRecords></OAI-PMH>`)
+		case mode == "http":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case mode == "oai":
+			fmt.Fprint(w, `<OAI-PMH><error code="badArgument">invalid token</error></OAI-PMH>`)
+		default:
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>2</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		mode   string
+		kind   string
+		status int
+		code   string
+	}{
+		{"http", FailureHTTP, http.StatusServiceUnavailable, ""},
+		{"oai", FailureOAI, 0, "badArgument"},
+		{"ok", "", 0, ""},
+	}
+	for _, c := range cases {
+		mode = c.mode
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			DailyInterval:     true,
+			RetryPolicy:       RetryPolicy{MaxAttempts: 1},
+			RequestMiddleware: []RequestMiddleware{SetParam("apikey", "secret")},
+		}
+		runErr := h.RunContext(context.Background())
+		r, err := h.ErrorReport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.kind == "" {
+			if runErr != nil || r != nil {
+				t.Errorf("%s: got %v and report %+v, want none", c.mode, runErr, r)
+			}
+			continue
+		}
+		if runErr == nil || r == nil {
+			t.Fatalf("%s: got %v and report %v, want both", c.mode, runErr, r)
+		}
+		if r.Kind != c.kind || r.HTTPStatus != c.status || r.OAIErrorCode != c.code || r.Error == "" {
+			t.Errorf("%s: got report %+v", c.mode, r)
+		}
+		if r.Request == nil || r.Request.ResumptionToken != "t1" || r.Request.Verb != "ListRecords" || r.Interval == nil || r.RequestIndex != 1 {
+			t.Errorf("%s: got request %+v, interval %v, index %d", c.mode, r.Request, r.Interval, r.RequestIndex)
+		}
+		if b, err := ioutil.ReadFile(filepath.Join(h.Dir(), ErrorReportFilename)); err != nil || bytes.Contains(b, []byte("secret")) {
+			t.Errorf("%s: report leaks parameters or is missing: %v", c.mode, err)
+		}
+		if reports, err := ListErrorReports(dir); err != nil || len(reports) != 1 {
+			t.Errorf("%s: got %d reports, %v", c.mode, len(reports), err)
+		}
+	}
+}
+
+func TestErrorReportNotAFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-errorreport-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var cases = []struct {
+		about    string
+		ctx      context.Context
+		locked   bool
+		previous bool
+		err      error
+	}{
+		{"another run holds the lock", context.Background(), true, false, ErrLocked},
+		{"report of the last run stays", context.Background(), true, true, ErrLocked},
+		{"interrupted", canceled, false, false, context.Canceled},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		if c.previous {
+			if err := h.writeErrorReport(HTTPError{StatusCode: http.StatusBadGateway}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var l *harvestLock
+		if c.locked {
+			other := &Harvest{BaseURL: h.BaseURL, Format: h.Format}
+			if l, err = other.lock(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := h.RunContext(c.ctx); !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.about, err, c.err)
+		}
+		if l != nil {
+			l.unlock()
+		}
+		r, err := h.ErrorReport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r != nil; got != c.previous || (got && r.HTTPStatus != http.StatusBadGateway) {
+			t.Errorf("%s: got report %+v, want previous report %v", c.about, r, c.previous)
+		}
+	}
+}
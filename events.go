@@ -0,0 +1,101 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// EventKind names a step in the lifecycle of a run.
+type EventKind string
+
+const (
+	// EventStart is sent, when a run starts to harvest.
+	EventStart EventKind = "start"
+	// EventInterval is sent, after the files of an interval are in place.
+	EventInterval EventKind = "interval"
+	// EventError is sent, when a run fails, it is the last event of the run.
+	EventError EventKind = "error"
+	// EventFinish is sent, when a run is complete or already synced, it is
+	// the last event of the run.
+	EventFinish EventKind = "finish"
+	// EventPause is sent, when the harvest pauses after a quota error or
+	// outside the window, until ResumeAt.
+	EventPause EventKind = "pause"
+)
+
+// Event describes a step of a run, e.g. to notify a chat or update the state
+// of an orchestration. Files are the files moved into place with the interval
+// or, on finish, during the whole run.
+type Event struct {
+	Kind     EventKind `json:"kind"`
+	Time     time.Time `json:"time"`
+	BaseURL  string    `json:"baseURL"`
+	Format   string    `json:"format"`
+	Set      string    `json:"set,omitempty"`
+	Dir      string    `json:"dir"`
+	Interval *Interval `json:"interval,omitempty"`
+	Files    []string  `json:"files,omitempty"`
+	Records  int       `json:"records"`
+	Requests int       `json:"requests"`
+	// Stopped is true, if a limit stopped the run before it was complete.
+	Stopped  bool       `json:"stopped,omitempty"`
+	ResumeAt *time.Time `json:"resumeAt,omitempty"`
+	Err      error      `json:"-"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// EventFunc is called for each event of a run. It is called synchronously,
+// so a slow function slows down the harvest.
+type EventFunc func(Event)
+
+// emit sends an event prefilled with harvest information, if an event
+// function is set.
+func (h *Harvest) emit(e Event) {
+	if h.OnEvent == nil {
+		return
+	}
+	h.mu.Lock()
+	e.Records, e.Requests, e.Stopped = h.progress.totalRecords, h.progress.requests, h.progress.stopped
+	h.mu.Unlock()
+	e.Time = time.Now()
+	e.BaseURL, e.Format, e.Set, e.Dir = h.BaseURL, h.Format, h.Set, h.Dir()
+	if e.Err != nil {
+		e.Error = e.Err.Error()
+	}
+	h.OnEvent(e)
+}
+
+// emitInterval sends an interval event, the interval is left out for a
+// harvest without intervals.
+func (h *Harvest) emitInterval(iv Interval, files []string) {
+	e := Event{Kind: EventInterval, Files: files}
+	if !iv.Begin.IsZero() {
+		e.Interval = &iv
+	}
+	h.emit(e)
+}
+
+// WebhookEvents returns an event function, that posts each event as JSON to
+// a URL. Failures are logged and do not stop the harvest.
+func WebhookEvents(link string) EventFunc {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(e Event) {
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("webhook: %s", err)
+			return
+		}
+		resp, err := client.Post(link, "application/json", bytes.NewReader(b))
+		if err != nil {
+			log.Printf("webhook: %s", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			log.Printf("webhook: %s returned %s", link, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package metha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-events-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity><earliestDatestamp>2016-01-01</earliestDatestamp></Identify></OAI-PMH>`)
+			return
+		}
+		fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	var (
+		mu    sync.Mutex
+		kinds []EventKind
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		kinds = append(kinds, e.Kind)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	var cases = []struct {
+		about   string
+		baseURL string
+		want    []EventKind
+	}{
+		{"first run", srv.URL, []EventKind{EventStart, EventInterval, EventInterval, EventInterval, EventFinish}},
+		{"already synced", srv.URL, []EventKind{EventStart, EventFinish}},
+		{"unreachable", closed.URL, []EventKind{EventError}},
+	}
+	for _, c := range cases {
+		kinds = nil
+		h := &Harvest{
+			BaseURL:           c.baseURL,
+			Format:            "oai_dc",
+			From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			DailyInterval:     true,
+			RetryPolicy:       RetryPolicy{MaxAttempts: 1},
+			OnEvent:           WebhookEvents(hook.URL),
+		}
+		h.RunContext(context.Background())
+		if fmt.Sprint(kinds) != fmt.Sprint(c.want) {
+			t.Errorf("%s: got %v, want %v", c.about, kinds, c.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package metha
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ProvenanceNamespace is the namespace of the attributes added by Provenance.
+const ProvenanceNamespace = "https://github.com/miku/metha"
+
+// ExportRecord is a record on its way to the writer. Data starts as the
+// serialized record and can be rewritten by transforms.
+type ExportRecord struct {
+	Record   Record
+	Filename string
+	Data     []byte
+}
+
+// Transform modifies a record during export. Setting Data to nil drops the
+// record.
+type Transform func(*ExportRecord) error
+
+// Exporter streams cached records through a chain of transforms into a
+// writer. Only one response is held in memory at a time.
+type Exporter struct {
+	Harvest    *Harvest
+	Transforms []Transform
+	// Files to export, defaults to all files, including cold storage.
+	Files []string
+	// Root is an optional element to wrap all records into.
+	Root string
+	// Origin restricts the export to records, an aggregator harvested from
+	// this base URL. Files default to the partition of the origin, if the
+	// harvest is partitioned, see PartitionByOrigin.
+	Origin string
+}
+
+// Export writes all records, one per line, and returns the number of records
+// written.
+func (e *Exporter) Export(w io.Writer) (int, error) {
+	files := e.Files
+	if files == nil && e.Origin != "" {
+		files = e.Harvest.OriginFiles(e.Origin)
+	}
+	if files == nil {
+		files = append(e.Harvest.Files(), e.Harvest.ColdFiles()...)
+		sort.Slice(files, func(i, j int) bool {
+			return filepath.Base(files[i]) < filepath.Base(files[j])
+		})
+	}
+	bw := bufio.NewWriter(w)
+	if e.Root != "" {
+		fmt.Fprintf(bw, "<%s xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\">\n", e.Root)
+	}
+	var n int
+	for _, filename := range files {
+		err := eachResponse(filename, func(filename string, resp *Response) error {
+			for _, rec := range resp.ListRecords.Records {
+				if e.Origin != "" && rec.Origin() != e.Origin {
+					continue
+				}
+				b, err := xml.Marshal(rec)
+				if err != nil {
+					return err
+				}
+				item := &ExportRecord{Record: rec, Filename: filename, Data: b}
+				for _, t := range e.Transforms {
+					if err := t(item); err != nil {
+						return err
+					}
+					if item.Data == nil {
+						break
+					}
+				}
+				if item.Data == nil {
+					continue
+				}
+				bw.Write(item.Data)
+				if err := bw.WriteByte('\n'); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return n, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+	if e.Root != "" {
+		fmt.Fprintf(bw, "</%s>\n", e.Root)
+	}
+	return n, bw.Flush()
+}
+
+// StripEnvelope replaces the record with its metadata, e.g. the oai_dc:dc
+// element. Deleted records without metadata are dropped, as are about
+// containers, e.g. rights statements; export full records to keep them.
+func StripEnvelope(r *ExportRecord) error {
+	r.Data = bytes.TrimSpace(r.Record.Metadata.Body)
+	if len(r.Data) == 0 {
+		r.Data = nil
+	}
+	return nil
+}
+
+// Provenance returns a transform, that adds the endpoint, format, set and
+// cache file as attributes to the outermost element of the record.
+func Provenance(h *Harvest) Transform {
+	return func(r *ExportRecord) error {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, ` xmlns:metha="%s"`, ProvenanceNamespace)
+		attrs := []struct{ name, value string }{
+			{"endpoint", h.BaseURL},
+			{"format", h.Format},
+			{"set", h.Set},
+			{"file", filepath.Base(r.Filename)},
+		}
+		for _, a := range attrs {
+			if a.value == "" {
+				continue
+			}
+			fmt.Fprintf(&buf, ` metha:%s="`, a.name)
+			xml.EscapeText(&buf, []byte(a.value))
+			buf.WriteString(`"`)
+		}
+		data, err := insertAttributes(r.Data, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		r.Data = data
+		return nil
+	}
+}
+
+// insertAttributes adds attrs to the first start tag in b, skipping
+// declarations, processing instructions and comments.
+func insertAttributes(b, attrs []byte) ([]byte, error) {
+	i := 0
+	for {
+		j := bytes.IndexByte(b[i:], '<')
+		if j < 0 || i+j+1 >= len(b) {
+			return nil, fmt.Errorf("no start element found")
+		}
+		i += j
+		if b[i+1] != '!' && b[i+1] != '?' && b[i+1] != '/' {
+			break
+		}
+		end := markupEnd(b[i:])
+		k := bytes.Index(b[i:], end)
+		if k < 0 {
+			return nil, fmt.Errorf("unterminated markup")
+		}
+		i += k + len(end)
+	}
+	// find the end of the tag, ignoring > in quoted attribute values
+	var quote byte
+	for k := i + 1; k < len(b); k++ {
+		c := b[k]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			if b[k-1] == '/' {
+				k--
+			}
+			var buf bytes.Buffer
+			buf.Grow(len(b) + len(attrs))
+			buf.Write(b[:k])
+			buf.Write(attrs)
+			buf.Write(b[k:])
+			return buf.Bytes(), nil
+		}
+	}
+	return nil, fmt.Errorf("unterminated start element")
+}
+
+// markupEnd returns the terminator of the markup starting at b.
+func markupEnd(b []byte) []byte {
+	switch {
+	case bytes.HasPrefix(b, []byte("<!--")):
+		return []byte("-->")
+	case bytes.HasPrefix(b, []byte("<![CDATA[")):
+		return []byte("]]>")
+	case bytes.HasPrefix(b, []byte("<?")):
+		return []byte("?>")
+	}
+	return []byte(">")
+}
+
+// EscapeNonASCII replaces non-ASCII characters in text and attribute values
+// with numeric character references, so the output can be declared in any
+// ASCII compatible encoding. Invalid UTF-8 is replaced by U+FFFD. Element
+// names, comments and CDATA sections are kept as is.
+func EscapeNonASCII(r *ExportRecord) error {
+	var (
+		buf   bytes.Buffer
+		b     = r.Data
+		inTag bool
+		quote byte
+	)
+	buf.Grow(len(b))
+	for i := 0; i < len(b); {
+		c := b[i]
+		if c == '<' && !inTag && i+1 < len(b) && (b[i+1] == '!' || b[i+1] == '?') {
+			// copy comments, CDATA sections and processing instructions
+			end := markupEnd(b[i:])
+			k := bytes.Index(b[i:], end)
+			if k < 0 {
+				k = len(b) - i
+			} else {
+				k += len(end)
+			}
+			buf.Write(b[i : i+k])
+			i += k
+			continue
+		}
+		if c < utf8.RuneSelf {
+			switch {
+			case inTag && quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case inTag && (c == '"' || c == '\''):
+				quote = c
+			case inTag && c == '>':
+				inTag = false
+			case c == '<':
+				inTag = true
+			}
+			buf.WriteByte(c)
+			i++
+			continue
+		}
+		ru, size := utf8.DecodeRune(b[i:])
+		if inTag && quote == 0 {
+			buf.Write(b[i : i+size])
+		} else {
+			fmt.Fprintf(&buf, "&#x%X;", ru)
+		}
+		i += size
+	}
+	r.Data = buf.Bytes()
+	return nil
+}
+
+// DropRecords returns a transform, that drops records for which fn returns
+// true.
+func DropRecords(fn func(Record) bool) Transform {
+	return func(r *ExportRecord) error {
+		if fn(r.Record) {
+			r.Data = nil
+		}
+		return nil
+	}
+}
+
+// Dedup returns a transform, that drops record versions already seen.
+func Dedup(seen *SeenIndex) Transform {
+	return func(r *ExportRecord) error {
+		ok, err := seen.Add(r.Record)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			r.Data = nil
+		}
+		return nil
+	}
+}
+
+// ParseTransforms parses a comma separated list of transform names: strip,
+// provenance and ascii.
+func ParseTransforms(h *Harvest, s string) ([]Transform, error) {
+	var transforms []Transform
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "strip":
+			transforms = append(transforms, StripEnvelope)
+		case "provenance":
+			transforms = append(transforms, Provenance(h))
+		case "ascii":
+			transforms = append(transforms, EscapeNonASCII)
+		default:
+			return nil, fmt.Errorf("unknown transform: %s", name)
+		}
+	}
+	return transforms, nil
+}
@@ -0,0 +1,89 @@
+package metha
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEscapeNonASCII(t *testing.T) {
+	var cases = []struct {
+		in, want string
+	}{
+		{`<a>abc</a>`, `<a>abc</a>`},
+		{`<a>Müller</a>`, `<a>M&#xFC;ller</a>`},
+		{`<a title="é">x</a>`, `<a title="&#xE9;">x</a>`},
+		{`<a><!-- ä --><![CDATA[ö]]></a>`, `<a><!-- ä --><![CDATA[ö]]></a>`},
+		{"<a>\xff</a>", `<a>&#xFFFD;</a>`},
+	}
+	for _, c := range cases {
+		r := &ExportRecord{Data: []byte(c.in)}
+		if err := EscapeNonASCII(r); err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Data) != c.want {
+			t.Errorf("%s: got %s, want %s", c.in, r.Data, c.want)
+		}
+	}
+}
+
+func TestInsertAttributes(t *testing.T) {
+	var cases = []struct {
+		in, want string
+		err      bool
+	}{
+		{`<a>x</a>`, `<a b="1">x</a>`, false},
+		{`<a/>`, `<a b="1"/>`, false},
+		{`<a c=">">x</a>`, `<a c=">" b="1">x</a>`, false},
+		{`<?xml version="1.0"?><!-- <x> --><a>x</a>`, `<?xml version="1.0"?><!-- <x> --><a b="1">x</a>`, false},
+		{`text`, ``, true},
+	}
+	for _, c := range cases {
+		got, err := insertAttributes([]byte(c.in), []byte(` b="1"`))
+		if (err != nil) != c.err {
+			t.Errorf("%s: got error %v, want %v", c.in, err, c.err)
+		}
+		if string(got) != c.want {
+			t.Errorf("%s: got %s, want %s", c.in, got, c.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-export-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "2016-01-31-00000000.xml.gz")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`<OAI-PMH><ListRecords>
+		<record><header><identifier>1</identifier></header><metadata><dc>Zürich</dc></metadata></record>
+		<record><header status="deleted"><identifier>2</identifier></header></record>
+	</ListRecords></OAI-PMH>`))
+	zw.Close()
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	e := Exporter{
+		Harvest:    h,
+		Files:      []string{filename},
+		Transforms: []Transform{StripEnvelope, Provenance(h), EscapeNonASCII},
+	}
+	var out bytes.Buffer
+	n, err := e.Export(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<dc xmlns:metha="https://github.com/miku/metha" metha:endpoint="http://example.com/oai" metha:format="oai_dc" metha:file="2016-01-31-00000000.xml.gz">Z&#xFC;rich</dc>` + "\n"
+	if n != 1 || out.String() != want {
+		t.Errorf("got %d records: %s, want 1: %s", n, out.String(), want)
+	}
+}
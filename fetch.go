@@ -0,0 +1,104 @@
+package metha
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+// fetchIntervals returns the intervals of a fetch, from From or the earliest
+// datestamp up to Until or the end of yesterday. Without selective
+// harvesting, a single zero interval is returned.
+func (h *Harvest) fetchIntervals() ([]Interval, error) {
+	if h.DisableSelectiveHarvesting {
+		return []Interval{{}}, nil
+	}
+	var begin time.Time
+	var err error
+	if h.From == "" {
+		begin, err = h.earliestDate()
+	} else {
+		begin, err = time.ParseInLocation("2006-01-02", h.From, h.location())
+	}
+	if err != nil {
+		return nil, err
+	}
+	end := now.New(time.Now().In(h.location()).AddDate(0, 0, -1)).EndOfDay()
+	if h.Until != "" {
+		until, err := time.ParseInLocation("2006-01-02", h.Until, h.location())
+		if err != nil {
+			return nil, err
+		}
+		if until = now.New(until).EndOfDay(); until.Before(end) {
+			end = until
+		}
+	}
+	iv := Interval{Begin: begin.In(h.location()), End: end}
+	if h.DailyInterval {
+		return iv.DailyIntervals(), nil
+	}
+	return iv.MonthlyIntervals(), nil
+}
+
+// Fetch harvests records like a run and calls fn for each record, without
+// reading or writing the cache, e.g. for services, that index records
+// directly. Records from From (or the earliest datestamp) up to Until (or
+// yesterday) are requested, in the intervals of a run. Identify is requested,
+// if it is not set. As for a run, retries, error actions, token restarts,
+// quota pauses, MaxRequests per interval, the limits of the run, Delay and
+// RecordFilter apply. If fn returns ErrStop or a limit of the run is
+// reached, Fetch stops without error.
+func (h *Harvest) Fetch(ctx context.Context, fn func(Record) error) error {
+	if err := h.ValidateDates(); err != nil {
+		return err
+	}
+	if h.Identify == nil {
+		if err := h.IdentifyContext(ctx); err != nil {
+			return err
+		}
+	}
+	h.progress = progressState{}
+	intervals, err := h.fetchIntervals()
+	if err != nil {
+		return err
+	}
+	h.progress.intervals = len(intervals)
+	for index, iv := range intervals {
+		h.progress.interval = index
+		stopped, err := h.fetchRecords(ctx, index, iv, fn)
+		if err == ErrStop || stopped {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchRecords requests the records of an interval, following resumption
+// tokens, and passes them to fn. Returns true, if a limit of the run was
+// reached.
+func (h *Harvest) fetchRecords(ctx context.Context, index int, iv Interval, fn func(Record) error) (bool, error) {
+	filter := h.recordFilter()
+	l := &listing{
+		do: func(ctx context.Context, client Client, req *Request) (*Response, int, error) {
+			resp, err := client.DoContext(ctx, req)
+			return resp, 0, err
+		},
+		handle: func(req *Request, resp *Response) error {
+			if filter != nil {
+				filterRecords(resp, filter)
+			}
+			for _, rec := range resp.ListRecords.Records {
+				if err := fn(rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	err := h.listRecords(ctx, index, iv, l)
+	return l.stopped, err
+}
@@ -0,0 +1,79 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-fetch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	record := `<record><header><identifier>%s</identifier><datestamp>%s</datestamp></header></record>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity><earliestDatestamp>2016-01-01</earliestDatestamp></Identify></OAI-PMH>`)
+		case q.Get("resumptionToken") == "t1":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`</ListRecords></OAI-PMH>`, "2", "2016-01-20")
+		case q.Get("from") == "2016-01-01":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`<resumptionToken>t1</resumptionToken></ListRecords></OAI-PMH>`, "1", "2016-01-10")
+		case q.Get("from") == "2016-02-01":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`</ListRecords></OAI-PMH>`, "3", "2016-02-10")
+		default:
+			fmt.Fprint(w, `<OAI-PMH><error code="noRecordsMatch"></error></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		stopAfter   int
+		maxRequests int
+		maxRecords  int
+		want        string
+	}{
+		{0, 10, 0, "[1 2 3]"},
+		{2, 10, 0, "[1 2]"},
+		// no limit on the requests of an interval
+		{0, 0, 0, "[1 2 3]"},
+		{0, 1, 0, "[1 3]"},
+		// a limit of the run stops the fetch
+		{0, 0, 2, "[1 2]"},
+	}
+	for _, c := range cases {
+		h := &Harvest{BaseURL: srv.URL, Format: "oai_dc", Until: "2016-03-31", MaxRequests: c.maxRequests,
+			MaxRecords: c.maxRecords, MaxEmptyResponses: 10}
+		var ids []string
+		err := h.Fetch(context.Background(), func(rec Record) error {
+			ids = append(ids, rec.Header.Identifier)
+			if len(ids) == c.stopAfter {
+				return ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(ids) != c.want {
+			t.Errorf("%+v: got %v, want %v", c, ids, c.want)
+		}
+	}
+	// nothing is written
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries in base directory, want none", len(entries))
+	}
+}
@@ -2,14 +2,15 @@ package metha
 
 import (
 	"fmt"
-	"io"
-	"math/rand"
 	"os"
 	"path/filepath"
-
-	gzip "github.com/klauspost/pgzip"
+	"sync/atomic"
+	"time"
 )
 
+// tempSeq numbers the temporary suffixes of this process.
+var tempSeq uint64
+
 // MustGlob is like filepath.Glob, but panics on bad pattern.
 func MustGlob(pattern string) []string {
 	m, err := filepath.Glob(pattern)
@@ -19,30 +20,10 @@ func MustGlob(pattern string) []string {
 	return m
 }
 
-// MoveAndCompress will move src to dst, gzipping in the process.
-func MoveAndCompress(src, dst string) error {
-	tmp := fmt.Sprintf("%s-tmp-%d", dst, rand.Intn(999999999))
-
-	f, err := os.Create(tmp)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	gw := gzip.NewWriter(f)
-	defer gw.Close()
-
-	ff, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer ff.Close()
-
-	if _, err := io.Copy(gw, ff); err != nil {
-		return err
-	}
-	if err := os.Rename(tmp, dst); err != nil {
-		return err
-	}
-	return os.Remove(src)
+// newTempSuffix returns a suffix for temporary files, that is unique across
+// batches and processes: process id, time, a sequence number and random
+// bytes, e.g. -tmp-4711-1476698400000000000-1-9f86d081b4a3c2e0.
+func newTempSuffix(prefix string) string {
+	return fmt.Sprintf("%s%d-%d-%d-%s", prefix, os.Getpid(), time.Now().UnixNano(),
+		atomic.AddUint64(&tempSeq, 1), newRequestID())
 }
@@ -0,0 +1,53 @@
+package metha
+
+import (
+	"regexp"
+	"strings"
+)
+
+// RecordFilter decides, whether a record is kept during a harvest.
+type RecordFilter func(Record) bool
+
+// NotDeleted keeps records, that are not marked as deleted.
+func NotDeleted(r Record) bool {
+	return r.Header.Status != "deleted"
+}
+
+// HasMetadata keeps records with a non-empty metadata section.
+func HasMetadata(r Record) bool {
+	return strings.TrimSpace(string(r.Metadata.Body)) != ""
+}
+
+// IdentifierMatches returns a filter, that keeps records, whose identifier
+// matches a pattern.
+func IdentifierMatches(re *regexp.Regexp) RecordFilter {
+	return func(r Record) bool {
+		return re.MatchString(r.Header.Identifier)
+	}
+}
+
+// AllFilters returns a filter, that keeps records, that pass all filters.
+func AllFilters(filters ...RecordFilter) RecordFilter {
+	return func(r Record) bool {
+		for _, f := range filters {
+			if !f(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// filterRecords removes records from a response, that do not pass the
+// filter, and returns the number of records removed.
+func filterRecords(resp *Response, filter RecordFilter) int {
+	var kept []Record
+	for _, r := range resp.ListRecords.Records {
+		if filter(r) {
+			kept = append(kept, r)
+		}
+	}
+	n := len(resp.ListRecords.Records) - len(kept)
+	resp.ListRecords.Records = kept
+	return n
+}
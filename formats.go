@@ -0,0 +1,129 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// FormatError is returned, if an endpoint cannot disseminate the requested
+// format. Available lists the formats, the endpoint supports.
+type FormatError struct {
+	Format    string
+	Available []string
+}
+
+// Error lists the available formats.
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("cannot disseminate format %s, available: %s",
+		e.Format, strings.Join(e.Available, ", "))
+}
+
+// Unwrap allows to match a FormatError with ErrCannotDisseminateFormat.
+func (e *FormatError) Unwrap() error {
+	return ErrCannotDisseminateFormat
+}
+
+// MetadataFormats returns the metadata prefixes supported by the endpoint.
+func (h *Harvest) MetadataFormats(ctx context.Context) ([]string, error) {
+	req := Request{Verb: "ListMetadataFormats", BaseURL: h.BaseURL, UsePost: h.UsePost}
+	policy := DefaultRetryPolicy
+	policy.MaxAttempts = 2
+	c := h.client(30*time.Second, policy)
+
+	resp, err := c.DoContext(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error.Code != "" {
+		return nil, resp.Error
+	}
+	var prefixes []string
+	for _, f := range resp.ListMetadataFormats.MetadataFormat {
+		prefixes = append(prefixes, strings.TrimSpace(f.MetadataPrefix))
+	}
+	return prefixes, nil
+}
+
+// negotiateFormat keeps the requested format, if the endpoint supports it,
+// otherwise switches to the first supported fallback.
+func (h *Harvest) negotiateFormat(ctx context.Context) error {
+	available, err := h.MetadataFormats(ctx)
+	if err != nil {
+		return err
+	}
+	supported := make(map[string]bool)
+	for _, prefix := range available {
+		supported[prefix] = true
+	}
+	if supported[h.Format] {
+		return nil
+	}
+	for _, prefix := range h.FormatFallbacks {
+		if supported[prefix] {
+			log.Printf("format %s not supported, falling back to %s", h.Format, prefix)
+			h.Format = prefix
+			return nil
+		}
+	}
+	return &FormatError{Format: h.Format, Available: available}
+}
+
+// formatError turns a cannotDisseminateFormat error into a FormatError, if
+// the available formats can be listed.
+func (h *Harvest) formatError(ctx context.Context, oaiErr OAIError) error {
+	available, err := h.MetadataFormats(ctx)
+	if err != nil {
+		return oaiErr
+	}
+	return &FormatError{Format: h.Format, Available: available}
+}
+
+// runFormats harvests each of Formats. A failing format does not stop the
+// others, all errors are returned together. Files of all formats are
+// reported by NewFiles and summed up in the result. MaxBytes, MaxRecords and
+// MaxTotalRequests apply to all formats together; once a limit stops a
+// format, the remaining formats are left for the next run.
+func (h *Harvest) runFormats(ctx context.Context) (*Result, error) {
+	defer func(format string, fallbacks []string, earlier runCounts) {
+		h.Format, h.FormatFallbacks, h.earlier = format, fallbacks, earlier
+	}(h.Format, h.FormatFallbacks, h.earlier)
+	h.FormatFallbacks = nil
+	earlier := h.earlier
+
+	var (
+		errs   []error
+		synced int
+	)
+	result := &Result{Started: time.Now()}
+	for _, format := range h.Formats {
+		h.Format = format
+		h.earlier = earlier.plus(result)
+		r, err := h.runFormatResult(ctx)
+		result.add(r)
+		result.Duration = time.Since(result.Started)
+		switch {
+		case err == nil && r.AlreadySynced:
+			synced++
+		case err == nil:
+		case ctx.Err() != nil:
+			h.finalized = result.Files
+			return result, err
+		default:
+			log.Printf("format %s failed: %s", format, err)
+			errs = append(errs, fmt.Errorf("%s: %w", format, err))
+		}
+		if r.Stopped {
+			log.Printf("run limit reached with format %s, stopping", format)
+			break
+		}
+	}
+	h.finalized = result.Files
+	if len(errs) > 0 {
+		return result, &MultiError{Errors: errs}
+	}
+	result.AlreadySynced = synced == len(h.Formats)
+	return result, nil
+}
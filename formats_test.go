@@ -0,0 +1,146 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<OAI-PMH><ListMetadataFormats>
+			<metadataFormat><metadataPrefix>oai_dc</metadataPrefix></metadataFormat>
+			<metadataFormat><metadataPrefix>marcxml</metadataPrefix></metadataFormat>
+		</ListMetadataFormats></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		format    string
+		fallbacks []string
+		want      string
+		err       bool
+	}{
+		{"oai_dc", []string{"marcxml"}, "oai_dc", false},
+		{"mets", []string{"xyz", "marcxml", "oai_dc"}, "marcxml", false},
+		{"mets", []string{"xyz"}, "mets", true},
+	}
+	for _, c := range cases {
+		h := Harvest{BaseURL: srv.URL, Format: c.format, FormatFallbacks: c.fallbacks}
+		err := h.negotiateFormat(context.Background())
+		if _, ok := err.(*FormatError); ok != c.err {
+			t.Errorf("%s: got error %v, want format error: %v", c.format, err, c.err)
+		}
+		if h.Format != c.want {
+			t.Errorf("%s: got %s, want %s", c.format, h.Format, c.want)
+		}
+	}
+}
+
+func TestRunFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-formats-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	var identify int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			identify++
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case q.Get("verb") == "ListMetadataFormats":
+			fmt.Fprint(w, `<OAI-PMH><ListMetadataFormats><metadataFormat><metadataPrefix>oai_dc</metadataPrefix></metadataFormat></ListMetadataFormats></OAI-PMH>`)
+		case q.Get("metadataPrefix") == "mets":
+			fmt.Fprint(w, `<OAI-PMH><error code="cannotDisseminateFormat"/></OAI-PMH>`)
+		default:
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		Formats:           []string{"oai_dc", "mets", "marcxml"},
+		From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+	}
+	err = h.RunContext(context.Background())
+	if merr, ok := err.(*MultiError); !ok || len(merr.Errors) != 1 || !errors.Is(merr.Errors[0], ErrCannotDisseminateFormat) {
+		t.Errorf("got %v, want a single format error", err)
+	}
+	if identify != 1 {
+		t.Errorf("got %d Identify requests, want 1", identify)
+	}
+	for _, format := range []string{"oai_dc", "marcxml"} {
+		f := &Harvest{BaseURL: srv.URL, Format: format}
+		if len(f.Files()) == 0 {
+			t.Errorf("%s: no files harvested", format)
+		}
+	}
+	if h.Format != "oai_dc" {
+		t.Errorf("got format %s after run, want oai_dc", h.Format)
+	}
+}
+
+func TestRunFormatsLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-formats-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+			return
+		}
+		fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		maxRecords int
+		records    int
+		stopped    bool
+		marcxml    bool
+	}{
+		{2, 2, true, false},
+		{0, 6, false, true},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Formats:           []string{"oai_dc", "marcxml"},
+			From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+			DailyInterval:     true,
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			MaxRecords:        c.maxRecords,
+		}
+		r, err := h.RunResult(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Records != c.records || r.Stopped != c.stopped {
+			t.Errorf("max %d: got %d records, stopped %v, want %d, %v", c.maxRecords, r.Records, r.Stopped, c.records, c.stopped)
+		}
+		f := &Harvest{BaseURL: srv.URL, Format: "marcxml"}
+		if got := len(f.Files()) > 0; got != c.marcxml {
+			t.Errorf("max %d: got marcxml files %v, want %v", c.maxRecords, got, c.marcxml)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package metha
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The two granularities defined by OAI-PMH.
+const (
+	GranularityDay    = "YYYY-MM-DD"
+	GranularitySecond = "YYYY-MM-DDThh:mm:ssZ"
+)
+
+// ErrUnknownGranularity is returned for a ForceGranularity, that cannot be
+// mapped to one of the OAI-PMH granularities.
+var ErrUnknownGranularity = errors.New("unknown granularity")
+
+// granularities maps granularities seen in the wild, upper case and without
+// spaces, to the OAI-PMH granularities.
+var granularities = map[string]string{
+	"YYYY-MM-DD":             GranularityDay,
+	"YYYYMMDD":               GranularityDay,
+	"DAY":                    GranularityDay,
+	"DAYS":                   GranularityDay,
+	"DATE":                   GranularityDay,
+	"YYYY-MM-DDTHH:MM:SSZ":   GranularitySecond,
+	"YYYY-MM-DDTHH:MM:SS":    GranularitySecond,
+	"YYYY-MM-DDHH:MM:SS":     GranularitySecond,
+	"YYYY-MM-DDTHH:MM:SS.SZ": GranularitySecond,
+	"SECOND":                 GranularitySecond,
+	"SECONDS":                GranularitySecond,
+}
+
+// ParseGranularity maps an advertised granularity to GranularityDay or
+// GranularitySecond, tolerating case, spaces and variants like
+// "YYYY-MM-DDThh:mm:ss" without Z. It returns false, if the granularity is
+// unknown.
+func ParseGranularity(s string) (string, bool) {
+	key := strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	v, ok := granularities[key]
+	return v, ok
+}
+
+// validateGranularity checks ForceGranularity.
+func (h *Harvest) validateGranularity() error {
+	if h.ForceGranularity == "" {
+		return nil
+	}
+	if _, ok := ParseGranularity(h.ForceGranularity); !ok {
+		return fmt.Errorf("%w: %q, use %s or %s", ErrUnknownGranularity,
+			h.ForceGranularity, GranularityDay, GranularitySecond)
+	}
+	return nil
+}
+
+// granularity returns the granularity used for requests, ForceGranularity
+// or the one advertised by the endpoint, or an empty string, if unknown.
+func (h *Harvest) granularity() string {
+	s := h.ForceGranularity
+	if s == "" && h.Identify != nil {
+		s = h.Identify.Granularity
+	}
+	v, _ := ParseGranularity(s)
+	return v
+}
@@ -0,0 +1,51 @@
+package metha
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGranularity(t *testing.T) {
+	var cases = []struct {
+		advertised string
+		force      string
+		layout     string
+		earliest   string
+		want       string
+	}{
+		{"YYYY-MM-DD", "", "2006-01-02", "2001-02-03", "2001-02-03"},
+		{"YYYY-MM-DDThh:mm:ssZ", "", "2006-01-02T15:04:05Z", "2001-02-03T04:05:06Z", "2001-02-03T04:05:06Z"},
+		{"YYYY-MM-DDThh:mm:ss", "", "2006-01-02T15:04:05Z", "2001-02-03T04:05:06", "2001-02-03T00:00:00Z"},
+		{" yyyy-mm-dd ", "", "2006-01-02", "2001-02-03", "2001-02-03"},
+		{"YYYY-MM-DD hh:mm:ss", "", "2006-01-02T15:04:05Z", "2001-02-03 04:05:06", "2001-02-03T00:00:00Z"},
+		{"YYYY-MM-DDThh:mm:ssZ", "YYYY-MM-DD", "2006-01-02", "2001-02-03T04:05:06Z", "2001-02-03"},
+		{"", "day", "2006-01-02", "2001-02-03", "2001-02-03"},
+		{"hourly", "", "", "2001-02-03", ""},
+	}
+	for _, c := range cases {
+		h := Harvest{Identify: &Identify{Granularity: c.advertised, EarliestDatestamp: c.earliest}, ForceGranularity: c.force}
+		if got := h.DateLayout(); got != c.layout {
+			t.Errorf("%q, %q: got layout %q, want %q", c.advertised, c.force, got, c.layout)
+		}
+		earliest, err := h.earliestDate()
+		if c.want == "" {
+			if err != ErrInvalidEarliestDate {
+				t.Errorf("%q: got %v, want ErrInvalidEarliestDate", c.advertised, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.advertised, err)
+			continue
+		}
+		if got := earliest.Format(h.DateLayout()); got != c.want {
+			t.Errorf("%q, %q: got earliest %s, want %s", c.advertised, c.force, got, c.want)
+		}
+	}
+
+	h := Harvest{ForceGranularity: "minutes", From: time.Now().Format("2006-01-02")}
+	if err := h.ValidateDates(); !errors.Is(err, ErrUnknownGranularity) {
+		t.Errorf("got %v, want ErrUnknownGranularity", err)
+	}
+}
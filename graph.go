@@ -0,0 +1,174 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// NodeRecord is a record identifier.
+	NodeRecord = "record"
+	// NodeSet is a set spec.
+	NodeSet = "set"
+	// NodeRelation is the target of a dc:relation, usually an URL or identifier.
+	NodeRelation = "relation"
+)
+
+// Edge connects two nodes in a graph.
+type Edge struct {
+	Source string
+	Target string
+	Kind   string
+}
+
+// Graph of records, sets and relations.
+type Graph struct {
+	// Nodes are kept in insertion order, Kinds maps node to node kind.
+	Nodes []string
+	Kinds map[string]string
+	Edges []Edge
+
+	seen map[Edge]bool
+}
+
+// NewGraph creates an empty graph.
+func NewGraph() *Graph {
+	return &Graph{Kinds: make(map[string]string), seen: make(map[Edge]bool)}
+}
+
+// addNode adds a node, if it does not exist yet. A relation target, that
+// turns out to be a record of the harvest is marked as record.
+func (g *Graph) addNode(id, kind string) {
+	if k, ok := g.Kinds[id]; ok {
+		if k == NodeRelation && kind == NodeRecord {
+			g.Kinds[id] = kind
+		}
+		return
+	}
+	g.Nodes = append(g.Nodes, id)
+	g.Kinds[id] = kind
+}
+
+// AddEdge adds an edge and its nodes, duplicate edges are ignored.
+func (g *Graph) AddEdge(source, sourceKind, target, targetKind, kind string) {
+	e := Edge{Source: source, Target: target, Kind: kind}
+	if g.seen[e] {
+		return
+	}
+	g.seen[e] = true
+	g.addNode(source, sourceKind)
+	g.addNode(target, targetKind)
+	g.Edges = append(g.Edges, e)
+}
+
+// WriteCSV writes the graph as an edge list with source, target and kind.
+func (g *Graph) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"source", "target", "kind"}); err != nil {
+		return err
+	}
+	for _, e := range g.Edges {
+		if err := cw.Write([]string{e.Source, e.Target, e.Kind}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteGraphML writes the graph in GraphML format, node and edge kinds are
+// stored as data attributes.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	escape := func(s string) string {
+		var buf bytes.Buffer
+		xml.EscapeText(&buf, []byte(s))
+		return buf.String()
+	}
+	if _, err := io.WriteString(w, xml.Header+
+		`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`+"\n"+
+		`<key id="kind" for="node" attr.name="kind" attr.type="string"/>`+"\n"+
+		`<key id="rel" for="edge" attr.name="kind" attr.type="string"/>`+"\n"+
+		`<graph id="G" edgedefault="directed">`+"\n"); err != nil {
+		return err
+	}
+	for _, n := range g.Nodes {
+		if _, err := fmt.Fprintf(w, `<node id="%s"><data key="kind">%s</data></node>`+"\n",
+			escape(n), g.Kinds[n]); err != nil {
+			return err
+		}
+	}
+	for _, e := range g.Edges {
+		if _, err := fmt.Fprintf(w, `<edge source="%s" target="%s"><data key="rel">%s</data></edge>`+"\n",
+			escape(e.Source), escape(e.Target), e.Kind); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "</graph>\n</graphml>\n")
+	return err
+}
+
+// relations extracts the values of all relation elements from metadata, e.g.
+// dc:relation in oai_dc.
+func relations(md Metadata) ([]string, error) {
+	var result []string
+	dec := xml.NewDecoder(bytes.NewReader(md.Body))
+	dec.Strict = false
+	var inRelation bool
+	var buf bytes.Buffer
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "relation" {
+				inRelation = true
+				buf.Reset()
+			}
+		case xml.CharData:
+			if inRelation {
+				buf.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "relation" && inRelation {
+				if v := strings.TrimSpace(buf.String()); v != "" {
+					result = append(result, v)
+				}
+				inRelation = false
+			}
+		}
+	}
+	return result, nil
+}
+
+// Graph builds a graph of set membership and relations of all records in the
+// cache. Deleted records are skipped.
+func (h *Harvest) Graph() (*Graph, error) {
+	g := NewGraph()
+	err := h.EachRecord(func(rec Record) error {
+		if rec.Header.Status == "deleted" {
+			return nil
+		}
+		id := rec.Header.Identifier
+		for _, spec := range rec.Header.SetSpec {
+			g.AddEdge(id, NodeRecord, spec, NodeSet, "member")
+		}
+		rels, err := relations(rec.Metadata)
+		if err != nil {
+			return fmt.Errorf("%s: %s", id, err)
+		}
+		for _, rel := range rels {
+			g.AddEdge(id, NodeRecord, rel, NodeRelation, "relation")
+		}
+		return nil
+	})
+	return g, err
+}
@@ -0,0 +1,119 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-graph-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	dc := func(rels ...string) string {
+		var buf bytes.Buffer
+		buf.WriteString(`<metadata><oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+		for _, rel := range rels {
+			buf.WriteString(`<dc:relation>` + rel + `</dc:relation>`)
+		}
+		buf.WriteString(`</oai_dc:dc></metadata>`)
+		return buf.String()
+	}
+	var cases = []struct {
+		about   string
+		records string
+		csv     string
+		kinds   map[string]string
+	}{
+		{
+			"set membership",
+			`<record><header><identifier>a</identifier><setSpec>s1</setSpec><setSpec>s2</setSpec></header>` + dc() + `</record>`,
+			"a,s1,member\na,s2,member\n",
+			map[string]string{"a": NodeRecord, "s1": NodeSet, "s2": NodeSet},
+		},
+		{
+			"relations, duplicates are dropped",
+			`<record><header><identifier>a</identifier></header>` + dc(" http://x.org/?a=1&amp;b=2 ", "http://x.org/?a=1&amp;b=2", "") + `</record>`,
+			"a,http://x.org/?a=1&b=2,relation\n",
+			map[string]string{"a": NodeRecord, "http://x.org/?a=1&b=2": NodeRelation},
+		},
+		{
+			"related records are records",
+			`<record><header><identifier>a</identifier></header>` + dc("b") + `</record>` +
+				`<record><header><identifier>b</identifier><setSpec>s1</setSpec></header>` + dc() + `</record>`,
+			"a,b,relation\nb,s1,member\n",
+			map[string]string{"a": NodeRecord, "b": NodeRecord, "s1": NodeSet},
+		},
+		{
+			"deleted records are skipped",
+			`<record><header status="deleted"><identifier>a</identifier><setSpec>s1</setSpec></header></record>`,
+			"",
+			map[string]string{},
+		},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		doc := `<OAI-PMH><ListRecords>` + c.records + `</ListRecords></OAI-PMH>`
+		if err := ioutil.WriteFile(filepath.Join(h.Dir(), "2016-01-31-00000000.xml"), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		g, err := h.Graph()
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		var buf bytes.Buffer
+		if err := g.WriteCSV(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimPrefix(buf.String(), "source,target,kind\n"); got != c.csv {
+			t.Errorf("%s: got %q, want %q", c.about, got, c.csv)
+		}
+		if len(g.Kinds) != len(c.kinds) {
+			t.Errorf("%s: got nodes %v, want %v", c.about, g.Kinds, c.kinds)
+		}
+		for id, kind := range c.kinds {
+			if g.Kinds[id] != kind {
+				t.Errorf("%s: got %s for %s, want %s", c.about, g.Kinds[id], id, kind)
+			}
+		}
+		// GraphML must be well-formed and contain the same graph
+		buf.Reset()
+		if err := g.WriteGraphML(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var graphml struct {
+			Nodes []struct {
+				ID   string `xml:"id,attr"`
+				Kind string `xml:"data"`
+			} `xml:"graph>node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"graph>edge"`
+		}
+		if err := xml.Unmarshal(buf.Bytes(), &graphml); err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if len(graphml.Nodes) != len(g.Nodes) || len(graphml.Edges) != len(g.Edges) {
+			t.Errorf("%s: got %d nodes and %d edges in GraphML, want %d and %d", c.about,
+				len(graphml.Nodes), len(graphml.Edges), len(g.Nodes), len(g.Edges))
+		}
+		for _, n := range graphml.Nodes {
+			if g.Kinds[n.ID] != n.Kind {
+				t.Errorf("%s: got node %s of kind %s in GraphML", c.about, n.ID, n.Kind)
+			}
+		}
+	}
+}
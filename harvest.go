@@ -1,20 +1,22 @@
 package metha
 
 import (
-	"encoding/base64"
+	"bufio"
+	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/jinzhu/now"
@@ -26,7 +28,7 @@ const Day = 24 * time.Hour
 var (
 	// BaseDir is where all downloaded data is stored
 	BaseDir   = filepath.Join(UserHomeDir(), ".metha")
-	fnPattern = regexp.MustCompile("(?P<Date>[0-9]{4,4}-[0-9]{2,2}-[0-9]{2,2})-[0-9]{8,}.xml(.gz)?$")
+	fnPattern = regexp.MustCompile("(?P<Date>[0-9]{4,4}-[0-9]{2,2}-[0-9]{2,2})-[0-9]{8,}.xml(.gz|.zst)?(.cold)?$")
 
 	// ErrAlreadySynced is not really an error, only signals completion.
 	ErrAlreadySynced = errors.New("already synced")
@@ -47,7 +49,9 @@ func PrependSchema(s string) string {
 // funny chars in responses. Some repos do not support selective harvesting
 // (e.g. zvdd.org/oai2). Set "DisableSelectiveHarvesting" to try to grab
 // metadata from these repositories. From and Until must always be given with
-// 2006-01-02 layout. TODO(miku): make zero type work (lazily run identify).
+// 2006-01-02 layout, other layouts are normalized, see ValidateDates. Until
+// limits the harvest to records up to that day. If Identify is not set, it is
+// requested on the first run.
 type Harvest struct {
 	BaseURL string
 	Format  string
@@ -55,36 +59,269 @@ type Harvest struct {
 	From    string
 	Until   string
 
+	// Formats harvests several formats of an endpoint in one run, one after
+	// another, each into its own directory. Identify is requested once and
+	// rate limits apply across all formats. Format and FormatFallbacks are
+	// not used.
+	Formats []string
+	// Sets harvests several sets in one run, each into its own directory,
+	// like Formats. SetInclude and SetExclude filter Sets, Set or, without
+	// both, all sets listed by the endpoint. Patterns match the whole
+	// setSpec with * as wildcard, e.g. embargoed-*, or are regular
+	// expressions enclosed in slashes, e.g. /^embargoed-/. See ResolveSets.
+	Sets       []string
+	SetInclude []string
+	SetExclude []string
+
 	MaxRequests                int
 	DisableSelectiveHarvesting bool
 	CleanBeforeDecode          bool
 	IgnoreHTTPErrors           bool
 	MaxEmptyResponses          int
 	SuppressFormatParameter    bool
+	UsePost                    bool
+	// FormatFallbacks are tried in order, if the endpoint does not support
+	// Format, e.g. []string{"marcxml", "oai_dc"}.
+	FormatFallbacks []string
+	// ErrorActions configure the reaction to OAI error codes, e.g.
+	// {"noSetHierarchy": ErrorIgnore}. Unset codes use DefaultErrorActions.
+	ErrorActions map[string]ErrorAction
+	// TokenRestarts is the number of times an interval is restarted after a
+	// badResumptionToken error, e.g. when tokens expire during long harvests.
+	TokenRestarts int
+	// MaxBytes, MaxRecords and MaxTotalRequests stop a run, once the number
+	// of bytes downloaded, records received or requests sent is exceeded.
+	// While MaxRequests limits the requests of each interval, these limit the
+	// whole run. The files of the interval in progress are moved into place
+	// and a resume point is written, so the next run harvests that interval
+	// again.
+	MaxBytes         int64
+	MaxRecords       int
+	MaxTotalRequests int
 	// TODO: use more flexible intervals
 	DailyInterval bool
+	// Delay is the minimum time between two requests.
+	Delay time.Duration
+	// MaxBandwidth caps the sustained download rate in bytes per second, by
+	// pausing before a request, until the bytes of the last one have been
+	// received at this rate.
+	MaxBandwidth int64
+	// Window restricts requests to a time of day in Timezone, e.g.
+	// 22:00-06:00 for off-peak harvesting. Outside the window, the harvest
+	// pauses and continues the interval in progress, when the window opens
+	// again; expired resumption tokens are handled like with TokenRestarts.
+	Window *Window
+	// RequestTimeout caps the time of a single attempt of a request,
+	// including reading the body, defaults to DefaultTimeout for harvesting
+	// requests. StallTimeout fails an attempt, if no bytes arrive for this
+	// long. Both are retried according to the retry policy, except in
+	// streaming mode, where part of the response may already be written.
+	RequestTimeout time.Duration
+	StallTimeout   time.Duration
+	// HTTPCache sends conditional requests, if the endpoint supports ETag or
+	// Last-Modified. Intervals, that did not change at all, are not written.
+	HTTPCache bool
+	// Compression of cached files, defaults to gzip.
+	Compression Compression
+	// Naming of the harvest directory. Shard places files into YYYY/MM
+	// subdirectories. Files are found regardless of these settings.
+	Naming Naming
+	Shard  bool
+	// RetryPolicy for failed requests and OAI InternalException errors,
+	// defaults to DefaultRetryPolicy.
+	RetryPolicy RetryPolicy
+
+	// PostChunkCommand is run after each file is moved into place, {} is
+	// replaced with the path. Its failures are logged, the harvest goes on.
+	// PostRunCommand runs after a successful harvest, its failure fails the
+	// run. Both receive a JSON context on stdin.
+	PostChunkCommand string
+	PostRunCommand   string
 
 	Identify *Identify
 	Started  time.Time
 
+	// Transport for HTTP requests, e.g. to record or simulate an endpoint,
+	// defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	// Progress is called after each request, if set.
+	Progress ProgressFunc
+	// OnEvent is called when a run starts, after each interval and when a
+	// run fails or finishes, if set.
+	OnEvent EventFunc
+
+	// StreamResponses writes responses to disk as they arrive, instead of
+	// decoding them in memory first. Records larger than MaxRecordSize bytes
+	// are skipped and logged; a positive MaxRecordSize implies streaming.
+	StreamResponses bool
+	MaxRecordSize   int64
+	// RawMode writes responses byte for byte as received, after
+	// decompression and, with CleanBeforeDecode, removal of control
+	// characters. It implies streaming; MaxRecordSize and RecordFilter are
+	// ignored, since skipping records would alter the response.
+	RawMode bool
+	// HeaderIndex maintains an index of record headers in the harvest
+	// directory, updated as files are moved into place. An existing index
+	// is always kept up to date.
+	HeaderIndex bool
+	// VersionStore keeps every version of each record in the versions
+	// directory, with the metadata stored once per content hash and a
+	// version chain per identifier, see RecordAt. Cached files may be
+	// compacted or purged, the store keeps the history. An existing store
+	// is always kept up to date.
+	VersionStore bool
+	// PartitionByOrigin sorts the records of a harvest from an aggregator
+	// into a directory per origin, as given by the provenance in the about
+	// section of each record, so a single origin can be exported or
+	// re-harvested, see ReharvestOriginContext. Existing partitions are
+	// always kept up to date.
+	PartitionByOrigin bool
+	// RecordFilter, if set, drops records before they are written, e.g.
+	// deleted records or records with identifiers not matching a pattern.
+	RecordFilter RecordFilter
+	// KeepCompressed stores gzip compressed responses as sent by the server,
+	// without decompressing and compressing them again, if cached files are
+	// gzip compressed, too. Like RawMode, responses are kept byte for byte,
+	// but control characters are not removed either.
+	KeepCompressed bool
+	// MaxResponseBytes fails the harvest on responses larger than this, after
+	// decompression, before they exhaust memory or disk.
+	MaxResponseBytes int64
+	// HostLimiter, if set, limits the request rate per host, shared with
+	// other harvests using the same limiter or limiter directory.
+	HostLimiter *HostLimiter
+	// FollowMoved switches to the new base URL, if the endpoint redirects
+	// permanently on Identify, e.g. from http to https. The cache directory
+	// is moved along, since its name contains the base URL. Otherwise, only
+	// a warning is logged.
+	FollowMoved bool
+	// UserAgent is sent with each request, e.g. UserAgent("ops@example.org")
+	// to include a contact, defaults to DefaultUserAgent.
+	UserAgent string
+	// Validate checks each response against the rules of the OAI-PMH schema
+	// and of the metadata format, if known. Invalid responses are listed in
+	// a report and, depending on the action, kept, quarantined or rejected.
+	Validate ValidationAction
+	// Sanitize repairs broken encodings of responses, beyond the removal of
+	// control characters by CleanBeforeDecode. Repairs are counted in the
+	// run manifest.
+	Sanitize Sanitize
+
+	// Timezone is the zone, in which interval boundaries (midnight, end of
+	// day or month) are computed, defaults to UTC. Timestamps are always sent
+	// in UTC, dates in this zone.
+	Timezone *time.Location
+	// Overlap moves the start of each interval back, so records near the
+	// boundaries are not missed, e.g. due to clock skew on the server.
+	Overlap time.Duration
+	// LagDays harvests the last days before the previous harvest again, for
+	// servers, that index records later than their datestamp. Record
+	// versions already cached are not written again.
+	LagDays int
+
+	// Parallel is the number of intervals downloaded concurrently. Files are
+	// still moved into place one interval at a time and in order, so an
+	// interrupted run leaves no gaps. Delay applies across all downloads.
+	Parallel int
+
+	// AutoDisableSelective switches to a harvest without intervals, when the
+	// endpoint seems to ignore from and until, e.g. because two intervals
+	// start with the same record. Intervals already moved into place are
+	// kept. Without it, only a warning is logged.
+	AutoDisableSelective bool
+	// AdaptiveIntervals splits an interval in halves and harvests them one
+	// after another, if the server fails on it with a timeout, a server
+	// error or badArgument, e.g. because the interval contains too many
+	// records. Halves are split further down to single days.
+	AdaptiveIntervals bool
+	// ResumeWindow keeps the responses of an unfinished harvest without
+	// intervals, together with the last resumption token. A run within this
+	// duration continues with the token, instead of starting over.
+	ResumeWindow time.Duration
+	// LockWait is how long a run waits for another process harvesting into
+	// the same directory to finish. By default, it fails with ErrLocked.
+	LockWait time.Duration
+	// Quota pauses the harvest after an exhausted request quota, instead of
+	// failing, see QuotaPolicy.
+	Quota QuotaPolicy
+	// ForceGranularity overrides the granularity advertised by the endpoint,
+	// e.g. YYYY-MM-DD for endpoints, that advertise timestamps, but only
+	// accept dates. Variants are accepted, see ParseGranularity.
+	ForceGranularity string
+	// RequestMiddleware is applied in order to each request before it is
+	// sent, e.g. SetParam("apikey", "secret") for endpoints, that require
+	// additional parameters.
+	RequestMiddleware []RequestMiddleware
+	// Debug logs method, full URL, status and latency of each HTTP request,
+	// including retries. DebugDump additionally writes failing responses,
+	// with an HTTP error status or an OAI error, together with headers and
+	// raw body to the debug directory of the harvest. Dumping implies Debug
+	// and reads each response into memory.
+	Debug     bool
+	DebugDump bool
+
+	// files moved into place during this run
+	finalized []string
+	// progress of the current run
+	progress progressState
+	// counts of earlier formats and sets of the run, for the run limits
+	earlier runCounts
+	// time of the last request, for Delay
+	lastRequest time.Time
+	// bytes downloaded before the last request, for MaxBandwidth
+	lastBytes int64
+	// protects progress and lastRequest during parallel downloads
+	mu sync.Mutex
+	// http cache of the current run
+	cache *CacheTransport
+	// first responses of intervals, to detect ignored from and until
+	selective selectiveState
+	// record versions cached for the days harvested again
+	lag lagState
+	// time of the last Identify request
+	identified time.Time
+	// latest version per identifier in the version store, loaded on first use
+	versionsLatest map[string]RecordVersion
+
 	// protects the (rare) case, where we are in the process of renaming
 	// harvested files and get a termination signal at the same time.
 	sync.Mutex
 }
 
 // NewHarvest creates a new harvest. A network connection will be used for an initial Identify request.
+// Known workarounds for the endpoint are applied, see Quirks, fields can be
+// changed afterwards.
 func NewHarvest(baseURL string) (*Harvest, error) {
+	return NewHarvestContext(context.Background(), baseURL)
+}
+
+// NewHarvestContext is like NewHarvest, but the Identify request can be
+// cancelled through the context.
+func NewHarvestContext(ctx context.Context, baseURL string) (*Harvest, error) {
 	h := Harvest{BaseURL: baseURL}
-	if err := h.identify(); err != nil {
+	h.ApplyQuirks()
+	if err := h.IdentifyContext(ctx); err != nil {
 		return nil, err
 	}
 	return &h, nil
 }
 
-// Dir returns the absolute path to the harvesting directory.
+// Dir returns the absolute path to the harvesting directory. With the default
+// naming, an existing directory with a readable name is used, if there is no
+// base64 named one.
 func (h *Harvest) Dir() string {
-	data := []byte(h.Set + "#" + h.Format + "#" + h.BaseURL)
-	return filepath.Join(BaseDir, base64.RawURLEncoding.EncodeToString(data))
+	readable := filepath.Join(BaseDir, h.readableName())
+	if h.Naming == NamingReadable {
+		return readable
+	}
+	encoded := filepath.Join(BaseDir, h.encodedName())
+	if _, err := os.Stat(encoded); os.IsNotExist(err) {
+		if _, err := os.Stat(readable); err == nil {
+			return readable
+		}
+	}
+	return encoded
 }
 
 // MkdirAll creates necessary directories.
@@ -94,34 +331,169 @@ func (h *Harvest) MkdirAll() error {
 			return err
 		}
 	}
-	return nil
+	return h.writeInfo()
 }
 
 // Files returns all files for a given harvest, without the temporary files.
+// Files with any supported compression are included, sharded or not. Files
+// are sorted by name.
 func (h *Harvest) Files() []string {
-	return MustGlob(filepath.Join(h.Dir(), "*.xml.gz"))
+	var files []string
+	for _, ext := range chunkExtensions {
+		files = append(files, h.glob("*"+ext)...)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+	return files
 }
 
-// DateLayout converts the repository endpoints advertised granularity to Go
-// date format strings.
+// location returns the zone of interval boundaries.
+func (h *Harvest) location() *time.Location {
+	if h.Timezone != nil {
+		return h.Timezone
+	}
+	return time.UTC
+}
+
+// formatDate formats an interval boundary for a request. Timestamps are
+// converted to UTC, dates are taken in the harvest time zone.
+func (h *Harvest) formatDate(t time.Time) string {
+	layout := h.DateLayout()
+	if strings.HasSuffix(layout, "Z") {
+		return t.UTC().Format(layout)
+	}
+	return t.In(h.location()).Format(layout)
+}
+
+// DateLayout converts the repository endpoints advertised granularity, or
+// ForceGranularity, to Go date format strings. Variants of the granularities
+// are accepted, see ParseGranularity.
 func (h *Harvest) DateLayout() string {
-	switch h.Identify.Granularity {
-	case "YYYY-MM-DD":
+	switch h.granularity() {
+	case GranularityDay:
 		return "2006-01-02"
-	case "YYYY-MM-DDThh:mm:ssZ":
+	case GranularitySecond:
 		return "2006-01-02T15:04:05Z"
 	}
 	return ""
 }
 
-// Run starts the harvest.
+// Run starts the harvest. The harvest is cancelled on an interrupt signal,
+// so we can CTRL-C savely.
 func (h *Harvest) Run() error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+	return h.RunContext(ctx)
+}
+
+// interruptContext returns a context, that is cancelled on an interrupt
+// signal. The cancel function must be called to release the signal handler.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, interruptSignals...)
+
+	go func() {
+		select {
+		case <-sigc:
+			log.Println("interrupted, waiting for any rename to finish...")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sigc)
+		cancel()
+	}
+}
+
+// RunContext starts the harvest and stops, when the context is cancelled.
+// Files of an interval in progress are removed, any finalize in progress is
+// completed. Use RunResult for a summary of the run.
+func (h *Harvest) RunContext(ctx context.Context) error {
+	r, err := h.RunResult(ctx)
+	if err == nil && r.AlreadySynced {
+		return ErrAlreadySynced
+	}
+	return err
+}
+
+// runFormat harvests a single format.
+func (h *Harvest) runFormat(ctx context.Context) (err error) {
+	defer func() {
+		switch {
+		case err == nil, errors.Is(err, ErrAlreadySynced):
+			if e := h.removeErrorReport(); e != nil {
+				log.Printf("failed to remove error report: %s", e)
+			}
+			h.emit(Event{Kind: EventFinish, Files: h.finalized})
+		case errors.Is(err, context.Canceled), errors.Is(err, ErrLocked):
+			// not a failure of the harvest, e.g. another run holds the lock,
+			// so the report of the last run stays
+			h.emit(Event{Kind: EventError, Err: err, Files: h.finalized})
+		default:
+			if e := h.writeErrorReport(err); e != nil {
+				log.Printf("failed to write error report: %s", e)
+			}
+			h.emit(Event{Kind: EventError, Err: err, Files: h.finalized})
+		}
+	}()
+	if err := h.ValidateDates(); err != nil {
+		return err
+	}
+	if h.Identify == nil {
+		if err := h.IdentifyContext(ctx); err != nil {
+			return err
+		}
+	}
+	// the format is part of the directory name, so settle it first
+	if len(h.FormatFallbacks) > 0 {
+		if err := h.negotiateFormat(ctx); err != nil {
+			return err
+		}
+	}
 	if err := h.MkdirAll(); err != nil {
 		return err
 	}
-	h.setupInterruptHandler()
+	// concurrent runs would remove each other's temporary files
+	l, err := h.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer l.unlock()
+	if err := h.waitQuota(ctx); err != nil {
+		return err
+	}
+	if err := h.saveIdentify(); err != nil {
+		return err
+	}
+	if err := h.recover(); err != nil {
+		return err
+	}
 	h.Started = time.Now()
-	return h.run()
+	h.finalized = nil
+	h.cache = nil
+	if h.HTTPCache {
+		h.cache = NewCacheTransport(h.networkTransport(), filepath.Join(h.Dir(), HTTPCacheDir))
+	}
+	h.emit(Event{Kind: EventStart})
+	err = h.run(ctx)
+	if h.progress.repairs.Total() > 0 {
+		log.Printf("repaired %s", &h.progress.repairs)
+	}
+	if len(h.finalized) > 0 || len(h.progress.covered) > 0 {
+		// record new files and covered intervals, even if the run failed
+		// later on, intervals without new files are checked by Verify, too
+		if e := h.writeManifest(RunHarvest, h.Started, h.finalized, err); e != nil {
+			log.Printf("failed to write run manifest: %s", e)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return h.postRun()
 }
 
 // temporaryFiles list all temporary files in the harvesting dir.
@@ -138,7 +510,7 @@ func (h *Harvest) temporaryFilesSuffix(suffix string) []string {
 func (h *Harvest) cleanupTemporaryFiles() error {
 	for _, filename := range h.temporaryFiles() {
 		if err := os.Remove(filename); err != nil {
-			if e, ok := err.(*os.PathError); ok && e.Err == syscall.ENOENT {
+			if os.IsNotExist(err) {
 				continue
 			}
 			return err
@@ -147,59 +519,75 @@ func (h *Harvest) cleanupTemporaryFiles() error {
 	return nil
 }
 
-// setupInterruptHandler will cleanup, so we can CTRL-C or kill savely.
-func (h *Harvest) setupInterruptHandler() {
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGINT)
-
-	go func() {
-		<-sigc
-
-		log.Println("waiting for any rename to finish...")
-		// allow h.finalize() to finish
-		h.Lock()
-		// for good measure
-		defer h.Unlock()
-
-		// cleanup anything left over
-		if err := h.cleanupTemporaryFiles(); err != nil {
-			log.Fatal(err)
-		}
-		os.Exit(0)
-	}()
-}
-
-// finalize will move all files with a given suffix into place.
-func (h *Harvest) finalize(suffix string) error {
-	// collect all successfully renamed files
-	var renamed []string
-
+// finalize will move the files of a batch into place. Only files recorded
+// by the batch itself are accepted, they must carry its suffix and still
+// exist, so files of other batches or processes are never moved. The batch
+// is committed in two phases through a journal, so an interrupted finalize
+// is completed or discarded on the next run, see Journal. Returns the list
+// of files moved into place.
+func (h *Harvest) finalize(suffix string, files []string) ([]string, error) {
 	// lock, so we can finish even in the presence of an term signal.
 	h.Lock()
 	defer h.Unlock()
 
-	for _, filename := range h.temporaryFilesSuffix(suffix) {
-		dst := fmt.Sprintf("%s.gz", strings.Replace(filename, suffix, "", -1))
-		if err := MoveAndCompress(filename, dst); err != nil {
-			// try to cleanup all the already renamed files
-			for _, fn := range renamed {
-				if e := os.Remove(fn); err != nil {
-					if ee, ok := err.(*os.PathError); ok && ee.Err == syscall.ENOENT {
-						continue
-					}
-					return &MultiError{[]error{err, e,
-						fmt.Errorf("inconsistent cache state; start over and purge %s", h.Dir())}}
-				}
-			}
-			// stop with an error, but still in a consistent state
-			return err
+	j := &Journal{Suffix: suffix, State: JournalPrepare}
+	for _, filename := range files {
+		if !strings.HasSuffix(filename, suffix) {
+			log.Printf("warning: %s does not belong to batch %s, skipping", filename, suffix)
+			continue
+		}
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			// e.g. quarantined
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(filename), suffix)
+		dst := h.chunkPath(name) + h.Compression.Extension()
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, err
 		}
-		renamed = append(renamed, dst)
+		j.Entries = append(j.Entries, JournalEntry{Src: filename, Dst: dst, Staged: dst + stagedInfix + suffix})
 	}
-	if len(renamed) > 0 {
-		log.Printf("moved %d files into place", len(renamed))
+	if len(j.Entries) == 0 {
+		return nil, nil
 	}
-	return nil
+	// phase one: stage all files, the cache is not touched yet
+	if err := h.writeJournal(j); err != nil {
+		return nil, err
+	}
+	err := j.prepare()
+	if err == nil {
+		j.State = JournalCommit
+		err = h.writeJournal(j)
+	}
+	if err != nil {
+		if e := j.discard(); e != nil {
+			// journal stays in place, the batch is discarded on next run
+			return nil, &MultiError{[]error{err, e}}
+		}
+		if e := h.removeJournal(); e != nil {
+			return nil, &MultiError{[]error{err, e}}
+		}
+		return nil, err
+	}
+	// phase two: the batch is committed, move files into place; if this
+	// fails, the journal stays and the batch is completed on next run
+	if err := j.rollForward(); err != nil {
+		return nil, err
+	}
+	if err := h.removeJournal(); err != nil {
+		return nil, err
+	}
+	var moved []string
+	for _, e := range j.Entries {
+		moved = append(moved, e.Dst)
+	}
+	log.Printf("moved %d files into place", len(moved))
+	h.updateHeaderIndex(moved)
+	h.updateVersions(moved)
+	h.updateOrigins(moved)
+	return moved, nil
 }
 
 // defaultInterval returns a harvesting interval based on the cached
@@ -213,7 +601,7 @@ func (h *Harvest) defaultInterval() (Interval, error) {
 	if h.From == "" {
 		earliestDate, err = h.earliestDate()
 	} else {
-		earliestDate, err = time.Parse("2006-01-02", h.From)
+		earliestDate, err = time.ParseInLocation("2006-01-02", h.From, h.location())
 	}
 	if err != nil {
 		return Interval{}, err
@@ -222,6 +610,7 @@ func (h *Harvest) defaultInterval() (Interval, error) {
 	// last value for this directory
 	laster := DirLaster{
 		Dir:          h.Dir(),
+		Recursive:    true,
 		DefaultValue: earliestDate.Format("2006-01-02"),
 		ExtractorFunc: func(fi os.FileInfo) string {
 			groups := fnPattern.FindStringSubmatch(fi.Name())
@@ -237,7 +626,7 @@ func (h *Harvest) defaultInterval() (Interval, error) {
 		return Interval{}, err
 	}
 
-	begin, err := time.Parse("2006-01-02", last)
+	begin, err := time.ParseInLocation("2006-01-02", last, h.location())
 	if err != nil {
 		return Interval{}, err
 	}
@@ -247,7 +636,25 @@ func (h *Harvest) defaultInterval() (Interval, error) {
 		begin = begin.AddDate(0, 0, 1)
 	}
 
-	end := now.New(h.Started.AddDate(0, 0, -1)).EndOfDay()
+	end := now.New(h.Started.In(h.location()).AddDate(0, 0, -1)).EndOfDay()
+	if h.Until != "" {
+		until, err := time.ParseInLocation("2006-01-02", h.Until, h.location())
+		if err != nil {
+			return Interval{}, err
+		}
+		if until = now.New(until).EndOfDay(); until.Before(end) {
+			end = until
+		}
+	}
+
+	// the last run was stopped by a limit, harvest the rest again
+	rp, err := h.ResumePoint()
+	if err != nil {
+		return Interval{}, err
+	}
+	if rp != nil && rp.Interval.Begin.Before(begin) {
+		return Interval{Begin: rp.Interval.Begin.In(h.location()), End: end}, nil
+	}
 
 	if last == end.Format("2006-01-02") {
 		return Interval{}, ErrAlreadySynced
@@ -256,7 +663,7 @@ func (h *Harvest) defaultInterval() (Interval, error) {
 }
 
 // run runs a harvest (one request plus subsequent tokens).
-func (h *Harvest) run() (err error) {
+func (h *Harvest) run(ctx context.Context) (err error) {
 	defer func() {
 		// however we exit, cleanup any temporary files
 		if e := h.cleanupTemporaryFiles(); e != nil {
@@ -268,46 +675,212 @@ func (h *Harvest) run() (err error) {
 		}
 	}()
 
+	h.progress = progressState{intervals: 1}
+	h.selective = selectiveState{}
+	h.lag = lagState{}
+
 	if h.DisableSelectiveHarvesting {
-		return h.runInterval(Interval{})
+		return h.runInterval(ctx, Interval{})
 	}
 
 	interval, err := h.defaultInterval()
 	if err != nil {
 		return err
 	}
+	if h.LagDays > 0 {
+		if err := h.loadLagVersions(interval.Begin); err != nil {
+			return err
+		}
+	}
+	if err := h.runIntervals(ctx, interval); err != nil {
+		return err
+	}
+	return h.writeResumePoint()
+}
 
+// runIntervals harvests an interval, split into monthly or daily intervals.
+func (h *Harvest) runIntervals(ctx context.Context, interval Interval) error {
+	var intervals []Interval
 	if h.DailyInterval {
-		for _, iv := range interval.DailyIntervals() {
-			if err := h.runInterval(iv); err != nil {
-				return err
+		intervals = interval.DailyIntervals()
+	} else {
+		intervals = interval.MonthlyIntervals()
+	}
+	h.progress.intervals = len(intervals)
+
+	var err error
+	if h.Parallel > 1 {
+		err = h.runIntervalsParallel(ctx, intervals)
+	} else {
+		err = h.runIntervalsSequential(ctx, intervals)
+	}
+	if err == ErrDatesIgnored {
+		return h.switchToNonSelective(ctx)
+	}
+	return err
+}
+
+// runIntervalsSequential harvests one interval after another.
+func (h *Harvest) runIntervalsSequential(ctx context.Context, intervals []Interval) error {
+	for i, iv := range intervals {
+		h.progress.interval = i
+		if err := h.runInterval(ctx, iv); err != nil {
+			return err
+		}
+		if h.progress.stopped {
+			break
+		}
+		h.progress.covered = append(h.progress.covered, iv)
+	}
+	return nil
+}
+
+// runIntervalsParallel downloads up to Parallel intervals at a time, but
+// moves files into place in order. After the first failed or stopped
+// interval, later intervals are discarded.
+func (h *Harvest) runIntervalsParallel(ctx context.Context, intervals []Interval) error {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	// downloads must be done, before temporary files are cleaned up
+	defer wg.Wait()
+	defer cancel()
+
+	type result struct {
+		f   *fetched
+		err error
+	}
+	results := make([]chan result, len(intervals))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	// at most Parallel intervals are downloading or waiting to be moved
+	sem := make(chan struct{}, h.Parallel)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, iv := range intervals {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] <- result{err: ctx.Err()}
+				continue
 			}
+			wg.Add(1)
+			go func(i int, iv Interval) {
+				defer wg.Done()
+				f, err := h.fetchInterval(ctx, i, iv)
+				results[i] <- result{f: f, err: err}
+			}(i, iv)
 		}
-	} else {
-		for _, iv := range interval.MonthlyIntervals() {
-			if err := h.runInterval(iv); err != nil {
+	}()
+
+	for i, iv := range intervals {
+		r := <-results[i]
+		h.progress.interval = i
+		if r.err != nil {
+			// halves are harvested here, later intervals wait
+			if err := h.splitInterval(ctx, iv, r.err); err != nil {
 				return err
 			}
+		} else if err := h.commitInterval(r.f); err != nil {
+			return err
+		}
+		<-sem
+		if h.progress.stopped {
+			break
 		}
+		h.progress.covered = append(h.progress.covered, iv)
 	}
 	return nil
 }
 
 // runInterval runs a selective harvest on the given interval.
-func (h *Harvest) runInterval(iv Interval) error {
-	// suffix for this batch
-	suffix := fmt.Sprintf("-tmp-%d", rand.Intn(999999999))
-	// current resumption token
-	var token string
-	// number of responses, empty responses
-	var i, empty int
+func (h *Harvest) runInterval(ctx context.Context, iv Interval) error {
+	f, err := h.fetchInterval(ctx, h.progress.interval, iv)
+	if err != nil {
+		return h.splitInterval(ctx, iv, err)
+	}
+	return h.commitInterval(f)
+}
+
+// fetched describes the temporary files downloaded for an interval.
+type fetched struct {
+	suffix string
+	// files is the ledger of temporary files written for the interval
+	files []string
+	// files written and responses, that did not change since the last run
+	written, unchanged int
+	// a limit of the run was reached, the rest of the interval is missing
+	stopped bool
+	resume  *ResumePoint
+	iv      Interval
+}
+
+// listing is the state of the ListRecords requests of an interval, see
+// listRecords. The hooks tell the caller about responses, the other fields
+// may be set to continue an unfinished interval.
+type listing struct {
+	token string
+	// number of requests and records received
+	requests, records int
+	// current is the request in progress, for the error report
+	current *Request
+	// a limit of the run was reached, the rest of the interval is missing
+	stopped bool
+	resume  *ResumePoint
+
+	// do sends a request and returns the response together with the number
+	// of records left out of it by the filter, if any.
+	do func(ctx context.Context, client Client, req *Request) (*Response, int, error)
+	// handle processes a successful response.
+	handle func(req *Request, resp *Response) error
+	// discard drops the output of a response, that is not handled, optional.
+	discard func()
+	// restart drops everything handled so far, before the interval is
+	// requested again, optional.
+	restart func() error
+	// next is called, before the resumption token is requested, optional.
+	next func() error
+}
+
+// listRecords requests the records of an interval, following resumption
+// tokens. It applies MaxRequests (unless zero), the limits of the run,
+// retries, quota pauses, error actions and token restarts. A run limit sets
+// stopped and a resume point.
+func (h *Harvest) listRecords(ctx context.Context, index int, iv Interval, l *listing) error {
+	policy := h.retryPolicy()
+	client := h.client(DefaultTimeout, policy)
+	discard := func() {
+		if l.discard != nil {
+			l.discard()
+		}
+	}
+	// empty responses and retries after internal errors
+	var empty, retries int
+	// left boundary, moved forward after an expired resumption token
+	begin := iv.Begin
+	// latest record datestamp seen in this interval and number of restarts
+	var last string
+	var restarts int
 
 	for {
+		// Stop early, files of this interval are removed by run.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		// Limit the number of total requests.
-		if h.MaxRequests == i {
+		// Limit the number of requests of the interval.
+		if h.MaxRequests > 0 && l.requests == h.MaxRequests {
 			log.Printf("max requests limit (%d) reached", h.MaxRequests)
-			break
+			return nil
+		}
+		// Limit bytes and records of the whole run.
+		if msg := h.limitReached(); msg != "" {
+			log.Println(msg)
+			l.stopped = true
+			l.resume = &ResumePoint{Interval: Interval{Begin: begin, End: iv.End}, Reason: msg}
+			return nil
 		}
 
 		req := Request{
@@ -315,79 +888,139 @@ func (h *Harvest) runInterval(iv Interval) error {
 			MetadataPrefix:          h.Format,
 			Verb:                    "ListRecords",
 			Set:                     h.Set,
-			ResumptionToken:         token,
+			ResumptionToken:         l.token,
 			CleanBeforeDecode:       h.CleanBeforeDecode,
 			SuppressFormatParameter: h.SuppressFormatParameter,
+			UsePost:                 h.UsePost,
 		}
+		if !h.DisableSelectiveHarvesting {
+			req.From = h.formatDate(begin.Add(-h.overlap(index)))
+			req.Until = h.formatDate(iv.End)
+		}
+		l.current = &req
 
-		var filedate string
-
-		if h.DisableSelectiveHarvesting {
-			// used, when endpoint cannot handle from and until
-			filedate = h.Started.Format("2006-01-02")
-		} else {
-			filedate = iv.End.Format("2006-01-02")
-			req.From = iv.Begin.Format(h.DateLayout())
-			req.Until = iv.End.Format(h.DateLayout())
+		if err := h.wait(ctx); err != nil {
+			return err
 		}
 
 		// do request, return any http error, except when we ignore HTTPErrors - in that case, break out early
-		resp, err := Do(&req)
+		resp, filtered, err := l.do(ctx, client, &req)
 		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if h.Quota.Enabled() && h.Quota.Matches(err) {
+				if err := h.pauseForQuota(ctx, err); err != nil {
+					return err
+				}
+				continue
+			}
 			if h.IgnoreHTTPErrors {
 				log.Printf("stopping early due to failed request (IgnoreHTTPErrors=true): %s", err)
-				break
+				return nil
 			}
 			return err
 		}
 
+		if resp.Error.Code != "" {
+			h.countOAIError(resp.Error.Code)
+		}
+
+		// Tokens may expire, e.g. overnight, so start over, keeping the
+		// records up to the last datestamp seen, if possible.
+		if l.token != "" && resp.Error.Is(ErrBadResumptionToken) && restarts < h.TokenRestarts {
+			restarts++
+			l.token = ""
+			discard()
+			t, err := time.ParseInLocation("2006-01-02", last, h.location())
+			if err == nil && t.After(iv.Begin) && !t.Before(begin) && !h.DisableSelectiveHarvesting {
+				begin = t
+				log.Printf("badResumptionToken, continuing from %s (%d/%d)", last, restarts, h.TokenRestarts)
+				l.requests++
+				continue
+			}
+			log.Printf("badResumptionToken, restarting interval (%d/%d)", restarts, h.TokenRestarts)
+			if l.restart != nil {
+				if err := l.restart(); err != nil {
+					return err
+				}
+			}
+			l.requests, empty = 0, 0
+			continue
+		}
+
 		// handle OAI specific errors
+		if resp.Error.Code != "" && h.Quota.Enabled() && h.Quota.Matches(resp.Error) {
+			discard()
+			if err := h.pauseForQuota(ctx, resp.Error); err != nil {
+				return err
+			}
+			continue
+		}
 		if resp.Error.Code != "" {
-			// Rare case, where a resumptionToken is given, but it leads to noRecordsMatch, e.g. https://goo.gl/K3gpQB
-			// we still want to save, whatever we got up until this point, so we break here.
-			switch resp.Error.Code {
-			case "noRecordsMatch":
-				if !resp.HasResumptionToken() {
-					break
-				} else {
-					log.Printf("resumptionToken set and noRecordsMatch, continuing")
+			switch h.errorAction(resp.Error.Code) {
+			case ErrorIgnore:
+				// Rare case, where a resumptionToken is given, but it leads to noRecordsMatch, e.g. https://goo.gl/K3gpQB
+				// we still want to save, whatever we got up until this point.
+				if resp.HasResumptionToken() {
+					log.Printf("resumptionToken set and %s, continuing", resp.Error.Code)
 				}
-			case "InternalException":
+			case ErrorRetry:
 				// #9717, InternalException Could not send Message.
-				log.Println("InternalException: retrying request in a few instants ...")
-				time.Sleep(30 * time.Second)
-				// Count towards the total request limit.
-				i++
+				discard()
+				if retries+1 >= policy.MaxAttempts {
+					return resp.Error
+				}
+				delay := policy.Delay(retries)
+				retries++
+				log.Printf("%s: retrying request in %s ...", resp.Error.Code, delay)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				// Count towards the request limit.
+				l.requests++
 				continue
 			default:
+				discard()
+				if resp.Error.Is(ErrCannotDisseminateFormat) {
+					return h.formatError(ctx, resp.Error)
+				}
 				return resp.Error
 			}
 		}
 
-		// filename consists of the right boundary (until), the serial
-		// number of the request and a suffix, marking this request in
-		// progress
-		filename := filepath.Join(h.Dir(), fmt.Sprintf("%s-%08d.xml%s", filedate, i, suffix))
-
-		// write response to file
-		if b, err := xml.Marshal(resp); err == nil {
-			if e := ioutil.WriteFile(filename, b, 0644); e != nil {
-				return e
-			}
-			log.Printf("written %s", filename)
-		} else {
+		retries = 0
+		if l.requests == 0 {
+			l.records = 0
+		}
+		// received records, some may have been filtered
+		received := len(resp.ListRecords.Records) + filtered
+		l.records += received
+		h.reportProgress(iv, index, l.requests, l.records, resp)
+		if ds := lastDateStamp(resp); ds > last {
+			last = ds
+		}
+		if err := l.handle(&req, resp); err != nil {
 			return err
 		}
 
 		// the usual stop condition
-		if token = resp.GetResumptionToken(); token == "" {
-			break
+		if l.token = resp.GetResumptionToken(); l.token == "" {
+			return nil
 		}
 
-		i++
+		l.requests++
+
+		if l.next != nil {
+			if err := l.next(); err != nil {
+				return err
+			}
+		}
 
 		// stop, if we have too many empty responses, despite resumption tokens
-		if len(resp.ListRecords.Records) > 0 {
+		if received > 0 {
 			empty = 0
 		} else {
 			empty++
@@ -395,48 +1028,374 @@ func (h *Harvest) runInterval(iv Interval) error {
 		}
 		if empty == h.MaxEmptyResponses {
 			log.Printf("max number of empty responses reached")
-			break
+			return nil
+		}
+	}
+}
+
+// fetchInterval downloads an interval into temporary files, index is the
+// position of the interval in the run. On error, the temporary files are
+// removed.
+func (h *Harvest) fetchInterval(ctx context.Context, index int, iv Interval) (_ *fetched, err error) {
+	// suffix for this batch
+	suffix := newTempSuffix("-tmp-")
+	// temporary files written by this batch
+	var files []string
+	defer func() {
+		if err != nil && !isPartial(suffix) {
+			for _, filename := range files {
+				os.Remove(filename)
+			}
+		}
+	}()
+	// files written and responses, that did not change since the last run
+	var written, unchanged int
+	// date in filenames of a harvest without intervals
+	filedate := h.Started.Format("2006-01-02")
+	l := &listing{}
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			h.noteFailure(l.current, iv, l.requests)
+		}
+	}()
+
+	if h.resumable() {
+		p, err := h.loadPartial()
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			log.Printf("continuing unfinished harvest at request %d", p.Requests)
+			suffix, filedate, files = p.Suffix, p.FileDate, p.Files
+			l.token, l.requests, l.records, written = p.Token, p.Requests, p.Records, p.Requests
+		} else {
+			suffix = newPartialSuffix()
+		}
+	}
+	if !h.DisableSelectiveHarvesting {
+		filedate = iv.End.Format("2006-01-02")
+	}
+
+	// filename consists of the right boundary (until), the serial number
+	// of the request and a suffix, marking this request in progress
+	var filename string
+	l.do = func(ctx context.Context, client Client, req *Request) (*Response, int, error) {
+		filename = filepath.Join(h.Dir(), fmt.Sprintf("%s-%08d.xml%s", filedate, l.requests, suffix))
+		if h.streaming() {
+			return h.streamRequest(ctx, client, req, filename)
+		}
+		resp, err := client.DoContext(ctx, req)
+		return resp, 0, err
+	}
+	l.discard = func() {
+		if h.streaming() {
+			os.Remove(filename)
+		}
+	}
+	l.restart = func() error {
+		for _, filename := range files {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		files = nil
+		written, unchanged = 0, 0
+		return nil
+	}
+	l.handle = func(req *Request, resp *Response) error {
+		if l.requests == 0 && !h.DisableSelectiveHarvesting && h.datesIgnored(index, iv, resp) && h.AutoDisableSelective {
+			l.discard()
+			return ErrDatesIgnored
+		}
+		written++
+		if h.cache != nil {
+			if link, err := req.URL(); err == nil && h.cache.Revalidated(link.String()) {
+				unchanged++
+			}
+		}
+		// write response to file, streamed responses are already there
+		if filter := h.recordFilter(); filter != nil && !h.streaming() {
+			if n := filterRecords(resp, filter); n > 0 {
+				log.Printf("filtered %d records", n)
+			}
+		}
+		if h.streaming() {
+			log.Printf("written %s", filename)
+		} else if b, err := xml.Marshal(resp); err == nil {
+			if e := ioutil.WriteFile(filename, b, 0644); e != nil {
+				return e
+			}
+			log.Printf("written %s", filename)
+		} else {
+			return err
+		}
+		files = append(files, filename)
+		if h.Validate != "" {
+			if keep, err := h.validateFile(filename); err != nil {
+				return err
+			} else if !keep {
+				written--
+				files = files[:len(files)-1]
+			}
+		}
+		return nil
+	}
+	if h.resumable() {
+		l.next = func() error {
+			return h.savePartial(partialState{Suffix: suffix, Token: l.token, Requests: l.requests, Records: l.records, FileDate: filedate, Files: files})
+		}
+	}
+
+	if err := h.listRecords(ctx, index, iv, l); err != nil {
+		return nil, err
+	}
+	return &fetched{suffix: suffix, files: files, written: written, unchanged: unchanged, stopped: l.stopped, resume: l.resume, iv: iv}, nil
+}
+
+// commitInterval moves the files of an interval into place, unless none of
+// them changed since the last run.
+func (h *Harvest) commitInterval(f *fetched) error {
+	if f.stopped && isPartial(f.suffix) {
+		log.Printf("keeping %d responses, the next run continues", f.written)
+		h.progress.stopped = true
+		return nil
+	}
+	if f.stopped {
+		h.progress.stopped = true
+		if !h.DisableSelectiveHarvesting {
+			h.progress.resume = f.resume
 		}
 	}
+	if f.written > 0 && f.unchanged == f.written {
+		log.Printf("no changes since last run, dropping %d files", f.written)
+		for _, filename := range f.files {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		h.emitInterval(f.iv, nil)
+		return nil
+	}
 	// rename files
-	if err := h.finalize(suffix); err != nil {
+	files, err := h.finalize(f.suffix, f.files)
+	if err != nil {
 		return err
 	}
+	if isPartial(f.suffix) {
+		if err := h.removePartial(""); err != nil {
+			return err
+		}
+	}
+	h.finalized = append(h.finalized, files...)
+	h.postChunk(files)
+	h.emitInterval(f.iv, files)
+	return nil
+}
+
+// wait blocks until Delay has passed since the last request, the bandwidth
+// allows another request and the window is open, and counts the request.
+// Concurrent callers get consecutive slots.
+func (h *Harvest) wait(ctx context.Context) error {
+	h.mu.Lock()
+	next := time.Now()
+	if h.Delay > 0 && !h.lastRequest.IsZero() && h.lastRequest.Add(h.Delay).After(next) {
+		next = h.lastRequest.Add(h.Delay)
+	}
+	next, closed := h.schedule(next)
+	h.lastRequest = next
+	h.progress.requests++
+	h.mu.Unlock()
+
+	if closed {
+		h.pauseForWindow(next)
+	}
+	select {
+	case <-time.After(time.Until(next)):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	return nil
 }
 
+// streaming returns true, if responses should be written as they arrive.
+func (h *Harvest) streaming() bool {
+	return h.StreamResponses || h.MaxRecordSize > 0 || h.RawMode || h.KeepCompressed
+}
+
+// recordFilter returns the filter to apply before writing, if any.
+func (h *Harvest) recordFilter() RecordFilter {
+	if h.RawMode {
+		return nil
+	}
+	if h.lag.seen != nil {
+		if h.RecordFilter != nil {
+			return AllFilters(h.notCached, h.RecordFilter)
+		}
+		return h.notCached
+	}
+	return h.RecordFilter
+}
+
+// maxRecordSize returns the record size limit for streamed responses.
+func (h *Harvest) maxRecordSize() int64 {
+	if h.RawMode {
+		return 0
+	}
+	return h.MaxRecordSize
+}
+
+// streamRequest executes a request and writes the raw response to filename.
+// The returned response contains only headers and the resumption token, the
+// number of records dropped by the record filter is returned as well.
+func (h *Harvest) streamRequest(ctx context.Context, client Client, req *Request, filename string) (*Response, int, error) {
+	body, encoding, err := client.streamEncoded(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	bw := bufio.NewWriter(f)
+	var result *StreamResult
+	if h.KeepCompressed && encoding == "gzip" && h.Compression.Extension() == ".gz" {
+		// keep the body, only look at the decompressed response
+		tee := io.TeeReader(body, bw)
+		var reader io.Reader
+		if reader, err = client.decodeBody(tee, encoding, req); err == nil {
+			if result, err = StreamResponse(reader, ioutil.Discard, 0); err == nil {
+				_, err = io.Copy(ioutil.Discard, tee)
+			}
+		}
+	} else {
+		var reader io.Reader
+		if reader, err = client.decodeBody(body, encoding, req); err == nil {
+			result, err = StreamResponseFilter(reader, bw, h.maxRecordSize(), h.recordFilter())
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(filename)
+		return nil, 0, err
+	}
+	for _, id := range result.Skipped {
+		log.Printf("skipped record %s, larger than %d bytes", id, h.MaxRecordSize)
+	}
+	if result.Filtered > 0 {
+		log.Printf("filtered %d records", result.Filtered)
+	}
+	return result.Response, result.Filtered, nil
+}
+
+// networkTransport returns the harvest transport, with stall detection, if
+// StallTimeout is set. The HTTP cache is built on top of it.
+func (h *Harvest) networkTransport() http.RoundTripper {
+	if h.StallTimeout > 0 {
+		return stallTransport{Transport: h.Transport, Timeout: h.StallTimeout}
+	}
+	return h.Transport
+}
+
+// client returns a client with the harvest transport, if any. During a run
+// with HTTPCache, the cache is used.
+func (h *Harvest) client(timeout time.Duration, policy RetryPolicy) Client {
+	var transport = h.networkTransport()
+	if h.RequestTimeout > 0 {
+		timeout = h.RequestTimeout
+	}
+	if h.cache != nil {
+		// the cache wraps the network transport itself
+		transport = h.cache
+	}
+	if h.DebugDump {
+		transport = debugTransport{Transport: transport, Dir: h.debugDir()}
+	} else if h.Debug {
+		transport = debugTransport{Transport: transport}
+	}
+	transport = countingTransport{Transport: transport, n: &h.progress.bytes}
+	if h.HostLimiter != nil {
+		transport = rateLimitTransport{Transport: transport, Limiter: h.HostLimiter}
+	}
+	return Client{
+		Doer: &RetryDoer{
+			Client:   &http.Client{Timeout: timeout, Transport: transport},
+			Policy:   policy,
+			ReadBody: !h.streaming() && (h.StallTimeout > 0 || h.RequestTimeout > 0),
+		},
+		MaxResponseBytes: h.MaxResponseBytes,
+		UserAgent:        h.UserAgent,
+		Sanitize:         h.Sanitize,
+		Repairs:          &h.progress.repairs,
+		Middleware:       h.RequestMiddleware,
+	}
+}
+
+// retryPolicy returns the configured or the default retry policy.
+func (h *Harvest) retryPolicy() RetryPolicy {
+	policy := h.RetryPolicy
+	if policy.IsZero() {
+		policy = DefaultRetryPolicy
+	}
+	// quota errors pause the harvest instead
+	policy.RetryOn = h.Quota.retryOn(policy.RetryOn)
+	return policy
+}
+
 // earliestDate returns the earliest date as a time.Time value.
 func (h *Harvest) earliestDate() (time.Time, error) {
 	// different granularities are possible: https://eudml.org/oai/OAIHandler?verb=Identify
-	switch h.Identify.Granularity {
-	case "YYYY-MM-DD":
-		if len(h.Identify.EarliestDatestamp) <= 10 {
-			return time.Parse("2006-01-02", h.Identify.EarliestDatestamp)
-		}
-		return time.Parse("2006-01-02", h.Identify.EarliestDatestamp[:10])
-	case "YYYY-MM-DDThh:mm:ssZ":
-		// refs. #8825
-		if len(h.Identify.EarliestDatestamp) >= 10 && len(h.Identify.EarliestDatestamp) < 20 {
-			return time.Parse("2006-01-02", h.Identify.EarliestDatestamp[:10])
-		}
-		return time.Parse("2006-01-02T15:04:05Z", h.Identify.EarliestDatestamp)
+	earliest := strings.TrimSpace(h.Identify.EarliestDatestamp)
+	switch h.granularity() {
+	case GranularityDay:
+		if len(earliest) <= 10 {
+			return time.Parse("2006-01-02", earliest)
+		}
+		return time.Parse("2006-01-02", earliest[:10])
+	case GranularitySecond:
+		// refs. #8825, also timestamps without Z
+		if len(earliest) >= 10 && len(earliest) < 20 {
+			return time.Parse("2006-01-02", earliest[:10])
+		}
+		return time.Parse("2006-01-02T15:04:05Z", earliest)
 	default:
 		return time.Time{}, ErrInvalidEarliestDate
 	}
 }
 
-// identify runs an OAI identify request and caches the result.
-func (h *Harvest) identify() error {
-	req := Request{Verb: "Identify", BaseURL: h.BaseURL}
+// IdentifyContext runs an OAI identify request and caches the result.
+func (h *Harvest) IdentifyContext(ctx context.Context) error {
+	req := Request{Verb: "Identify", BaseURL: h.BaseURL, UsePost: h.UsePost}
 
 	// use a less resilient client for indentify requests
-	c := CreateClient(30*time.Second, 2)
+	policy := DefaultRetryPolicy
+	policy.MaxAttempts = 2
+	c := h.client(30*time.Second, policy)
+	redirects := &redirectTransport{}
+	if d, ok := c.Doer.(*RetryDoer); ok {
+		redirects.Transport = d.Client.Transport
+		d.Client.Transport = redirects
+	}
 
-	resp, err := c.Do(&req)
+	resp, err := c.DoContext(ctx, &req)
 	if err != nil {
 		return err
 	}
 	h.Identify = &resp.Identify
+	h.identified = time.Now()
+	if moved := redirects.movedTo(h.BaseURL); moved != "" {
+		if !h.FollowMoved {
+			log.Printf("warning: endpoint moved permanently to %s, consider -follow-moved", moved)
+			return nil
+		}
+		return h.moveBaseURL(moved)
+	}
 	return nil
 }
 
@@ -0,0 +1,451 @@
+package metha
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-harvest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	record := `<record><header><identifier>%s</identifier><datestamp>%s</datestamp></header></record>`
+	var froms []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		froms = append(froms, q.Get("from"))
+		switch {
+		case q.Get("resumptionToken") == "t1":
+			fmt.Fprint(w, `<OAI-PMH><error code="badResumptionToken">expired</error></OAI-PMH>`)
+		case q.Get("from") == "2016-01-01":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`<resumptionToken>t1</resumptionToken></ListRecords></OAI-PMH>`,
+				"1", "2016-01-10")
+		default:
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`</ListRecords></OAI-PMH>`, "2", "2016-01-20")
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		restarts int
+		files    int
+		err      bool
+	}{
+		{0, 0, true},
+		{1, 2, false},
+	}
+	for _, c := range cases {
+		froms = nil
+		h := Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			TokenRestarts:     c.restarts,
+			Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+			Started:           time.Now(),
+			Set:               fmt.Sprintf("restarts-%d", c.restarts),
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		iv := Interval{
+			Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2016, 1, 31, 23, 59, 59, 0, time.UTC),
+		}
+		err := h.runInterval(context.Background(), iv)
+		if (err != nil) != c.err {
+			t.Errorf("%d restarts: got error %v, want %v", c.restarts, err, c.err)
+		}
+		if len(h.Files()) != c.files {
+			t.Errorf("%d restarts: got %d files, want %d", c.restarts, len(h.Files()), c.files)
+		}
+		if c.restarts > 0 && froms[len(froms)-1] != "2016-01-10" {
+			t.Errorf("got requests from %v, want last from 2016-01-10", froms)
+		}
+	}
+}
+
+func TestRunIntervalsParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-parallel-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from := r.URL.Query().Get("from")
+		switch {
+		case r.URL.Query().Get("set") == "fail" && from == "2016-02-01":
+			fmt.Fprint(w, `<OAI-PMH><error code="badArgument">x</error></OAI-PMH>`)
+			return
+		case from == "2016-01-01":
+			// the first interval finishes last
+			time.Sleep(50 * time.Millisecond)
+		}
+		fmt.Fprintf(w, `<OAI-PMH><ListRecords><record><header><identifier>%s</identifier><datestamp>%s</datestamp></header></record></ListRecords></OAI-PMH>`, from, from)
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		set   string
+		files []string
+		err   bool
+	}{
+		{"ok", []string{"2016-01-31-00000000.xml.gz", "2016-02-29-00000000.xml.gz", "2016-03-31-00000000.xml.gz"}, false},
+		{"fail", []string{"2016-01-31-00000000.xml.gz"}, true},
+	}
+	for _, c := range cases {
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			Set:               c.set,
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			Parallel:          3,
+			Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+			Started:           time.Now(),
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		iv := Interval{
+			Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2016, 3, 31, 23, 59, 59, 0, time.UTC),
+		}
+		err := h.runIntervals(context.Background(), iv)
+		if (err != nil) != c.err {
+			t.Errorf("%s: got error %v, want %v", c.set, err, c.err)
+		}
+		h.cleanupTemporaryFiles()
+		var names []string
+		for _, f := range h.Files() {
+			names = append(names, filepath.Base(f))
+		}
+		if fmt.Sprint(names) != fmt.Sprint(c.files) {
+			t.Errorf("%s: got %v, want %v", c.set, names, c.files)
+		}
+	}
+}
+
+func TestDatesIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-selective-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	// the same response for every interval
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-15</datestamp></header></record></ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	started := time.Date(2016, 4, 2, 0, 0, 0, 0, time.UTC)
+	var cases = []struct {
+		auto  bool
+		files []string
+	}{
+		{false, []string{"2016-01-31-00000000.xml.gz", "2016-02-29-00000000.xml.gz", "2016-03-31-00000000.xml.gz"}},
+		{true, []string{"2016-01-31-00000000.xml.gz", "2016-04-02-00000000.xml.gz"}},
+	}
+	for _, c := range cases {
+		h := &Harvest{
+			BaseURL:              srv.URL,
+			Format:               "oai_dc",
+			Set:                  fmt.Sprintf("auto-%v", c.auto),
+			MaxRequests:          10,
+			MaxEmptyResponses:    10,
+			AutoDisableSelective: c.auto,
+			Identify:             &Identify{Granularity: "YYYY-MM-DD"},
+			Started:              started,
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		iv := Interval{
+			Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2016, 3, 31, 23, 59, 59, 0, time.UTC),
+		}
+		if err := h.runIntervals(context.Background(), iv); err != nil {
+			t.Fatalf("auto=%v: %v", c.auto, err)
+		}
+		var names []string
+		for _, f := range h.Files() {
+			names = append(names, filepath.Base(f))
+		}
+		if fmt.Sprint(names) != fmt.Sprint(c.files) {
+			t.Errorf("auto=%v: got %v, want %v", c.auto, names, c.files)
+		}
+		if h.DisableSelectiveHarvesting != c.auto {
+			t.Errorf("auto=%v: got DisableSelectiveHarvesting %v", c.auto, h.DisableSelectiveHarvesting)
+		}
+	}
+}
+
+func TestRawMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-raw-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<!-- served by x -->
+<OAI-PMH xmlns="http://www.openarchives.org/OAI/2.0/">
+  <ListRecords>
+    <record><header><identifier>1</identifier><datestamp>2016-01-15</datestamp></header>
+      <metadata><oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/"><x:unknown xmlns:x="urn:x"/></oai_dc:dc></metadata>
+    </record>
+  </ListRecords>
+</OAI-PMH>
+`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, doc)
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		RawMode:           true,
+		MaxRecordSize:     10,
+		Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+		Started:           time.Now(),
+	}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	iv := Interval{
+		Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2016, 1, 31, 23, 59, 59, 0, time.UTC),
+	}
+	if err := h.runInterval(context.Background(), iv); err != nil {
+		t.Fatal(err)
+	}
+	files := h.Files()
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	r, err := NewChunkReader(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != doc {
+		t.Errorf("got %q, want %q", b, doc)
+	}
+}
+
+func TestKeepCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-keep-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	var body bytes.Buffer
+	gw := gzip.NewWriter(&body)
+	fmt.Fprint(gw, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-15</datestamp></header></record></ListRecords></OAI-PMH>`)
+	gw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body.Bytes())
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		KeepCompressed:    true,
+		Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+		Started:           time.Now(),
+	}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	iv := Interval{
+		Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2016, 1, 31, 23, 59, 59, 0, time.UTC),
+	}
+	if err := h.runInterval(context.Background(), iv); err != nil {
+		t.Fatal(err)
+	}
+	files := h.Files()
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, body.Bytes()) {
+		t.Errorf("got %d bytes, want the %d bytes sent", len(b), body.Len())
+	}
+	var n int
+	if err := h.EachRecord(func(Record) error { n++; return nil }); err != nil || n != 1 {
+		t.Errorf("got %d records, %v, want 1", n, err)
+	}
+}
+
+func TestLagDays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-lag-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	// record 2 is indexed late, after the first harvest
+	var late bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<OAI-PMH><ListRecords>`)
+		if r.URL.Query().Get("from") <= "2016-01-30" {
+			fmt.Fprint(w, `<record><header><identifier>1</identifier><datestamp>2016-01-30</datestamp></header></record>`)
+		}
+		if late && r.URL.Query().Get("from") <= "2016-01-31" {
+			fmt.Fprint(w, `<record><header><identifier>2</identifier><datestamp>2016-01-31</datestamp></header></record>`)
+		}
+		fmt.Fprint(w, `</ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		started time.Time
+		late    bool
+		file    string
+		ids     []string
+	}{
+		{time.Date(2016, 2, 1, 12, 0, 0, 0, time.UTC), false, "2016-01-31-00000000.xml.gz", []string{"1"}},
+		{time.Date(2016, 2, 3, 12, 0, 0, 0, time.UTC), true, "2016-02-02-00000000.xml.gz", []string{"2"}},
+	}
+	for _, c := range cases {
+		late = c.late
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			From:              "2016-01-01",
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			LagDays:           3,
+			Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+			Started:           c.started,
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		err := eachRecordPosition(filepath.Join(h.Dir(), c.file), func(_ int, rec Record) error {
+			ids = append(ids, rec.Header.Identifier)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(c.ids) {
+			t.Errorf("%s: got %v, want %v", c.file, ids, c.ids)
+		}
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-harvest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	record := `<record><header><identifier>1</identifier><datestamp>%s</datestamp></header></record>`
+	// blocked is signalled, once a response hangs
+	blocked := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case q.Get("resumptionToken") == "":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`<resumptionToken>t1</resumptionToken></ListRecords></OAI-PMH>`,
+				q.Get("until"))
+		default:
+			// half a response, then wait for the client to give up
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record, q.Get("until"))
+			w.(http.Flusher).Flush()
+			blocked <- struct{}{}
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		about  string
+		stream bool
+	}{
+		{"decoded", false},
+		{"streamed", true},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			StreamResponses:   c.stream,
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-blocked
+			cancel()
+		}()
+		if err := h.RunContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want %v", c.about, err, context.Canceled)
+		}
+		cancel()
+		if tmp, _ := filepath.Glob(filepath.Join(h.Dir(), "*-tmp*")); len(tmp) > 0 {
+			t.Errorf("%s: got temporary files %v", c.about, tmp)
+		}
+		if locks, _ := filepath.Glob(filepath.Join(h.Dir(), LockFilename+"*")); len(locks) > 0 {
+			t.Errorf("%s: got lock files %v", c.about, locks)
+		}
+		if files := h.Files(); len(files) > 0 {
+			t.Errorf("%s: got files %v of an interrupted interval", c.about, files)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package metha
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HeaderIndexFilename is the name of the header index in a harvest directory.
+// It is a tab separated file with one line per record version and is only
+// ever appended to, later lines supersede earlier ones. Instead of an
+// embedded database, the file is loaded into memory on open, so metha needs
+// no database dependency.
+const HeaderIndexFilename = "headers.tsv"
+
+// HeaderEntry locates a record version in the cache. File is relative to the
+// harvest directory, Offset is the byte offset of the record element in the
+// decompressed file.
+type HeaderEntry struct {
+	Identifier string
+	DateStamp  string
+	Sets       []string
+	File       string
+	Offset     int64
+	Deleted    bool
+}
+
+// String formats an entry as a line of the index, without newline.
+func (e HeaderEntry) String() string {
+	var deleted = "0"
+	if e.Deleted {
+		deleted = "1"
+	}
+	return strings.Join([]string{e.Identifier, e.DateStamp, strings.Join(e.Sets, " "),
+		filepath.ToSlash(e.File), strconv.FormatInt(e.Offset, 10), deleted}, "\t")
+}
+
+// parseHeaderEntry parses a line of the index.
+func parseHeaderEntry(line string) (HeaderEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return HeaderEntry{}, fmt.Errorf("invalid header index line: %s", line)
+	}
+	offset, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return HeaderEntry{}, err
+	}
+	return HeaderEntry{
+		Identifier: fields[0],
+		DateStamp:  fields[1],
+		Sets:       strings.Fields(fields[2]),
+		File:       filepath.FromSlash(fields[3]),
+		Offset:     offset,
+		Deleted:    fields[5] == "1",
+	}, nil
+}
+
+// HeaderIndex maps identifiers to the latest version of a record in the
+// cache, for lookups without scanning all cached files.
+type HeaderIndex struct {
+	harvest *Harvest
+	latest  map[string]HeaderEntry
+}
+
+// headerIndexPath returns the path of the header index.
+func (h *Harvest) headerIndexPath() string {
+	return filepath.Join(h.Dir(), HeaderIndexFilename)
+}
+
+// hasHeaderIndex returns true, if the index is enabled or already exists.
+func (h *Harvest) hasHeaderIndex() bool {
+	if h.HeaderIndex {
+		return true
+	}
+	_, err := os.Stat(h.headerIndexPath())
+	return err == nil
+}
+
+// OpenHeaderIndex reads the header index of a harvest. Use
+// RebuildHeaderIndex to create it for an existing cache.
+func (h *Harvest) OpenHeaderIndex() (*HeaderIndex, error) {
+	f, err := os.Open(h.headerIndexPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	idx := &HeaderIndex{harvest: h, latest: make(map[string]HeaderEntry)}
+	br := bufio.NewScanner(f)
+	br.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for br.Scan() {
+		e, err := parseHeaderEntry(br.Text())
+		if err != nil {
+			return nil, err
+		}
+		idx.latest[e.Identifier] = e
+	}
+	return idx, br.Err()
+}
+
+// Len returns the number of distinct identifiers.
+func (idx *HeaderIndex) Len() int {
+	return len(idx.latest)
+}
+
+// Lookup returns the entry of the latest version of a record.
+func (idx *HeaderIndex) Lookup(identifier string) (HeaderEntry, bool) {
+	e, ok := idx.latest[identifier]
+	return e, ok
+}
+
+// Each calls fn for the latest version of every record, in no particular
+// order, e.g. to list deleted records.
+func (idx *HeaderIndex) Each(fn func(HeaderEntry) error) error {
+	for _, e := range idx.latest {
+		if err := fn(e); err != nil {
+			if err == ErrStop {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Record reads the record of an entry from the cache. The file is
+// decompressed up to the offset, only the record itself is decoded. Files
+// moved to cold storage are read from there.
+func (idx *HeaderIndex) Record(e HeaderEntry) (*Record, error) {
+	filename := filepath.Join(idx.harvest.Dir(), e.File)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		filename += StubSuffix
+	}
+	found, err := recordAt(filename, e.Offset)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && found.Header.Identifier != e.Identifier) {
+		return nil, fmt.Errorf("record %s not found in %s, rebuild the header index", e.Identifier, e.File)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// headerEntries returns the entries for all records of a cached file.
+func (h *Harvest) headerEntries(filename string) ([]HeaderEntry, error) {
+	rel, err := filepath.Rel(h.Dir(), strings.TrimSuffix(filename, StubSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var entries []HeaderEntry
+	err = eachRecordOffset(filename, func(offset int64, rec Record) error {
+		entries = append(entries, HeaderEntry{
+			Identifier: rec.Header.Identifier,
+			DateStamp:  rec.Header.DateStamp,
+			Sets:       rec.Header.SetSpec,
+			File:       rel,
+			Offset:     offset,
+			Deleted:    rec.Header.Status == "deleted",
+		})
+		return nil
+	})
+	return entries, err
+}
+
+// appendHeaders adds the records of files to the header index.
+func (h *Harvest) appendHeaders(files []string) error {
+	f, err := os.OpenFile(h.headerIndexPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, filename := range files {
+		entries, err := h.headerEntries(filename)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		for _, e := range entries {
+			if _, err := fmt.Fprintln(bw, e); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// updateHeaderIndex adds finalized files to the index, if there is one. On
+// failure, the index is removed, so it does not silently miss records.
+func (h *Harvest) updateHeaderIndex(files []string) {
+	if len(files) == 0 || !h.hasHeaderIndex() {
+		return
+	}
+	if err := h.appendHeaders(files); err != nil {
+		log.Printf("header index update failed, removing index: %s", err)
+		os.Remove(h.headerIndexPath())
+	}
+}
+
+// RebuildHeaderIndex creates the header index from all cached files, e.g.
+// for an existing cache or after files were compacted or purged.
+func (h *Harvest) RebuildHeaderIndex() error {
+	tmp, err := ioutil.TempFile(h.Dir(), HeaderIndexFilename+"-tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	bw := bufio.NewWriter(tmp)
+	for _, filename := range h.cacheFiles() {
+		entries, err := h.headerEntries(filename)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		for _, e := range entries {
+			if _, err := fmt.Fprintln(bw, e); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.headerIndexPath())
+}
@@ -0,0 +1,104 @@
+package metha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHeaderIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-headers-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	record := `<record><header%s><identifier>%s</identifier><datestamp>%s</datestamp><setSpec>a</setSpec></header><metadata><dc>%s</dc></metadata></record>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("from") {
+		case "2016-01-01":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+record+`</ListRecords></OAI-PMH>`,
+				"", "1", "2016-01-10", "old", "", "2", "2016-01-20", "two")
+		default:
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+record+`</ListRecords></OAI-PMH>`,
+				"", "1", "2016-02-05", "new", ` status="deleted"`, "2", "2016-02-10", "")
+		}
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		HeaderIndex:       true,
+		Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+		Started:           time.Now(),
+	}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	iv := Interval{
+		Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2016, 2, 29, 23, 59, 59, 0, time.UTC),
+	}
+	if err := h.runIntervals(context.Background(), iv); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		idx, err := h.OpenHeaderIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idx.Len() != 2 {
+			t.Errorf("%s: got %d entries, want 2", stage, idx.Len())
+		}
+		e, ok := idx.Lookup("1")
+		if !ok || e.DateStamp != "2016-02-05" || e.Deleted {
+			t.Errorf("%s: got %+v, want latest version of 1", stage, e)
+		}
+		rec, err := idx.Record(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rec.Metadata.Body) != "<dc>new</dc>" {
+			t.Errorf("%s: got metadata %s", stage, rec.Metadata.Body)
+		}
+		if e, _ := idx.Lookup("2"); !e.Deleted || len(e.Sets) != 1 {
+			t.Errorf("%s: got %+v, want deleted record in set a", stage, e)
+		}
+		// offsets point to the record elements of the decompressed files
+		if err := idx.Each(func(e HeaderEntry) error {
+			r, err := NewChunkReader(filepath.Join(h.Dir(), e.File))
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if e.Offset >= int64(len(b)) || !bytes.HasPrefix(b[e.Offset:], []byte("<record>")) {
+				t.Errorf("%s: %s: offset %d is not a record", stage, e.Identifier, e.Offset)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("harvest")
+
+	if _, err := h.Compact(CompactAll); err != nil {
+		t.Fatal(err)
+	}
+	check("compact")
+}
@@ -0,0 +1,94 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// HookContext is passed as JSON on standard input to post-chunk and post-run
+// commands.
+type HookContext struct {
+	Event   string    `json:"event"`
+	Dir     string    `json:"dir"`
+	BaseURL string    `json:"baseURL"`
+	Format  string    `json:"format"`
+	Set     string    `json:"set"`
+	Started time.Time `json:"started"`
+	Path    string    `json:"path,omitempty"`
+	Files   []string  `json:"files,omitempty"`
+}
+
+// shellQuote wraps a string in single quotes for use in a shell command.
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + s + `"`
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// runHook executes a command line through the shell, passing the context as
+// JSON on stdin. Any occurrence of {} in the command is replaced with the path.
+func runHook(command string, hc HookContext) error {
+	if hc.Path != "" {
+		command = strings.Replace(command, "{}", shellQuote(hc.Path), -1)
+	}
+	b, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Printf("hook (%s): %s", hc.Event, command)
+	return cmd.Run()
+}
+
+// hookContext returns a context prefilled with harvest information.
+func (h *Harvest) hookContext(event string) HookContext {
+	return HookContext{
+		Event:   event,
+		Dir:     h.Dir(),
+		BaseURL: h.BaseURL,
+		Format:  h.Format,
+		Set:     h.Set,
+		Started: h.Started,
+	}
+}
+
+// postChunk runs the post chunk command for each finalized file. The files
+// are already in place and the next run continues after them, so a failing
+// command is logged and does not stop the harvest.
+func (h *Harvest) postChunk(files []string) {
+	if h.PostChunkCommand == "" {
+		return
+	}
+	for _, fn := range files {
+		hc := h.hookContext("chunk")
+		hc.Path = fn
+		if err := runHook(h.PostChunkCommand, hc); err != nil {
+			log.Printf("post-chunk command failed for %s: %s", fn, err)
+		}
+	}
+}
+
+// postRun runs the post run command with all files finalized during this run.
+func (h *Harvest) postRun() error {
+	if h.PostRunCommand == "" {
+		return nil
+	}
+	hc := h.hookContext("run")
+	hc.Files = h.finalized
+	return runHook(h.PostRunCommand, hc)
+}
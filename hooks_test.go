@@ -0,0 +1,142 @@
+package metha
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run through sh")
+	}
+	dir, err := ioutil.TempDir("", "metha-hooks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	var cases = []struct {
+		path string
+	}{
+		{"/tmp/plain.xml.gz"},
+		{"/tmp/with space.xml.gz"},
+		{"/tmp/it's; rm -rf x.xml.gz"},
+		{"/tmp/$(echo no)`echo no`.xml.gz"},
+	}
+	for _, c := range cases {
+		hc := HookContext{Event: "chunk", Dir: dir, BaseURL: "http://example.com/oai", Format: "oai_dc", Path: c.path}
+		if err := runHook("printf '%s' {} > "+shellQuote(out)+"; cat > "+shellQuote(out+".json"), hc); err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != c.path {
+			t.Errorf("got argument %q, want %q", b, c.path)
+		}
+		var got HookContext
+		b, err = ioutil.ReadFile(out + ".json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Event != hc.Event || got.Path != c.path || got.BaseURL != hc.BaseURL || got.Format != hc.Format {
+			t.Errorf("got context %+v, want %+v", got, hc)
+		}
+	}
+	if err := runHook("exit 3", HookContext{Event: "run"}); err == nil {
+		t.Errorf("failing command succeeded")
+	}
+}
+
+func TestHarvestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run through sh")
+	}
+	dir, err := ioutil.TempDir("", "metha-hooks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = filepath.Join(dir, "cache")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+			return
+		}
+		fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	chunks, run := filepath.Join(dir, "chunks"), filepath.Join(dir, "run.json")
+	var cases = []struct {
+		about     string
+		postChunk string
+		postRun   string
+		chunks    int
+		err       bool
+	}{
+		{"hooks see every file", "echo {} >> " + shellQuote(chunks), "cat > " + shellQuote(run), 2, false},
+		{"failing post-chunk command does not stop the run", "echo {} >> " + shellQuote(chunks) + "; exit 1", "cat > " + shellQuote(run), 2, false},
+		{"failing post-run command fails the run", "", "exit 1", 0, true},
+	}
+	for _, c := range cases {
+		os.RemoveAll(BaseDir)
+		os.Remove(chunks)
+		os.Remove(run)
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			From:              time.Now().UTC().AddDate(0, 0, -2).Format("2006-01-02"),
+			DailyInterval:     true,
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			PostChunkCommand:  c.postChunk,
+			PostRunCommand:    c.postRun,
+		}
+		err := h.RunContext(context.Background())
+		if (err != nil) != c.err {
+			t.Errorf("%s: got %v, want error %v", c.about, err, c.err)
+		}
+		if n := len(h.Files()); n != 2 {
+			t.Errorf("%s: got %d files, want 2", c.about, n)
+		}
+		var lines int
+		if b, err := ioutil.ReadFile(chunks); err == nil {
+			for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+				if _, err := os.Stat(string(line)); err != nil {
+					t.Errorf("%s: post-chunk command got %s before it was in place", c.about, line)
+				}
+				lines++
+			}
+		}
+		if lines != c.chunks {
+			t.Errorf("%s: got %d post-chunk calls, want %d", c.about, lines, c.chunks)
+		}
+		if c.err {
+			continue
+		}
+		var hc HookContext
+		if b, err := ioutil.ReadFile(run); err != nil || json.Unmarshal(b, &hc) != nil {
+			t.Fatalf("%s: no post-run context: %v", c.about, err)
+		}
+		if hc.Event != "run" || len(hc.Files) != 2 || hc.Dir != h.Dir() {
+			t.Errorf("%s: got post-run context %+v", c.about, hc)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package metha
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HTTPCacheDir is the name of the HTTP cache inside a harvest directory.
+const HTTPCacheDir = "httpcache"
+
+// cacheEntry holds the validators and headers of a cached response.
+type cacheEntry struct {
+	URL          string      `json:"url"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"lastModified,omitempty"`
+	Header       http.Header `json:"header"`
+}
+
+// CacheTransport keeps ETag and Last-Modified validators and the body of
+// responses per URL and sends conditional requests. Unchanged responses (304)
+// are answered from the cache. Responses without validators are not cached.
+type CacheTransport struct {
+	Transport http.RoundTripper
+	Dir       string
+
+	mu          sync.Mutex
+	revalidated map[string]bool
+}
+
+// NewCacheTransport returns a caching transport storing entries in dir.
+func NewCacheTransport(t http.RoundTripper, dir string) *CacheTransport {
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &CacheTransport{Transport: t, Dir: dir, revalidated: make(map[string]bool)}
+}
+
+// Revalidated returns true, if the last response for the URL was served from
+// the cache, because it did not change.
+func (t *CacheTransport) Revalidated(u string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.revalidated[u]
+}
+
+// paths returns the entry and body filenames for a URL.
+func (t *CacheTransport) paths(u string) (string, string) {
+	key := fmt.Sprintf("%x", sha1.Sum([]byte(u)))
+	return filepath.Join(t.Dir, key+".json"), filepath.Join(t.Dir, key+".body")
+}
+
+// load returns the cached entry and body for a URL or nil.
+func (t *CacheTransport) load(u string) (*cacheEntry, []byte) {
+	entryFile, bodyFile := t.paths(u)
+	b, err := ioutil.ReadFile(entryFile)
+	if err != nil {
+		return nil, nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil || entry.URL != u {
+		return nil, nil
+	}
+	body, err := ioutil.ReadFile(bodyFile)
+	if err != nil {
+		return nil, nil
+	}
+	return &entry, body
+}
+
+// store saves entry and body, the entry is written last, so a partial write
+// leaves no valid entry.
+func (t *CacheTransport) store(entry *cacheEntry, body []byte) error {
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return err
+	}
+	entryFile, bodyFile := t.paths(entry.URL)
+	os.Remove(entryFile)
+	if err := ioutil.WriteFile(bodyFile, body, 0644); err != nil {
+		return err
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(entryFile, b, 0644)
+}
+
+// remove drops an entry.
+func (t *CacheTransport) remove(u string) {
+	entryFile, bodyFile := t.paths(u)
+	os.Remove(entryFile)
+	os.Remove(bodyFile)
+}
+
+// RoundTrip sends a conditional request, if there is a cached response.
+func (t *CacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return t.Transport.RoundTrip(req)
+	}
+	u := req.URL.String()
+	entry, body := t.load(u)
+	if entry != nil {
+		// a round tripper must not modify the request
+		r := new(http.Request)
+		*r = *req
+		r.Header = make(http.Header)
+		for k, v := range req.Header {
+			r.Header[k] = v
+		}
+		if entry.ETag != "" {
+			r.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			r.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+		req = r
+	}
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.revalidated[u] = entry != nil && resp.StatusCode == http.StatusNotModified
+	t.mu.Unlock()
+
+	switch {
+	case entry != nil && resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        entry.Header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	case resp.StatusCode == http.StatusOK:
+		etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if etag == "" && lastModified == "" {
+			if entry != nil {
+				t.remove(u)
+			}
+			return resp, nil
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+		entry := &cacheEntry{URL: u, ETag: etag, LastModified: lastModified, Header: resp.Header}
+		if err := t.store(entry, b); err != nil {
+			// the response is still fine, the next request is not conditional
+			t.remove(u)
+		}
+	}
+	return resp, nil
+}
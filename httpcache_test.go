@@ -0,0 +1,53 @@
+package metha
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCacheTransport(t *testing.T) {
+	var sent int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		sent++
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "<OAI-PMH/>")
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "metha-httpcache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewCacheTransport(nil, dir)
+	client := &http.Client{Transport: cache}
+	for i, wantRevalidated := range []bool{false, true, true} {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "<OAI-PMH/>" || resp.StatusCode != http.StatusOK {
+			t.Errorf("request %d: got %d %q", i, resp.StatusCode, b)
+		}
+		if got := cache.Revalidated(srv.URL); got != wantRevalidated {
+			t.Errorf("request %d: got revalidated %v, want %v", i, got, wantRevalidated)
+		}
+	}
+	if sent != 1 {
+		t.Errorf("got %d full responses, want 1", sent)
+	}
+}
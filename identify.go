@@ -0,0 +1,110 @@
+package metha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// IdentifyFilename is the name of the cached Identify response in a harvest
+// directory. It is updated on each run.
+const IdentifyFilename = "identify.json"
+
+// ErrNoIdentify is returned, if there is no cached Identify response.
+var ErrNoIdentify = errors.New("no cached identify response")
+
+// identifyFile is the content of the Identify cache file. Fetched is zero,
+// if Identify was not requested by the harvest itself.
+type identifyFile struct {
+	Identify *Identify `json:"identify"`
+	Fetched  time.Time `json:"fetched"`
+}
+
+// identifyPath returns the path to the cached Identify response.
+func (h *Harvest) identifyPath() string {
+	return filepath.Join(h.Dir(), IdentifyFilename)
+}
+
+// saveIdentify writes the Identify response into the harvest directory.
+func (h *Harvest) saveIdentify() error {
+	if h.Identify == nil {
+		return nil
+	}
+	b, err := json.Marshal(identifyFile{Identify: h.Identify, Fetched: h.identified})
+	if err != nil {
+		return err
+	}
+	tmp := h.identifyPath() + "-tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.identifyPath())
+}
+
+// cachedIdentify returns the cached Identify response and when it was
+// fetched. Harvests from before it was cached fall back to the Identify
+// response of the last run.
+func (h *Harvest) cachedIdentify() (*Identify, time.Time, error) {
+	b, err := ioutil.ReadFile(h.identifyPath())
+	if err == nil {
+		var f identifyFile
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, time.Time{}, err
+		}
+		if f.Identify != nil {
+			return f.Identify, f.Fetched, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, time.Time{}, err
+	}
+	runs, err := h.Runs()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].Identify != nil {
+			return runs[i].Identify, runs[i].Started, nil
+		}
+	}
+	return nil, time.Time{}, ErrNoIdentify
+}
+
+// LoadIdentify sets Identify from the cache, without network access, e.g.
+// to inspect granularity and earliest datestamp of a harvested endpoint.
+func (h *Harvest) LoadIdentify() error {
+	identify, fetched, err := h.cachedIdentify()
+	if err != nil {
+		return err
+	}
+	h.Identify, h.identified = identify, fetched
+	return nil
+}
+
+// RefreshIdentify requests Identify again and updates the cache, e.g. after
+// an endpoint changed its granularity.
+func (h *Harvest) RefreshIdentify(ctx context.Context) error {
+	if err := h.IdentifyContext(ctx); err != nil {
+		return err
+	}
+	if err := h.MkdirAll(); err != nil {
+		return err
+	}
+	return h.saveIdentify()
+}
+
+// OpenHarvest returns a harvest of an existing cache, with Identify loaded
+// from the cache. No requests are made.
+func OpenHarvest(baseURL, format, set string) (*Harvest, error) {
+	h := &Harvest{BaseURL: baseURL, Format: format, Set: set}
+	if _, err := os.Stat(h.Dir()); err != nil {
+		return nil, err
+	}
+	if err := h.LoadIdentify(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
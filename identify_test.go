@@ -0,0 +1,46 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestIdentifyCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-identify-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity><earliestDatestamp>2016-01-01</earliestDatestamp></Identify></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	if _, err := OpenHarvest(srv.URL, "oai_dc", ""); err == nil {
+		t.Fatal("got a harvest without cache, want an error")
+	}
+	h := &Harvest{BaseURL: srv.URL, Format: "oai_dc"}
+	if err := h.RefreshIdentify(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// no network access from here on
+	srv.Close()
+
+	cached, err := OpenHarvest(srv.URL, "oai_dc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Identify.Granularity != "YYYY-MM-DD" || cached.Identify.EarliestDatestamp != "2016-01-01" {
+		t.Errorf("got %+v", cached.Identify)
+	}
+	if cached.identified.IsZero() {
+		t.Error("fetch time not cached")
+	}
+}
@@ -0,0 +1,153 @@
+package metha
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoRecords signals a response without any records.
+var ErrNoRecords = errors.New("no records")
+
+// readDump reads and validates a single, possibly compressed OAI response.
+func readDump(filename string) (*Response, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := maybeCompressed(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+
+	var resp Response
+	if err := dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error.Code != "" && !resp.Error.Is(ErrNoRecordsMatch) {
+		return nil, resp.Error
+	}
+	if len(resp.ListRecords.Records) == 0 {
+		return nil, ErrNoRecords
+	}
+	return &resp, nil
+}
+
+// lastDateStamp returns the latest record datestamp, in 2006-01-02 layout.
+func lastDateStamp(resp *Response) string {
+	var last string
+	for _, rec := range resp.ListRecords.Records {
+		ds := rec.Header.DateStamp
+		if len(ds) > 10 {
+			ds = ds[:10]
+		}
+		if ds > last {
+			last = ds
+		}
+	}
+	return last
+}
+
+// nextSerials returns the next free serial number for each date in the cache.
+func (h *Harvest) nextSerials() map[string]int {
+	next := make(map[string]int)
+	for _, fn := range h.Files() {
+		groups := chunkPattern.FindStringSubmatch(filepath.Base(fn))
+		if len(groups) < 3 {
+			continue
+		}
+		n, err := strconv.Atoi(groups[2])
+		if err != nil {
+			continue
+		}
+		if n >= next[groups[1]] {
+			next[groups[1]] = n + 1
+		}
+	}
+	return next
+}
+
+// Import ingests existing ListRecords responses, e.g. from other harvesters,
+// into the cache. Each file is named after the latest record datestamp it
+// contains, so subsequent harvests will continue from there. Files that are
+// not valid responses or contain no records are skipped. Returns the files
+// moved into the cache. Like a run, an import holds the lock of the harvest
+// directory.
+func (h *Harvest) Import(filenames []string) ([]string, error) {
+	if err := h.MkdirAll(); err != nil {
+		return nil, err
+	}
+	l, err := h.lock(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer l.unlock()
+	if err := h.recover(); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	suffix := newTempSuffix("-tmp-")
+	var written []string
+	next := h.nextSerials()
+	h.progress = progressState{}
+
+	sort.Strings(filenames)
+	for _, fn := range filenames {
+		resp, err := readDump(fn)
+		if err != nil {
+			log.Printf("skipping %s: %s", fn, err)
+			continue
+		}
+		if u := strings.TrimSpace(resp.Request.BaseURL); u != "" && u != h.BaseURL {
+			log.Printf("warning: %s was harvested from %s, not %s", fn, u, h.BaseURL)
+		}
+		date := lastDateStamp(resp)
+		if len(date) != 10 {
+			log.Printf("skipping %s: invalid datestamp %q", fn, date)
+			continue
+		}
+		b, err := xml.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(h.Dir(), fmt.Sprintf("%s-%08d.xml%s", date, next[date], suffix))
+		if err := ioutil.WriteFile(dst, b, 0644); err != nil {
+			return nil, err
+		}
+		written = append(written, dst)
+		next[date]++
+		h.progress.totalRecords += len(resp.ListRecords.Records)
+		log.Printf("imported %s", fn)
+	}
+	files, err := h.finalize(suffix, written)
+	if err != nil {
+		// only remove the files of this import
+		for _, filename := range written {
+			if e := os.Remove(filename); e != nil && !os.IsNotExist(e) {
+				return nil, &MultiError{[]error{err, e}}
+			}
+		}
+		return nil, err
+	}
+	if len(files) > 0 {
+		if err := h.writeManifest(RunImport, started, files, nil); err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,77 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-import-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = filepath.Join(dir, "cache")
+
+	dumps := map[string]string{
+		"a.xml":     `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-02T10:00:00Z</datestamp></header></record></ListRecords></OAI-PMH>`,
+		"b.xml":     `<OAI-PMH><ListRecords><record><header><identifier>2</identifier><datestamp>2016-01-02</datestamp></header></record></ListRecords></OAI-PMH>`,
+		"empty.xml": `<OAI-PMH><error code="noRecordsMatch"/></OAI-PMH>`,
+		"error.xml": `<OAI-PMH><error code="badArgument"/></OAI-PMH>`,
+		"junk.xml":  `not xml`,
+	}
+	for name, content := range dumps {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var cases = []struct {
+		about  string
+		files  []string
+		locked bool
+		want   []string
+		err    error
+	}{
+		{"invalid dumps are skipped", []string{"empty.xml", "error.xml", "junk.xml"}, false, nil, nil},
+		{"named after the latest datestamp", []string{"b.xml", "a.xml"}, false, []string{"2016-01-02-00000000.xml.gz", "2016-01-02-00000001.xml.gz"}, nil},
+		{"serials continue", []string{"a.xml"}, false, []string{"2016-01-02-00000002.xml.gz"}, nil},
+		{"a running harvest holds the lock", []string{"a.xml"}, true, nil, ErrLocked},
+	}
+	for _, c := range cases {
+		h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+		if c.locked {
+			if err := h.MkdirAll(); err != nil {
+				t.Fatal(err)
+			}
+			l, err := h.lock(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.unlock()
+		}
+		var filenames []string
+		for _, name := range c.files {
+			filenames = append(filenames, filepath.Join(dir, name))
+		}
+		files, err := h.Import(filenames)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got %v, want %v", c.about, err, c.err)
+		}
+		var got []string
+		for _, fn := range files {
+			got = append(got, filepath.Base(fn))
+		}
+		if strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("%s: got %v, want %v", c.about, got, c.want)
+		}
+	}
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if n := len(h.Files()); n != 3 {
+		t.Errorf("got %d files in the cache, want 3", n)
+	}
+}
@@ -9,8 +9,8 @@ import (
 
 // Interval represents a span of time.
 type Interval struct {
-	Begin time.Time
-	End   time.Time
+	Begin time.Time `json:"begin"`
+	End   time.Time `json:"end"`
 }
 
 // String formats the interval.
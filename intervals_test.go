@@ -1,6 +1,8 @@
 package metha
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 )
@@ -53,3 +55,47 @@ func TestDailyIntervals(t *testing.T) {
 	}
 
 }
+
+func TestFormatDate(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	var cases = []struct {
+		granularity string
+		tz          *time.Location
+		overlap     time.Duration
+		from, until string
+	}{
+		{"YYYY-MM-DD", nil, 0, "2016-01-01", "2016-01-31"},
+		{"YYYY-MM-DDThh:mm:ssZ", nil, 0, "2016-01-01T00:00:00Z", "2016-01-31T23:59:59Z"},
+		{"YYYY-MM-DDThh:mm:ssZ", nil, time.Hour, "2015-12-31T23:00:00Z", "2016-01-31T23:59:59Z"},
+		{"YYYY-MM-DDThh:mm:ssZ", berlin, 0, "2015-12-31T23:00:00Z", "2016-01-31T22:59:59Z"},
+		{"YYYY-MM-DD", berlin, 0, "2016-01-01", "2016-01-31"},
+		{"YYYY-MM-DD", nil, 48 * time.Hour, "2015-12-30", "2016-01-31"},
+	}
+	dir, err := ioutil.TempDir("", "metha-intervals-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	for _, c := range cases {
+		h := Harvest{
+			Identify: &Identify{Granularity: c.granularity},
+			Timezone: c.tz,
+			Overlap:  c.overlap,
+			From:     "2016-01-01",
+			Started:  time.Date(2016, 2, 1, 12, 0, 0, 0, time.UTC),
+		}
+		iv, err := h.defaultInterval()
+		if err != nil {
+			t.Fatal(err)
+		}
+		from, until := h.formatDate(iv.Begin.Add(-h.Overlap)), h.formatDate(iv.End)
+		if from != c.from || until != c.until {
+			t.Errorf("%s %v %s: got %s--%s, want %s--%s", c.granularity, c.tz, c.overlap, from, until, c.from, c.until)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package metha
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// JournalFilename is the name of the write-ahead journal in a harvest directory.
+const JournalFilename = "finalize.journal"
+
+const (
+	// JournalPrepare marks a batch, that is being staged. It is discarded.
+	JournalPrepare = "prepare"
+	// JournalCommit marks a batch, that should be rolled forward.
+	JournalCommit = "commit"
+)
+
+// stagedInfix is inserted between the final name of a file and the batch
+// suffix, while the file is staged.
+const stagedInfix = "-staged"
+
+// JournalEntry records a single move, from a temporary file to its final
+// place. Staged is the compressed file next to Dst, Sum its SHA-256.
+type JournalEntry struct {
+	Src    string `json:"src"`
+	Dst    string `json:"dst"`
+	Staged string `json:"staged"`
+	Sum    string `json:"sum,omitempty"`
+}
+
+// Journal is a write-ahead log for a finalize batch, committed in two phases.
+// First, all files are compressed into staged files next to their final
+// place, while the journal is in state prepare; an interrupted batch is
+// discarded and the cache is unchanged. Then the journal is switched to
+// commit and the staged files are renamed into place; an interrupted batch is
+// rolled forward on the next run. The journal is removed after the batch
+// completed, so a batch is always either fully applied or ignored.
+type Journal struct {
+	Suffix  string         `json:"suffix"`
+	State   string         `json:"state"`
+	Entries []JournalEntry `json:"entries"`
+}
+
+// journalPath returns the path to the journal file.
+func (h *Harvest) journalPath() string {
+	return filepath.Join(h.Dir(), JournalFilename)
+}
+
+// writeJournal saves the journal atomically and syncs it to disk.
+func (h *Harvest) writeJournal(j *Journal) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := h.journalPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.journalPath())
+}
+
+// readJournal reads a journal, returns nil, if there is none.
+func (h *Harvest) readJournal() (*Journal, error) {
+	b, err := ioutil.ReadFile(h.journalPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var j Journal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("corrupt journal %s: %s", h.journalPath(), err)
+	}
+	return &j, nil
+}
+
+// removeJournal marks the end of a batch.
+func (h *Harvest) removeJournal() error {
+	if err := os.Remove(h.journalPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// prepare compresses all sources of a batch into their staged files. The
+// sources are kept.
+func (j *Journal) prepare() error {
+	for i, e := range j.Entries {
+		sum, err := compressFile(e.Src, e.Staged, compressionFromFilename(e.Dst))
+		if err != nil {
+			return err
+		}
+		j.Entries[i].Sum = hex.EncodeToString(sum)
+	}
+	return nil
+}
+
+// discard removes the staged files of a batch, that was not committed.
+func (j *Journal) discard() error {
+	for _, e := range j.Entries {
+		if err := os.Remove(e.Staged); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollForward completes all moves of a batch. Staged files are renamed into
+// place, replacing existing files; it is safe to call again after an
+// interruption. It fails, if a staged file is gone, while the destination
+// is missing, too.
+func (j *Journal) rollForward() error {
+	for _, e := range j.Entries {
+		if _, err := os.Stat(e.Staged); err == nil {
+			sum, err := hex.DecodeString(e.Sum)
+			if err != nil {
+				return fmt.Errorf("invalid checksum of %s in journal: %s", e.Staged, err)
+			}
+			if err := writeChecksum(e.Dst, sum); err != nil {
+				return err
+			}
+			if err := os.Rename(e.Staged, e.Dst); err != nil {
+				return err
+			}
+		} else if _, err := os.Stat(e.Dst); err != nil {
+			return fmt.Errorf("cannot roll forward, %s and %s missing", e.Staged, e.Dst)
+		}
+		if err := os.Remove(e.Src); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// recover inspects the harvest directory for an interrupted compaction,
+// tiering or finalize and brings the cache into a consistent state again,
+// either by completing a committed batch or by discarding a batch, that was
+// still being prepared.
+func (h *Harvest) recover() error {
+	if err := h.recoverCompact(); err != nil {
+		return err
+	}
+	if err := h.recoverTier(); err != nil {
+		return err
+	}
+	j, err := h.readJournal()
+	if err != nil || j == nil {
+		return err
+	}
+	log.Printf("found interrupted batch %s (%s), recovering", j.Suffix, j.State)
+	switch j.State {
+	case JournalPrepare:
+		if err := j.discard(); err != nil {
+			return err
+		}
+		log.Printf("discarded %d staged files", len(j.Entries))
+		return h.removeJournal()
+	case JournalCommit:
+		// files may have been replaced, so there is no way back
+		if err := j.rollForward(); err != nil {
+			return fmt.Errorf("cannot complete batch %s, see %s: %w", j.Suffix, h.journalPath(), err)
+		}
+		log.Printf("rolled forward %d files", len(j.Entries))
+		return h.removeJournal()
+	default:
+		return fmt.Errorf("invalid state %q in journal %s", j.State, h.journalPath())
+	}
+}
@@ -0,0 +1,233 @@
+package metha
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(h.Dir(), "2016-01-31-00000000.xml.gz")
+	var cases = []struct {
+		about string
+		state string
+	}{
+		// staged file and destination are gone, there is nothing to complete
+		{"lost staged file", JournalCommit},
+		{"invalid state", "rollback"},
+	}
+	for _, c := range cases {
+		j := &Journal{Suffix: "-tmp-1", State: c.state, Entries: []JournalEntry{
+			{Src: dst + "-tmp-1", Dst: dst, Staged: dst + stagedInfix + "-tmp-1"},
+		}}
+		if err := h.writeJournal(j); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.recover(); err == nil {
+			t.Errorf("%s: recovered", c.about)
+		}
+		// the journal is kept for inspection
+		if _, err := os.Stat(h.journalPath()); err != nil {
+			t.Errorf("%s: journal removed", c.about)
+		}
+	}
+}
+
+func TestFinalizeLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	suffix := newTempSuffix("-tmp-")
+	if other := newTempSuffix("-tmp-"); other == suffix {
+		t.Fatalf("suffix not unique: %s", suffix)
+	}
+	own := filepath.Join(h.Dir(), "2016-01-31-00000000.xml"+suffix)
+	// same suffix, but not written by this batch
+	foreign := filepath.Join(h.Dir(), "2016-01-31-00000001.xml"+suffix)
+	for _, filename := range []string{own, foreign} {
+		if err := ioutil.WriteFile(filename, []byte("<x/>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := h.finalize(suffix, []string{own})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "2016-01-31-00000000.xml.gz" {
+		t.Errorf("got %v, want only the recorded file", files)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("foreign file was touched: %v", err)
+	}
+}
+
+func TestFinalizeCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	doc := `<OAI-PMH><ListRecords><record><header><identifier>a</identifier><datestamp>2016-01-10</datestamp></header></record></ListRecords></OAI-PMH>`
+	var cases = []struct {
+		compression Compression
+		name        string
+		// magic are the first bytes of the file
+		magic []byte
+	}{
+		{"", "2016-01-31-00000000.xml.gz", []byte{0x1f, 0x8b}},
+		{CompressionGzip, "2016-01-31-00000000.xml.gz", []byte{0x1f, 0x8b}},
+		{CompressionZstd, "2016-01-31-00000000.xml.zst", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{CompressionNone, "2016-01-31-00000000.xml", []byte("<OAI-PMH>")},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc", Compression: c.compression}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		suffix := newTempSuffix("-tmp-")
+		src := filepath.Join(h.Dir(), "2016-01-31-00000000.xml"+suffix)
+		if err := ioutil.WriteFile(src, []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files, err := h.finalize(suffix, []string{src})
+		if err != nil {
+			t.Fatalf("%q: %v", c.compression, err)
+		}
+		if len(files) != 1 || filepath.Base(files[0]) != c.name {
+			t.Errorf("%q: finalized %v, want %s", c.compression, files, c.name)
+		}
+		if got := h.Files(); len(got) != 1 || filepath.Base(got[0]) != c.name {
+			t.Errorf("%q: got files %v, want %s", c.compression, got, c.name)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(h.Dir(), c.name))
+		if err != nil {
+			t.Fatalf("%q: %v", c.compression, err)
+		}
+		if !bytes.HasPrefix(b, c.magic) {
+			t.Errorf("%q: got %x..., want %x", c.compression, b[:len(c.magic)], c.magic)
+		}
+		var ids []string
+		if err := h.EachRecord(func(rec Record) error {
+			ids = append(ids, rec.Header.Identifier)
+			return nil
+		}); err != nil {
+			t.Fatalf("%q: %v", c.compression, err)
+		}
+		if len(ids) != 1 || ids[0] != "a" {
+			t.Errorf("%q: got records %v", c.compression, ids)
+		}
+		if result, err := h.Fsck(); err != nil || !result.OK() {
+			t.Errorf("%q: checksums do not match: %v %+v", c.compression, err, result)
+		}
+	}
+}
+
+func TestJournalTwoPhase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-journal-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(h.Dir(), "2016-01-31-00000000.xml")
+	if err := ioutil.WriteFile(existing, []byte("<old/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	batch := func(state string) *Journal {
+		suffix := newTempSuffix("-tmp-")
+		j := &Journal{Suffix: suffix, State: JournalPrepare}
+		for _, name := range []string{"2016-01-31-00000000.xml", "2016-01-31-00000001.xml"} {
+			src := filepath.Join(h.Dir(), name+suffix)
+			if err := ioutil.WriteFile(src, []byte("<new/>"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dst := filepath.Join(h.Dir(), name)
+			j.Entries = append(j.Entries, JournalEntry{Src: src, Dst: dst, Staged: dst + stagedInfix + suffix})
+		}
+		if err := j.prepare(); err != nil {
+			t.Fatal(err)
+		}
+		j.State = state
+		if err := h.writeJournal(j); err != nil {
+			t.Fatal(err)
+		}
+		return j
+	}
+
+	// interrupted while staging, the cache is unchanged
+	j := batch(JournalPrepare)
+	if err := h.recover(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(h.Files()); got != 1 {
+		t.Errorf("prepare: got %d files, want 1", got)
+	}
+	if b, _ := ioutil.ReadFile(existing); string(b) != "<old/>" {
+		t.Errorf("prepare: existing file changed to %s", b)
+	}
+	for _, e := range j.Entries {
+		if _, err := os.Stat(e.Staged); !os.IsNotExist(err) {
+			t.Errorf("prepare: staged file %s not removed", e.Staged)
+		}
+		os.Remove(e.Src)
+	}
+
+	// interrupted after the first rename, the batch is completed
+	j = batch(JournalCommit)
+	if err := os.Rename(j.Entries[0].Staged, j.Entries[0].Dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.recover(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(h.Files()); got != 2 {
+		t.Errorf("commit: got %d files, want 2", got)
+	}
+	for _, e := range j.Entries {
+		if b, _ := ioutil.ReadFile(e.Dst); string(b) != "<new/>" {
+			t.Errorf("commit: got %s in %s", b, e.Dst)
+		}
+		if _, err := os.Stat(e.Src); !os.IsNotExist(err) {
+			t.Errorf("commit: source %s not removed", e.Src)
+		}
+	}
+	want, err := readChecksum(j.Entries[1].Dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fileChecksum(j.Entries[1].Dst); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("commit: checksum mismatch: %x, %x, %v", got, want, err)
+	}
+	if _, err := os.Stat(h.journalPath()); !os.IsNotExist(err) {
+		t.Errorf("journal not removed")
+	}
+}
@@ -0,0 +1,58 @@
+package metha
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// lagState holds the record versions already cached for the days harvested
+// again, so they are not written twice.
+type lagState struct {
+	mu   sync.Mutex
+	seen *SeenIndex
+}
+
+// overlap returns how far to move the start of an interval back. The first
+// interval of a run starts at least LagDays days earlier.
+func (h *Harvest) overlap(index int) time.Duration {
+	lag := time.Duration(h.LagDays) * 24 * time.Hour
+	if index == 0 && lag > h.Overlap {
+		return lag
+	}
+	return h.Overlap
+}
+
+// loadLagVersions remembers the record versions of cached files, that may
+// contain records of the days harvested again before begin.
+func (h *Harvest) loadLagVersions(begin time.Time) error {
+	seen := NewSeenIndex()
+	since := begin.Add(-h.overlap(0)).Format("2006-01-02")
+	for _, filename := range h.cacheFiles() {
+		groups := fnPattern.FindStringSubmatch(filepath.Base(filename))
+		if len(groups) < 2 || groups[1] < since {
+			continue
+		}
+		if err := eachRecordPosition(filename, func(_ int, rec Record) error {
+			_, err := seen.Add(rec)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	if seen.Len() > 0 {
+		log.Printf("harvesting %d days again, skipping %d cached record versions", h.LagDays, seen.Len())
+	}
+	h.lag = lagState{seen: seen}
+	return nil
+}
+
+// notCached keeps records, whose version is neither cached nor was seen
+// before in this run.
+func (h *Harvest) notCached(r Record) bool {
+	h.lag.mu.Lock()
+	defer h.lag.mu.Unlock()
+	ok, _ := h.lag.seen.Add(r)
+	return ok
+}
@@ -3,6 +3,7 @@ package metha
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 )
 
@@ -14,24 +15,47 @@ type Laster interface {
 // DirLaster extract the maximum value from the files of a directory. The values
 // are extracted per file via TransformFunc, which gets a filename and returns a
 // token. The tokens are sorted and the lexikographically largest element is
-// returned.
+// returned. With Recursive set, files in subdirectories are included. A missing
+// directory yields the default value.
 type DirLaster struct {
 	Dir           string
 	DefaultValue  string
 	ExtractorFunc func(os.FileInfo) string
+	Recursive     bool
 }
 
 // Last extracts the maximum value from a directory, given an extractor function.
 func (l DirLaster) Last() (string, error) {
-	files, err := ioutil.ReadDir(l.Dir)
-	if err != nil {
-		return "", err
+	if _, err := os.Stat(l.Dir); os.IsNotExist(err) {
+		return l.DefaultValue, nil
 	}
 	var values []string
-	for _, fi := range files {
-		v := l.ExtractorFunc(fi)
-		if v != "" {
-			values = append(values, v)
+	if l.Recursive {
+		err := filepath.Walk(l.Dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			if v := l.ExtractorFunc(fi); v != "" {
+				values = append(values, v)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	} else {
+		files, err := ioutil.ReadDir(l.Dir)
+		if err != nil {
+			return "", err
+		}
+		for _, fi := range files {
+			v := l.ExtractorFunc(fi)
+			if v != "" {
+				values = append(values, v)
+			}
 		}
 	}
 	sort.Strings(values)
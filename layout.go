@@ -0,0 +1,161 @@
+package metha
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Naming is the naming scheme of harvest directories.
+type Naming int
+
+const (
+	// NamingBase64 uses the base64 encoded set, format and endpoint.
+	NamingBase64 Naming = iota
+	// NamingReadable uses host, path, format and set, plus a short hash to
+	// keep names unique, e.g. export.arxiv.org_oai2-oai_dc-3f6a1b2c.
+	NamingReadable
+)
+
+// HarvestInfoFilename is written into each harvest directory, so harvests can
+// be listed regardless of the naming scheme.
+const HarvestInfoFilename = "harvest.json"
+
+// maxReadableName limits the readable part of a directory name.
+const maxReadableName = 96
+
+var (
+	unsafeChars = regexp.MustCompile("[^A-Za-z0-9._-]+")
+	shardGlob   = filepath.Join("[0-9][0-9][0-9][0-9]", "[0-9][0-9]")
+)
+
+// ParseNaming parses base64 or readable.
+func ParseNaming(s string) (Naming, error) {
+	switch strings.ToLower(s) {
+	case "", "base64":
+		return NamingBase64, nil
+	case "readable":
+		return NamingReadable, nil
+	}
+	return NamingBase64, fmt.Errorf("unknown naming scheme: %s", s)
+}
+
+// HarvestInfo identifies the harvest a directory belongs to. The remaining
+// fields are filled in by ReadHarvestInfo and not stored.
+type HarvestInfo struct {
+	BaseURL string `json:"baseURL"`
+	Format  string `json:"format"`
+	Set     string `json:"set,omitempty"`
+
+	Dir   string `json:"dir,omitempty"`
+	Files int    `json:"files,omitempty"`
+	Bytes int64  `json:"bytes,omitempty"`
+	First string `json:"first,omitempty"`
+	Last  string `json:"last,omitempty"`
+}
+
+// key is the string, that identifies a harvest.
+func (h *Harvest) key() string {
+	return h.Set + "#" + h.Format + "#" + h.BaseURL
+}
+
+// encodedName is the base64 directory name.
+func (h *Harvest) encodedName() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(h.key()))
+}
+
+// readableName is the human readable directory name.
+func (h *Harvest) readableName() string {
+	name := h.BaseURL
+	if u, err := url.Parse(h.BaseURL); err == nil && u.Host != "" {
+		name = u.Host + u.Path
+	}
+	parts := []string{name, h.Format}
+	if h.Set != "" {
+		parts = append(parts, h.Set)
+	}
+	for i, p := range parts {
+		parts[i] = strings.Trim(unsafeChars.ReplaceAllString(p, "_"), "_.")
+	}
+	readable := strings.Join(parts, "-")
+	if len(readable) > maxReadableName {
+		readable = readable[:maxReadableName]
+	}
+	sum := sha1.Sum([]byte(h.key()))
+	return fmt.Sprintf("%s-%x", readable, sum[:4])
+}
+
+// HarvestFromDir returns a harvest for a directory in BaseDir, given its
+// name. The harvest info file is used, if present.
+func HarvestFromDir(name string) (*Harvest, error) {
+	if b, err := ioutil.ReadFile(filepath.Join(BaseDir, name, HarvestInfoFilename)); err == nil {
+		var info HarvestInfo
+		if err := json.Unmarshal(b, &info); err != nil {
+			return nil, err
+		}
+		h := &Harvest{BaseURL: info.BaseURL, Format: info.Format, Set: info.Set}
+		if name != h.encodedName() {
+			h.Naming = NamingReadable
+		}
+		return h, nil
+	}
+	info, err := decodeName(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Harvest{Set: info.Set, Format: info.Format, BaseURL: info.BaseURL}, nil
+}
+
+// decodeName decodes a base64 directory name.
+func decodeName(name string) (HarvestInfo, error) {
+	b, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil {
+		return HarvestInfo{}, err
+	}
+	parts := strings.SplitN(string(b), "#", 3)
+	if len(parts) < 3 {
+		return HarvestInfo{}, fmt.Errorf("invalid harvest directory: %s", name)
+	}
+	return HarvestInfo{Set: parts[0], Format: parts[1], BaseURL: parts[2]}, nil
+}
+
+// writeInfo writes the harvest info file, if it does not exist yet.
+func (h *Harvest) writeInfo() error {
+	filename := filepath.Join(h.Dir(), HarvestInfoFilename)
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+	b, err := json.Marshal(HarvestInfo{BaseURL: h.BaseURL, Format: h.Format, Set: h.Set})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// chunkPath returns the path for a cached file given its name. With Shard
+// set, files are placed into YYYY/MM subdirectories.
+func (h *Harvest) chunkPath(name string) string {
+	if h.Shard && len(name) >= 7 && chunkPattern.MatchString(name) {
+		return filepath.Join(h.Dir(), name[:4], name[5:7], name)
+	}
+	return filepath.Join(h.Dir(), name)
+}
+
+// glob returns files matching a pattern in the harvest directory and all
+// shard directories, sorted by filename.
+func (h *Harvest) glob(pattern string) []string {
+	files := MustGlob(filepath.Join(h.Dir(), pattern))
+	files = append(files, MustGlob(filepath.Join(h.Dir(), shardGlob, pattern))...)
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+	return files
+}
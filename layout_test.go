@@ -0,0 +1,38 @@
+package metha
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkPath(t *testing.T) {
+	BaseDir = "/tmp/metha-layout-test"
+	var cases = []struct {
+		shard bool
+		name  string
+		want  string
+	}{
+		{false, "2016-03-31-00000000.xml", "2016-03-31-00000000.xml"},
+		{true, "2016-03-31-00000000.xml", filepath.Join("2016", "03", "2016-03-31-00000000.xml")},
+		{true, "finalize.journal", "finalize.journal"},
+	}
+	for _, c := range cases {
+		h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc", Shard: c.shard}
+		if got := h.chunkPath(c.name); got != filepath.Join(h.Dir(), c.want) {
+			t.Errorf("chunkPath(%q) got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReadableName(t *testing.T) {
+	h := Harvest{BaseURL: "http://export.arxiv.org/oai2", Format: "oai_dc", Set: "cs:AI"}
+	name := h.readableName()
+	if !strings.HasPrefix(name, "export.arxiv.org_oai2-oai_dc-cs_AI-") {
+		t.Errorf("got %s", name)
+	}
+	other := Harvest{BaseURL: "https://export.arxiv.org/oai2", Format: "oai_dc", Set: "cs:AI"}
+	if other.readableName() == name {
+		t.Errorf("names must differ for different endpoints: %s", name)
+	}
+}
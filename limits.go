@@ -0,0 +1,71 @@
+package metha
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingTransport counts the bytes of all response bodies.
+type countingTransport struct {
+	Transport http.RoundTripper
+	n         *int64
+}
+
+// RoundTrip executes the request and wraps the response body.
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingReader{ReadCloser: resp.Body, n: t.n}
+	return resp, nil
+}
+
+// countingReader adds the number of bytes read to a counter.
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// runCounts are the counts of the formats and sets harvested earlier in the
+// same run, so the limits apply to the whole run.
+type runCounts struct {
+	bytes    int64
+	records  int
+	requests int
+}
+
+// plus returns the counts with those of a result added.
+func (c runCounts) plus(r *Result) runCounts {
+	return runCounts{bytes: c.bytes + r.Bytes, records: c.records + r.Records, requests: c.requests + r.Requests}
+}
+
+// limitReached returns a message, if the byte, record or request limit of the
+// run is exceeded, otherwise the empty string.
+func (h *Harvest) limitReached() string {
+	if n := atomic.LoadInt64(&h.progress.bytes) + h.earlier.bytes; h.MaxBytes > 0 && n >= h.MaxBytes {
+		return fmt.Sprintf("max bytes limit (%d) reached, %d bytes downloaded", h.MaxBytes, n)
+	}
+	h.mu.Lock()
+	n, requests := h.progress.totalRecords+h.earlier.records, h.progress.requests+h.earlier.requests
+	h.mu.Unlock()
+	if h.MaxRecords > 0 && n >= h.MaxRecords {
+		return fmt.Sprintf("max records limit (%d) reached, %d records downloaded", h.MaxRecords, n)
+	}
+	if h.MaxTotalRequests > 0 && requests >= h.MaxTotalRequests {
+		return fmt.Sprintf("max total requests limit (%d) reached", h.MaxTotalRequests)
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLimitReached(t *testing.T) {
+	var cases = []struct {
+		maxBytes         int64
+		maxRecords       int
+		maxTotalRequests int
+		bytes            int64
+		records          int
+		requests         int
+		reached          bool
+	}{
+		{0, 0, 0, 1000, 1000, 1000, false},
+		{1000, 0, 0, 999, 1000, 0, false},
+		{1000, 0, 0, 1000, 0, 0, true},
+		{0, 10, 0, 1000, 9, 0, false},
+		{0, 10, 0, 1000, 11, 0, true},
+		{0, 0, 5, 0, 0, 4, false},
+		{0, 0, 5, 0, 0, 5, true},
+	}
+	for _, c := range cases {
+		h := Harvest{MaxBytes: c.maxBytes, MaxRecords: c.maxRecords, MaxTotalRequests: c.maxTotalRequests}
+		h.progress.bytes, h.progress.totalRecords, h.progress.requests = c.bytes, c.records, c.requests
+		if got := h.limitReached() != ""; got != c.reached {
+			t.Errorf("%+v: got %v, want %v", c, got, c.reached)
+		}
+	}
+}
+
+func TestResumePoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-resume-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	// three pages per interval
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var next string
+		switch r.URL.Query().Get("resumptionToken") {
+		case "":
+			next = "1"
+		case "1":
+			next = "2"
+		}
+		fmt.Fprintf(w, `<OAI-PMH><ListRecords><record><header><identifier>x</identifier><datestamp>2016-01-15</datestamp></header></record><resumptionToken>%s</resumptionToken></ListRecords></OAI-PMH>`, next)
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		maxTotalRequests int
+		resume           string
+		files            int
+	}{
+		// january complete, february stopped after one request
+		{4, "2016-02-01", 4},
+		// february again, first file replaced, march and april 1st
+		{0, "", 12},
+	}
+	for _, c := range cases {
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			From:              "2016-01-01",
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			MaxTotalRequests:  c.maxTotalRequests,
+			Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+			Started:           time.Date(2016, 4, 2, 0, 0, 0, 0, time.UTC),
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		rp, err := h.ResumePoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resume string
+		if rp != nil {
+			resume = rp.Interval.Begin.Format("2006-01-02")
+		}
+		if resume != c.resume {
+			t.Errorf("got resume point %q, want %q", resume, c.resume)
+		}
+		if n := len(h.Files()); n != c.files {
+			t.Errorf("got %d files, want %d", n, c.files)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package metha
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ListHarvests returns information about all harvests in a base directory,
+// e.g. BaseDir. Directories, that do not belong to a harvest, are skipped.
+func ListHarvests(baseDir string) ([]HarvestInfo, error) {
+	entries, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var harvests []HarvestInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := ReadHarvestInfo(filepath.Join(baseDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		harvests = append(harvests, *info)
+	}
+	return harvests, nil
+}
+
+// ReadHarvestInfo identifies the harvest in a directory, either from its
+// harvest info file or from a base64 encoded name, and adds number and size
+// (on local disk) of the cached files and the dates of the first and last
+// file.
+func ReadHarvestInfo(dir string) (*HarvestInfo, error) {
+	var info HarvestInfo
+	if b, err := ioutil.ReadFile(filepath.Join(dir, HarvestInfoFilename)); err == nil {
+		if err := json.Unmarshal(b, &info); err != nil {
+			return nil, err
+		}
+	} else if info, err = decodeName(filepath.Base(dir)); err != nil {
+		return nil, err
+	}
+	info.Dir = dir
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		groups := fnPattern.FindStringSubmatch(fi.Name())
+		if len(groups) < 2 {
+			return nil
+		}
+		info.Files++
+		info.Bytes += fi.Size()
+		if info.First == "" || groups[1] < info.First {
+			info.First = groups[1]
+		}
+		if groups[1] > info.Last {
+			info.Last = groups[1]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,76 @@
+package metha
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListHarvests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-list-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	var cases = []struct {
+		h     *Harvest
+		files []string
+		want  HarvestInfo
+	}{
+		{
+			&Harvest{BaseURL: "http://a.com/oai", Format: "oai_dc"},
+			[]string{"2016-01-31-00000000.xml.gz", "2016-03-31-00000001.xml.gz"},
+			HarvestInfo{BaseURL: "http://a.com/oai", Format: "oai_dc", Files: 2, First: "2016-01-31", Last: "2016-03-31"},
+		},
+		{
+			&Harvest{BaseURL: "http://b.com/oai", Format: "marcxml", Set: "x", Naming: NamingReadable, Shard: true},
+			[]string{"2017-02-28-00000000.xml.gz"},
+			HarvestInfo{BaseURL: "http://b.com/oai", Format: "marcxml", Set: "x", Files: 1, First: "2017-02-28", Last: "2017-02-28"},
+		},
+	}
+	for _, c := range cases {
+		if err := c.h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range c.files {
+			filename := c.h.chunkPath(name)
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filename, []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// an unrelated directory
+	if err := os.Mkdir(filepath.Join(dir, "not base64!"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	harvests, err := ListHarvests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(harvests) != len(cases) {
+		t.Fatalf("got %d harvests, want %d", len(harvests), len(cases))
+	}
+	for _, c := range cases {
+		var found bool
+		for _, got := range harvests {
+			if got.BaseURL != c.want.BaseURL {
+				continue
+			}
+			found = true
+			got.Dir, got.Bytes = "", 0
+			if got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		}
+		if !found {
+			t.Errorf("%s not found", c.want.BaseURL)
+		}
+	}
+}
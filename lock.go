@@ -0,0 +1,199 @@
+package metha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LockFilename is the name of the lock file in a harvest directory. It is
+// held during a run, so concurrent runs do not interfere with each other's
+// temporary files.
+const LockFilename = "metha.lock"
+
+// ErrLocked is returned, if another process is harvesting into the same
+// directory.
+var ErrLocked = errors.New("harvest is locked by another process")
+
+var (
+	// lockRefresh is the interval, in which the holder touches the lock.
+	lockRefresh = 30 * time.Second
+	// lockStale is the age, after which a lock is considered left over from
+	// a crashed process.
+	lockStale = 2 * time.Minute
+)
+
+// lockOwner is the content of the lock file.
+type lockOwner struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Token   string    `json:"token"`
+}
+
+// harvestLock is a lock held by this process.
+type harvestLock struct {
+	path  string
+	token string
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// lockPath returns the path to the lock file.
+func (h *Harvest) lockPath() string {
+	return filepath.Join(h.Dir(), LockFilename)
+}
+
+// tryLock creates the lock file, if it does not exist or is stale.
+func (h *Harvest) tryLock() (*harvestLock, *lockOwner, error) {
+	hostname, _ := os.Hostname()
+	owner := lockOwner{Host: hostname, PID: os.Getpid(), Started: time.Now(), Token: newRequestID()}
+	b, err := json.Marshal(owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the lock is written aside and linked into place, so it never exists
+	// without an owner and creating it fails, if it exists
+	tmp := h.lockPath() + newTempSuffix("-tmp-")
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		os.Remove(tmp)
+		return nil, nil, err
+	}
+	defer os.Remove(tmp)
+	for {
+		err := os.Link(tmp, h.lockPath())
+		if err == nil {
+			return &harvestLock{path: h.lockPath(), token: owner.Token, done: make(chan struct{})}, nil, nil
+		}
+		if !os.IsExist(err) {
+			return nil, nil, err
+		}
+		fi, err := os.Stat(h.lockPath())
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var other lockOwner
+		if b, err := ioutil.ReadFile(h.lockPath()); err == nil {
+			json.Unmarshal(b, &other)
+		}
+		if time.Since(fi.ModTime()) > lockStale {
+			retry, err := removeStaleLockFile(h.lockPath(), lockStale)
+			if err != nil {
+				return nil, nil, err
+			}
+			if retry {
+				log.Printf("removed stale lock of pid %d on %s from %s", other.PID, other.Host, fi.ModTime().Format(time.RFC3339))
+				continue
+			}
+		}
+		return nil, &other, nil
+	}
+}
+
+// takeoverSuffix names the file, that serializes the removal of a stale
+// lock file.
+const takeoverSuffix = "-takeover"
+
+// removeStaleLockFile removes a lock file, that has not been touched for
+// longer than maxAge. Processes taking over a lock hold a second lock file
+// meanwhile and check the age again, so a lock, that another process has
+// taken over in the meantime, is never removed. Returns true, if the caller
+// should try to create the lock again, false, if the lock is held.
+func removeStaleLockFile(path string, maxAge time.Duration) (bool, error) {
+	guard := path + takeoverSuffix
+	f, err := os.OpenFile(guard, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		// another process is taking over or crashed while doing so
+		if fi, err := os.Stat(guard); err == nil && time.Since(fi.ModTime()) > maxAge {
+			if err := os.Remove(guard); err != nil && !os.IsNotExist(err) {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(guard)
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if time.Since(fi.ModTime()) <= maxAge {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// lock acquires the lock of the harvest directory, waiting up to LockWait
+// for another process to finish.
+func (h *Harvest) lock(ctx context.Context) (*harvestLock, error) {
+	deadline := time.Now().Add(h.LockWait)
+	for {
+		l, other, err := h.tryLock()
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			l.wg.Add(1)
+			go l.refresh()
+			return l, nil
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: pid %d on %s, since %s, lock file %s", ErrLocked,
+				other.PID, other.Host, other.Started.Format(time.RFC3339), h.lockPath())
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// refresh touches the lock file, so other processes see it is alive.
+func (l *harvestLock) refresh() {
+	defer l.wg.Done()
+	for {
+		select {
+		case <-time.After(lockRefresh):
+			t := time.Now()
+			if err := os.Chtimes(l.path, t, t); err != nil {
+				log.Printf("failed to refresh lock: %s", err)
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// unlock removes the lock file, if it still belongs to this process.
+func (l *harvestLock) unlock() {
+	close(l.done)
+	l.wg.Wait()
+	var owner lockOwner
+	if b, err := ioutil.ReadFile(l.path); err == nil && json.Unmarshal(b, &owner) == nil && owner.Token != l.token {
+		log.Printf("lock %s was taken over by pid %d on %s", l.path, owner.PID, owner.Host)
+		return
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove lock: %s", err)
+	}
+}
@@ -0,0 +1,159 @@
+package metha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	l, err := h.lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a second run fails fast
+	other := &Harvest{BaseURL: h.BaseURL, Format: h.Format}
+	if _, err := other.lock(ctx); !errors.Is(err, ErrLocked) {
+		t.Fatalf("got %v, want %v", err, ErrLocked)
+	}
+	// or waits for the first to finish
+	first := l
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		first.unlock()
+	}()
+	other.LockWait = 5 * time.Second
+	l, err = other.lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// locks of crashed processes are taken over
+	close(l.done)
+	l.wg.Wait()
+	old := time.Now().Add(-lockStale - time.Minute)
+	if err := os.Chtimes(h.lockPath(), old, old); err != nil {
+		t.Fatal(err)
+	}
+	l, err = h.lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.unlock()
+	if _, err := os.Stat(h.lockPath()); !os.IsNotExist(err) {
+		t.Errorf("lock file not removed: %v", err)
+	}
+}
+
+func TestLockConcurrentTakeover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-lockStale - time.Minute)
+	for round := 0; round < 50; round++ {
+		if err := ioutil.WriteFile(h.lockPath(), []byte(`{"pid":1}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(h.lockPath(), old, old); err != nil {
+			t.Fatal(err)
+		}
+		// processes seeing the same stale lock, only one may take over
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			locks []*harvestLock
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				other := &Harvest{BaseURL: h.BaseURL, Format: h.Format}
+				l, _, err := other.tryLock()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if l != nil {
+					mu.Lock()
+					locks = append(locks, l)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if len(locks) != 1 {
+			t.Fatalf("round %d: got %d holders, want 1", round, len(locks))
+		}
+		var owner lockOwner
+		if b, err := ioutil.ReadFile(h.lockPath()); err != nil || json.Unmarshal(b, &owner) != nil || owner.Token != locks[0].token {
+			t.Fatalf("round %d: lock file does not belong to the holder: %v", round, err)
+		}
+	}
+
+	// a process, that saw the stale lock, but was slower than the one taking
+	// over, must not remove the new lock
+	if err := ioutil.WriteFile(h.lockPath(), []byte(`{"pid":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(h.lockPath(), old, old); err != nil {
+		t.Fatal(err)
+	}
+	first := &Harvest{BaseURL: h.BaseURL, Format: h.Format}
+	l, _, err := first.tryLock()
+	if err != nil || l == nil {
+		t.Fatalf("takeover failed: %v", err)
+	}
+	if retry, err := removeStaleLockFile(h.lockPath(), lockStale); err != nil || retry {
+		t.Errorf("fresh lock removed: %v", err)
+	}
+	if l, _, err := h.tryLock(); err != nil || l != nil {
+		t.Errorf("slow process got the lock, err %v", err)
+	}
+	// a process, that crashed while taking over, does not block others
+	guard := h.lockPath() + takeoverSuffix
+	if err := ioutil.WriteFile(guard, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(guard, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(h.lockPath(), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if l, _, err := h.tryLock(); err != nil || l != nil {
+		t.Errorf("got the lock during a takeover, err %v", err)
+	}
+	if l, _, err := h.tryLock(); err != nil || l == nil {
+		t.Errorf("stale takeover blocks the lock, err %v", err)
+	}
+	if files, _ := filepath.Glob(h.lockPath() + "-*"); len(files) > 0 {
+		t.Errorf("left over files: %v", files)
+	}
+}
@@ -0,0 +1,176 @@
+package metha
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestFilename is the name of the run log in the harvest directory. Each
+// run, that moved files into place, appends a single line of JSON.
+const ManifestFilename = "runs.jsonl"
+
+// Kinds of runs.
+const (
+	RunHarvest   = "harvest"
+	RunReharvest = "reharvest"
+	RunImport    = "import"
+)
+
+// RunManifest describes a single run and the files it added to the cache,
+// together with provenance information. Files are paths relative to the
+// harvest directory.
+type RunManifest struct {
+	Kind      string        `json:"kind"`
+	Endpoint  string        `json:"endpoint"`
+	Format    string        `json:"format"`
+	Set       string        `json:"set,omitempty"`
+	Identify  *Identify     `json:"identify,omitempty"`
+	Intervals []Interval    `json:"intervals,omitempty"`
+	Requests  int           `json:"requests"`
+	Records   int           `json:"records"`
+	Bytes     int64         `json:"bytes"`
+	Repairs   *RepairCounts `json:"repairs,omitempty"`
+	Version   string        `json:"version"`
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`
+	Files     []string      `json:"files"`
+	Err       string        `json:"err,omitempty"`
+}
+
+// manifestPath returns the path to the run log.
+func (h *Harvest) manifestPath() string {
+	return filepath.Join(h.Dir(), ManifestFilename)
+}
+
+// writeManifest appends a run to the run log. Counts and intervals are taken
+// from the state of the current run.
+func (h *Harvest) writeManifest(kind string, started time.Time, files []string, runErr error) error {
+	m := RunManifest{
+		Kind:      kind,
+		Endpoint:  h.BaseURL,
+		Format:    h.Format,
+		Set:       h.Set,
+		Identify:  h.Identify,
+		Intervals: h.progress.covered,
+		Requests:  h.progress.requests,
+		Records:   h.progress.totalRecords,
+		Bytes:     h.progress.bytes,
+		Version:   Version,
+		Started:   started,
+		Finished:  time.Now(),
+	}
+	if h.progress.repairs.Total() > 0 {
+		repairs := h.progress.repairs
+		m.Repairs = &repairs
+	}
+	for _, filename := range files {
+		rel, err := filepath.Rel(h.Dir(), filename)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, rel)
+	}
+	if runErr != nil {
+		m.Err = runErr.Error()
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.manifestPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Runs returns all recorded runs, oldest first. A missing run log is not an
+// error.
+func (h *Harvest) Runs() ([]RunManifest, error) {
+	f, err := os.Open(h.manifestPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []RunManifest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var m RunManifest
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, err
+		}
+		runs = append(runs, m)
+	}
+	return runs, scanner.Err()
+}
+
+// NewFiles returns the files moved into place by the last call to Run.
+func (h *Harvest) NewFiles() []string {
+	return append([]string(nil), h.finalized...)
+}
+
+// NewFilesSince returns the files added by runs, that finished after t, in
+// the order they were added. Files, that have been compacted or moved to cold
+// storage since, are left out.
+func (h *Harvest) NewFilesSince(t time.Time) ([]string, error) {
+	runs, err := h.Runs()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, run := range runs {
+		if !run.Finished.After(t) {
+			continue
+		}
+		for _, name := range run.Files {
+			filename := filepath.Join(h.Dir(), name)
+			if _, err := os.Stat(filename); err != nil {
+				continue
+			}
+			files = append(files, filename)
+		}
+	}
+	return files, nil
+}
+
+// EachRecordSince calls fn for every record in files added by runs, that
+// finished after t. Iteration stops at the first error, which is returned,
+// unless it is ErrStop.
+func (h *Harvest) EachRecordSince(t time.Time, fn func(Record) error) error {
+	files, err := h.NewFilesSince(t)
+	if err != nil {
+		return err
+	}
+	for _, filename := range files {
+		err := eachResponse(filename, func(_ string, resp *Response) error {
+			for _, rec := range resp.ListRecords.Records {
+				if err := fn(rec); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == ErrStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
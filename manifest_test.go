@@ -0,0 +1,62 @@
+package metha
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewFilesSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-manifest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, name := range []string{
+		"2016-01-31-00000000.xml.gz",
+		"2016-02-29-00000000.xml.gz",
+		"2016-03-31-00000000.xml.gz",
+	} {
+		filename := filepath.Join(h.Dir(), name)
+		if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filename)
+	}
+	first := time.Now()
+	if err := h.writeManifest(RunHarvest, first, names[:2], nil); err != nil {
+		t.Fatal(err)
+	}
+	between := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	if err := h.writeManifest(RunHarvest, time.Now(), names[2:], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		since time.Time
+		want  int
+	}{
+		{first.Add(-time.Second), 3},
+		{between, 1},
+		{time.Now(), 0},
+	}
+	for _, c := range cases {
+		files, err := h.NewFilesSince(c.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != c.want {
+			t.Errorf("since %s: got %d files, want %d", c.since, len(files), c.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNoMetadata signals an empty metadata section, e.g. for deleted records.
+var ErrNoMetadata = errors.New("no metadata")
+
+// DublinCore holds the fifteen elements of simple Dublin Core, as delivered
+// with the oai_dc format. Namespaces are ignored.
+type DublinCore struct {
+	Title       []string `xml:"title" json:"title,omitempty"`
+	Creator     []string `xml:"creator" json:"creator,omitempty"`
+	Subject     []string `xml:"subject" json:"subject,omitempty"`
+	Description []string `xml:"description" json:"description,omitempty"`
+	Publisher   []string `xml:"publisher" json:"publisher,omitempty"`
+	Contributor []string `xml:"contributor" json:"contributor,omitempty"`
+	Date        []string `xml:"date" json:"date,omitempty"`
+	Type        []string `xml:"type" json:"type,omitempty"`
+	Format      []string `xml:"format" json:"format,omitempty"`
+	Identifier  []string `xml:"identifier" json:"identifier,omitempty"`
+	Source      []string `xml:"source" json:"source,omitempty"`
+	Language    []string `xml:"language" json:"language,omitempty"`
+	Relation    []string `xml:"relation" json:"relation,omitempty"`
+	Coverage    []string `xml:"coverage" json:"coverage,omitempty"`
+	Rights      []string `xml:"rights" json:"rights,omitempty"`
+}
+
+// MarcRecord is a single MARCXML record.
+type MarcRecord struct {
+	Leader        string             `xml:"leader" json:"leader"`
+	ControlFields []MarcControlField `xml:"controlfield" json:"controlfield,omitempty"`
+	DataFields    []MarcDataField    `xml:"datafield" json:"datafield,omitempty"`
+}
+
+// MarcControlField is a MARC control field, e.g. 001.
+type MarcControlField struct {
+	Tag   string `xml:"tag,attr" json:"tag"`
+	Value string `xml:",chardata" json:"value"`
+}
+
+// MarcDataField is a MARC data field with indicators and subfields.
+type MarcDataField struct {
+	Tag       string         `xml:"tag,attr" json:"tag"`
+	Ind1      string         `xml:"ind1,attr" json:"ind1"`
+	Ind2      string         `xml:"ind2,attr" json:"ind2"`
+	SubFields []MarcSubField `xml:"subfield" json:"subfield,omitempty"`
+}
+
+// MarcSubField is a single subfield of a data field.
+type MarcSubField struct {
+	Code  string `xml:"code,attr" json:"code"`
+	Value string `xml:",chardata" json:"value"`
+}
+
+// ControlField returns the value of the first control field with the given
+// tag or the empty string.
+func (r *MarcRecord) ControlField(tag string) string {
+	for _, f := range r.ControlFields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Values returns all values of subfields with the given code in data fields
+// with the given tag, e.g. Values("245", "a") for the title.
+func (r *MarcRecord) Values(tag, code string) []string {
+	var result []string
+	for _, f := range r.DataFields {
+		if f.Tag != tag {
+			continue
+		}
+		for _, sf := range f.SubFields {
+			if sf.Code == code {
+				result = append(result, sf.Value)
+			}
+		}
+	}
+	return result
+}
+
+// decodeElement decodes the first element with the given local name into v,
+// any element matches, if name is empty.
+func (md Metadata) decodeElement(name string, v interface{}) error {
+	dec := xml.NewDecoder(bytes.NewReader(md.Body))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return ErrNoMetadata
+		}
+		if err != nil {
+			return err
+		}
+		if se, ok := tok.(xml.StartElement); ok && (name == "" || se.Name.Local == name) {
+			return dec.DecodeElement(v, &se)
+		}
+	}
+}
+
+// Decode unmarshals the metadata payload (the first element inside the
+// metadata section) into v, like xml.Unmarshal.
+func (md Metadata) Decode(v interface{}) error {
+	return md.decodeElement("", v)
+}
+
+// DublinCore decodes oai_dc metadata. Values are trimmed, empty values are
+// dropped.
+func (md Metadata) DublinCore() (*DublinCore, error) {
+	var dc DublinCore
+	if err := md.Decode(&dc); err != nil {
+		return nil, err
+	}
+	for _, field := range []*[]string{
+		&dc.Title, &dc.Creator, &dc.Subject, &dc.Description, &dc.Publisher,
+		&dc.Contributor, &dc.Date, &dc.Type, &dc.Format, &dc.Identifier,
+		&dc.Source, &dc.Language, &dc.Relation, &dc.Coverage, &dc.Rights,
+	} {
+		*field = trimValues(*field)
+	}
+	return &dc, nil
+}
+
+// MarcXML decodes MARCXML metadata. The first record is used, if the payload
+// is a collection.
+func (md Metadata) MarcXML() (*MarcRecord, error) {
+	var r MarcRecord
+	if err := md.decodeElement("record", &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// trimValues trims whitespace and removes empty values.
+func trimValues(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
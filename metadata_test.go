@@ -0,0 +1,54 @@
+package metha
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDublinCore(t *testing.T) {
+	md := Metadata{Body: []byte(`
+	<oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:title>A title</dc:title>
+		<dc:creator>Doe, Jane</dc:creator>
+		<dc:creator> Roe, Richard </dc:creator>
+		<dc:subject></dc:subject>
+	</oai_dc:dc>`)}
+	dc, err := md.DublinCore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dc.Title, []string{"A title"}) {
+		t.Errorf("got title %v", dc.Title)
+	}
+	if !reflect.DeepEqual(dc.Creator, []string{"Doe, Jane", "Roe, Richard"}) {
+		t.Errorf("got creator %v", dc.Creator)
+	}
+	if len(dc.Subject) != 0 {
+		t.Errorf("got subject %v, want none", dc.Subject)
+	}
+	if _, err := (Metadata{}).DublinCore(); err != ErrNoMetadata {
+		t.Errorf("got %v, want %v", err, ErrNoMetadata)
+	}
+}
+
+func TestMarcXML(t *testing.T) {
+	md := Metadata{Body: []byte(`
+	<marc:collection xmlns:marc="http://www.loc.gov/MARC21/slim"><marc:record>
+		<marc:leader>00000nam a2200000 c 4500</marc:leader>
+		<marc:controlfield tag="001">123</marc:controlfield>
+		<marc:datafield tag="245" ind1="1" ind2="0">
+			<marc:subfield code="a">A title</marc:subfield>
+			<marc:subfield code="b">A subtitle</marc:subfield>
+		</marc:datafield>
+	</marc:record></marc:collection>`)}
+	r, err := md.MarcXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.ControlField("001"); got != "123" {
+		t.Errorf("got 001 %q, want 123", got)
+	}
+	if got := r.Values("245", "b"); !reflect.DeepEqual(got, []string{"A subtitle"}) {
+		t.Errorf("got 245b %v", got)
+	}
+}
@@ -0,0 +1,43 @@
+package metha
+
+import "net/url"
+
+// RequestMiddleware changes a request before it is sent, e.g. to add
+// endpoint specific parameters. An error stops the request.
+type RequestMiddleware func(*Request) error
+
+// SetParam returns a middleware, that sets an additional parameter on each
+// request, e.g. SetParam("apikey", "secret").
+func SetParam(key, value string) RequestMiddleware {
+	return func(r *Request) error {
+		if r.Params == nil {
+			r.Params = url.Values{}
+		}
+		r.Params.Set(key, value)
+		return nil
+	}
+}
+
+// SetParams returns a middleware, that sets all given parameters on each
+// request, e.g. parsed from apikey=secret&x=1.
+func SetParams(params url.Values) RequestMiddleware {
+	return func(r *Request) error {
+		for key, values := range params {
+			if r.Params == nil {
+				r.Params = url.Values{}
+			}
+			r.Params[key] = append([]string(nil), values...)
+		}
+		return nil
+	}
+}
+
+// applyMiddleware runs the middleware chain in order on a request.
+func applyMiddleware(r *Request, chain []RequestMiddleware) error {
+	for _, m := range chain {
+		if err := m(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
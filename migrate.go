@@ -0,0 +1,406 @@
+package metha
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// migratePrefix starts the names of directories, that are being written by
+// MigrateCache.
+const migratePrefix = ".migrate-"
+
+// MigrateOptions configures MigrateCache. The zero value gives base64 names,
+// gzip compression and no shards.
+type MigrateOptions struct {
+	Naming      Naming
+	Compression Compression
+	Shard       bool
+	// Move removes each source harvest, after it has been copied and
+	// verified. With the same source and destination directory, this
+	// converts harvests in place.
+	Move bool
+}
+
+// MigrateResult summarizes a migration.
+type MigrateResult struct {
+	// Harvests are the destination directories.
+	Harvests []string `json:"harvests"`
+	// Files are the cached files written, Converted the files, that were
+	// compressed with another codec.
+	Files     int   `json:"files"`
+	Converted int   `json:"converted"`
+	Bytes     int64 `json:"bytes"`
+	// Skipped are source directories, that do not belong to a harvest.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// MigrateCache copies all harvests in srcDir, e.g. BaseDir, into dstDir with
+// the naming, compression and sharding of the options. Each cached file is
+// checked against its checksum, if there is one, and the copy is verified by
+// comparing the decompressed content, before the harvest directory is
+// renamed into place. Compacted files and stubs of files in cold storage are
+// copied as they are. The header index is rewritten to the new file names,
+// statistics are recomputed on demand. Harvests, that are locked, have an
+// unfinished finalize or already exist in dstDir, are not migrated.
+func MigrateCache(srcDir, dstDir string, opts MigrateOptions) (*MigrateResult, error) {
+	if opts.Compression == "" {
+		opts.Compression = CompressionGzip
+	}
+	entries, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return nil, err
+	}
+	result := &MigrateResult{}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		src := filepath.Join(srcDir, e.Name())
+		info, err := ReadHarvestInfo(src)
+		if err != nil {
+			result.Skipped = append(result.Skipped, src)
+			continue
+		}
+		h := &Harvest{BaseURL: info.BaseURL, Format: info.Format, Set: info.Set}
+		name := h.encodedName()
+		if opts.Naming == NamingReadable {
+			name = h.readableName()
+		}
+		dst := filepath.Join(dstDir, name)
+		if err := migrateHarvest(h, src, dst, opts, result); err != nil {
+			return result, fmt.Errorf("%s: %s", src, err)
+		}
+		result.Harvests = append(result.Harvests, dst)
+	}
+	return result, nil
+}
+
+// migrateHarvest copies a single harvest directory into a staging directory
+// next to dst and moves it into place.
+func migrateHarvest(h *Harvest, src, dst string, opts MigrateOptions, result *MigrateResult) error {
+	if fi, err := os.Stat(filepath.Join(src, LockFilename)); err == nil && time.Since(fi.ModTime()) <= lockStale {
+		return ErrLocked
+	}
+	if _, err := os.Stat(filepath.Join(src, JournalFilename)); err == nil {
+		return fmt.Errorf("unfinished finalize, run a harvest first to recover")
+	}
+	inPlace := sameDir(src, dst)
+	if _, err := os.Stat(dst); err == nil && !(inPlace && opts.Move) {
+		return fmt.Errorf("destination %s exists", dst)
+	}
+	staging := filepath.Join(filepath.Dir(dst), migratePrefix+filepath.Base(dst))
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := copyHarvest(src, staging, opts, result); err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(staging, HarvestInfoFilename)); os.IsNotExist(err) {
+		if err := writeHarvestInfo(h, staging); err != nil {
+			return err
+		}
+	}
+	if !opts.Move {
+		return os.Rename(staging, dst)
+	}
+	// keep the source, until the copy is in place
+	old := filepath.Join(filepath.Dir(src), migratePrefix+"old-"+filepath.Base(src))
+	if err := os.Rename(src, old); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// sameDir returns true, if both paths point to the same directory.
+func sameDir(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
+
+// writeHarvestInfo writes the harvest info file into dir.
+func writeHarvestInfo(h *Harvest, dir string) error {
+	b, err := json.Marshal(HarvestInfo{BaseURL: h.BaseURL, Format: h.Format, Set: h.Set})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, HarvestInfoFilename), append(b, '\n'), 0644)
+}
+
+// migrateSkip returns true for files, that are not copied: temporary files,
+// partial responses, the lock, statistics and checksums and indexes, which
+// are handled together with their files.
+func migrateSkip(name string) bool {
+	switch name {
+	case LockFilename, StatsFilename, PartialFilename, HeaderIndexFilename:
+		return true
+	}
+	return strings.Contains(name, ".xml-") || strings.HasSuffix(name, "-tmp") ||
+		strings.HasSuffix(name, ChecksumSuffix) || strings.HasSuffix(name, IndexSuffix)
+}
+
+// chunkDst returns the path of a cached file in the destination directory.
+func chunkDst(dir, name string, shard bool) string {
+	if shard && chunkPattern.MatchString(name) {
+		return filepath.Join(dir, name[:4], name[5:7], name)
+	}
+	return filepath.Join(dir, name)
+}
+
+// copyHarvest copies the files of a harvest directory into dst.
+func copyHarvest(src, dst string, opts MigrateOptions, result *MigrateResult) error {
+	// relative paths of cached files, old to new, for the header index
+	renamed := make(map[string]string)
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := fi.Name()
+		if fi.IsDir() || migrateSkip(name) {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		// cached files are in the harvest directory or a shard directory,
+		// others, e.g. quarantined files, are copied as they are
+		dir := filepath.Dir(rel)
+		sharded, _ := filepath.Match(shardGlob, dir)
+		if !IsChunk(name) || (dir != "." && !sharded) {
+			_, err := copyVerbatim(path, filepath.Join(dst, rel))
+			return err
+		}
+		target, err := migrateChunk(path, dst, opts, result)
+		if err != nil {
+			return err
+		}
+		newRel, err := filepath.Rel(dst, target)
+		if err != nil {
+			return err
+		}
+		renamed[strings.TrimSuffix(rel, StubSuffix)] = strings.TrimSuffix(newRel, StubSuffix)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return rewriteHeaderIndex(filepath.Join(src, HeaderIndexFilename), filepath.Join(dst, HeaderIndexFilename), renamed)
+}
+
+// migrateChunk copies a cached file, compressing it with the codec of the
+// options, and writes its checksum. Stubs and compacted files are copied as
+// they are, together with their checksum or index. Returns the new path.
+func migrateChunk(path, dst string, opts MigrateOptions, result *MigrateResult) (string, error) {
+	name := filepath.Base(path)
+	_, err := os.Stat(path + IndexSuffix)
+	compacted := err == nil
+	stub := strings.HasSuffix(name, StubSuffix)
+	if stub || compacted || compressionFromFilename(name) == opts.Compression {
+		target := chunkDst(dst, name, opts.Shard)
+		sum, err := copyVerbatim(path, target)
+		if err != nil {
+			return "", err
+		}
+		// the checksum of a stub is the one of the data in cold storage
+		side := IndexSuffix
+		if stub {
+			side = ChecksumSuffix
+		}
+		if _, err := os.Stat(strings.TrimSuffix(path, StubSuffix) + side); err == nil {
+			if _, err := copyVerbatim(strings.TrimSuffix(path, StubSuffix)+side, strings.TrimSuffix(target, StubSuffix)+side); err != nil {
+				return "", err
+			}
+		}
+		if !stub {
+			if err := writeChecksum(target, sum); err != nil {
+				return "", err
+			}
+		}
+		if fi, err := os.Stat(target); err == nil {
+			result.Bytes += fi.Size()
+		}
+		result.Files++
+		return target, nil
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".zst")
+	target := chunkDst(dst, base+opts.Compression.Extension(), opts.Shard)
+	n, err := recompress(path, target, opts.Compression)
+	if err != nil {
+		return "", err
+	}
+	result.Files++
+	result.Converted++
+	result.Bytes += n
+	return target, nil
+}
+
+// recompress writes the content of a cached file with another compression
+// and verifies the copy. Returns the number of bytes written.
+func recompress(src, dst string, c Compression) (int64, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	stored := sha256.New()
+	r, err := decompressReader(io.TeeReader(bufio.NewReader(f), stored), compressionFromFilename(src))
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	written := sha256.New()
+	w, err := compressWriter(io.MultiWriter(out, written), c)
+	if err != nil {
+		return 0, err
+	}
+	content := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, content), r); err != nil {
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	if want, err := readChecksum(src); err == nil && !bytes.Equal(want, stored.Sum(nil)) {
+		return 0, fmt.Errorf("%s: checksum mismatch", src)
+	}
+	// read the copy again
+	cr, err := NewChunkReader(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer cr.Close()
+	sum, err := checksum(cr)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(sum, content.Sum(nil)) {
+		return 0, fmt.Errorf("%s: copy differs from source", dst)
+	}
+	if err := writeChecksum(dst, written.Sum(nil)); err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// copyVerbatim copies a file and returns its checksum. Cached files are
+// checked against their checksum, if there is one, and the copy is read again.
+func copyVerbatim(src, dst string) ([]byte, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), f); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	sum := hash.Sum(nil)
+	if !IsChunk(src) || strings.HasSuffix(src, StubSuffix) {
+		return sum, nil
+	}
+	if want, err := readChecksum(src); err == nil && !bytes.Equal(want, sum) {
+		return nil, fmt.Errorf("%s: checksum mismatch", src)
+	}
+	if got, err := fileChecksum(dst); err != nil {
+		return nil, err
+	} else if !bytes.Equal(got, sum) {
+		return nil, fmt.Errorf("%s: copy differs from source", dst)
+	}
+	return sum, nil
+}
+
+// rewriteHeaderIndex copies a header index with the new file names. Entries
+// of files, that were not copied, are dropped.
+func rewriteHeaderIndex(src, dst string, renamed map[string]string) error {
+	f, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriter(out)
+	br := bufio.NewScanner(f)
+	br.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var dropped int
+	for br.Scan() {
+		e, err := parseHeaderEntry(br.Text())
+		if err != nil {
+			return err
+		}
+		file, ok := renamed[e.File]
+		if !ok {
+			dropped++
+			continue
+		}
+		e.File = file
+		fmt.Fprintln(bw, e.String())
+	}
+	if err := br.Err(); err != nil {
+		return err
+	}
+	if dropped > 0 {
+		log.Printf("%s: dropped %d entries of missing files", src, dropped)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,108 @@
+package metha
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMigrateCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-migrate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	BaseDir = src
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	doc := `<OAI-PMH><ListRecords><record><header><identifier>a</identifier><datestamp>2016-01-10</datestamp></header></record></ListRecords></OAI-PMH>`
+	tmp := filepath.Join(h.Dir(), "2016-01-31-00000000.xml-tmp-1")
+	if err := ioutil.WriteFile(tmp, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveAndCompress(tmp, filepath.Join(h.Dir(), "2016-01-31-00000000.xml.gz")); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"2016-02-29-00000000.xml-tmp-2":           doc,
+		HeaderIndexFilename:                       "a\t2016-01-10\t\t2016-01-31-00000000.xml.gz\t" + strconv.Itoa(strings.Index(doc, "<record>")) + "\t0\n",
+		"quarantine/2016-03-31-00000000.xml":      "broken",
+		filepath.Join("..", "not-a-harvest", "x"): "",
+	} {
+		filename := filepath.Join(h.Dir(), name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := MigrateOptions{Naming: NamingReadable, Compression: CompressionZstd, Shard: true}
+	result, err := MigrateCache(src, dst, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Harvests) != 1 || result.Files != 1 || result.Converted != 1 || len(result.Skipped) != 1 {
+		t.Fatalf("got %+v", result)
+	}
+	BaseDir = dst
+	m := &Harvest{BaseURL: h.BaseURL, Format: h.Format, Naming: NamingReadable}
+	if m.Dir() != result.Harvests[0] {
+		t.Errorf("got %s, want %s", result.Harvests[0], m.Dir())
+	}
+	want := filepath.Join(m.Dir(), "2016", "01", "2016-01-31-00000000.xml.zst")
+	if files := m.Files(); len(files) != 1 || files[0] != want {
+		t.Errorf("got files %v, want %s", files, want)
+	}
+	if fsck, err := m.Fsck(); err != nil || !fsck.OK() {
+		t.Errorf("fsck: %+v, %v", fsck, err)
+	}
+	idx, err := m.OpenHeaderIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := idx.Lookup("a"); !ok || e.File != filepath.Join("2016", "01", "2016-01-31-00000000.xml.zst") {
+		t.Errorf("got header entry %+v", e)
+	} else if rec, err := idx.Record(e); err != nil || rec.Header.Identifier != "a" {
+		t.Errorf("got record %v, %v", rec, err)
+	}
+	if _, err := os.Stat(filepath.Join(m.Dir(), "quarantine", "2016-03-31-00000000.xml")); err != nil {
+		t.Errorf("quarantine not copied: %v", err)
+	}
+	if tmps := MustGlob(filepath.Join(m.Dir(), "*-tmp-*")); len(tmps) != 0 {
+		t.Errorf("got temporary files %v", tmps)
+	}
+
+	// destination exists, unless converted in place
+	if _, err := MigrateCache(src, dst, opts); err == nil || !strings.Contains(err.Error(), "exists") {
+		t.Errorf("got %v, want destination exists", err)
+	}
+	opts = MigrateOptions{Naming: NamingReadable, Compression: CompressionGzip, Move: true}
+	if _, err := MigrateCache(dst, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	want = filepath.Join(m.Dir(), "2016-01-31-00000000.xml.gz")
+	if files := m.Files(); len(files) != 1 || files[0] != want {
+		t.Errorf("got files %v, want %s", files, want)
+	}
+
+	// corrupt source files are not migrated
+	if err := ioutil.WriteFile(want+ChecksumSuffix, []byte("00  x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.Compression = CompressionNone
+	if _, err := MigrateCache(dst, dst, opts); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("got %v, want checksum mismatch", err)
+	}
+	if files := m.Files(); len(files) != 1 || files[0] != want {
+		t.Errorf("got files %v after failed migration, want %s", files, want)
+	}
+}
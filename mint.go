@@ -0,0 +1,87 @@
+package metha
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// NamespaceURL is the RFC 4122 namespace for URLs.
+var NamespaceURL = [16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1,
+	0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+// Minter derives a downstream identifier for a record from the source (the
+// endpoint URL) and the OAI identifier. The same input must always yield the
+// same identifier, so independent exports agree on document identity.
+type Minter interface {
+	Mint(source, identifier string) string
+}
+
+// MinterFunc adapts a function to the Minter interface.
+type MinterFunc func(source, identifier string) string
+
+// Mint calls f.
+func (f MinterFunc) Mint(source, identifier string) string { return f(source, identifier) }
+
+// UUIDMinter mints name based UUIDs (version 5). The name is the source and
+// the identifier, separated by "#".
+type UUIDMinter struct {
+	Namespace [16]byte
+}
+
+// Mint returns a UUID in canonical string form.
+func (m UUIDMinter) Mint(source, identifier string) string {
+	h := sha1.New()
+	h.Write(m.Namespace[:])
+	io.WriteString(h, source+"#"+identifier)
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// HashMinter mints a hex encoded hash of source and identifier, with an
+// optional prefix.
+type HashMinter struct {
+	New    func() hash.Hash
+	Prefix string
+}
+
+// Mint returns the prefixed hex digest.
+func (m HashMinter) Mint(source, identifier string) string {
+	h := m.New()
+	io.WriteString(h, source+"#"+identifier)
+	return m.Prefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// NewMinter returns a minter by name: uuid, sha1 or sha256.
+func NewMinter(name string) (Minter, error) {
+	switch name {
+	case "uuid":
+		return UUIDMinter{Namespace: NamespaceURL}, nil
+	case "sha1":
+		return HashMinter{New: sha1.New}, nil
+	case "sha256":
+		return HashMinter{New: sha256.New}, nil
+	}
+	return nil, fmt.Errorf("unknown minter: %s", name)
+}
+
+// WriteMapping writes a tab separated table of OAI identifiers and minted
+// identifiers for all records in the cache. Each identifier is written once.
+func (h *Harvest) WriteMapping(w io.Writer, m Minter) error {
+	seen := make(map[string]bool)
+	return h.EachRecord(func(rec Record) error {
+		id := rec.Header.Identifier
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		_, err := fmt.Fprintf(w, "%s\t%s\n", id, m.Mint(h.BaseURL, id))
+		return err
+	})
+}
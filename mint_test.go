@@ -0,0 +1,12 @@
+package metha
+
+import "testing"
+
+func TestUUIDMinter(t *testing.T) {
+	m := UUIDMinter{Namespace: NamespaceURL}
+	// python3 -c 'import uuid; print(uuid.uuid5(uuid.NAMESPACE_URL, "http://example.com/oai#oai:x:1"))'
+	want := "a6c82ec2-7dff-5e28-a454-37347e891d8b"
+	if got := m.Mint("http://example.com/oai", "oai:x:1"); got != want {
+		t.Errorf("Mint got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package metha
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OAI-PMH error conditions, http://www.openarchives.org/OAI/openarchivesprotocol.html#ErrorConditions.
+// Errors returned from a harvest can be compared with errors.Is, e.g.
+// errors.Is(err, ErrBadResumptionToken).
+var (
+	ErrBadArgument             = OAIError{Code: "badArgument"}
+	ErrBadResumptionToken      = OAIError{Code: "badResumptionToken"}
+	ErrBadVerb                 = OAIError{Code: "badVerb"}
+	ErrCannotDisseminateFormat = OAIError{Code: "cannotDisseminateFormat"}
+	ErrIDDoesNotExist          = OAIError{Code: "idDoesNotExist"}
+	ErrNoRecordsMatch          = OAIError{Code: "noRecordsMatch"}
+	ErrNoMetadataFormats       = OAIError{Code: "noMetadataFormats"}
+	ErrNoSetHierarchy          = OAIError{Code: "noSetHierarchy"}
+	// ErrInternalException is not part of the protocol, but seen in the
+	// wild, refs. #9717.
+	ErrInternalException = OAIError{Code: "InternalException"}
+)
+
+// Is reports whether target is an OAIError with the same code, regardless of
+// the message.
+func (e OAIError) Is(target error) bool {
+	t, ok := target.(OAIError)
+	return ok && t.Code == e.Code
+}
+
+// ErrorAction is the reaction to an OAI error during a harvest.
+type ErrorAction string
+
+const (
+	// ErrorAbort stops the harvest and returns the error.
+	ErrorAbort ErrorAction = "abort"
+	// ErrorIgnore keeps the response and continues, like for an empty
+	// response; without a resumption token the interval is done.
+	ErrorIgnore ErrorAction = "ignore"
+	// ErrorRetry repeats the request, following the retry policy.
+	ErrorRetry ErrorAction = "retry"
+)
+
+// DefaultErrorActions are used for codes, that are not configured in a
+// harvest. Other codes abort.
+var DefaultErrorActions = map[string]ErrorAction{
+	ErrNoRecordsMatch.Code:    ErrorIgnore,
+	ErrInternalException.Code: ErrorRetry,
+}
+
+// DefaultTokenRestarts is the number of interval restarts after an expired
+// resumption token used by the command line tools.
+const DefaultTokenRestarts = 3
+
+// ParseErrorActions parses a comma separated list of code=action pairs, e.g.
+// "badResumptionToken=retry,noSetHierarchy=ignore".
+func ParseErrorActions(s string) (map[string]ErrorAction, error) {
+	actions := make(map[string]ErrorAction)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid error action, want code=action: %s", pair)
+		}
+		action, err := ParseErrorAction(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		actions[strings.TrimSpace(parts[0])] = action
+	}
+	return actions, nil
+}
+
+// ParseErrorAction returns the action for a name.
+func ParseErrorAction(s string) (ErrorAction, error) {
+	switch a := ErrorAction(strings.TrimSpace(s)); a {
+	case ErrorAbort, ErrorIgnore, ErrorRetry:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown error action: %s", s)
+}
+
+// errorAction returns the configured or default action for an error code.
+func (h *Harvest) errorAction(code string) ErrorAction {
+	if a, ok := h.ErrorActions[code]; ok {
+		return a
+	}
+	if a, ok := DefaultErrorActions[code]; ok {
+		return a
+	}
+	return ErrorAbort
+}
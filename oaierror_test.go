@@ -0,0 +1,56 @@
+package metha
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestOAIErrorIs(t *testing.T) {
+	var cases = []struct {
+		err    error
+		target error
+		want   bool
+	}{
+		{OAIError{Code: "noRecordsMatch", Message: "empty"}, ErrNoRecordsMatch, true},
+		{OAIError{Code: "badArgument"}, ErrNoRecordsMatch, false},
+		{fmt.Errorf("interval: %w", OAIError{Code: "badResumptionToken"}), ErrBadResumptionToken, true},
+		{&FormatError{Format: "mets"}, ErrCannotDisseminateFormat, true},
+		{errors.New("noRecordsMatch"), ErrNoRecordsMatch, false},
+	}
+	for _, c := range cases {
+		if got := errors.Is(c.err, c.target); got != c.want {
+			t.Errorf("%v, %v: got %v, want %v", c.err, c.target, got, c.want)
+		}
+	}
+}
+
+func TestParseErrorActions(t *testing.T) {
+	var cases = []struct {
+		s    string
+		code string
+		want ErrorAction
+		err  bool
+	}{
+		{"", "noRecordsMatch", ErrorIgnore, false},
+		{"", "InternalException", ErrorRetry, false},
+		{"", "badArgument", ErrorAbort, false},
+		{"noSetHierarchy=ignore", "noSetHierarchy", ErrorIgnore, false},
+		{"noRecordsMatch=abort, badResumptionToken=retry", "noRecordsMatch", ErrorAbort, false},
+		{"noRecordsMatch", "", "", true},
+		{"noRecordsMatch=skip", "", "", true},
+	}
+	for _, c := range cases {
+		actions, err := ParseErrorActions(c.s)
+		if (err != nil) != c.err {
+			t.Errorf("%q: got error %v, want %v", c.s, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		h := Harvest{ErrorActions: actions}
+		if got := h.errorAction(c.code); got != c.want {
+			t.Errorf("%q: got %s for %s, want %s", c.s, got, c.code, c.want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package metha
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// ProvenanceSchemaNamespace is the namespace of the provenance container,
+	// that aggregators add to the about section of a record.
+	ProvenanceSchemaNamespace = "http://www.openarchives.org/OAI/2.0/provenance"
+	// OriginsDir is the name of the directory inside a harvest directory,
+	// that holds the records partitioned by origin.
+	OriginsDir = "origins"
+	// OriginFilename names the origin of a partition.
+	OriginFilename = "origin.txt"
+)
+
+// OriginDescription describes, where a record was harvested from. If the
+// origin was an aggregator itself, Origin describes the source before that.
+type OriginDescription struct {
+	HarvestDate       string             `xml:"harvestDate,attr" json:"harvestDate,omitempty"`
+	Altered           bool               `xml:"altered,attr" json:"altered,omitempty"`
+	BaseURL           string             `xml:"baseURL" json:"baseURL"`
+	Identifier        string             `xml:"identifier" json:"identifier,omitempty"`
+	DateStamp         string             `xml:"datestamp" json:"datestamp,omitempty"`
+	MetadataNamespace string             `xml:"metadataNamespace" json:"metadataNamespace,omitempty"`
+	Origin            *OriginDescription `xml:"originDescription" json:"originDescription,omitempty"`
+}
+
+// Chain returns the description and all nested descriptions, from the
+// repository the record was harvested from to the original source.
+func (o *OriginDescription) Chain() []*OriginDescription {
+	var chain []*OriginDescription
+	for d := o; d != nil; d = d.Origin {
+		chain = append(chain, d)
+	}
+	return chain
+}
+
+// provenance is the container of origin descriptions.
+type provenance struct {
+	XMLName xml.Name           `xml:"provenance"`
+	Origin  *OriginDescription `xml:"originDescription"`
+}
+
+// Provenance returns the origin description from the about section of a
+// record or nil, if there is none. Other about containers, e.g. rights
+// statements, are skipped.
+func (rec Record) Provenance() *OriginDescription {
+	for _, about := range rec.About {
+		var p provenance
+		if err := xml.Unmarshal(bytes.TrimSpace(about.Body), &p); err != nil {
+			continue
+		}
+		if p.Origin != nil {
+			return p.Origin
+		}
+	}
+	return nil
+}
+
+// Origin returns the base URL of the repository, an aggregator harvested the
+// record from, or an empty string.
+func (rec Record) Origin() string {
+	if p := rec.Provenance(); p != nil {
+		return strings.TrimSpace(p.BaseURL)
+	}
+	return ""
+}
+
+// FromOrigins keeps records harvested by an aggregator from one of the given
+// repositories.
+func FromOrigins(origins ...string) RecordFilter {
+	return func(rec Record) bool {
+		origin := rec.Origin()
+		for _, o := range origins {
+			if o == origin {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// originsDir returns the directory of the partitions.
+func (h *Harvest) originsDir() string {
+	return filepath.Join(h.Dir(), OriginsDir)
+}
+
+// OriginDir returns the directory of the partition of an origin, named like
+// a readable harvest directory.
+func (h *Harvest) OriginDir(origin string) string {
+	name := origin
+	if u, err := url.Parse(origin); err == nil && u.Host != "" {
+		name = u.Host + u.Path
+	}
+	name = strings.Trim(unsafeChars.ReplaceAllString(name, "_"), "_.")
+	if len(name) > maxReadableName {
+		name = name[:maxReadableName]
+	}
+	sum := sha1.Sum([]byte(origin))
+	return filepath.Join(h.originsDir(), fmt.Sprintf("%s-%x", name, sum[:4]))
+}
+
+// Origins returns the origins, the cache is partitioned into, sorted.
+func (h *Harvest) Origins() ([]string, error) {
+	entries, err := ioutil.ReadDir(h.originsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var origins []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(h.originsDir(), e.Name(), OriginFilename))
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, strings.TrimSpace(string(b)))
+	}
+	sort.Strings(origins)
+	return origins, nil
+}
+
+// OriginFiles returns the files of the partition of an origin, sorted by
+// name. They are laid out like cached files.
+func (h *Harvest) OriginFiles(origin string) []string {
+	var files []string
+	for _, ext := range chunkExtensions {
+		files = append(files, MustGlob(filepath.Join(h.OriginDir(origin), "*"+ext))...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// originRecords groups the records of a file by origin. Records without
+// provenance are left out.
+func originRecords(filename string) (map[string][]Record, error) {
+	groups := make(map[string][]Record)
+	err := eachRecordPosition(filename, func(_ int, rec Record) error {
+		if origin := rec.Origin(); origin != "" {
+			groups[origin] = append(groups[origin], rec)
+		}
+		return nil
+	})
+	return groups, err
+}
+
+// writePartitionFile writes records as a ListRecords response to a file in
+// the partition of an origin, compressed like cached files.
+func (h *Harvest) writePartitionFile(origin, name string, records []Record) error {
+	dir := h.OriginDir(origin)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	marker := filepath.Join(dir, OriginFilename)
+	if _, err := os.Stat(marker); os.IsNotExist(err) {
+		if err := ioutil.WriteFile(marker, []byte(origin+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<OAI-PMH xmlns="http://www.openarchives.org/OAI/2.0/"><ListRecords>`)
+	enc := xml.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.EncodeElement(rec, xml.StartElement{Name: xml.Name{Local: "record"}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	buf.WriteString("</ListRecords></OAI-PMH>\n")
+	dst := filepath.Join(dir, name)
+	tmp := dst + newTempSuffix("-tmp-")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return MoveAndCompress(tmp, dst)
+}
+
+// partition sorts the records of a file into the partitions, replacing
+// partition files of the same name. With only, other origins are left
+// untouched. Name is the name of the partition files.
+func (h *Harvest) partition(filename, name, only string) error {
+	groups, err := originRecords(filename)
+	if err != nil {
+		return err
+	}
+	origins, err := h.Origins()
+	if err != nil {
+		return err
+	}
+	for origin := range groups {
+		origins = append(origins, origin)
+	}
+	done := make(map[string]bool)
+	for _, origin := range origins {
+		if done[origin] || (only != "" && origin != only) {
+			continue
+		}
+		done[origin] = true
+		if records := groups[origin]; len(records) > 0 {
+			if err := h.writePartitionFile(origin, name, records); err != nil {
+				return err
+			}
+			continue
+		}
+		// the file no longer has records of this origin
+		stale := filepath.Join(h.OriginDir(origin), name)
+		if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := removeChecksum(stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasOrigins returns true, if partitioning is enabled or partitions exist.
+func (h *Harvest) hasOrigins() bool {
+	if h.PartitionByOrigin {
+		return true
+	}
+	_, err := os.Stat(h.originsDir())
+	return err == nil
+}
+
+// updateOrigins partitions finalized files, if there are partitions.
+// Failures are logged, RebuildOrigins repairs the partitions.
+func (h *Harvest) updateOrigins(files []string) {
+	if len(files) == 0 || !h.hasOrigins() {
+		return
+	}
+	for _, filename := range files {
+		if err := h.partition(filename, filepath.Base(filename), ""); err != nil {
+			log.Printf("partitioning by origin failed, run metha-origins -rebuild: %s", err)
+			return
+		}
+	}
+}
+
+// RebuildOrigins partitions all cached files by origin again, e.g. for an
+// existing cache of an aggregator or after compaction.
+func (h *Harvest) RebuildOrigins() error {
+	if err := os.RemoveAll(h.originsDir()); err != nil {
+		return err
+	}
+	for _, filename := range h.Files() {
+		if err := h.partition(filename, filepath.Base(filename), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReharvestOrigin is like ReharvestOriginContext, but is cancelled on an
+// interrupt signal.
+func (h *Harvest) ReharvestOrigin(origin, from, until string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+	return h.ReharvestOriginContext(ctx, origin, from, until)
+}
+
+// ReharvestOriginContext harvests a date range from the aggregator again and
+// replaces the records of a single origin in its partition, e.g. after the
+// aggregator fixed the records of a misbehaving repository. The cache and the
+// partitions of other origins are not changed. The range is widened to whole
+// months (or days, with DailyInterval), like in ReharvestRangeContext. A
+// re-harvest stopped by a run limit fails with ErrReharvestStopped.
+func (h *Harvest) ReharvestOriginContext(ctx context.Context, origin, from, until string) error {
+	if h.DisableSelectiveHarvesting {
+		return ErrNotSelective
+	}
+	interval, l, err := h.startReharvest(ctx, from, until)
+	if err != nil {
+		return err
+	}
+	defer l.unlock()
+	intervals := interval.MonthlyIntervals()
+	if h.DailyInterval {
+		intervals = interval.DailyIntervals()
+	}
+	log.Printf("re-harvesting %s for origin %s", interval, origin)
+
+	// fetch everything first, so a failure leaves the partition intact
+	var fetched []*fetched
+	defer func() {
+		for _, f := range fetched {
+			for _, filename := range f.files {
+				os.Remove(filename)
+			}
+		}
+	}()
+	h.progress = progressState{intervals: len(intervals)}
+	for i, iv := range intervals {
+		h.progress.interval = i
+		f, err := h.fetchInterval(ctx, i, iv)
+		if err != nil {
+			return err
+		}
+		fetched = append(fetched, f)
+		if err := reharvestStopped(f.stopped, iv); err != nil {
+			return err
+		}
+	}
+	// remove the files of the range, then write the new ones
+	for _, filename := range h.OriginFiles(origin) {
+		groups := fnPattern.FindStringSubmatch(filepath.Base(filename))
+		if len(groups) < 2 || groups[1] < interval.Begin.Format("2006-01-02") || groups[1] > interval.End.Format("2006-01-02") {
+			continue
+		}
+		if err := os.Remove(filename); err != nil {
+			return err
+		}
+		if err := removeChecksum(filename); err != nil {
+			return err
+		}
+	}
+	var n int
+	for _, f := range fetched {
+		for _, filename := range f.files {
+			if _, err := os.Stat(filename); os.IsNotExist(err) {
+				continue
+			}
+			name := strings.TrimSuffix(filepath.Base(filename), f.suffix) + h.Compression.Extension()
+			if err := h.partition(filename, name, origin); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	log.Printf("partitioned %d responses for origin %s", n, origin)
+	return nil
+}
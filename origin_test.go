@@ -0,0 +1,179 @@
+package metha
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// provenanceAbout returns an about section for a chain of base URLs,
+// outermost first.
+func provenanceAbout(baseURLs ...string) string {
+	var inner string
+	for i := len(baseURLs) - 1; i >= 0; i-- {
+		inner = fmt.Sprintf(`<originDescription harvestDate="2016-01-01" altered="false"><baseURL>%s</baseURL><identifier>x</identifier>%s</originDescription>`, baseURLs[i], inner)
+	}
+	return `<about><provenance xmlns="` + ProvenanceSchemaNamespace + `">` + inner + `</provenance></about>`
+}
+
+func TestRecordProvenance(t *testing.T) {
+	var cases = []struct {
+		about string
+		chain []string
+	}{
+		{"", nil},
+		{`<about><rights>cc-by</rights></about>`, nil},
+		{provenanceAbout("http://a.org/oai"), []string{"http://a.org/oai"}},
+		{`<about><rights>cc-by</rights></about>` + provenanceAbout(" http://a.org/oai ", "http://b.org/oai"),
+			[]string{"http://a.org/oai", "http://b.org/oai"}},
+	}
+	for _, c := range cases {
+		var resp Response
+		body := `<OAI-PMH><ListRecords><record><header><identifier>1</identifier></header>` + c.about + `</record></ListRecords></OAI-PMH>`
+		if err := xml.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatal(err)
+		}
+		rec := resp.ListRecords.Records[0]
+		var chain []string
+		if p := rec.Provenance(); p != nil {
+			for _, d := range p.Chain() {
+				chain = append(chain, strings.TrimSpace(d.BaseURL))
+			}
+		}
+		if fmt.Sprint(chain) != fmt.Sprint(c.chain) {
+			t.Errorf("%s: got %v, want %v", c.about, chain, c.chain)
+		}
+		var want string
+		if len(c.chain) > 0 {
+			want = c.chain[0]
+		}
+		if rec.Origin() != want || FromOrigins("http://a.org/oai")(rec) != (want == "http://a.org/oai") {
+			t.Errorf("%s: got origin %q, want %q", c.about, rec.Origin(), want)
+		}
+	}
+}
+
+func TestPartitionByOrigin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-origin-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	const a, b = "http://a.org/oai", "http://b.org/oai"
+	fixed := false
+	record := `<record><header><identifier>%s</identifier><datestamp>%s</datestamp></header><metadata><dc>%s</dc></metadata>%s</record>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from := r.URL.Query().Get("from")
+		if from != "2016-01-01" && from != "2016-02-01" {
+			fmt.Fprint(w, `<OAI-PMH><error code="noRecordsMatch"/></OAI-PMH>`)
+			return
+		}
+		version := "broken"
+		if fixed {
+			version = "fixed"
+		}
+		fmt.Fprint(w, `<OAI-PMH><ListRecords>`)
+		fmt.Fprintf(w, record, "a-"+from, from, version, provenanceAbout(a))
+		fmt.Fprintf(w, record, "b-"+from, from, version, provenanceAbout(b, "http://c.org/oai"))
+		fmt.Fprintf(w, record, "local-"+from, from, version, "")
+		fmt.Fprint(w, `</ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		PartitionByOrigin: true,
+		Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+		Started:           time.Now(),
+	}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	iv := Interval{
+		Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2016, 2, 29, 23, 59, 59, 0, time.UTC),
+	}
+	if err := h.runIntervals(context.Background(), iv); err != nil {
+		t.Fatal(err)
+	}
+	origins, err := h.Origins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(origins) != fmt.Sprint([]string{a, b}) {
+		t.Fatalf("got origins %v", origins)
+	}
+
+	export := func(origin string, files []string) string {
+		var buf bytes.Buffer
+		e := Exporter{Harvest: h, Origin: origin, Files: files}
+		if _, err := e.Export(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	for _, origin := range origins {
+		if n := len(h.OriginFiles(origin)); n != 2 {
+			t.Errorf("%s: got %d partition files, want 2", origin, n)
+		}
+		got := export(origin, nil)
+		// the unpartitioned cache gives the same records
+		if want := export(origin, h.Files()); got != want || strings.Count(got, "\n") != 2 {
+			t.Errorf("%s: got export %s, want %s", origin, got, want)
+		}
+	}
+
+	// the aggregator fixed the records of a in January
+	fixed = true
+	// a re-harvest stopped by a limit leaves the partition intact
+	h.MaxTotalRequests = 1
+	if err := h.ReharvestOriginContext(context.Background(), a, "2016-01-15", "2016-02-20"); !errors.Is(err, ErrReharvestStopped) {
+		t.Fatalf("got %v, want %v", err, ErrReharvestStopped)
+	}
+	if got := strings.Count(export(a, nil), "<dc>fixed</dc>"); got != 0 {
+		t.Errorf("stopped: got %d fixed records, want 0", got)
+	}
+	h.MaxTotalRequests = 0
+	if err := h.ReharvestOriginContext(context.Background(), a, "2016-01-15", "2016-01-20"); err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		origin string
+		files  []string
+		fixed  int
+	}{
+		{a, nil, 1},
+		{b, nil, 0},
+		{a, h.Files(), 0},
+	}
+	for _, c := range cases {
+		if got := strings.Count(export(c.origin, c.files), "<dc>fixed</dc>"); got != c.fixed {
+			t.Errorf("%s (cache %v): got %d fixed records, want %d", c.origin, c.files != nil, got, c.fixed)
+		}
+	}
+	if n := len(h.temporaryFiles()); n != 0 {
+		t.Errorf("got %d temporary files", n)
+	}
+
+	// a rebuild restores the partitions from the cache
+	if err := h.RebuildOrigins(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(export(a, nil), "<dc>broken</dc>"); got != 2 {
+		t.Errorf("rebuild: got %d records from cache, want 2", got)
+	}
+}
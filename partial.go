@@ -0,0 +1,118 @@
+package metha
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PartialFilename is the name of the state of an unfinished harvest without
+// intervals, see ResumeWindow.
+const PartialFilename = "partial.json"
+
+// partialPrefix starts the suffix of files of a harvest without intervals,
+// that can be resumed. They are not removed with other temporary files.
+const partialPrefix = "-part-"
+
+// partialState is saved after each response of a resumable harvest.
+type partialState struct {
+	Suffix   string `json:"suffix"`
+	Token    string `json:"token"`
+	Requests int    `json:"requests"`
+	Records  int    `json:"records"`
+	FileDate string `json:"filedate"`
+	// Files are the responses written so far, the ledger of the batch.
+	Files []string  `json:"files,omitempty"`
+	Saved time.Time `json:"saved"`
+}
+
+// resumable returns true, if an unfinished harvest without intervals is
+// continued by the next run.
+func (h *Harvest) resumable() bool {
+	return h.DisableSelectiveHarvesting && h.ResumeWindow > 0
+}
+
+// partialPath returns the path to the saved state.
+func (h *Harvest) partialPath() string {
+	return filepath.Join(h.Dir(), PartialFilename)
+}
+
+// newPartialSuffix returns a suffix for the files of a resumable harvest.
+func newPartialSuffix() string {
+	return newTempSuffix(partialPrefix)
+}
+
+// isPartial returns true, if a suffix belongs to a resumable harvest.
+func isPartial(suffix string) bool {
+	return strings.HasPrefix(suffix, partialPrefix)
+}
+
+// loadPartial returns the state of an unfinished harvest, that can be
+// continued. Expired or incomplete state is removed together with its files.
+func (h *Harvest) loadPartial() (*partialState, error) {
+	b, err := ioutil.ReadFile(h.partialPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p partialState
+	if err := json.Unmarshal(b, &p); err != nil || !isPartial(p.Suffix) {
+		log.Printf("ignoring broken %s", h.partialPath())
+		return nil, h.removePartial("")
+	}
+	if p.Files == nil {
+		// state written before files were recorded
+		p.Files = h.temporaryFilesSuffix(p.Suffix)
+	}
+	var files []string
+	for _, filename := range p.Files {
+		if _, err := os.Stat(filename); err == nil {
+			files = append(files, filename)
+		}
+	}
+	switch {
+	case time.Since(p.Saved) > h.ResumeWindow:
+		log.Printf("unfinished harvest from %s is too old, starting over", p.Saved.Format(time.RFC3339))
+	case len(files) != p.Requests:
+		log.Printf("unfinished harvest has %d files, want %d, starting over", len(files), p.Requests)
+	default:
+		return &p, nil
+	}
+	return nil, h.removePartial(p.Suffix)
+}
+
+// savePartial saves the state after a response.
+func (h *Harvest) savePartial(p partialState) error {
+	p.Saved = time.Now()
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	tmp := h.partialPath() + "-tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.partialPath())
+}
+
+// removePartial removes the saved state and, if a suffix is given, the files
+// of the unfinished harvest.
+func (h *Harvest) removePartial(suffix string) error {
+	if suffix != "" {
+		for _, filename := range h.temporaryFilesSuffix(suffix) {
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Remove(h.partialPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
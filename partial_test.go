@@ -0,0 +1,96 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResumeWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-partial-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	var (
+		mu     sync.Mutex
+		fail   = true
+		starts int
+	)
+	// five responses, the fourth fails once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity><earliestDatestamp>2016-01-01</earliestDatestamp></Identify></OAI-PMH>`)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		page, _ := strconv.Atoi(q.Get("resumptionToken"))
+		if page == 0 {
+			starts++
+		}
+		if page == 3 && fail {
+			fail = false
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		token := ""
+		if page < 4 {
+			token = strconv.Itoa(page + 1)
+		}
+		fmt.Fprintf(w, `<OAI-PMH><ListRecords><record><header><identifier>%d</identifier><datestamp>2016-01-01</datestamp></header></record><resumptionToken>%s</resumptionToken></ListRecords></OAI-PMH>`, page, token)
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:                    srv.URL,
+		Format:                     "oai_dc",
+		MaxRequests:                100,
+		MaxEmptyResponses:          10,
+		DisableSelectiveHarvesting: true,
+		RetryPolicy:                RetryPolicy{MaxAttempts: 1},
+		ResumeWindow:               time.Hour,
+	}
+	if err := h.RunContext(context.Background()); err == nil {
+		t.Fatalf("expected error")
+	}
+	p, err := h.loadPartial()
+	if err != nil || p == nil || p.Requests != 3 || p.Token != "3" {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	if err := h.RunContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if starts != 1 {
+		t.Errorf("got %d starts, want 1", starts)
+	}
+	if got := len(h.Files()); got != 5 {
+		t.Errorf("got %d files, want 5", got)
+	}
+	if _, err := os.Stat(h.partialPath()); !os.IsNotExist(err) {
+		t.Errorf("state not removed: %v", err)
+	}
+
+	// too old state is discarded
+	fail = true
+	if err := h.RunContext(context.Background()); err == nil {
+		t.Fatalf("expected error")
+	}
+	h.ResumeWindow = time.Nanosecond
+	if err := h.RunContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if starts != 3 {
+		t.Errorf("got %d starts, want 3", starts)
+	}
+}
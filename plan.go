@@ -0,0 +1,112 @@
+package metha
+
+import (
+	"context"
+	"time"
+)
+
+// Plan describes what a run would do. Estimates are based on a single probe
+// request over the whole range and are -1, if the endpoint does not report a
+// complete list size.
+type Plan struct {
+	Dir       string        `json:"dir"`
+	Intervals []Interval    `json:"intervals,omitempty"`
+	Records   int           `json:"records"`
+	PageSize  int           `json:"pageSize"`
+	Requests  int           `json:"requests"`
+	Latency   time.Duration `json:"latency"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Plan computes the intervals, that would be harvested and estimates the
+// number of requests, without writing anything.
+func (h *Harvest) Plan() (*Plan, error) {
+	return h.PlanContext(context.Background())
+}
+
+// PlanContext is like Plan, but the probe request can be cancelled.
+func (h *Harvest) PlanContext(ctx context.Context) (*Plan, error) {
+	if err := h.ValidateDates(); err != nil {
+		return nil, err
+	}
+	if h.Identify == nil {
+		if err := h.IdentifyContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(h.FormatFallbacks) > 0 {
+		if err := h.negotiateFormat(ctx); err != nil {
+			return nil, err
+		}
+	}
+	h.Started = time.Now()
+	plan := &Plan{Dir: h.Dir(), Records: -1, Requests: -1, Duration: -1}
+
+	req := Request{
+		BaseURL:                 h.BaseURL,
+		MetadataPrefix:          h.Format,
+		Verb:                    "ListRecords",
+		Set:                     h.Set,
+		SuppressFormatParameter: h.SuppressFormatParameter,
+		CleanBeforeDecode:       h.CleanBeforeDecode,
+		UsePost:                 h.UsePost,
+	}
+	if !h.DisableSelectiveHarvesting {
+		interval, err := h.defaultInterval()
+		if err == ErrAlreadySynced {
+			plan.Records, plan.Requests, plan.Duration = 0, 0, 0
+			return plan, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.DailyInterval {
+			plan.Intervals = interval.DailyIntervals()
+		} else {
+			plan.Intervals = interval.MonthlyIntervals()
+		}
+		req.From = h.formatDate(interval.Begin.Add(-h.overlap(0)))
+		req.Until = h.formatDate(interval.End)
+	}
+
+	client := h.client(DefaultTimeout, h.retryPolicy())
+	started := time.Now()
+	resp, err := client.DoContext(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	plan.Latency = time.Since(started)
+
+	switch resp.Error.Code {
+	case "":
+	case ErrNoRecordsMatch.Code:
+		plan.Records, plan.PageSize = 0, 0
+	default:
+		return nil, resp.Error
+	}
+	if resp.Error.Code == "" {
+		plan.PageSize = len(resp.ListRecords.Records)
+		if resp.GetResumptionToken() == "" {
+			plan.Records = plan.PageSize
+		} else {
+			plan.Records = resp.ListRecords.TokenInfo.Size()
+		}
+	}
+
+	if plan.Records >= 0 {
+		// at least one request per interval, plus pages
+		plan.Requests = len(plan.Intervals)
+		if plan.Requests == 0 {
+			plan.Requests = 1
+		}
+		if plan.PageSize > 0 && plan.Records/plan.PageSize > plan.Requests {
+			plan.Requests = (plan.Records + plan.PageSize - 1) / plan.PageSize
+		}
+		perRequest := plan.Latency
+		if h.Delay > perRequest {
+			perRequest = h.Delay
+		}
+		plan.Duration = time.Duration(plan.Requests) * perRequest
+	}
+	return plan, nil
+}
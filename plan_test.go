@@ -0,0 +1,49 @@
+package metha
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-plan-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<OAI-PMH><ListRecords>%s
+			<resumptionToken completeListSize="250">t1</resumptionToken>
+		</ListRecords></OAI-PMH>`, strings.Repeat("<record><header><identifier>x</identifier></header></record>", 100))
+	}))
+	defer srv.Close()
+
+	h := Harvest{
+		BaseURL:  srv.URL,
+		Format:   "oai_dc",
+		From:     time.Now().AddDate(0, 0, -3).Format("2006-01-02"),
+		Identify: &Identify{Granularity: "YYYY-MM-DD"},
+	}
+	plan, err := h.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Records != 250 || plan.PageSize != 100 || plan.Requests != 3 {
+		t.Errorf("got %d records, page size %d, %d requests, want 250, 100, 3",
+			plan.Records, plan.PageSize, plan.Requests)
+	}
+	if len(plan.Intervals) == 0 {
+		t.Errorf("got no intervals")
+	}
+	if _, err := os.Stat(h.Dir()); !os.IsNotExist(err) {
+		t.Errorf("plan must not create %s", h.Dir())
+	}
+}
@@ -0,0 +1,272 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeReport describes an endpoint and how to harvest it. Problems lists
+// anything, that did not work as expected, Flags suggests metha-sync flags.
+type ProbeReport struct {
+	BaseURL           string        `json:"baseURL"`
+	RepositoryName    string        `json:"repositoryName,omitempty"`
+	ProtocolVersion   string        `json:"protocolVersion,omitempty"`
+	Granularity       string        `json:"granularity,omitempty"`
+	EarliestDatestamp string        `json:"earliestDatestamp,omitempty"`
+	DeletedRecord     string        `json:"deletedRecord,omitempty"`
+	Formats           []string      `json:"formats,omitempty"`
+	Format            string        `json:"format,omitempty"`
+	Sets              int           `json:"sets"`
+	Compression       string        `json:"compression,omitempty"`
+	PageSize          int           `json:"pageSize"`
+	CompleteListSize  int           `json:"completeListSize"`
+	RecordSize        int           `json:"recordSize"`
+	ResumptionToken   bool          `json:"resumptionToken"`
+	TokenLength       int           `json:"tokenLength,omitempty"`
+	TokenExpiration   string        `json:"tokenExpiration,omitempty"`
+	TokenWorks        bool          `json:"tokenWorks"`
+	Selective         bool          `json:"selective"`
+	Latency           time.Duration `json:"latency"`
+	Problems          []string      `json:"problems,omitempty"`
+	Flags             []string      `json:"flags,omitempty"`
+}
+
+// String formats a report for humans.
+func (r *ProbeReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "endpoint\t%s\n", r.BaseURL)
+	fmt.Fprintf(&sb, "name\t%s\n", r.RepositoryName)
+	fmt.Fprintf(&sb, "granularity\t%s\n", r.Granularity)
+	fmt.Fprintf(&sb, "earliest\t%s\n", r.EarliestDatestamp)
+	fmt.Fprintf(&sb, "deleted\t%s\n", r.DeletedRecord)
+	fmt.Fprintf(&sb, "formats\t%s\n", strings.Join(r.Formats, ", "))
+	fmt.Fprintf(&sb, "sets\t%d\n", r.Sets)
+	fmt.Fprintf(&sb, "compression\t%s\n", r.Compression)
+	fmt.Fprintf(&sb, "page size\t%d\n", r.PageSize)
+	fmt.Fprintf(&sb, "list size\t%d\n", r.CompleteListSize)
+	fmt.Fprintf(&sb, "record size\t%d\n", r.RecordSize)
+	fmt.Fprintf(&sb, "token\t%v (length %d, expires %q, works %v)\n",
+		r.ResumptionToken, r.TokenLength, r.TokenExpiration, r.TokenWorks)
+	fmt.Fprintf(&sb, "selective\t%v\n", r.Selective)
+	fmt.Fprintf(&sb, "latency\t%s\n", r.Latency)
+	for _, p := range r.Problems {
+		fmt.Fprintf(&sb, "problem\t%s\n", p)
+	}
+	if len(r.Flags) > 0 {
+		fmt.Fprintf(&sb, "flags\t%s\n", strings.Join(r.Flags, " "))
+	}
+	return sb.String()
+}
+
+// Thresholds for flag suggestions.
+const (
+	probeLongToken   = 1024
+	probeLargeRecord = 1 << 20
+)
+
+// encodingTransport records the content encoding of responses.
+type encodingTransport struct {
+	Transport http.RoundTripper
+	mu        sync.Mutex
+	encoding  string
+}
+
+func (t *encodingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case resp.Header.Get("Content-Encoding") != "":
+		t.encoding = resp.Header.Get("Content-Encoding")
+	case resp.Uncompressed:
+		// the transport asked for gzip and already decompressed it
+		t.encoding = "gzip"
+	}
+	return resp, nil
+}
+
+// Probe runs Identify, ListMetadataFormats, ListSets and a one page
+// ListRecords against an endpoint and reports its behaviour, e.g. before
+// harvesting a new endpoint.
+func Probe(baseURL string) (*ProbeReport, error) {
+	return ProbeContext(context.Background(), baseURL)
+}
+
+// ProbeContext is like Probe, but requests can be cancelled.
+func ProbeContext(ctx context.Context, baseURL string) (*ProbeReport, error) {
+	transport := &encodingTransport{}
+	h := &Harvest{BaseURL: baseURL, Format: "oai_dc", CleanBeforeDecode: true, Transport: transport}
+	report := &ProbeReport{BaseURL: baseURL, CompleteListSize: -1}
+	problem := func(format string, args ...interface{}) {
+		report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+	}
+	suggest := func(flag string) {
+		report.Flags = append(report.Flags, flag)
+	}
+	defer func() {
+		transport.mu.Lock()
+		report.Compression = transport.encoding
+		transport.mu.Unlock()
+	}()
+
+	// Identify, an endpoint without it is not usable.
+	started := time.Now()
+	if err := h.IdentifyContext(ctx); err != nil {
+		return nil, err
+	}
+	report.Latency = time.Since(started)
+	report.RepositoryName = h.Identify.RepositoryName
+	report.ProtocolVersion = h.Identify.ProtocolVersion
+	report.Granularity = h.Identify.Granularity
+	report.EarliestDatestamp = h.Identify.EarliestDatestamp
+	report.DeletedRecord = h.Identify.DeletedRecord
+	if _, err := h.earliestDate(); err != nil {
+		problem("earliest datestamp %q: %s", h.Identify.EarliestDatestamp, err)
+		suggest("-from YYYY-MM-DD")
+	}
+
+	// ListMetadataFormats, prefer oai_dc.
+	formats, err := h.MetadataFormats(ctx)
+	if err != nil {
+		problem("ListMetadataFormats: %s", err)
+	}
+	report.Formats = formats
+	if len(formats) > 0 {
+		var found bool
+		for _, f := range formats {
+			found = found || f == h.Format
+		}
+		if !found {
+			h.Format = formats[0]
+			suggest("-format " + h.Format)
+		}
+	}
+	report.Format = h.Format
+
+	client := h.client(30*time.Second, DefaultRetryPolicy)
+
+	// ListSets, first page only.
+	resp, err := client.DoContext(ctx, &Request{BaseURL: baseURL, Verb: "ListSets"})
+	switch {
+	case err != nil:
+		problem("ListSets: %s", err)
+	case resp.Error.Is(ErrNoSetHierarchy):
+	case resp.Error.Code != "":
+		problem("ListSets: %s", resp.Error)
+	default:
+		report.Sets = len(resp.ListSets.Set)
+	}
+
+	// ListRecords, first page without dates.
+	req := Request{BaseURL: baseURL, Verb: "ListRecords", MetadataPrefix: h.Format, CleanBeforeDecode: true}
+	resp, err = client.DoContext(ctx, &req)
+	if err != nil {
+		problem("ListRecords: %s", err)
+		return report, nil
+	}
+	switch {
+	case resp.Error.Is(ErrNoRecordsMatch):
+		report.CompleteListSize = 0
+		return report, nil
+	case resp.Error.Code != "":
+		problem("ListRecords: %s", resp.Error)
+		return report, nil
+	}
+	records := resp.ListRecords.Records
+	report.PageSize = len(records)
+	if report.PageSize > 0 {
+		var size int
+		for _, r := range records {
+			size += len(r.Metadata.Body)
+		}
+		report.RecordSize = size / report.PageSize
+		if report.RecordSize > probeLargeRecord {
+			suggest("-stream")
+		}
+	}
+	if token := resp.GetResumptionToken(); token != "" {
+		report.ResumptionToken = true
+		report.TokenLength = len(token)
+		report.TokenExpiration = resp.ListRecords.TokenInfo.ExpirationDate
+		report.CompleteListSize = resp.ListRecords.TokenInfo.Size()
+		if report.TokenLength > probeLongToken {
+			suggest("-post")
+		}
+		next, err := client.DoContext(ctx, &Request{BaseURL: baseURL, Verb: "ListRecords", ResumptionToken: token, CleanBeforeDecode: true})
+		switch {
+		case err != nil:
+			problem("resumption token: %s", err)
+		case next.Error.Code != "":
+			problem("resumption token: %s", next.Error)
+		default:
+			report.TokenWorks = true
+		}
+	} else {
+		report.CompleteListSize = report.PageSize
+	}
+	report.Selective = probeSelective(ctx, client, h, records, problem)
+	if report.PageSize > 0 && !report.Selective {
+		suggest("-no-intervals")
+	}
+	return report, nil
+}
+
+// probeSelective requests the day of the first record and checks, whether
+// the endpoint restricts the response to that day.
+func probeSelective(ctx context.Context, client Client, h *Harvest, records []Record, problem func(string, ...interface{})) bool {
+	if len(records) == 0 || len(records[0].Header.DateStamp) < 10 {
+		return false
+	}
+	if h.DateLayout() == "" {
+		problem("selective harvesting: unknown granularity %q", h.Identify.Granularity)
+		return false
+	}
+	day, err := time.Parse("2006-01-02", records[0].Header.DateStamp[:10])
+	if err != nil {
+		problem("datestamp %q: %s", records[0].Header.DateStamp, err)
+		return false
+	}
+	iv := Interval{Begin: day, End: day.Add(24*time.Hour - time.Second)}
+	req := Request{
+		BaseURL:           h.BaseURL,
+		Verb:              "ListRecords",
+		MetadataPrefix:    h.Format,
+		From:              h.formatDate(iv.Begin),
+		Until:             h.formatDate(iv.End),
+		CleanBeforeDecode: true,
+	}
+	resp, err := client.DoContext(ctx, &req)
+	switch {
+	case err != nil:
+		problem("selective harvesting: %s", err)
+		return false
+	case resp.Error.Code != "":
+		problem("selective harvesting: %s", resp.Error)
+		return false
+	case len(resp.ListRecords.Records) == 0:
+		problem("selective harvesting: no records for %s", iv)
+		return false
+	}
+	var outside int
+	for _, r := range resp.ListRecords.Records {
+		if !strings.HasPrefix(r.Header.DateStamp, day.Format("2006-01-02")) {
+			outside++
+		}
+	}
+	if outside > 0 {
+		problem("selective harvesting: %d of %d records outside %s", outside, len(resp.ListRecords.Records), iv)
+		return false
+	}
+	return true
+}
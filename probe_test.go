@@ -0,0 +1,62 @@
+package metha
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	record := `<record><header><identifier>%s</identifier><datestamp>%s</datestamp></header><metadata>x</metadata></record>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		// the path selects the behaviour: selective or not
+		selective := r.URL.Path == "/selective"
+		switch q.Get("verb") {
+		case "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><repositoryName>test</repositoryName><earliestDatestamp>2016-01-01</earliestDatestamp><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case "ListMetadataFormats":
+			fmt.Fprint(w, `<OAI-PMH><ListMetadataFormats><metadataFormat><metadataPrefix>marcxml</metadataPrefix></metadataFormat></ListMetadataFormats></OAI-PMH>`)
+		case "ListSets":
+			fmt.Fprint(w, `<OAI-PMH><error code="noSetHierarchy"/></OAI-PMH>`)
+		case "ListRecords":
+			switch {
+			case q.Get("resumptionToken") != "":
+				fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`</ListRecords></OAI-PMH>`, "2", "2016-02-01")
+			case q.Get("from") != "" && selective:
+				fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`</ListRecords></OAI-PMH>`, "1", "2016-01-10")
+			default:
+				fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+record+`<resumptionToken completeListSize="3">t</resumptionToken></ListRecords></OAI-PMH>`,
+					"1", "2016-01-10", "3", "2016-03-01")
+			}
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		path      string
+		selective bool
+		flags     string
+	}{
+		{"/selective", true, "-format marcxml"},
+		{"/ignores-dates", false, "-format marcxml -no-intervals"},
+	}
+	for _, c := range cases {
+		report, err := Probe(srv.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Selective != c.selective {
+			t.Errorf("%s: got selective %v, want %v", c.path, report.Selective, c.selective)
+		}
+		if got := strings.Join(report.Flags, " "); got != c.flags {
+			t.Errorf("%s: got flags %q, want %q", c.path, got, c.flags)
+		}
+		if report.PageSize != 2 || report.CompleteListSize != 3 || !report.TokenWorks {
+			t.Errorf("%s: got page size %d, list size %d, token works %v", c.path,
+				report.PageSize, report.CompleteListSize, report.TokenWorks)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package metha
+
+import "time"
+
+// ProgressInfo describes the state of a harvest after a request.
+// CompleteListSize is -1, if the endpoint does not report it. Fraction and
+// Remaining are estimates based on the number of intervals done and the
+// complete list size of the current interval, if available.
+type ProgressInfo struct {
+	Interval         Interval
+	IntervalIndex    int
+	IntervalCount    int
+	Request          int
+	Records          int
+	IntervalRecords  int
+	CompleteListSize int
+	TotalRecords     int
+	TotalRequests    int
+	Elapsed          time.Duration
+	Fraction         float64
+	Remaining        time.Duration
+}
+
+// ProgressFunc is called after each successful request.
+type ProgressFunc func(ProgressInfo)
+
+// progressState keeps counts across requests of a run.
+type progressState struct {
+	interval      int
+	intervals     int
+	totalRecords  int
+	totalRequests int
+	// requests sent and intervals completed, for the run manifest
+	requests int
+	covered  []Interval
+	// bytes downloaded and whether a limit stopped the run
+	bytes   int64
+	stopped bool
+	// where the next run starts, if a limit stopped an interval
+	resume *ResumePoint
+	// repairs of broken responses
+	repairs RepairCounts
+	// OAI error codes received
+	oaiErrors map[string]int
+	// request in progress, when an interval failed
+	failure *failedRequest
+}
+
+// countOAIError counts an OAI error code received during the run.
+func (h *Harvest) countOAIError(code string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.progress.oaiErrors == nil {
+		h.progress.oaiErrors = make(map[string]int)
+	}
+	h.progress.oaiErrors[code]++
+}
+
+// reportProgress updates counters and calls the progress function. Index is
+// the position of the interval in the run, i the request index within the
+// interval and records the number of records of the interval so far.
+func (h *Harvest) reportProgress(iv Interval, index, i, records int, resp *Response) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := len(resp.ListRecords.Records)
+	h.progress.totalRecords += n
+	h.progress.totalRequests++
+
+	if h.Progress == nil {
+		return
+	}
+	info := ProgressInfo{
+		Interval:         iv,
+		IntervalIndex:    index,
+		IntervalCount:    h.progress.intervals,
+		Request:          i,
+		Records:          n,
+		IntervalRecords:  records,
+		CompleteListSize: resp.ListRecords.TokenInfo.Size(),
+		TotalRecords:     h.progress.totalRecords,
+		TotalRequests:    h.progress.totalRequests,
+		Elapsed:          time.Since(h.Started),
+	}
+	// fraction of the current interval, zero if unknown
+	var current float64
+	if info.CompleteListSize > 0 {
+		current = float64(info.IntervalRecords) / float64(info.CompleteListSize)
+		if current > 1 {
+			current = 1
+		}
+	}
+	if resp.GetResumptionToken() == "" {
+		current = 1
+	}
+	if info.IntervalCount > 0 {
+		info.Fraction = (float64(info.IntervalIndex) + current) / float64(info.IntervalCount)
+	}
+	if info.Fraction > 0 {
+		info.Remaining = time.Duration(float64(info.Elapsed) * (1 - info.Fraction) / info.Fraction)
+	}
+	h.Progress(info)
+}
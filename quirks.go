@@ -0,0 +1,96 @@
+package metha
+
+import (
+	"log"
+	"strings"
+)
+
+// Quirk records settings, that are known to work for a problematic
+// endpoint. Quirks only switch on workarounds, they never switch off
+// settings made by the user.
+type Quirk struct {
+	// Pattern matches the base URL without scheme, * is a wildcard, e.g.
+	// "*.example.org/oai*".
+	Pattern string
+	// Note describes the problem.
+	Note string
+
+	DisableSelectiveHarvesting bool
+	SuppressFormatParameter    bool
+	CleanBeforeDecode          bool
+	UsePost                    bool
+	// DailyInterval is the finest interval available, for endpoints that
+	// cannot handle large result sets.
+	DailyInterval bool
+	// MaxEmptyResponses is raised to this value, if larger.
+	MaxEmptyResponses int
+}
+
+// Quirks is the registry of known problem endpoints. Entries are matched in
+// order, the first match wins. Programs may add their own entries.
+var Quirks = []Quirk{
+	{
+		Pattern:                    "zvdd.org/oai2*",
+		Note:                       "does not support selective harvesting",
+		DisableSelectiveHarvesting: true,
+	},
+	{
+		Pattern:           "eprints.vu.edu.au/perl/oai2*",
+		Note:              "control characters in responses",
+		CleanBeforeDecode: true,
+	},
+	{
+		Pattern:           "digitalcommons.gardner-webb.edu/do/oai*",
+		Note:              "control characters in responses",
+		CleanBeforeDecode: true,
+	},
+	{
+		Pattern:       "export.arxiv.org/oai2*",
+		Note:          "large monthly result sets",
+		DailyInterval: true,
+	},
+	{
+		Pattern:                 "copac.jisc.ac.uk/oai-pmh*",
+		Note:                    "does not accept the metadataPrefix argument",
+		SuppressFormatParameter: true,
+	},
+}
+
+// normalizeBaseURL removes scheme and trailing slashes, so http and https
+// endpoints match the same quirk.
+func normalizeBaseURL(baseURL string) string {
+	if i := strings.Index(baseURL, "://"); i >= 0 {
+		baseURL = baseURL[i+3:]
+	}
+	return strings.TrimRight(baseURL, "/")
+}
+
+// LookupQuirk returns the first quirk matching a base URL.
+func LookupQuirk(baseURL string) (Quirk, bool) {
+	normalized := normalizeBaseURL(baseURL)
+	for _, q := range Quirks {
+		if wildcard(normalizeBaseURL(q.Pattern)).MatchString(normalized) {
+			return q, true
+		}
+	}
+	return Quirk{}, false
+}
+
+// ApplyQuirks switches on the workarounds known for the endpoint, if any,
+// and reports whether a quirk was found.
+func (h *Harvest) ApplyQuirks() bool {
+	q, ok := LookupQuirk(h.BaseURL)
+	if !ok {
+		return false
+	}
+	log.Printf("applying quirks for %s: %s", q.Pattern, q.Note)
+	h.DisableSelectiveHarvesting = h.DisableSelectiveHarvesting || q.DisableSelectiveHarvesting
+	h.SuppressFormatParameter = h.SuppressFormatParameter || q.SuppressFormatParameter
+	h.CleanBeforeDecode = h.CleanBeforeDecode || q.CleanBeforeDecode
+	h.UsePost = h.UsePost || q.UsePost
+	h.DailyInterval = h.DailyInterval || q.DailyInterval
+	if q.MaxEmptyResponses > h.MaxEmptyResponses {
+		h.MaxEmptyResponses = q.MaxEmptyResponses
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package metha
+
+import "testing"
+
+func TestQuirks(t *testing.T) {
+	saved := Quirks
+	defer func() { Quirks = saved }()
+	Quirks = append(Quirks, Quirk{
+		Pattern:                 "*.example.org/oai",
+		SuppressFormatParameter: true,
+		MaxEmptyResponses:       50,
+	})
+
+	var cases = []struct {
+		baseURL  string
+		found    bool
+		noDates  bool
+		noFormat bool
+		clean    bool
+		daily    bool
+		empty    int
+	}{
+		{"http://zvdd.org/oai2", true, true, false, false, false, 10},
+		{"https://zvdd.org/oai2/", true, true, false, false, false, 10},
+		{"http://eprints.vu.edu.au/perl/oai2", true, false, false, true, false, 10},
+		{"http://digitalcommons.gardner-webb.edu/do/oai/", true, false, false, true, false, 10},
+		{"http://export.arxiv.org/oai2", true, false, false, false, true, 10},
+		{"http://copac.jisc.ac.uk/oai-pmh", true, false, true, false, false, 10},
+		{"https://repo.example.org/oai", true, false, true, false, false, 50},
+		{"https://example.org/oai", false, false, false, false, false, 10},
+	}
+	for _, c := range cases {
+		h := &Harvest{BaseURL: c.baseURL, MaxEmptyResponses: 10}
+		if found := h.ApplyQuirks(); found != c.found {
+			t.Errorf("%s: got %v, want %v", c.baseURL, found, c.found)
+		}
+		if h.DisableSelectiveHarvesting != c.noDates || h.SuppressFormatParameter != c.noFormat ||
+			h.CleanBeforeDecode != c.clean || h.DailyInterval != c.daily || h.MaxEmptyResponses != c.empty {
+			t.Errorf("%s: got %v %v %v %v %d", c.baseURL, h.DisableSelectiveHarvesting, h.SuppressFormatParameter,
+				h.CleanBeforeDecode, h.DailyInterval, h.MaxEmptyResponses)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package metha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuotaFilename is the name of the state of a paused harvest. It is written,
+// when an endpoint reports an exhausted request quota, and removed, when the
+// harvest resumes.
+const QuotaFilename = "quota.json"
+
+// DefaultQuotaStatusCodes mark quota errors, if a policy lists neither status
+// codes nor OAI errors: 429 Too Many Requests and 509 Bandwidth Limit Exceeded.
+var DefaultQuotaStatusCodes = []int{http.StatusTooManyRequests, 509}
+
+// QuotaPolicy describes, how an endpoint signals an exhausted daily request
+// quota and when to continue. Instead of failing, the harvest pauses for
+// Pause or until the next ResumeAt, then repeats the request. The policy is
+// only used, if Pause or ResumeAt is set.
+type QuotaPolicy struct {
+	// StatusCodes are HTTP status codes of quota errors. They are not retried.
+	StatusCodes []int
+	// Errors are OAI error codes or parts of OAI error messages, e.g. "quota".
+	Errors []string
+	// Pause is how long to wait after a quota error.
+	Pause time.Duration
+	// ResumeAt is a time of day, e.g. 00:05, in the time zone of the harvest,
+	// it takes precedence over Pause.
+	ResumeAt string
+}
+
+// QuotaState is the persisted pause of a harvest. A run started before
+// Until waits as well.
+type QuotaState struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// ParseQuotaErrors parses a comma separated list of status codes and OAI
+// errors, e.g. "429,badArgument,quota exceeded".
+func ParseQuotaErrors(s string) (codes []int, patterns []string) {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if code, err := strconv.Atoi(v); err == nil {
+			codes = append(codes, code)
+		} else {
+			patterns = append(patterns, v)
+		}
+	}
+	return codes, patterns
+}
+
+// parseTimeOfDay parses a time of day like 00:05.
+func parseTimeOfDay(s string) (hour, min int, err error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time of day %q, use HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// Enabled returns true, if quota errors pause the harvest.
+func (q QuotaPolicy) Enabled() bool {
+	return q.Pause > 0 || q.ResumeAt != ""
+}
+
+// Validate checks ResumeAt.
+func (q QuotaPolicy) Validate() error {
+	if q.ResumeAt == "" {
+		return nil
+	}
+	_, _, err := parseTimeOfDay(q.ResumeAt)
+	return err
+}
+
+// statusCodes returns the status codes of quota errors.
+func (q QuotaPolicy) statusCodes() []int {
+	if len(q.StatusCodes) == 0 && len(q.Errors) == 0 {
+		return DefaultQuotaStatusCodes
+	}
+	return q.StatusCodes
+}
+
+// Matches reports, whether an error of a request is a quota error.
+func (q QuotaPolicy) Matches(err error) bool {
+	var he HTTPError
+	if errors.As(err, &he) {
+		for _, code := range q.statusCodes() {
+			if he.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+	var oe OAIError
+	if !errors.As(err, &oe) {
+		return false
+	}
+	for _, v := range q.Errors {
+		if oe.Code == v || strings.Contains(strings.ToLower(oe.Message), strings.ToLower(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ResumeTime returns the time to continue after a quota error at t.
+func (q QuotaPolicy) ResumeTime(t time.Time, loc *time.Location) (time.Time, error) {
+	if q.ResumeAt == "" {
+		return t.Add(q.Pause), nil
+	}
+	hour, min, err := parseTimeOfDay(q.ResumeAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t = t.In(loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), hour, min, 0, 0, loc)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+// retryOn removes quota status codes from the codes to retry.
+func (q QuotaPolicy) retryOn(codes []int) []int {
+	if !q.Enabled() {
+		return codes
+	}
+	var result []int
+outer:
+	for _, c := range codes {
+		for _, qc := range q.statusCodes() {
+			if c == qc {
+				continue outer
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// quotaPath returns the path to the persisted pause.
+func (h *Harvest) quotaPath() string {
+	return filepath.Join(h.Dir(), QuotaFilename)
+}
+
+// QuotaState returns the persisted pause of the harvest, or nil.
+func (h *Harvest) QuotaState() (*QuotaState, error) {
+	b, err := ioutil.ReadFile(h.quotaPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s QuotaState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// saveQuotaState persists a pause, a later pause wins.
+func (h *Harvest) saveQuotaState(s QuotaState) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old, err := h.QuotaState(); err == nil && old != nil && old.Until.After(s.Until) {
+		return nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := h.quotaPath() + "-tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.quotaPath())
+}
+
+// removeQuotaState removes a persisted pause.
+func (h *Harvest) removeQuotaState() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := os.Remove(h.quotaPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sleepUntil waits until t or the context is done.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	select {
+	case <-time.After(time.Until(t)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pauseForQuota persists a pause after a quota error and waits, until the
+// quota is reset. An interrupted pause is continued by the next run.
+func (h *Harvest) pauseForQuota(ctx context.Context, reason error) error {
+	until, err := h.Quota.ResumeTime(time.Now(), h.location())
+	if err != nil {
+		return err
+	}
+	if err := h.saveQuotaState(QuotaState{Until: until, Reason: reason.Error()}); err != nil {
+		return err
+	}
+	log.Printf("quota exceeded (%s), pausing until %s", reason, until.Format(time.RFC3339))
+	h.emit(Event{Kind: EventPause, ResumeAt: &until, Err: reason})
+	if err := sleepUntil(ctx, until); err != nil {
+		return err
+	}
+	log.Printf("resuming after quota pause")
+	return h.removeQuotaState()
+}
+
+// waitQuota waits for a pause persisted by an earlier run. Without a quota
+// policy, a persisted pause is dropped.
+func (h *Harvest) waitQuota(ctx context.Context) error {
+	s, err := h.QuotaState()
+	if err != nil || s == nil {
+		return err
+	}
+	if h.Quota.Enabled() && time.Now().Before(s.Until) {
+		log.Printf("harvest paused after quota error (%s), waiting until %s", s.Reason, s.Until.Format(time.RFC3339))
+		if err := sleepUntil(ctx, s.Until); err != nil {
+			return err
+		}
+	}
+	return h.removeQuotaState()
+}
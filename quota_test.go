@@ -0,0 +1,112 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQuotaResumeTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := time.Date(2020, 3, 1, 22, 30, 0, 0, time.UTC)
+	var tests = []struct {
+		q    QuotaPolicy
+		loc  *time.Location
+		want time.Time
+	}{
+		{QuotaPolicy{Pause: time.Hour}, time.UTC, at.Add(time.Hour)},
+		{QuotaPolicy{ResumeAt: "23:00"}, time.UTC, time.Date(2020, 3, 1, 23, 0, 0, 0, time.UTC)},
+		{QuotaPolicy{ResumeAt: "22:30"}, time.UTC, time.Date(2020, 3, 2, 22, 30, 0, 0, time.UTC)},
+		// 23:30 in Berlin, so the next day
+		{QuotaPolicy{ResumeAt: "00:05", Pause: time.Hour}, berlin, time.Date(2020, 3, 2, 0, 5, 0, 0, berlin)},
+	}
+	for _, test := range tests {
+		got, err := test.q.ResumeTime(at, test.loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%+v: got %v, want %v", test.q, got, test.want)
+		}
+	}
+	if err := (QuotaPolicy{ResumeAt: "25:00"}).Validate(); err == nil {
+		t.Errorf("want error for invalid time of day")
+	}
+}
+
+func TestQuotaPause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-quota-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	record := `<record><header><identifier>%s</identifier><datestamp>2016-01-10</datestamp></header></record>`
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		token := r.URL.Query().Get("resumptionToken")
+		switch {
+		case r.URL.Path == "/http" && requests == 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/oai" && token == "":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`<resumptionToken>t1</resumptionToken></ListRecords></OAI-PMH>`, "1")
+		case r.URL.Path == "/oai" && requests == 2:
+			fmt.Fprint(w, `<OAI-PMH><error code="badArgument">Daily quota exceeded</error></OAI-PMH>`)
+		default:
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`</ListRecords></OAI-PMH>`, "2")
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		path  string
+		quota QuotaPolicy
+	}{
+		{"/http", QuotaPolicy{Pause: 50 * time.Millisecond}},
+		{"/oai", QuotaPolicy{Pause: 50 * time.Millisecond, Errors: []string{"quota"}}},
+	}
+	for _, c := range cases {
+		requests = 0
+		var paused int
+		h := Harvest{
+			BaseURL:           srv.URL + c.path,
+			Format:            "oai_dc",
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+			Started:           time.Now(),
+			Quota:             c.quota,
+			OnEvent: func(e Event) {
+				if e.Kind == EventPause {
+					paused++
+				}
+			},
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		iv := Interval{
+			Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2016, 1, 31, 23, 59, 59, 0, time.UTC),
+		}
+		if err := h.runInterval(context.Background(), iv); err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		if paused != 1 || len(h.Files()) == 0 {
+			t.Errorf("%s: got %d pauses and %d files, want 1 and some", c.path, paused, len(h.Files()))
+		}
+		if s, err := h.QuotaState(); err != nil || s != nil {
+			t.Errorf("%s: got quota state %v, %v, want none", c.path, s, err)
+		}
+	}
+}
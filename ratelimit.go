@@ -0,0 +1,186 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitDir is the directory below BaseDir, where shared host limiters
+// keep their state.
+const RateLimitDir = ".ratelimit"
+
+// HostLimiter limits the request rate per host, with a token bucket: Rate
+// requests per second on average and bursts of up to Burst requests. A
+// limiter can be shared by many harvests. With a directory, the buckets are
+// kept in files, so harvests in different processes share the budget, too.
+type HostLimiter struct {
+	Rate  float64
+	Burst int
+	Dir   string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewHostLimiter returns a limiter for harvests within a process.
+func NewHostLimiter(rate float64, burst int) *HostLimiter {
+	return &HostLimiter{Rate: rate, Burst: burst}
+}
+
+// NewSharedHostLimiter returns a limiter, that keeps its state in dir, e.g.
+// filepath.Join(BaseDir, RateLimitDir), to be shared across processes.
+func NewSharedHostLimiter(dir string, rate float64, burst int) *HostLimiter {
+	return &HostLimiter{Rate: rate, Burst: burst, Dir: dir}
+}
+
+// bucket holds the tokens available at a point in time. Tokens may be
+// negative, for requests, that have been granted a slot in the future.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long to wait for it.
+func (b *bucket) reserve(now time.Time, rate float64, burst int) time.Duration {
+	if b.last.IsZero() {
+		b.tokens, b.last = float64(burst), now
+	}
+	if now.After(b.last) {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// Wait blocks until a request to host is allowed.
+func (l *HostLimiter) Wait(ctx context.Context, host string) error {
+	if l.Rate <= 0 {
+		return nil
+	}
+	burst := l.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	var (
+		d   time.Duration
+		err error
+	)
+	if l.Dir == "" {
+		l.mu.Lock()
+		if l.buckets == nil {
+			l.buckets = make(map[string]*bucket)
+		}
+		b, ok := l.buckets[host]
+		if !ok {
+			b = &bucket{}
+			l.buckets[host] = b
+		}
+		d = b.reserve(time.Now(), l.Rate, burst)
+		l.mu.Unlock()
+	} else {
+		l.mu.Lock()
+		d, err = l.reserveFile(ctx, host, burst)
+		l.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if d == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// staleLock is the age, after which a lock file is considered left over
+// from a crashed process.
+const staleLock = 10 * time.Second
+
+// reserveFile takes a token from the bucket stored in a file. The file is
+// guarded by a lock file, so it works on all platforms.
+func (l *HostLimiter) reserveFile(ctx context.Context, host string, burst int) (time.Duration, error) {
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return 0, err
+	}
+	name := filepath.Join(l.Dir, strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(host))
+	lock := name + ".lock"
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return 0, err
+		}
+		if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) > staleLock {
+			retry, err := removeStaleLockFile(lock, staleLock)
+			if err != nil {
+				return 0, err
+			}
+			if retry {
+				continue
+			}
+		}
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	defer os.Remove(lock)
+
+	var b bucket
+	if data, err := ioutil.ReadFile(name); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 2 {
+			tokens, err1 := strconv.ParseFloat(fields[0], 64)
+			nanos, err2 := strconv.ParseInt(fields[1], 10, 64)
+			if err1 == nil && err2 == nil {
+				b = bucket{tokens: tokens, last: time.Unix(0, nanos)}
+			}
+		}
+	}
+	d := b.reserve(time.Now(), l.Rate, burst)
+	data := fmt.Sprintf("%f %d\n", b.tokens, b.last.UnixNano())
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		return 0, err
+	}
+	return d, nil
+}
+
+// rateLimitTransport waits for the host limiter before each request,
+// including retries.
+type rateLimitTransport struct {
+	Transport http.RoundTripper
+	Limiter   *HostLimiter
+}
+
+// RoundTrip waits and executes the request.
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context(), req.URL.Host); err != nil {
+		return nil, err
+	}
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req)
+}
@@ -0,0 +1,95 @@
+package metha
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostLimiter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-ratelimit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var cases = []struct {
+		about    string
+		limiters []*HostLimiter
+	}{
+		{"in-process", []*HostLimiter{NewHostLimiter(50, 1)}},
+		{"shared by two limiters", []*HostLimiter{
+			NewSharedHostLimiter(dir, 50, 1),
+			NewSharedHostLimiter(dir, 50, 1),
+		}},
+	}
+	for _, c := range cases {
+		// 11 requests at 50/s take at least 200ms, with a burst of one.
+		var (
+			wg      sync.WaitGroup
+			started = time.Now()
+		)
+		for i := 0; i < 11; i++ {
+			wg.Add(1)
+			go func(l *HostLimiter) {
+				defer wg.Done()
+				if err := l.Wait(context.Background(), "example.com"); err != nil {
+					t.Error(err)
+				}
+			}(c.limiters[i%len(c.limiters)])
+		}
+		wg.Wait()
+		if elapsed := time.Since(started); elapsed < 190*time.Millisecond {
+			t.Errorf("%s: 11 requests in %s, want at least 200ms", c.about, elapsed)
+		}
+		// Other hosts have their own budget.
+		started = time.Now()
+		if err := c.limiters[0].Wait(context.Background(), "example.org"); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+			t.Errorf("%s: other host waited %s", c.about, elapsed)
+		}
+	}
+}
+
+func TestHostLimiterStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-ratelimit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock := filepath.Join(dir, "example.com.lock")
+	old := time.Now().Add(-staleLock - time.Minute)
+	var cases = []struct {
+		about string
+		files []string
+	}{
+		{"crashed process", []string{lock}},
+		{"crashed while taking over", []string{lock, lock + takeoverSuffix}},
+	}
+	for _, c := range cases {
+		for _, name := range c.files {
+			if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(name, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := NewSharedHostLimiter(dir, 50, 1).Wait(ctx, "example.com")
+		cancel()
+		if err != nil {
+			t.Errorf("%s: %v", c.about, err)
+		}
+		if files, _ := filepath.Glob(lock + "*"); len(files) > 0 {
+			t.Errorf("%s: left over %v", c.about, files)
+		}
+	}
+}
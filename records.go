@@ -0,0 +1,143 @@
+package metha
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// ErrStop can be returned from a record function to stop iteration early.
+var ErrStop = errors.New("stop iteration")
+
+// EachRecord calls fn for every record in the cache, including files in cold
+// storage, in filename order. Iteration stops at the first error, which is
+// returned, unless it is ErrStop.
+func (h *Harvest) EachRecord(fn func(Record) error) error {
+	err := h.EachResponse(func(_ string, resp *Response) error {
+		for _, rec := range resp.ListRecords.Records {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == ErrStop {
+		return nil
+	}
+	return err
+}
+
+// EachResponse calls fn for every response in the cache together with the
+// name of the file it was read from. A compacted file yields multiple
+// responses.
+func (h *Harvest) EachResponse(fn func(string, *Response) error) error {
+	for _, filename := range h.cacheFiles() {
+		if err := eachResponse(filename, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheFiles returns local and cold files, in filename order.
+func (h *Harvest) cacheFiles() []string {
+	files := append(h.Files(), h.ColdFiles()...)
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+	return files
+}
+
+// eachResponse decodes all responses of a single file.
+func eachResponse(filename string, fn func(string, *Response) error) error {
+	r, err := NewChunkReader(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+
+	for {
+		var resp Response
+		if err := dec.Decode(&resp); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := fn(filename, &resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachRecordOffset calls fn for every record of a file together with the
+// byte offset of its record element in the decompressed file.
+func eachRecordOffset(filename string, fn func(int64, Record) error) error {
+	r, err := NewChunkReader(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+
+	// records of other elements, e.g. an empty GetRecord, are skipped
+	var list bool
+	for {
+		// the next token starts here
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.EndElement:
+			if t.Name.Local == "ListRecords" {
+				list = false
+			}
+		case xml.StartElement:
+			switch {
+			case t.Name.Local == "ListRecords":
+				list = true
+			case t.Name.Local == "record" && list:
+				var rec Record
+				if err := dec.DecodeElement(&rec, &t); err != nil {
+					return err
+				}
+				if err := fn(offset, rec); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// recordAt decodes the record starting at a byte offset of the decompressed
+// file, as returned by eachRecordOffset. Only the record itself is parsed.
+func recordAt(filename string, offset int64) (*Record, error) {
+	r, err := NewChunkReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if _, err := io.CopyN(ioutil.Discard, r, offset); err != nil {
+		return nil, err
+	}
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	var rec Record
+	if err := dec.Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,132 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// redirectTransport records redirects, to detect endpoints, that moved
+// permanently, e.g. from http to https.
+type redirectTransport struct {
+	Transport http.RoundTripper
+
+	mu sync.Mutex
+	// last permanent redirect and whether any redirect was temporary
+	location  *url.URL
+	temporary bool
+}
+
+// RoundTrip executes the request and records the target of a redirect.
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		if loc, err := resp.Location(); err == nil {
+			t.location = loc
+		}
+	case http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect:
+		t.temporary = true
+	}
+	return resp, nil
+}
+
+// movedTo returns the new base URL, if all redirects were permanent, or the
+// empty string.
+func (t *redirectTransport) movedTo(baseURL string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.location == nil || t.temporary {
+		return ""
+	}
+	u := *t.location
+	u.RawQuery, u.Fragment = "", ""
+	if moved := u.String(); moved != baseURL {
+		return moved
+	}
+	return ""
+}
+
+// moveBaseURL switches the harvest to a new base URL and moves existing
+// cache directories of all formats and sets, so incremental harvesting
+// continues where it stopped. Like Rebind, it holds the locks of the
+// directories, nothing is moved, if one of them is locked.
+func (h *Harvest) moveBaseURL(moved string) error {
+	formats := h.Formats
+	if len(formats) == 0 {
+		formats = []string{h.Format}
+	}
+	sets := h.Sets
+	if len(sets) == 0 {
+		sets = []string{h.Set}
+	}
+	log.Printf("endpoint moved permanently from %s to %s", h.BaseURL, moved)
+	type move struct {
+		src, dst *Harvest
+		lock     *harvestLock
+	}
+	var moves []*move
+	defer func() {
+		for _, m := range moves {
+			if m.lock != nil {
+				m.lock.unlock()
+			}
+		}
+	}()
+	for _, format := range formats {
+		for _, set := range sets {
+			src := &Harvest{BaseURL: h.BaseURL, Format: format, Set: set, Naming: h.Naming, LockWait: h.LockWait}
+			dst := &Harvest{BaseURL: moved, Format: format, Set: set, Naming: h.Naming}
+			if _, err := os.Stat(src.Dir()); os.IsNotExist(err) || src.Dir() == dst.Dir() {
+				continue
+			}
+			if _, err := os.Stat(dst.Dir()); err == nil {
+				return fmt.Errorf("cannot move %s to %s, directory exists", src.Dir(), dst.Dir())
+			}
+			m := &move{src: src, dst: dst}
+			moves = append(moves, m)
+			l, err := src.lock(context.Background())
+			if err != nil {
+				return err
+			}
+			m.lock = l
+			if _, err := os.Stat(src.journalPath()); err == nil {
+				return fmt.Errorf("unfinished finalize in %s, run a harvest first", src.Dir())
+			}
+		}
+	}
+	h.BaseURL = moved
+	for _, m := range moves {
+		src, dst := m.src.Dir(), m.dst.Dir()
+		log.Printf("moving %s to %s", src, dst)
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		m.lock.unlock()
+		m.lock = nil
+		for _, name := range []string{LockFilename, HarvestInfoFilename} {
+			if err := os.Remove(filepath.Join(dst, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		// the info file named the old endpoint
+		if err := m.dst.writeInfo(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
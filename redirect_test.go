@@ -0,0 +1,138 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFollowMoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-redirect-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/oai?"+r.URL.RawQuery, http.StatusMovedPermanently)
+		case "/temporary":
+			http.Redirect(w, r, "/oai?"+r.URL.RawQuery, http.StatusFound)
+		default:
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		path   string
+		follow bool
+		want   string
+	}{
+		{"/moved", false, "/moved"},
+		{"/temporary", true, "/temporary"},
+		{"/moved", true, "/oai"},
+	}
+	for _, c := range cases {
+		h := &Harvest{BaseURL: srv.URL + c.path, Format: "oai_dc", FollowMoved: c.follow}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(h.Dir(), "2016-01-31-00000000.xml"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.IdentifyContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if h.BaseURL != srv.URL+c.want {
+			t.Errorf("%s: got %s, want %s", c.path, h.BaseURL, srv.URL+c.want)
+		}
+		if len(h.Files()) != 1 {
+			t.Errorf("%s: got %d files in %s, want 1", c.path, len(h.Files()), h.Dir())
+		}
+		moved, err := HarvestFromDir(filepath.Base(h.Dir()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved.BaseURL != h.BaseURL {
+			t.Errorf("%s: harvest info names %s, want %s", c.path, moved.BaseURL, h.BaseURL)
+		}
+	}
+}
+
+func TestFollowMovedFormatsAndSets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-redirect-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/oai?"+r.URL.RawQuery, http.StatusMovedPermanently)
+			return
+		}
+		fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		about  string
+		locked bool
+		want   string
+		moved  bool
+	}{
+		{"a running harvest blocks the move", true, "/moved", false},
+		{"all formats and sets are moved", false, "/oai", true},
+	}
+	formats, sets := []string{"oai_dc", "marcxml"}, []string{"a", "b"}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		for _, format := range formats {
+			for _, set := range sets {
+				h := &Harvest{BaseURL: srv.URL + "/moved", Format: format, Set: set}
+				if err := h.MkdirAll(); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(h.Dir(), "2016-01-31-00000000.xml"), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		var l *harvestLock
+		if c.locked {
+			other := &Harvest{BaseURL: srv.URL + "/moved", Format: "marcxml", Set: "b"}
+			if l, err = other.lock(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		h := &Harvest{BaseURL: srv.URL + "/moved", Format: formats[0], Formats: formats, Sets: sets, FollowMoved: true}
+		err := h.IdentifyContext(context.Background())
+		if l != nil {
+			l.unlock()
+		}
+		if c.locked != errors.Is(err, ErrLocked) {
+			t.Errorf("%s: got %v", c.about, err)
+		}
+		if h.BaseURL != srv.URL+c.want {
+			t.Errorf("%s: got %s, want %s", c.about, h.BaseURL, srv.URL+c.want)
+		}
+		for _, format := range formats {
+			for _, set := range sets {
+				f := &Harvest{BaseURL: srv.URL + "/oai", Format: format, Set: set}
+				if got := len(f.Files()) == 1; got != c.moved {
+					t.Errorf("%s: %s/%s moved %v, want %v", c.about, format, set, got, c.moved)
+				}
+			}
+		}
+	}
+}
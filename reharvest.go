@@ -0,0 +1,228 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+var (
+	// ErrInvalidRange signals an empty or unparsable date range.
+	ErrInvalidRange = errors.New("invalid date range")
+	// ErrNotSelective signals, that an operation requires selective harvesting.
+	ErrNotSelective = errors.New("selective harvesting disabled")
+	// ErrReharvestStopped signals, that a run limit stopped a re-harvest
+	// before the range was complete. The cache is left unchanged.
+	ErrReharvestStopped = errors.New("re-harvest stopped by a run limit")
+)
+
+// PurgedSuffix marks files set aside during a re-harvest.
+const PurgedSuffix = ".purged"
+
+// ReharvestRange removes cached files covering a date range and harvests the
+// range again, leaving the rest of the cache intact. From and until use the
+// 2006-01-02 layout. Like Run, it is cancelled on an interrupt signal.
+func (h *Harvest) ReharvestRange(from, until string) error {
+	ctx, cancel := interruptContext()
+	defer cancel()
+	return h.ReharvestRangeContext(ctx, from, until)
+}
+
+// ReharvestRangeContext is like ReharvestRange, but stops, when the context
+// is cancelled. The range is widened to whole months (or days, with
+// DailyInterval), since cached files always cover complete intervals. Old
+// files are only deleted after the new harvest succeeded, otherwise they are
+// put back. A run stopped by a limit counts as failed.
+func (h *Harvest) ReharvestRangeContext(ctx context.Context, from, until string) (err error) {
+	if h.DisableSelectiveHarvesting {
+		return ErrNotSelective
+	}
+	interval, l, err := h.startReharvest(ctx, from, until)
+	if err != nil {
+		return err
+	}
+	defer l.unlock()
+
+	aside, err := h.purgeFiles(interval)
+	if err != nil {
+		return err
+	}
+	log.Printf("re-harvesting %s, %d files set aside", interval, len(aside))
+
+	defer func() {
+		if e := h.cleanupTemporaryFiles(); e != nil && err == nil {
+			err = e
+		}
+		// purged files and files of a failed harvest are still indexed
+		if h.hasHeaderIndex() {
+			if e := h.RebuildHeaderIndex(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}()
+
+	h.progress = progressState{intervals: 1}
+	err = h.runIntervals(ctx, interval)
+	if err == nil {
+		// the rest of the range was not harvested again, keep the old files
+		err = reharvestStopped(h.progress.stopped, interval)
+	}
+	if err != nil {
+		// remove partial results and put the old files back
+		for _, filename := range h.finalized {
+			if e := os.Remove(filename); e != nil && !os.IsNotExist(e) {
+				return &MultiError{[]error{err, e}}
+			}
+			if e := removeChecksum(filename); e != nil {
+				return &MultiError{[]error{err, e}}
+			}
+		}
+		h.finalized = nil
+		if e := restoreFiles(aside); e != nil {
+			return &MultiError{[]error{err, e}}
+		}
+		return err
+	}
+	for _, filename := range aside {
+		if err := os.Remove(filename + PurgedSuffix); err != nil {
+			return err
+		}
+		if err := os.Remove(filename + IndexSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Remove(checksumPath(filename) + PurgedSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if len(h.finalized) > 0 || len(h.progress.covered) > 0 {
+		if err := h.writeManifest(RunReharvest, h.Started, h.finalized, nil); err != nil {
+			log.Printf("failed to write run manifest: %s", err)
+		}
+	}
+	return h.postRun()
+}
+
+// startReharvest prepares a re-harvest of a date range: Identify is
+// requested, if it is not set, the harvest directory is locked and
+// interrupted operations are recovered. The range is widened to whole months
+// (or days, with DailyInterval) and ends yesterday at the latest. The caller
+// must release the lock.
+func (h *Harvest) startReharvest(ctx context.Context, from, until string) (Interval, *harvestLock, error) {
+	begin, err := time.ParseInLocation("2006-01-02", from, h.location())
+	if err != nil {
+		return Interval{}, nil, fmt.Errorf("%s: %s", ErrInvalidRange, err)
+	}
+	end, err := time.ParseInLocation("2006-01-02", until, h.location())
+	if err != nil {
+		return Interval{}, nil, fmt.Errorf("%s: %s", ErrInvalidRange, err)
+	}
+	if h.Identify == nil {
+		if err := h.IdentifyContext(ctx); err != nil {
+			return Interval{}, nil, err
+		}
+	}
+	if err := h.MkdirAll(); err != nil {
+		return Interval{}, nil, err
+	}
+	// concurrent runs would remove each other's temporary files
+	l, err := h.lock(ctx)
+	if err != nil {
+		return Interval{}, nil, err
+	}
+	if err := h.recover(); err != nil {
+		l.unlock()
+		return Interval{}, nil, err
+	}
+	h.Started = time.Now()
+	h.finalized = nil
+
+	if h.DailyInterval {
+		end = now.New(end).EndOfDay()
+	} else {
+		begin = now.New(begin).BeginningOfMonth()
+		end = now.New(end).EndOfMonth()
+	}
+	if yesterday := now.New(h.Started.In(h.location()).AddDate(0, 0, -1)).EndOfDay(); end.After(yesterday) {
+		end = yesterday
+	}
+	if end.Before(begin) {
+		l.unlock()
+		return Interval{}, nil, ErrInvalidRange
+	}
+	return Interval{Begin: begin, End: end}, l, nil
+}
+
+// reharvestStopped returns ErrReharvestStopped, if a run limit stopped the
+// re-harvest of an interval.
+func reharvestStopped(stopped bool, iv Interval) error {
+	if stopped {
+		return fmt.Errorf("%w: %s", ErrReharvestStopped, iv)
+	}
+	return nil
+}
+
+// purgeFiles renames all cached files within the interval, returns the
+// original names. Compacted files holding records outside the interval cannot
+// be purged.
+func (h *Harvest) purgeFiles(iv Interval) ([]string, error) {
+	var candidates []string
+	for _, filename := range append(h.Files(), h.ColdFiles()...) {
+		groups := fnPattern.FindStringSubmatch(filename)
+		if len(groups) < 2 {
+			continue
+		}
+		date, err := time.ParseInLocation("2006-01-02", groups[1], h.location())
+		if err != nil {
+			return nil, err
+		}
+		if date.Before(iv.Begin) || date.After(iv.End) {
+			continue
+		}
+		if entries, err := ReadIndex(filename); err == nil {
+			for _, e := range entries {
+				if len(e.DateStamp) >= 10 && e.DateStamp[:10] < iv.Begin.Format("2006-01-02") {
+					return nil, fmt.Errorf("%s holds records before %s, cannot purge", filename, iv.Begin.Format("2006-01-02"))
+				}
+			}
+		}
+		candidates = append(candidates, filename)
+	}
+	var aside []string
+	for _, filename := range candidates {
+		if err := os.Rename(filename, filename+PurgedSuffix); err != nil {
+			if e := restoreFiles(aside); e != nil {
+				return nil, &MultiError{[]error{err, e}}
+			}
+			return nil, err
+		}
+		aside = append(aside, filename)
+		// new files get new checksums, keep the old ones for a restore
+		sidecar := checksumPath(filename)
+		if err := os.Rename(sidecar, sidecar+PurgedSuffix); err != nil && !os.IsNotExist(err) {
+			if e := restoreFiles(aside); e != nil {
+				return nil, &MultiError{[]error{err, e}}
+			}
+			return nil, err
+		}
+	}
+	return aside, nil
+}
+
+// restoreFiles moves files set aside back into place.
+func restoreFiles(filenames []string) error {
+	for _, filename := range filenames {
+		if err := os.Rename(filename+PurgedSuffix, filename); err != nil {
+			return err
+		}
+		sidecar := checksumPath(filename)
+		if err := os.Rename(sidecar+PurgedSuffix, sidecar); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
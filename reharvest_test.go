@@ -0,0 +1,95 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReharvestRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-reharvest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	var fail bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case fail:
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords><record><header><identifier>new</identifier><datestamp>%s</datestamp></header></record></ListRecords></OAI-PMH>`, q.Get("from"))
+		}
+	}))
+	defer srv.Close()
+
+	old := []string{"2016-01-31-00000000.xml", "2016-02-29-00000000.xml", "2016-02-29-00000001.xml", "2016-03-31-00000000.xml"}
+	var cases = []struct {
+		about       string
+		from, until string
+		daily       bool
+		selective   bool
+		fail        bool
+		maxRequests int
+		err         string
+		files       []string
+	}{
+		{"month is replaced", "2016-02-10", "2016-02-12", false, true, false, 0, "",
+			[]string{"2016-01-31-00000000.xml", "2016-02-29-00000000.xml.gz", "2016-03-31-00000000.xml"}},
+		{"days are replaced", "2016-02-28", "2016-03-01", true, true, false, 0, "",
+			[]string{"2016-01-31-00000000.xml", "2016-02-28-00000000.xml.gz", "2016-02-29-00000000.xml.gz", "2016-03-01-00000000.xml.gz", "2016-03-31-00000000.xml"}},
+		{"failure puts old files back", "2016-02-10", "2016-02-12", false, true, true, 0, "Internal Server Error", old},
+		{"stopped by a limit puts old files back", "2016-02-10", "2016-03-12", false, true, false, 1, ErrReharvestStopped.Error(), old},
+		{"empty range", "2016-03-01", "2016-02-01", false, true, false, 0, ErrInvalidRange.Error(), old},
+		{"unparsable range", "2016-02", "2016-03-01", false, true, false, 0, ErrInvalidRange.Error(), old},
+		{"selective harvesting disabled", "2016-02-10", "2016-02-12", false, false, false, 0, ErrNotSelective.Error(), old},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{
+			BaseURL:                    srv.URL,
+			Format:                     "oai_dc",
+			DailyInterval:              c.daily,
+			DisableSelectiveHarvesting: !c.selective,
+			MaxRequests:                10,
+			MaxEmptyResponses:          10,
+			MaxTotalRequests:           c.maxRequests,
+			RetryPolicy:                RetryPolicy{MaxAttempts: 1},
+		}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range old {
+			doc := `<OAI-PMH><ListRecords><record><header><identifier>old</identifier><datestamp>` + name[:10] + `</datestamp></header></record></ListRecords></OAI-PMH>`
+			if err := ioutil.WriteFile(filepath.Join(h.Dir(), name), []byte(doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fail = c.fail
+		err := h.ReharvestRangeContext(context.Background(), c.from, c.until)
+		if (c.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: got %v, want %q", c.about, err, c.err)
+		}
+		var names []string
+		for _, fn := range h.Files() {
+			names = append(names, filepath.Base(fn))
+		}
+		if strings.Join(names, " ") != strings.Join(c.files, " ") {
+			t.Errorf("%s: got files %v, want %v", c.about, names, c.files)
+		}
+		if purged, _ := filepath.Glob(filepath.Join(h.Dir(), "*"+PurgedSuffix)); len(purged) > 0 {
+			t.Errorf("%s: left over %v", c.about, purged)
+		}
+	}
+}
@@ -15,6 +15,9 @@ var (
 	ErrCannotGenerateID = errors.New("cannot generate ID")
 	ErrMissingURL       = errors.New("missing URL")
 	ErrParameterMissing = errors.New("missing required parameter")
+	// ErrIllegalArgument signals an argument, that is not allowed for a verb
+	// or in combination with other arguments.
+	ErrIllegalArgument = errors.New("illegal argument")
 )
 
 // A Request can express any request, that can be sent to an OAI server. Not all
@@ -30,6 +33,13 @@ type Request struct {
 	ResumptionToken         string
 	CleanBeforeDecode       bool
 	SuppressFormatParameter bool
+	// UsePost sends the parameters form-encoded in the body of a POST
+	// request, as allowed by OAI-PMH, e.g. for very long resumption tokens.
+	UsePost bool
+	// Params are sent in addition to the OAI arguments, also together with
+	// a resumption token, e.g. an API key or vendor specific arguments. They
+	// are not checked.
+	Params url.Values
 }
 
 // Values enhances the builtin url.Values.
@@ -73,14 +83,11 @@ func (r *Request) URL() (*url.URL, error) {
 	if r.BaseURL == "" {
 		return nil, ErrMissingURL
 	}
-
-	v := NewValues()
-	v.Add("verb", r.Verb)
-
-	// An exclusive argument with a value that is the flow control token
-	// returned by a previous a request that issued an incomplete list.
+	v, err := r.Values()
+	if err != nil {
+		return nil, err
+	}
 	if r.ResumptionToken != "" {
-		v.Add("resumptionToken", r.ResumptionToken)
 		// http://opencontext.org/oai/request has spaces in tokens so encode in
 		// this case.
 		if strings.Contains(r.ResumptionToken, " ") {
@@ -90,48 +97,99 @@ func (r *Request) URL() (*url.URL, error) {
 		// problems with encoded tokens.
 		return url.Parse(fmt.Sprintf("%s?%s", r.BaseURL, v.EncodeVerbatim()))
 	}
+	// TODO(miku): some endpoints do not like encoded urls, e.g. http://web2.bium.univ-paris5.fr/oai-img/oai2.php
+	return url.Parse(fmt.Sprintf("%s?%s", r.BaseURL, v.EncodeVerbatim()))
+}
 
-	// Only add parameter, if it is not the zero value.
-	addOptional := func(key, value string) {
-		if value != "" {
-			v.Add(key, value)
-		}
+// arguments lists the arguments allowed for each verb, true means required,
+// refs. http://www.openarchives.org/OAI/openarchivesprotocol.html#ProtocolMessages.
+// A resumptionToken replaces all arguments but the verb.
+var arguments = map[string]map[string]bool{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier": false},
+	"ListSets":            {"resumptionToken": false},
+	"ListIdentifiers":     {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"ListRecords":         {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"GetRecord":           {"identifier": true, "metadataPrefix": true},
+}
+
+// validArgument returns true, if the argument is allowed for the verb.
+func validArgument(verb, key string) bool {
+	_, ok := arguments[verb][key]
+	return ok
+}
+
+// Validate checks the verb and the combination of arguments, following the
+// rules of the protocol, without sending the request. With a resumption
+// token, other arguments are ignored.
+func (r *Request) Validate() error {
+	_, err := r.Values()
+	return err
+}
+
+// Values returns the parameters of the request, after checking them like
+// Validate. With a resumption token, other arguments are left out.
+func (r *Request) Values() (Values, error) {
+	v := NewValues()
+	if r.Verb == "" {
+		return v, ErrMissingVerb
+	}
+	allowed, ok := arguments[r.Verb]
+	if !ok {
+		return v, ErrInvalidVerb
 	}
+	v.Add("verb", r.Verb)
 
-	// If required parameter is missing, complain.
-	addRequired := func(key, value string) error {
-		if value == "" {
-			return ErrParameterMissing
+	// An exclusive argument with a value that is the flow control token
+	// returned by a previous a request that issued an incomplete list.
+	if r.ResumptionToken != "" {
+		if !validArgument(r.Verb, "resumptionToken") {
+			return v, fmt.Errorf("%w: resumptionToken with %s", ErrIllegalArgument, r.Verb)
 		}
-		v.Add(key, value)
-		return nil
+		v.Add("resumptionToken", r.ResumptionToken)
+		r.addParams(v)
+		return v, nil
 	}
 
-	switch r.Verb {
-	case "ListMetadataFormats", "ListSets":
-	case "ListIdentifiers", "ListRecords":
-		if !r.SuppressFormatParameter {
-			if err := addRequired("metadataPrefix", r.MetadataPrefix); err != nil {
-				return nil, err
-			}
+	given := map[string]string{
+		"identifier":     r.Identifier,
+		"metadataPrefix": r.MetadataPrefix,
+		"from":           r.From,
+		"until":          r.Until,
+		"set":            r.Set,
+	}
+	if r.SuppressFormatParameter {
+		delete(given, "metadataPrefix")
+	}
+	for _, key := range []string{"identifier", "metadataPrefix", "from", "until", "set"} {
+		value, ok := given[key]
+		if !ok {
+			continue
 		}
-		addOptional("from", r.From)
-		addOptional("until", r.Until)
-		addOptional("set", r.Set)
-	case "Identify":
-		addOptional("identifier", r.Identifier)
-	case "GetRecord":
-		if err := addRequired("identifier", r.Identifier); err != nil {
-			return nil, err
+		required, allowed := allowed[key]
+		switch {
+		case value == "" && required:
+			return v, ErrParameterMissing
+		case value == "":
+		case !allowed:
+			return v, fmt.Errorf("%w: %s with %s", ErrIllegalArgument, key, r.Verb)
+		default:
+			v.Add(key, value)
 		}
-		if !r.SuppressFormatParameter {
-			if err := addRequired("metadataPrefix", r.MetadataPrefix); err != nil {
-				return nil, err
-			}
+	}
+	// both dates must use the same granularity
+	if r.From != "" && r.Until != "" && len(r.From) != len(r.Until) {
+		return v, fmt.Errorf("%w: from %s and until %s differ in granularity", ErrIllegalArgument, r.From, r.Until)
+	}
+	r.addParams(v)
+	return v, nil
+}
+
+// addParams adds the additional parameters to v.
+func (r *Request) addParams(v Values) {
+	for key, values := range r.Params {
+		for _, value := range values {
+			v.Add(key, value)
 		}
-	default:
-		return nil, ErrInvalidVerb
 	}
-	// TODO(miku): some endpoints do not like encoded urls, e.g. http://web2.bium.univ-paris5.fr/oai-img/oai2.php
-	return url.Parse(fmt.Sprintf("%s?%s", r.BaseURL, v.EncodeVerbatim()))
 }
@@ -1,6 +1,10 @@
 package metha
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 )
@@ -43,3 +47,55 @@ func TestURL(t *testing.T) {
 		}
 	}
 }
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		req Request
+		err error
+	}{
+		{req: Request{}, err: ErrMissingVerb},
+		{req: Request{Verb: "Identify"}, err: nil},
+		{req: Request{Verb: "Identify", Identifier: "x"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "Identify", ResumptionToken: "1"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "ListMetadataFormats", Identifier: "x"}, err: nil},
+		{req: Request{Verb: "ListMetadataFormats", MetadataPrefix: "x"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "ListMetadataFormats", ResumptionToken: "1"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "ListSets", Set: "x"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "ListSets", ResumptionToken: "1"}, err: nil},
+		{req: Request{Verb: "ListIdentifiers", MetadataPrefix: "x", Identifier: "x"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "ListIdentifiers", SuppressFormatParameter: true}, err: nil},
+		{req: Request{Verb: "ListRecords", MetadataPrefix: "x", From: "2020-01-01", Until: "2020-01-31"}, err: nil},
+		{req: Request{Verb: "ListRecords", MetadataPrefix: "x", From: "2020-01-01", Until: "2020-01-31T00:00:00Z"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "GetRecord", MetadataPrefix: "x"}, err: ErrParameterMissing},
+		{req: Request{Verb: "GetRecord", Identifier: "x", MetadataPrefix: "x"}, err: nil},
+		{req: Request{Verb: "GetRecord", Identifier: "x", MetadataPrefix: "x", Set: "x"}, err: ErrIllegalArgument},
+		{req: Request{Verb: "GetRecord", Identifier: "x", MetadataPrefix: "x", ResumptionToken: "1"}, err: ErrIllegalArgument},
+	}
+	for _, test := range tests {
+		if err := test.req.Validate(); !errors.Is(err, test.err) || (err == nil) != (test.err == nil) {
+			t.Errorf("req.Validate(%+v), got %v, want %v", test.req, err, test.err)
+		}
+	}
+}
+
+func TestUsePost(t *testing.T) {
+	token := "a&b=c d"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("got %s, want POST", r.Method)
+		}
+		if r.URL.RawQuery != "" {
+			t.Errorf("got query %q, want none", r.URL.RawQuery)
+		}
+		if got := r.PostFormValue("resumptionToken"); got != token {
+			t.Errorf("got token %q, want %q", got, token)
+		}
+		fmt.Fprint(w, "<OAI-PMH><ListRecords></ListRecords></OAI-PMH>")
+	}))
+	defer srv.Close()
+
+	req := Request{BaseURL: srv.URL, Verb: "ListRecords", ResumptionToken: token, UsePost: true}
+	if _, err := StdClient.Do(&req); err != nil {
+		t.Fatal(err)
+	}
+}
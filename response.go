@@ -3,7 +3,10 @@ package metha
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/nytlabs/mxj"
 )
@@ -75,11 +78,17 @@ func (md Metadata) MarshalJSON() ([]byte, error) {
 // GoString is a formatter for Metadata content.
 func (md Metadata) GoString() string { return fmt.Sprintf("%s", md.Body) }
 
-// About has addition record information.
+// About is a container with additional information on a record, e.g.
+// provenance or rights statements. A record can have any number of them.
 type About struct {
 	Body []byte `xml:",innerxml" json:"body,omitempty"`
 }
 
+// MarshalJSON marshals the about body, like Metadata.
+func (ab About) MarshalJSON() ([]byte, error) {
+	return Metadata{Body: ab.Body}.MarshalJSON()
+}
+
 // GoString is a formatter for About content.
 func (ab About) GoString() string { return fmt.Sprintf("%s", ab.Body) }
 
@@ -87,7 +96,7 @@ func (ab About) GoString() string { return fmt.Sprintf("%s", ab.Body) }
 type Record struct {
 	Header   Header   `xml:"header,omitempty" json:"header,omitempty"`
 	Metadata Metadata `xml:"metadata,omitempty" json:"metadata,omitempty"`
-	About    About    `xml:"about,omitempty" json:"about,omitempty"`
+	About    []About  `xml:"about,omitempty" json:"about,omitempty"`
 }
 
 // ListIdentifiers lists headers only.
@@ -96,10 +105,43 @@ type ListIdentifiers struct {
 	ResumptionToken string   `xml:"resumptionToken,omitempty" json:"resumptionToken,omitempty"`
 }
 
-// ListRecords lists records.
+// ListRecords lists records. TokenInfo is only populated on decoding.
 type ListRecords struct {
-	Records         []Record `xml:"record" json:"record"`
-	ResumptionToken string   `xml:"resumptionToken" json:"resumptionToken"`
+	Records         []Record            `xml:"record" json:"record"`
+	ResumptionToken string              `xml:"resumptionToken" json:"resumptionToken"`
+	TokenInfo       ResumptionTokenInfo `xml:"-" json:"-"`
+}
+
+// UnmarshalXML decodes the records and the token including its attributes.
+func (lr *ListRecords) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v struct {
+		Records []Record            `xml:"record"`
+		Token   ResumptionTokenInfo `xml:"resumptionToken"`
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	lr.Records = v.Records
+	lr.ResumptionToken = v.Token.Value
+	lr.TokenInfo = v.Token
+	return nil
+}
+
+// ResumptionTokenInfo carries the optional attributes of a resumption token.
+type ResumptionTokenInfo struct {
+	Value            string `xml:",chardata"`
+	CompleteListSize string `xml:"completeListSize,attr"`
+	Cursor           string `xml:"cursor,attr"`
+	ExpirationDate   string `xml:"expirationDate,attr"`
+}
+
+// Size returns the complete list size or -1, if it is not known.
+func (t ResumptionTokenInfo) Size() int {
+	n, err := strconv.Atoi(strings.TrimSpace(t.CompleteListSize))
+	if err != nil {
+		return -1
+	}
+	return n
 }
 
 // GetRecord returns a single record.
@@ -107,11 +149,13 @@ type GetRecord struct {
 	Record Record `xml:"record,omitempty" json:"record,omitempty"`
 }
 
-// RequestNode carries the request information into the response.
+// RequestNode carries the request information into the response. The
+// element content is the base URL of the repository.
 type RequestNode struct {
 	Verb           string `xml:"verb,attr" json:"verb,omitempty"`
 	Set            string `xml:"set,attr" json:"set,omitempty"`
 	MetadataPrefix string `xml:"metadataPrefix,attr" json:"metadataPrefix,omitempty"`
+	BaseURL        string `xml:",chardata" json:"baseURL,omitempty"`
 }
 
 // OAIError is an OAI protocol error.
@@ -0,0 +1,68 @@
+package metha
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestResumptionTokenInfo(t *testing.T) {
+	var doc = `<OAI-PMH><ListRecords>
+		<record><header><identifier>oai:x:1</identifier></header></record>
+		<resumptionToken completeListSize="120" cursor="0">abc</resumptionToken>
+	</ListRecords></OAI-PMH>`
+	var resp Response
+	if err := xml.Unmarshal([]byte(doc), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetResumptionToken(); got != "abc" {
+		t.Errorf("got %q, want abc", got)
+	}
+	if got := resp.ListRecords.TokenInfo.Size(); got != 120 {
+		t.Errorf("got %d, want 120", got)
+	}
+	if got := len(resp.ListRecords.Records); got != 1 {
+		t.Errorf("got %d records, want 1", got)
+	}
+}
+
+func TestRecordAbout(t *testing.T) {
+	var doc = `<OAI-PMH><ListRecords><record>
+		<header><identifier>oai:x:1</identifier></header>
+		<metadata><dc>x</dc></metadata>
+		<about><provenance><originDescription>y</originDescription></provenance></about>
+		<about><rights><rightsReference>http://creativecommons.org/licenses/by/4.0/</rightsReference></rights></about>
+	</record><record><header><identifier>oai:x:2</identifier></header></record></ListRecords></OAI-PMH>`
+	var resp Response
+	if err := xml.Unmarshal([]byte(doc), &resp); err != nil {
+		t.Fatal(err)
+	}
+	rec := resp.ListRecords.Records[0]
+	if len(rec.About) != 2 {
+		t.Fatalf("got %d about containers, want 2", len(rec.About))
+	}
+	b, err := xml.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(b), "<about>") != 2 || !strings.Contains(string(b), "<rightsReference>") {
+		t.Errorf("about lost in XML: %s", b)
+	}
+	var v struct {
+		About []json.RawMessage `json:"about"`
+	}
+	if b, err = json.Marshal(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &v); err != nil || len(v.About) != 2 {
+		t.Errorf("about lost in JSON: %s", b)
+	}
+	// no empty about elements
+	if b, err = xml.Marshal(resp.ListRecords.Records[1]); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "about") {
+		t.Errorf("got %s", b)
+	}
+}
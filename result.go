@@ -0,0 +1,103 @@
+package metha
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Result summarizes a run, so callers can report and decide without reading
+// the log. Counts cover all formats of a run.
+type Result struct {
+	// Intervals harvested completely.
+	Intervals []Interval `json:"intervals,omitempty"`
+	Requests  int        `json:"requests"`
+	// Records received and bytes downloaded.
+	Records int   `json:"records"`
+	Bytes   int64 `json:"bytes"`
+	// Files moved into place and their size on disk.
+	Files        []string `json:"files,omitempty"`
+	BytesWritten int64    `json:"bytes_written"`
+	// OAIErrors counts the OAI error codes received, including ignored and
+	// retried ones.
+	OAIErrors map[string]int `json:"oai_errors,omitempty"`
+	Started   time.Time      `json:"started"`
+	Duration  time.Duration  `json:"duration"`
+	// AlreadySynced is true, if there was nothing to harvest.
+	AlreadySynced bool `json:"already_synced"`
+	// Stopped is true, if a limit stopped the run before it was complete,
+	// see ResumePoint.
+	Stopped bool `json:"stopped"`
+	// Sets harvested completely or already synced, with Sets, SetInclude or
+	// SetExclude.
+	Sets []string `json:"sets,omitempty"`
+}
+
+// add merges the result of another format into r.
+func (r *Result) add(o *Result) {
+	r.Intervals = append(r.Intervals, o.Intervals...)
+	r.Requests += o.Requests
+	r.Records += o.Records
+	r.Bytes += o.Bytes
+	r.Files = append(r.Files, o.Files...)
+	r.BytesWritten += o.BytesWritten
+	for code, n := range o.OAIErrors {
+		if r.OAIErrors == nil {
+			r.OAIErrors = make(map[string]int)
+		}
+		r.OAIErrors[code] += n
+	}
+	r.Stopped = r.Stopped || o.Stopped
+	r.Sets = append(r.Sets, o.Sets...)
+}
+
+// RunResult starts the harvest like RunContext and returns a summary. The
+// result covers the run up to an error and is returned with the error, too.
+// An already synced harvest is not an error here, see AlreadySynced. Outside
+// the Window, it waits for the window to open first.
+func (h *Harvest) RunResult(ctx context.Context) (*Result, error) {
+	if err := h.waitWindow(ctx); err != nil {
+		return &Result{Started: time.Now()}, err
+	}
+	if h.multiSet() {
+		return h.runSets(ctx)
+	}
+	if len(h.Formats) > 0 {
+		return h.runFormats(ctx)
+	}
+	return h.runFormatResult(ctx)
+}
+
+// runFormatResult harvests a single format and summarizes the run.
+func (h *Harvest) runFormatResult(ctx context.Context) (*Result, error) {
+	started := time.Now()
+	h.progress = progressState{}
+	h.finalized = nil
+	err := h.runFormat(ctx)
+	r := &Result{
+		Intervals:     append([]Interval(nil), h.progress.covered...),
+		Requests:      h.progress.requests,
+		Records:       h.progress.totalRecords,
+		Bytes:         h.progress.bytes,
+		Files:         append([]string(nil), h.finalized...),
+		Started:       started,
+		Duration:      time.Since(started),
+		AlreadySynced: err == ErrAlreadySynced,
+		Stopped:       h.progress.stopped,
+	}
+	for code, n := range h.progress.oaiErrors {
+		if r.OAIErrors == nil {
+			r.OAIErrors = make(map[string]int)
+		}
+		r.OAIErrors[code] = n
+	}
+	for _, filename := range r.Files {
+		if fi, err := os.Stat(filename); err == nil {
+			r.BytesWritten += fi.Size()
+		}
+	}
+	if err == ErrAlreadySynced {
+		return r, nil
+	}
+	return r, err
+}
@@ -0,0 +1,71 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRunResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-result-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	from := time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case q.Get("from") == from:
+			fmt.Fprint(w, `<OAI-PMH><error code="noRecordsMatch"/></OAI-PMH>`)
+		default:
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords><record><header><identifier>%s</identifier><datestamp>%s</datestamp></header></record></ListRecords></OAI-PMH>`,
+				q.Get("from"), q.Get("from"))
+		}
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		From:              from,
+		DailyInterval:     true,
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+	}
+	r, err := h.RunResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AlreadySynced || r.Stopped {
+		t.Errorf("got synced %v, stopped %v, want a complete run", r.AlreadySynced, r.Stopped)
+	}
+	if len(r.Intervals) != 3 || r.Requests != 3 || r.Records != 2 {
+		t.Errorf("got %d intervals, %d requests, %d records, want 3, 3, 2", len(r.Intervals), r.Requests, r.Records)
+	}
+	// the ignored error is kept as an empty response
+	if len(r.Files) != 3 || r.BytesWritten == 0 || r.Bytes == 0 {
+		t.Errorf("got %d files, %d bytes written, %d bytes downloaded", len(r.Files), r.BytesWritten, r.Bytes)
+	}
+	if r.OAIErrors["noRecordsMatch"] != 1 {
+		t.Errorf("got OAI errors %v, want one noRecordsMatch", r.OAIErrors)
+	}
+
+	// nothing left to harvest
+	r, err = h.RunResult(context.Background())
+	if err != nil || !r.AlreadySynced || len(r.Files) != 0 {
+		t.Errorf("got %+v, %v, want already synced", r, err)
+	}
+	if err := h.RunContext(context.Background()); err != ErrAlreadySynced {
+		t.Errorf("got %v, want ErrAlreadySynced", err)
+	}
+}
@@ -0,0 +1,61 @@
+package metha
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ResumeFilename is the name of the resume point in the harvest directory.
+// It is written, when a limit of the run stopped an interval before it was
+// complete, and removed after the next complete run.
+const ResumeFilename = "resume.json"
+
+// ResumePoint is the part of an interval, that was not completely harvested.
+// The files of that part are already in place, the next run harvests it again
+// from its beginning.
+type ResumePoint struct {
+	Interval Interval `json:"interval"`
+	Reason   string   `json:"reason"`
+}
+
+// resumePath returns the path to the resume point.
+func (h *Harvest) resumePath() string {
+	return filepath.Join(h.Dir(), ResumeFilename)
+}
+
+// ResumePoint returns the resume point of the last run, or nil, if the last
+// run was complete.
+func (h *Harvest) ResumePoint() (*ResumePoint, error) {
+	b, err := ioutil.ReadFile(h.resumePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rp ResumePoint
+	if err := json.Unmarshal(b, &rp); err != nil {
+		return nil, err
+	}
+	return &rp, nil
+}
+
+// writeResumePoint records where the next run starts, if the run was
+// stopped, otherwise an old resume point is removed.
+func (h *Harvest) writeResumePoint() error {
+	if h.progress.resume == nil {
+		if err := os.Remove(h.resumePath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(h.progress.resume)
+	if err != nil {
+		return err
+	}
+	log.Printf("stopped in %s, next run continues there", h.progress.resume.Interval)
+	return ioutil.WriteFile(h.resumePath(), b, 0644)
+}
@@ -0,0 +1,153 @@
+package metha
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how often and how long to wait before a failed
+// request is retried. Delays grow exponentially from BaseDelay up to MaxDelay,
+// with a random jitter (fraction of the delay) added. Responses with a status
+// code in RetryOn are retried as well as network errors. A Retry-After header,
+// as recommended by OAI-PMH for 503 responses, takes precedence, but is capped
+// at MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+	RetryOn     []int
+}
+
+// DefaultRetryPolicy is used, if no other policy is given.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: DefaultMaxRetries,
+	BaseDelay:   1 * time.Second,
+	MaxDelay:    5 * time.Minute,
+	Jitter:      0.1,
+	RetryOn: []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// IsZero returns true, if no value of the policy has been set.
+func (p RetryPolicy) IsZero() bool {
+	return p.MaxAttempts == 0 && p.BaseDelay == 0 && p.MaxDelay == 0 &&
+		p.Jitter == 0 && len(p.RetryOn) == 0
+}
+
+// Delay returns the time to wait before the given retry, starting at zero.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < retry && (p.MaxDelay == 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// ShouldRetry returns true, if a response with the given status code should
+// be retried.
+func (p RetryPolicy) ShouldRetry(statusCode int) bool {
+	for _, code := range p.RetryOn {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, which can be either seconds or a
+// HTTP date. Returns zero, if the header is missing or invalid.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// RetryDoer executes requests with a retry policy. With ReadBody, the body
+// is read completely before Do returns, so failures while reading, e.g. a
+// stall or the client timeout, are retried, too.
+type RetryDoer struct {
+	Client   *http.Client
+	Policy   RetryPolicy
+	ReadBody bool
+}
+
+// Do executes the request and retries on network errors and retryable status
+// codes. Waiting is cancelled with the request context.
+func (d *RetryDoer) Do(req *http.Request) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			// the body of the previous attempt has been consumed
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err = d.Client.Do(req)
+		if err == nil && !d.Policy.ShouldRetry(resp.StatusCode) {
+			if !d.ReadBody {
+				return resp, nil
+			}
+			b, rerr := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if rerr == nil {
+				resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+				return resp, nil
+			}
+			resp, err = nil, fmt.Errorf("reading body: %w", rerr)
+		}
+		if attempt+1 >= d.Policy.MaxAttempts {
+			return resp, err
+		}
+		delay := d.Policy.Delay(attempt)
+		if err == nil {
+			if ra := retryAfter(resp); ra > 0 {
+				delay = ra
+				if d.Policy.MaxDelay > 0 && delay > d.Policy.MaxDelay {
+					delay = d.Policy.MaxDelay
+				}
+			}
+			log.Printf("retrying %s [%s] in %s: %s", req.URL, req.Header.Get(RequestIDHeader), delay, resp.Status)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			log.Printf("retrying %s [%s] in %s: %s", req.URL, req.Header.Get(RequestIDHeader), delay, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package metha
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Sanitize selects repairs of broken responses, that go beyond the removal of
+// control characters by CleanBeforeDecode.
+type Sanitize struct {
+	// InvalidUTF8 replaces invalid UTF-8 sequences with U+FFFD.
+	InvalidUTF8 bool
+	// ControlChars removes all characters, that are not allowed in XML 1.0,
+	// including multibyte ones like U+FFFE.
+	ControlChars bool
+	// Entities repairs double encoded entities, e.g. &amp;amp; or &amp;#233;.
+	Entities bool
+	// Latin1 decodes bytes, that are not valid UTF-8, as ISO-8859-1, for
+	// servers, that declare UTF-8, but send Latin-1, in parts or in full.
+	Latin1 bool
+}
+
+// IsZero returns true, if no repair is selected.
+func (s Sanitize) IsZero() bool {
+	return s == Sanitize{}
+}
+
+// ParseSanitize parses a comma separated list of repairs: utf8, control,
+// entities, latin1 or all.
+func ParseSanitize(s string) (Sanitize, error) {
+	var v Sanitize
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "utf8":
+			v.InvalidUTF8 = true
+		case "control":
+			v.ControlChars = true
+		case "entities":
+			v.Entities = true
+		case "latin1":
+			v.Latin1 = true
+		case "all":
+			v = Sanitize{InvalidUTF8: true, ControlChars: true, Entities: true, Latin1: true}
+		default:
+			return v, fmt.Errorf("unknown repair: %s", name)
+		}
+	}
+	return v, nil
+}
+
+// RepairCounts counts the repairs done by the sanitizer. It is safe for
+// concurrent use.
+type RepairCounts struct {
+	InvalidUTF8  int64 `json:"utf8,omitempty"`
+	ControlChars int64 `json:"control,omitempty"`
+	Entities     int64 `json:"entities,omitempty"`
+	Latin1       int64 `json:"latin1,omitempty"`
+}
+
+// Total returns the number of all repairs.
+func (c *RepairCounts) Total() int64 {
+	return atomic.LoadInt64(&c.InvalidUTF8) + atomic.LoadInt64(&c.ControlChars) +
+		atomic.LoadInt64(&c.Entities) + atomic.LoadInt64(&c.Latin1)
+}
+
+// String summarizes the repairs.
+func (c *RepairCounts) String() string {
+	return fmt.Sprintf("%d invalid utf-8, %d control chars, %d entities, %d latin-1",
+		atomic.LoadInt64(&c.InvalidUTF8), atomic.LoadInt64(&c.ControlChars),
+		atomic.LoadInt64(&c.Entities), atomic.LoadInt64(&c.Latin1))
+}
+
+// doubleEncoded are the entity names, that are repaired, if found after an
+// encoded ampersand.
+var doubleEncoded = []string{"amp;", "lt;", "gt;", "quot;", "apos;"}
+
+// lookahead is the number of bytes needed to decide on a repair.
+const lookahead = 16
+
+// sanitizer repairs a stream. Input is kept back, until enough bytes are
+// available to decide on a repair, so sequences across reads are handled.
+type sanitizer struct {
+	r      io.Reader
+	opts   Sanitize
+	counts *RepairCounts
+
+	in  []byte
+	out bytes.Buffer
+	err error
+}
+
+// newSanitizer returns a reader, that applies the selected repairs. Counts
+// may be nil.
+func newSanitizer(r io.Reader, opts Sanitize, counts *RepairCounts) io.Reader {
+	if counts == nil {
+		counts = &RepairCounts{}
+	}
+	return &sanitizer{r: r, opts: opts, counts: counts}
+}
+
+func (s *sanitizer) Read(p []byte) (int, error) {
+	for s.out.Len() == 0 {
+		if s.err != nil {
+			if len(s.in) > 0 {
+				s.in = s.in[s.process(s.in, true):]
+				continue
+			}
+			return 0, s.err
+		}
+		buf := make([]byte, 32*1024)
+		n, err := s.r.Read(buf)
+		s.in = append(s.in, buf[:n]...)
+		s.err = err
+		s.in = s.in[s.process(s.in, s.err != nil):]
+	}
+	return s.out.Read(p)
+}
+
+// process repairs data into the output buffer and returns the number of bytes
+// consumed. Unless at the end, the last bytes are left for the next call.
+func (s *sanitizer) process(data []byte, atEOF bool) int {
+	i := 0
+	for i < len(data) {
+		if !atEOF && len(data)-i < lookahead {
+			break
+		}
+		b := data[i]
+		if b == '&' && s.opts.Entities && bytes.HasPrefix(data[i:], []byte("&amp;")) {
+			if n := doubleEncodedLen(data[i+5:]); n > 0 {
+				s.out.WriteByte('&')
+				s.out.Write(data[i+5 : i+5+n])
+				atomic.AddInt64(&s.counts.Entities, 1)
+				i += 5 + n
+				continue
+			}
+		}
+		if b < utf8.RuneSelf {
+			if s.opts.ControlChars && b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+				atomic.AddInt64(&s.counts.ControlChars, 1)
+			} else {
+				s.out.WriteByte(b)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		switch {
+		case r == utf8.RuneError && size == 1 && s.opts.Latin1:
+			s.out.WriteRune(rune(b))
+			atomic.AddInt64(&s.counts.Latin1, 1)
+		case r == utf8.RuneError && size == 1 && s.opts.InvalidUTF8:
+			s.out.WriteRune(utf8.RuneError)
+			atomic.AddInt64(&s.counts.InvalidUTF8, 1)
+		case s.opts.ControlChars && size > 1 && !isXMLChar(r):
+			atomic.AddInt64(&s.counts.ControlChars, 1)
+		default:
+			s.out.Write(data[i : i+size])
+		}
+		i += size
+	}
+	return i
+}
+
+// doubleEncodedLen returns the length of an entity name or character
+// reference at the start of b, e.g. "amp;" or "#233;", or zero.
+func doubleEncodedLen(b []byte) int {
+	for _, name := range doubleEncoded {
+		if bytes.HasPrefix(b, []byte(name)) {
+			return len(name)
+		}
+	}
+	if len(b) < 3 || b[0] != '#' {
+		return 0
+	}
+	digits, j := "0123456789", 1
+	if b[1] == 'x' {
+		digits, j = "0123456789abcdefABCDEF", 2
+	}
+	start := j
+	for j < len(b) && j-start < 8 && strings.IndexByte(digits, b[j]) >= 0 {
+		j++
+	}
+	if j == start || j >= len(b) || b[j] != ';' {
+		return 0
+	}
+	return j + 1
+}
+
+// isXMLChar reports, whether a rune is allowed in XML 1.0 documents.
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
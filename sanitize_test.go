@@ -0,0 +1,49 @@
+package metha
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSanitize(t *testing.T) {
+	var cases = []struct {
+		about   string
+		opts    string
+		in      string
+		want    string
+		repairs RepairCounts
+	}{
+		{"nothing", "all", "<a>Café</a>", "<a>Café</a>", RepairCounts{}},
+		{"invalid utf-8", "utf8", "<a>Caf\xe9</a>", "<a>Caf�</a>", RepairCounts{InvalidUTF8: 1}},
+		{"latin-1", "utf8,latin1", "<a>Caf\xe9 \xfcber</a>", "<a>Café über</a>", RepairCounts{Latin1: 2}},
+		{"control", "control", "<a>x\x01y￾\x0b</a>", "<a>xy</a>", RepairCounts{ControlChars: 3}},
+		{"entities", "entities", "<a>&amp;amp; &amp;#233; &amp;#xE9; &amp;lt;</a>", "<a>&amp; &#233; &#xE9; &lt;</a>", RepairCounts{Entities: 4}},
+		{"plain ampersand", "entities", "<a>A &amp; B &amp;#; &amp;x;</a>", "<a>A &amp; B &amp;#; &amp;x;</a>", RepairCounts{}},
+		{"entity at end", "entities", "&amp;amp;", "&amp;", RepairCounts{Entities: 1}},
+		{"off", "", "<a>Caf\xe9</a>", "<a>Caf\xe9</a>", RepairCounts{}},
+	}
+	for _, c := range cases {
+		opts, err := ParseSanitize(c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var counts RepairCounts
+		// single bytes, so that sequences span reads
+		r := newSanitizer(iotest.OneByteReader(strings.NewReader(c.in)), opts, &counts)
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if string(b) != c.want {
+			t.Errorf("%s: got %q, want %q", c.about, b, c.want)
+		}
+		if counts != c.repairs {
+			t.Errorf("%s: got %s, want %s", c.about, &counts, &c.repairs)
+		}
+	}
+	if _, err := ParseSanitize("utf8,magic"); err == nil {
+		t.Errorf("expected error for unknown repair")
+	}
+}
@@ -0,0 +1,118 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errOutsideWindow is the reason of a pause outside the harvesting window.
+var errOutsideWindow = errors.New("outside harvesting window")
+
+// Window is a daily time span, in which requests may be sent, e.g. off-peak
+// hours of an endpoint. A window, that ends before it starts, spans midnight,
+// e.g. 22:00-06:00. Times are minutes after midnight.
+type Window struct {
+	Start int
+	End   int
+}
+
+// ParseWindow parses a window like 22:00-06:00.
+func ParseWindow(s string) (*Window, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid window %q, use HH:MM-HH:MM", s)
+	}
+	var w Window
+	for i, p := range parts {
+		hour, min, err := parseTimeOfDay(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			w.Start = hour*60 + min
+		} else {
+			w.End = hour*60 + min
+		}
+	}
+	if w.Start == w.End {
+		return nil, fmt.Errorf("invalid window %q, start and end are equal", s)
+	}
+	return &w, nil
+}
+
+// String formats the window like 22:00-06:00.
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// Contains returns true, if t is inside the window, in the given zone.
+func (w Window) Contains(t time.Time, loc *time.Location) bool {
+	t = t.In(loc)
+	m := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// Next returns t, if it is inside the window, otherwise the next start of the
+// window.
+func (w Window) Next(t time.Time, loc *time.Location) time.Time {
+	if w.Contains(t, loc) {
+		return t
+	}
+	t = t.In(loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), w.Start/60, w.Start%60, 0, 0, loc)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// waitWindow waits for the window to open, before a run sends any request.
+func (h *Harvest) waitWindow(ctx context.Context) error {
+	if h.Window == nil {
+		return nil
+	}
+	now := time.Now()
+	next := h.Window.Next(now, h.location())
+	if !next.After(now) {
+		return nil
+	}
+	h.pauseForWindow(next)
+	return sleepUntil(ctx, next)
+}
+
+// pauseForWindow announces a pause until the window opens.
+func (h *Harvest) pauseForWindow(until time.Time) {
+	log.Printf("outside harvesting window %s, pausing until %s", h.Window, until.Format(time.RFC3339))
+	h.emit(Event{Kind: EventPause, ResumeAt: &until, Err: errOutsideWindow})
+}
+
+// schedule returns the earliest start of the next request after t, given
+// the start of the last request, so that the bytes received since then do
+// not exceed MaxBandwidth on average and the request falls into the window.
+// The second value is true, if the request has to wait for the window.
+// Must be called with h.mu held.
+func (h *Harvest) schedule(t time.Time) (time.Time, bool) {
+	if h.MaxBandwidth > 0 {
+		n := atomic.LoadInt64(&h.progress.bytes)
+		if !h.lastRequest.IsZero() && n > h.lastBytes {
+			d := time.Duration(float64(n-h.lastBytes) / float64(h.MaxBandwidth) * float64(time.Second))
+			if next := h.lastRequest.Add(d); next.After(t) {
+				t = next
+			}
+		}
+		h.lastBytes = n
+	}
+	if h.Window == nil {
+		return t, false
+	}
+	next := h.Window.Next(t, h.location())
+	return next, next.After(t)
+}
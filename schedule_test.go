@@ -0,0 +1,75 @@
+package metha
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	day := func(hour, min int, loc *time.Location) time.Time {
+		return time.Date(2020, 3, 1, hour, min, 0, 0, loc)
+	}
+	var tests = []struct {
+		window string
+		t      time.Time
+		loc    *time.Location
+		want   time.Time
+	}{
+		{"22:00-06:00", day(23, 0, time.UTC), time.UTC, day(23, 0, time.UTC)},
+		{"22:00-06:00", day(5, 59, time.UTC), time.UTC, day(5, 59, time.UTC)},
+		{"22:00-06:00", day(6, 0, time.UTC), time.UTC, day(22, 0, time.UTC)},
+		{"01:00-05:00", day(12, 0, time.UTC), time.UTC, day(1, 0, time.UTC).AddDate(0, 0, 1)},
+		{"01:00-05:00", day(0, 30, time.UTC), time.UTC, day(1, 0, time.UTC)},
+		// 21:30 UTC is 22:30 in Berlin
+		{"22:00-06:00", day(21, 30, time.UTC), berlin, day(21, 30, time.UTC)},
+		{"22:00-06:00", day(20, 30, time.UTC), berlin, day(22, 0, berlin)},
+	}
+	for _, test := range tests {
+		w, err := ParseWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Next(test.t, test.loc); !got.Equal(test.want) {
+			t.Errorf("%s at %v: got %v, want %v", w, test.t, got, test.want)
+		}
+	}
+	for _, s := range []string{"", "22:00", "22:00-22:00", "22:00-25:00"} {
+		if _, err := ParseWindow(s); err == nil {
+			t.Errorf("%q: want error", s)
+		}
+	}
+}
+
+func TestScheduleBandwidth(t *testing.T) {
+	start := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := &Harvest{MaxBandwidth: 1000}
+	var tests = []struct {
+		bytes  int64
+		t      time.Time
+		want   time.Time
+		closed bool
+	}{
+		// first request
+		{0, start, start, false},
+		// 2000 bytes take two seconds
+		{2000, start.Add(time.Second), start.Add(2 * time.Second), false},
+		// already waited long enough
+		{2500, start.Add(3 * time.Second), start.Add(3 * time.Second), false},
+	}
+	for i, test := range tests {
+		h.progress.bytes = test.bytes
+		got, closed := h.schedule(test.t)
+		if !got.Equal(test.want) || closed != test.closed {
+			t.Errorf("%d: got %v %v, want %v %v", i, got, closed, test.want, test.closed)
+		}
+		h.lastRequest = got
+	}
+	h.Window = &Window{Start: 22 * 60, End: 6 * 60}
+	if got, closed := h.schedule(start.Add(time.Hour)); !closed || !got.Equal(start.Add(10*time.Hour)) {
+		t.Errorf("window: got %v %v", got, closed)
+	}
+}
@@ -0,0 +1,142 @@
+package metha
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Query selects records from the cache. Identifier and SetSpec may contain *
+// as a wildcard, e.g. "physics:*", a record matches, if any of its sets
+// matches. From and Until are compared with the datestamp, a date as Until
+// includes the whole day. Empty fields match everything.
+type Query struct {
+	Identifier     string
+	SetSpec        string
+	From           string
+	Until          string
+	IncludeDeleted bool
+}
+
+// wildcard compiles a pattern with * as wildcard into a regular expression.
+func wildcard(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// matcher returns a function, that reports whether a header entry matches
+// the query.
+func (q Query) matcher() func(HeaderEntry) bool {
+	var id, set *regexp.Regexp
+	if q.Identifier != "" {
+		id = wildcard(q.Identifier)
+	}
+	if q.SetSpec != "" {
+		set = wildcard(q.SetSpec)
+	}
+	return func(e HeaderEntry) bool {
+		if e.Deleted && !q.IncludeDeleted {
+			return false
+		}
+		if id != nil && !id.MatchString(e.Identifier) {
+			return false
+		}
+		if q.From != "" && e.DateStamp < q.From {
+			return false
+		}
+		if q.Until != "" {
+			ds := e.DateStamp
+			if len(ds) > len(q.Until) {
+				ds = ds[:len(q.Until)]
+			}
+			if ds > q.Until {
+				return false
+			}
+		}
+		if set == nil {
+			return true
+		}
+		for _, s := range e.Sets {
+			if set.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// latestHeaders returns the latest version of each record, from the header
+// index, if there is one, otherwise by reading all cached files.
+func (h *Harvest) latestHeaders() (map[string]HeaderEntry, error) {
+	if _, err := os.Stat(h.headerIndexPath()); err == nil {
+		idx, err := h.OpenHeaderIndex()
+		if err != nil {
+			return nil, err
+		}
+		return idx.latest, nil
+	}
+	latest := make(map[string]HeaderEntry)
+	for _, filename := range h.cacheFiles() {
+		entries, err := h.headerEntries(filename)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			latest[e.Identifier] = e
+		}
+	}
+	return latest, nil
+}
+
+// Select returns the latest version of all cached records matching the
+// query, ordered by datestamp and identifier. A record, whose latest version
+// moved out of a set or was deleted, is not selected, even if an earlier
+// version matches. With a header index, only files containing matching
+// records are read.
+func (h *Harvest) Select(q Query) ([]Record, error) {
+	latest, err := h.latestHeaders()
+	if err != nil {
+		return nil, err
+	}
+	match := q.matcher()
+	// offsets of the selected records by file
+	offsets := make(map[string]map[int64]bool)
+	for _, e := range latest {
+		if !match(e) {
+			continue
+		}
+		if offsets[e.File] == nil {
+			offsets[e.File] = make(map[int64]bool)
+		}
+		offsets[e.File][e.Offset] = true
+	}
+	var records []Record
+	for file, selected := range offsets {
+		filename := filepath.Join(h.Dir(), file)
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			filename += StubSuffix
+		}
+		err := eachRecordOffset(filename, func(offset int64, rec Record) error {
+			if selected[offset] {
+				records = append(records, rec)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i].Header, records[j].Header
+		if a.DateStamp != b.DateStamp {
+			return a.DateStamp < b.DateStamp
+		}
+		return a.Identifier < b.Identifier
+	})
+	return records, nil
+}
@@ -0,0 +1,74 @@
+package metha
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-select-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	record := `<record><header%s><identifier>%s</identifier><datestamp>%s</datestamp><setSpec>%s</setSpec></header></record>`
+	files := map[string]string{
+		"2016-01-31-00000000.xml": fmt.Sprintf(record+record+record,
+			"", "a", "2016-01-10", "physics:hep",
+			"", "b", "2016-01-20T10:00:00Z", "physics:astro",
+			"", "c", "2016-01-25", "math"),
+		// b moves to math, c is deleted
+		"2016-02-29-00000000.xml": fmt.Sprintf(record+record,
+			"", "b", "2016-02-05", "math",
+			` status="deleted"`, "c", "2016-02-10", "math"),
+	}
+	for name, records := range files {
+		doc := `<OAI-PMH><ListRecords>` + records + `</ListRecords></OAI-PMH>`
+		if err := ioutil.WriteFile(filepath.Join(h.Dir(), name), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var cases = []struct {
+		q    Query
+		want string
+	}{
+		{Query{}, "[a b]"},
+		{Query{IncludeDeleted: true}, "[a b c]"},
+		{Query{SetSpec: "physics:*"}, "[a]"},
+		{Query{SetSpec: "math"}, "[b]"},
+		{Query{SetSpec: "math", IncludeDeleted: true}, "[b c]"},
+		{Query{From: "2016-02-01"}, "[b]"},
+		{Query{Until: "2016-01-31"}, "[a]"},
+		{Query{Identifier: "a*"}, "[a]"},
+	}
+	for _, index := range []bool{false, true} {
+		if index {
+			if err := h.RebuildHeaderIndex(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, c := range cases {
+			records, err := h.Select(c.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, r := range records {
+				ids = append(ids, r.Header.Identifier)
+			}
+			if got := fmt.Sprint(ids); got != c.want {
+				t.Errorf("index=%v, %+v: got %v, want %v", index, c.q, got, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrDatesIgnored is returned, if an endpoint accepts from and until, but
+// responds with records from outside the requested interval.
+var ErrDatesIgnored = errors.New("endpoint seems to ignore from and until")
+
+// selectiveState keeps the first response of each interval, to detect
+// endpoints, that return the same data for every interval.
+type selectiveState struct {
+	// interval index by complete list size and first identifier
+	signatures map[string]int
+	warned     bool
+}
+
+// datesIgnored reports whether the first response of an interval suggests,
+// that the endpoint ignores from and until: either another interval started
+// with the same record and list size, or most records are outside the
+// interval. A warning is logged once.
+func (h *Harvest) datesIgnored(index int, iv Interval, resp *Response) bool {
+	records := resp.ListRecords.Records
+	if len(records) == 0 {
+		return false
+	}
+	var reason string
+
+	h.mu.Lock()
+	if h.selective.signatures == nil {
+		h.selective.signatures = make(map[string]int)
+	}
+	sig := fmt.Sprintf("%s|%s", resp.ListRecords.TokenInfo.CompleteListSize, records[0].Header.Identifier)
+	if other, ok := h.selective.signatures[sig]; ok && other != index {
+		reason = fmt.Sprintf("interval %d and %d start with the same record %s", other, index, records[0].Header.Identifier)
+	} else {
+		h.selective.signatures[sig] = index
+	}
+	h.mu.Unlock()
+
+	if reason == "" {
+		// datestamps are compared by day, with some tolerance
+		begin := iv.Begin.Add(-h.overlap(index)).AddDate(0, 0, -1).Format("2006-01-02")
+		end := iv.End.AddDate(0, 0, 1).Format("2006-01-02")
+		var outside int
+		for _, rec := range records {
+			ds := rec.Header.DateStamp
+			if len(ds) < 10 {
+				continue
+			}
+			if ds[:10] < begin || ds[:10] > end {
+				outside++
+			}
+		}
+		if outside*2 > len(records) {
+			reason = fmt.Sprintf("%d of %d records outside %s", outside, len(records), iv)
+		}
+	}
+	if reason == "" {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.selective.warned {
+		h.selective.warned = true
+		log.Printf("warning: %s: %s, consider -no-intervals", ErrDatesIgnored, reason)
+	}
+	return true
+}
+
+// switchToNonSelective drops all temporary files and harvests everything at
+// once, after the endpoint was found to ignore from and until. Intervals
+// moved into place before are kept.
+func (h *Harvest) switchToNonSelective(ctx context.Context) error {
+	log.Printf("switching to harvest without intervals")
+	if err := h.cleanupTemporaryFiles(); err != nil {
+		return err
+	}
+	h.DisableSelectiveHarvesting = true
+	h.progress.interval = 0
+	h.progress.intervals = 1
+	return h.runInterval(ctx, Interval{})
+}
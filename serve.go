@@ -0,0 +1,540 @@
+package metha
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultServePageSize is the number of records or headers per response.
+const DefaultServePageSize = 100
+
+// Server exposes a harvested cache as an OAI-PMH endpoint, so others can
+// harvest a mirror instead of the origin. Only the latest version of each
+// record is served. To find it, all cached records are read once by
+// NewServer and Refresh and an index of identifiers is kept in memory.
+type Server struct {
+	Harvest  *Harvest
+	PageSize int
+
+	mu       sync.RWMutex
+	files    []string
+	latest   map[string]recordPosition
+	sets     []string
+	earliest string
+	identify *Identify
+}
+
+// recordPosition is the file and the position of a record in the file.
+type recordPosition struct {
+	file string
+	pos  int
+}
+
+// NewServer creates a server for a harvest and indexes its records.
+func NewServer(h *Harvest) (*Server, error) {
+	s := &Server{Harvest: h, PageSize: DefaultServePageSize}
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh indexes the cache again, e.g. after a harvest added files.
+func (s *Server) Refresh() error {
+	files := s.Harvest.cacheFiles()
+	latest := make(map[string]recordPosition)
+	specs := make(map[string]bool)
+	var earliest string
+	for _, filename := range files {
+		base := filepath.Base(filename)
+		err := eachRecordPosition(filename, func(pos int, rec Record) error {
+			latest[rec.Header.Identifier] = recordPosition{file: base, pos: pos}
+			for _, spec := range rec.Header.SetSpec {
+				specs[spec] = true
+			}
+			if ds := rec.Header.DateStamp; ds != "" && (earliest == "" || ds < earliest) {
+				earliest = ds
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var sets []string
+	for spec := range specs {
+		sets = append(sets, spec)
+	}
+	sort.Strings(sets)
+
+	identify, _, _ := s.Harvest.cachedIdentify()
+	if identify == nil {
+		identify = s.Harvest.Identify
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files, s.latest, s.sets, s.earliest, s.identify = files, latest, sets, earliest, identify
+	return nil
+}
+
+// eachRecordPosition calls fn for every record of a file with its position.
+func eachRecordPosition(filename string, fn func(int, Record) error) error {
+	var pos int
+	return eachResponse(filename, func(_ string, resp *Response) error {
+		for _, rec := range resp.ListRecords.Records {
+			if err := fn(pos, rec); err != nil {
+				return err
+			}
+			pos++
+		}
+		return nil
+	})
+}
+
+// Envelope and elements of a served response. Unlike Response, empty
+// elements and attributes are left out.
+type (
+	serveEnvelope struct {
+		XMLName             xml.Name             `xml:"http://www.openarchives.org/OAI/2.0/ OAI-PMH"`
+		ResponseDate        string               `xml:"responseDate"`
+		Request             serveRequest         `xml:"request"`
+		Error               *OAIError            `xml:"error,omitempty"`
+		Identify            *serveIdentify       `xml:"Identify,omitempty"`
+		ListMetadataFormats *ListMetadataFormats `xml:"ListMetadataFormats,omitempty"`
+		ListSets            *serveSets           `xml:"ListSets,omitempty"`
+		GetRecord           *serveRecords        `xml:"GetRecord,omitempty"`
+		ListRecords         *serveRecords        `xml:"ListRecords,omitempty"`
+		ListIdentifiers     *serveHeaders        `xml:"ListIdentifiers,omitempty"`
+	}
+	serveRequest struct {
+		Verb            string `xml:"verb,attr,omitempty"`
+		Identifier      string `xml:"identifier,attr,omitempty"`
+		MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+		From            string `xml:"from,attr,omitempty"`
+		Until           string `xml:"until,attr,omitempty"`
+		Set             string `xml:"set,attr,omitempty"`
+		ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+		BaseURL         string `xml:",chardata"`
+	}
+	serveIdentify struct {
+		RepositoryName    string   `xml:"repositoryName"`
+		BaseURL           string   `xml:"baseURL"`
+		ProtocolVersion   string   `xml:"protocolVersion"`
+		AdminEmail        []string `xml:"adminEmail"`
+		EarliestDatestamp string   `xml:"earliestDatestamp"`
+		DeletedRecord     string   `xml:"deletedRecord"`
+		Granularity       string   `xml:"granularity"`
+	}
+	serveSets struct {
+		Sets []serveSet `xml:"set"`
+	}
+	serveSet struct {
+		SetSpec string `xml:"setSpec"`
+		SetName string `xml:"setName"`
+	}
+	serveHeader struct {
+		Status     string   `xml:"status,attr,omitempty"`
+		Identifier string   `xml:"identifier"`
+		DateStamp  string   `xml:"datestamp"`
+		SetSpec    []string `xml:"setSpec"`
+	}
+	serveRecord struct {
+		Header   serveHeader `xml:"header"`
+		Metadata *Metadata   `xml:"metadata,omitempty"`
+		About    []About     `xml:"about,omitempty"`
+	}
+	serveRecords struct {
+		Records []serveRecord `xml:"record"`
+		Token   *serveToken   `xml:"resumptionToken,omitempty"`
+	}
+	serveHeaders struct {
+		Headers []serveHeader `xml:"header"`
+		Token   *serveToken   `xml:"resumptionToken,omitempty"`
+	}
+	serveToken struct {
+		Value  string `xml:",chardata"`
+		Cursor int    `xml:"cursor,attr"`
+	}
+)
+
+// newServeRecord converts a cached record, deleted records have no metadata
+// and no about containers.
+func newServeRecord(rec Record) serveRecord {
+	r := serveRecord{Header: serveHeader{
+		Status:     rec.Header.Status,
+		Identifier: rec.Header.Identifier,
+		DateStamp:  rec.Header.DateStamp,
+		SetSpec:    rec.Header.SetSpec,
+	}}
+	if rec.Header.Status != "deleted" {
+		md := rec.Metadata
+		r.Metadata = &md
+		r.About = rec.About
+	}
+	return r
+}
+
+// ServeHTTP answers a single OAI-PMH request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	env := &serveEnvelope{
+		ResponseDate: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Request:      serveRequest{BaseURL: requestBaseURL(r)},
+	}
+	if err := r.ParseForm(); err != nil {
+		env.Error = &OAIError{Code: ErrBadArgument.Code, Message: err.Error()}
+	} else {
+		s.handle(env, r.Form)
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(env); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// requestBaseURL returns the URL of the endpoint as seen by the client.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// handle validates the arguments and fills the envelope.
+func (s *Server) handle(env *serveEnvelope, args url.Values) {
+	fail := func(code, format string, a ...interface{}) {
+		env.Error = &OAIError{Code: code, Message: fmt.Sprintf(format, a...)}
+	}
+	verb := args.Get("verb")
+	if _, ok := arguments[verb]; !ok {
+		fail(ErrBadVerb.Code, "illegal verb: %q", verb)
+		return
+	}
+	for k, v := range args {
+		if len(v) > 1 {
+			fail(ErrBadArgument.Code, "repeated argument: %s", k)
+			return
+		}
+		if k != "verb" && !validArgument(verb, k) {
+			fail(ErrBadArgument.Code, "illegal argument: %s", k)
+			return
+		}
+	}
+	if args.Get("resumptionToken") != "" && len(args) > 2 {
+		fail(ErrBadArgument.Code, "resumptionToken is an exclusive argument")
+		return
+	}
+	req := Request{
+		Verb:            verb,
+		Identifier:      args.Get("identifier"),
+		MetadataPrefix:  args.Get("metadataPrefix"),
+		From:            args.Get("from"),
+		Until:           args.Get("until"),
+		Set:             args.Get("set"),
+		ResumptionToken: args.Get("resumptionToken"),
+	}
+	if err := req.Validate(); err != nil {
+		fail(ErrBadArgument.Code, "%s", err)
+		return
+	}
+	env.Request = serveRequest{
+		Verb:            req.Verb,
+		Identifier:      req.Identifier,
+		MetadataPrefix:  req.MetadataPrefix,
+		From:            req.From,
+		Until:           req.Until,
+		Set:             req.Set,
+		ResumptionToken: req.ResumptionToken,
+		BaseURL:         env.Request.BaseURL,
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch verb {
+	case "Identify":
+		env.Identify = s.identifyResponse(env.Request.BaseURL)
+	case "ListMetadataFormats":
+		if id := args.Get("identifier"); id != "" {
+			if _, ok := s.latest[id]; !ok {
+				fail(ErrIDDoesNotExist.Code, "%s", id)
+				return
+			}
+		}
+		f := MetadataFormat{MetadataPrefix: s.Harvest.Format}
+		if s.Harvest.Format == "oai_dc" {
+			f.Schema = "http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+			f.MetadataNamespace = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+		}
+		env.ListMetadataFormats = &ListMetadataFormats{MetadataFormat: []MetadataFormat{f}}
+	case "ListSets":
+		if args.Get("resumptionToken") != "" {
+			fail(ErrBadResumptionToken.Code, "sets are not paged")
+			return
+		}
+		if len(s.sets) == 0 {
+			fail(ErrNoSetHierarchy.Code, "no sets")
+			return
+		}
+		env.ListSets = &serveSets{}
+		for _, spec := range s.sets {
+			env.ListSets.Sets = append(env.ListSets.Sets, serveSet{SetSpec: spec, SetName: spec})
+		}
+	case "GetRecord":
+		if args.Get("metadataPrefix") != s.Harvest.Format {
+			fail(ErrCannotDisseminateFormat.Code, "%s", args.Get("metadataPrefix"))
+			return
+		}
+		rec, err := s.record(args.Get("identifier"))
+		if err != nil {
+			fail(ErrIDDoesNotExist.Code, "%s", err)
+			return
+		}
+		env.GetRecord = &serveRecords{Records: []serveRecord{newServeRecord(*rec)}}
+	case "ListRecords", "ListIdentifiers":
+		q, oaiErr := s.parseListQuery(args)
+		if oaiErr != nil {
+			env.Error = oaiErr
+			return
+		}
+		records, token, err := s.list(q)
+		if err != nil {
+			fail(ErrInternalException.Code, "%s", err)
+			return
+		}
+		if len(records) == 0 {
+			fail(ErrNoRecordsMatch.Code, "no records")
+			return
+		}
+		if verb == "ListIdentifiers" {
+			env.ListIdentifiers = &serveHeaders{Token: token}
+			for _, rec := range records {
+				env.ListIdentifiers.Headers = append(env.ListIdentifiers.Headers, newServeRecord(rec).Header)
+			}
+			return
+		}
+		env.ListRecords = &serveRecords{Token: token}
+		for _, rec := range records {
+			env.ListRecords.Records = append(env.ListRecords.Records, newServeRecord(rec))
+		}
+	}
+}
+
+// identifyResponse describes the mirror, based on the Identify response of
+// the origin, if known.
+func (s *Server) identifyResponse(baseURL string) *serveIdentify {
+	id := &serveIdentify{
+		RepositoryName:    s.Harvest.BaseURL + " (mirror)",
+		BaseURL:           baseURL,
+		ProtocolVersion:   "2.0",
+		EarliestDatestamp: s.earliest,
+		DeletedRecord:     "transient",
+		Granularity:       "YYYY-MM-DD",
+	}
+	if s.identify != nil {
+		if s.identify.RepositoryName != "" {
+			id.RepositoryName = s.identify.RepositoryName + " (mirror)"
+		}
+		id.AdminEmail = s.identify.AdminEmail
+		if s.identify.DeletedRecord != "" {
+			id.DeletedRecord = s.identify.DeletedRecord
+		}
+		if s.identify.Granularity != "" {
+			id.Granularity = s.identify.Granularity
+		}
+	}
+	if id.Granularity == "YYYY-MM-DD" && len(id.EarliestDatestamp) > 10 {
+		id.EarliestDatestamp = id.EarliestDatestamp[:10]
+	}
+	return id
+}
+
+// record returns the latest version of a record.
+func (s *Server) record(identifier string) (*Record, error) {
+	p, ok := s.latest[identifier]
+	if !ok {
+		return nil, fmt.Errorf("%s", identifier)
+	}
+	var found *Record
+	for _, filename := range s.files {
+		if filepath.Base(filename) != p.file {
+			continue
+		}
+		err := eachRecordPosition(filename, func(pos int, rec Record) error {
+			if pos == p.pos {
+				found = &rec
+				return ErrStop
+			}
+			return nil
+		})
+		if err != nil && err != ErrStop {
+			return nil, err
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: file changed, refresh needed", identifier)
+	}
+	return found, nil
+}
+
+// listQuery is the state of a list request, it is encoded into resumption
+// tokens. Listing continues at record pos of file.
+type listQuery struct {
+	from, until, set string
+	file             string
+	pos, cursor      int
+}
+
+// encode turns a query into a resumption token.
+func (q listQuery) encode() string {
+	v := url.Values{}
+	v.Set("from", q.from)
+	v.Set("until", q.until)
+	v.Set("set", q.set)
+	v.Set("file", q.file)
+	v.Set("pos", strconv.Itoa(q.pos))
+	v.Set("cursor", strconv.Itoa(q.cursor))
+	return base64.RawURLEncoding.EncodeToString([]byte(v.Encode()))
+}
+
+// parseListQuery reads arguments or a resumption token.
+func (s *Server) parseListQuery(args url.Values) (listQuery, *OAIError) {
+	var q listQuery
+	if token := args.Get("resumptionToken"); token != "" {
+		b, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return q, &OAIError{Code: ErrBadResumptionToken.Code, Message: "invalid token"}
+		}
+		v, err := url.ParseQuery(string(b))
+		if err != nil {
+			return q, &OAIError{Code: ErrBadResumptionToken.Code, Message: "invalid token"}
+		}
+		q = listQuery{from: v.Get("from"), until: v.Get("until"), set: v.Get("set"), file: v.Get("file")}
+		if q.pos, err = strconv.Atoi(v.Get("pos")); err != nil {
+			return q, &OAIError{Code: ErrBadResumptionToken.Code, Message: "invalid token"}
+		}
+		if q.cursor, err = strconv.Atoi(v.Get("cursor")); err != nil {
+			return q, &OAIError{Code: ErrBadResumptionToken.Code, Message: "invalid token"}
+		}
+		return q, nil
+	}
+	switch prefix := args.Get("metadataPrefix"); {
+	case prefix == "":
+		return q, &OAIError{Code: ErrBadArgument.Code, Message: "metadataPrefix required"}
+	case prefix != s.Harvest.Format:
+		return q, &OAIError{Code: ErrCannotDisseminateFormat.Code, Message: prefix}
+	}
+	q.from, q.until, q.set = args.Get("from"), args.Get("until"), args.Get("set")
+	for _, d := range []string{q.from, q.until} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err == nil {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02T15:04:05Z", d); err == nil {
+			continue
+		}
+		return q, &OAIError{Code: ErrBadArgument.Code, Message: fmt.Sprintf("invalid date: %s", d)}
+	}
+	if q.from != "" && q.until != "" && len(q.from) != len(q.until) {
+		return q, &OAIError{Code: ErrBadArgument.Code, Message: "from and until must have the same granularity"}
+	}
+	if q.set != "" && len(s.sets) == 0 {
+		return q, &OAIError{Code: ErrNoSetHierarchy.Code, Message: "no sets"}
+	}
+	return q, nil
+}
+
+// matches reports whether a record is the latest version and within the
+// range and set of a query.
+func (s *Server) matches(q listQuery, file string, pos int, rec Record) bool {
+	if s.latest[rec.Header.Identifier] != (recordPosition{file: file, pos: pos}) {
+		return false
+	}
+	ds := rec.Header.DateStamp
+	if q.from != "" && !(truncate(ds, len(q.from)) >= q.from) {
+		return false
+	}
+	if q.until != "" && !(truncate(ds, len(q.until)) <= q.until) {
+		return false
+	}
+	if q.set == "" {
+		return true
+	}
+	for _, spec := range rec.Header.SetSpec {
+		if spec == q.set || strings.HasPrefix(spec, q.set+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// list returns a page of records and the token for the next page, if any.
+func (s *Server) list(q listQuery) ([]Record, *serveToken, error) {
+	size := s.PageSize
+	if size <= 0 {
+		size = DefaultServePageSize
+	}
+	var (
+		records []Record
+		token   *serveToken
+	)
+	for _, filename := range s.files {
+		base := filepath.Base(filename)
+		if base < q.file {
+			continue
+		}
+		// files are named after the end of their interval and contain no
+		// later records, so earlier files can be skipped
+		if q.from != "" && truncate(base, 10) < truncate(q.from, 10) {
+			continue
+		}
+		err := eachRecordPosition(filename, func(pos int, rec Record) error {
+			if base == q.file && pos < q.pos {
+				return nil
+			}
+			if !s.matches(q, base, pos, rec) {
+				return nil
+			}
+			if len(records) == size {
+				next := q
+				next.file, next.pos, next.cursor = base, pos, q.cursor+size
+				token = &serveToken{Value: next.encode(), Cursor: q.cursor}
+				return ErrStop
+			}
+			records = append(records, rec)
+			return nil
+		})
+		if err == ErrStop {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if token == nil && q.cursor > 0 {
+		// last page of a list, empty token
+		token = &serveToken{Cursor: q.cursor}
+	}
+	return records, token, nil
+}
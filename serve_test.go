@@ -0,0 +1,148 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-serve-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	record := `<record><header%s><identifier>%s</identifier><datestamp>%s</datestamp><setSpec>%s</setSpec></header><metadata><dc>%s</dc></metadata></record>`
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("from") {
+		case "2016-01-01":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+record+`</ListRecords></OAI-PMH>`,
+				"", "1", "2016-01-10", "a", "old", "", "2", "2016-01-20", "a", "two")
+		default:
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+record+`</ListRecords></OAI-PMH>`,
+				"", "1", "2016-02-05", "a", "new", ` status="deleted"`, "3", "2016-02-10", "b:c", "")
+		}
+	}))
+	defer origin.Close()
+
+	h := &Harvest{
+		BaseURL:           origin.URL,
+		Format:            "oai_dc",
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+		Started:           time.Now(),
+	}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	iv := Interval{
+		Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2016, 2, 29, 23, 59, 59, 0, time.UTC),
+	}
+	if err := h.runIntervals(context.Background(), iv); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewServer(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.PageSize = 1
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	client := Client{Doer: http.DefaultClient}
+	var cases = []struct {
+		req  Request
+		ids  string
+		code string
+	}{
+		{Request{Verb: "ListRecords", MetadataPrefix: "oai_dc"}, "2 1 3", ""},
+		{Request{Verb: "ListIdentifiers", MetadataPrefix: "oai_dc", From: "2016-02-01"}, "1 3", ""},
+		{Request{Verb: "ListRecords", MetadataPrefix: "oai_dc", Set: "b"}, "3", ""},
+		{Request{Verb: "ListRecords", MetadataPrefix: "oai_dc", Until: "2016-01-01"}, "", "noRecordsMatch"},
+		{Request{Verb: "ListRecords", MetadataPrefix: "marcxml"}, "", "cannotDisseminateFormat"},
+		{Request{Verb: "ListRecords", ResumptionToken: "x"}, "", "badResumptionToken"},
+		{Request{Verb: "GetRecord", MetadataPrefix: "oai_dc", Identifier: "1"}, "1", ""},
+		{Request{Verb: "GetRecord", MetadataPrefix: "oai_dc", Identifier: "9"}, "", "idDoesNotExist"},
+	}
+	for _, c := range cases {
+		req := c.req
+		req.BaseURL = srv.URL
+		var ids []string
+		for {
+			resp, err := client.Do(&req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error.Code != c.code {
+				t.Errorf("%v: got error %q, want %q", c.req, resp.Error.Code, c.code)
+			}
+			for _, rec := range resp.ListRecords.Records {
+				ids = append(ids, rec.Header.Identifier)
+			}
+			for _, header := range resp.ListIdentifiers.Headers {
+				ids = append(ids, header.Identifier)
+			}
+			if id := resp.GetRecord.Record.Header.Identifier; id != "" {
+				ids = append(ids, id)
+				if md := string(resp.GetRecord.Record.Metadata.Body); md != "<dc>new</dc>" {
+					t.Errorf("got metadata %s, want latest version", md)
+				}
+			}
+			token := resp.GetResumptionToken()
+			if token == "" {
+				break
+			}
+			req = Request{BaseURL: srv.URL, Verb: c.req.Verb, ResumptionToken: token}
+		}
+		if got := strings.Join(ids, " "); got != c.ids {
+			t.Errorf("%v: got %q, want %q", c.req, got, c.ids)
+		}
+	}
+
+	// arguments are checked like the requests of a client
+	var invalid = []struct {
+		query string
+		code  string
+	}{
+		{"verb=Nope", "badVerb"},
+		{"verb=GetRecord&metadataPrefix=oai_dc", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&foo=1", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&from=2016-01-01&until=2016-01-31T00:00:00Z", "badArgument"},
+		{"verb=ListMetadataFormats&resumptionToken=x", "badArgument"},
+		{"verb=ListRecords&metadataPrefix=oai_dc&resumptionToken=x", "badArgument"},
+	}
+	for _, c := range invalid {
+		resp, err := http.Get(srv.URL + "?" + c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), `code="`+c.code+`"`) {
+			t.Errorf("%s: got %s, want %s", c.query, b, c.code)
+		}
+	}
+
+	// the mirror can be harvested with metha itself
+	mirror, err := NewHarvest(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mirror.Identify.EarliestDatestamp != "2016-01-10" {
+		t.Errorf("got earliest datestamp %q, want 2016-01-10", mirror.Identify.EarliestDatestamp)
+	}
+}
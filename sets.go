@@ -0,0 +1,185 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNoSets is returned, if no set is left after applying include and
+// exclude patterns.
+var ErrNoSets = errors.New("no sets match")
+
+// compileSetPattern compiles an include or exclude pattern. Patterns enclosed
+// in slashes are regular expressions, e.g. /^embargoed-[0-9]+$/, others are
+// matched as a whole with * as wildcard, e.g. embargoed-*.
+func compileSetPattern(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid set pattern %s: %w", pattern, err)
+		}
+		return re, nil
+	}
+	return wildcard(pattern), nil
+}
+
+// matchAny returns true, if any pattern matches s.
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListSets returns all sets of the endpoint, following resumption tokens up
+// to MaxRequests.
+func (h *Harvest) ListSets(ctx context.Context) ([]Set, error) {
+	c := h.client(30*time.Second, h.retryPolicy())
+	var (
+		sets  []Set
+		token string
+	)
+	for i := 0; i < h.MaxRequests || h.MaxRequests == 0; i++ {
+		req := Request{Verb: "ListSets", BaseURL: h.BaseURL, ResumptionToken: token, UsePost: h.UsePost}
+		resp, err := c.DoContext(ctx, &req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Error.Code != "" {
+			return nil, resp.Error
+		}
+		sets = append(sets, resp.ListSets.Set...)
+		if token = resp.GetResumptionToken(); token == "" {
+			return sets, nil
+		}
+	}
+	return nil, fmt.Errorf("ListSets: max requests limit (%d) reached", h.MaxRequests)
+}
+
+// multiSet returns true, if the harvest covers several sets.
+func (h *Harvest) multiSet() bool {
+	return len(h.Sets) > 0 || len(h.SetInclude) > 0 || len(h.SetExclude) > 0
+}
+
+// ResolveSets returns the sets, a harvest with Sets, SetInclude or
+// SetExclude covers. Without Sets, Set is filtered, if given, otherwise the
+// sets of the endpoint are listed.
+func (h *Harvest) ResolveSets(ctx context.Context) ([]string, error) {
+	var include, exclude []*regexp.Regexp
+	for _, p := range h.SetInclude {
+		re, err := compileSetPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		include = append(include, re)
+	}
+	for _, p := range h.SetExclude {
+		re, err := compileSetPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, re)
+	}
+	candidates := h.Sets
+	if len(candidates) == 0 && h.Set != "" {
+		candidates = []string{h.Set}
+	}
+	if len(candidates) == 0 {
+		sets, err := h.ListSets(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list sets: %w", err)
+		}
+		for _, s := range sets {
+			candidates = append(candidates, strings.TrimSpace(s.SetSpec))
+		}
+	}
+	var (
+		result []string
+		seen   = make(map[string]bool)
+	)
+	for _, s := range candidates {
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		if len(include) > 0 && !matchAny(include, s) {
+			continue
+		}
+		if matchAny(exclude, s) {
+			continue
+		}
+		result = append(result, s)
+	}
+	if len(result) == 0 {
+		return nil, ErrNoSets
+	}
+	return result, nil
+}
+
+// runSets harvests each resolved set, like runFormats does for formats. A
+// failing set does not stop the others, all errors are returned together.
+// The sets harvested completely are listed in the result. Run limits apply
+// to all sets together; once a limit stops a set, the remaining sets are left
+// for the next run.
+func (h *Harvest) runSets(ctx context.Context) (*Result, error) {
+	sets, err := h.ResolveSets(ctx)
+	if err != nil {
+		return &Result{Started: time.Now()}, err
+	}
+	defer func(set string, earlier runCounts) {
+		h.Set, h.earlier = set, earlier
+	}(h.Set, h.earlier)
+	earlier := h.earlier
+
+	var (
+		errs   []error
+		synced int
+	)
+	result := &Result{Started: time.Now()}
+	log.Printf("harvesting %d sets: %s", len(sets), strings.Join(sets, ", "))
+	for _, set := range sets {
+		h.Set = set
+		h.earlier = earlier.plus(result)
+		var r *Result
+		if len(h.Formats) > 0 {
+			r, err = h.runFormats(ctx)
+		} else {
+			r, err = h.runFormatResult(ctx)
+		}
+		result.add(r)
+		result.Duration = time.Since(result.Started)
+		switch {
+		case err == nil && r.AlreadySynced:
+			synced++
+			result.Sets = append(result.Sets, set)
+		case err == nil && r.Stopped:
+			// partially harvested, continued by the next run
+		case err == nil:
+			result.Sets = append(result.Sets, set)
+		case ctx.Err() != nil:
+			h.finalized = result.Files
+			return result, err
+		default:
+			log.Printf("set %s failed: %s", set, err)
+			errs = append(errs, fmt.Errorf("set %s: %w", set, err))
+		}
+		if r.Stopped {
+			log.Printf("run limit reached with set %s, stopping", set)
+			break
+		}
+	}
+	h.finalized = result.Files
+	log.Printf("covered %d of %d sets", len(result.Sets), len(sets))
+	if len(errs) > 0 {
+		return result, &MultiError{Errors: errs}
+	}
+	result.AlreadySynced = synced == len(sets)
+	return result, nil
+}
@@ -0,0 +1,150 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolveSets(t *testing.T) {
+	var listed int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listed++
+		switch r.URL.Query().Get("resumptionToken") {
+		case "":
+			fmt.Fprint(w, `<OAI-PMH><ListSets><set><setSpec>physics</setSpec></set><set><setSpec>physics:hep</setSpec></set><set><setSpec>embargoed-1</setSpec></set><resumptionToken>x</resumptionToken></ListSets></OAI-PMH>`)
+		default:
+			fmt.Fprint(w, `<OAI-PMH><ListSets><set><setSpec>math</setSpec></set><set><setSpec>embargoed-2</setSpec></set></ListSets></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		h      Harvest
+		want   []string
+		err    error
+		listed bool
+	}{
+		{Harvest{SetExclude: []string{"embargoed-*"}}, []string{"physics", "physics:hep", "math"}, nil, true},
+		{Harvest{SetInclude: []string{"physics*"}, SetExclude: []string{"/:hep$/"}}, []string{"physics"}, nil, true},
+		{Harvest{SetInclude: []string{"/^(math|physics)$/"}}, []string{"physics", "math"}, nil, true},
+		{Harvest{Sets: []string{"a", "b", "a", "c-1"}, SetExclude: []string{"c-*"}}, []string{"a", "b"}, nil, false},
+		{Harvest{Set: "embargoed-3", SetExclude: []string{"embargoed-*"}}, nil, ErrNoSets, false},
+	}
+	for i := range cases {
+		c := &cases[i]
+		listed = 0
+		c.h.BaseURL = srv.URL
+		got, err := c.h.ResolveSets(context.Background())
+		if !errors.Is(err, c.err) {
+			t.Errorf("%d: got %v, want %v", i, err, c.err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%d: got %v, want %v", i, got, c.want)
+		}
+		if (listed > 0) != c.listed {
+			t.Errorf("%d: got %d ListSets requests", i, listed)
+		}
+	}
+	h := Harvest{BaseURL: srv.URL, SetInclude: []string{"/(/"}}
+	if _, err := h.ResolveSets(context.Background()); err == nil {
+		t.Errorf("invalid regular expression accepted")
+	}
+}
+
+func TestRunSets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-sets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case q.Get("verb") == "ListSets":
+			fmt.Fprint(w, `<OAI-PMH><ListSets><set><setSpec>a</setSpec></set><set><setSpec>b</setSpec></set><set><setSpec>embargoed</setSpec></set></ListSets></OAI-PMH>`)
+		case q.Get("set") == "embargoed":
+			t.Errorf("excluded set harvested")
+		case q.Get("set") == "b":
+			fmt.Fprint(w, `<OAI-PMH><error code="badArgument"/></OAI-PMH>`)
+		default:
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		SetExclude:        []string{"embargoed"},
+		From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+	}
+	r, err := h.RunResult(context.Background())
+	if merr, ok := err.(*MultiError); !ok || len(merr.Errors) != 1 {
+		t.Errorf("got %v, want a single error for set b", err)
+	}
+	if !reflect.DeepEqual(r.Sets, []string{"a"}) {
+		t.Errorf("got covered sets %v, want [a]", r.Sets)
+	}
+	if h.Set != "" {
+		t.Errorf("set not restored: %s", h.Set)
+	}
+	a := &Harvest{BaseURL: srv.URL, Format: "oai_dc", Set: "a"}
+	if len(a.Files()) == 0 {
+		t.Errorf("no files harvested for set a")
+	}
+}
+
+func TestRunSetsLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-sets-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+			return
+		}
+		fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		Sets:              []string{"a", "b", "c"},
+		From:              time.Now().UTC().AddDate(0, 0, -3).Format("2006-01-02"),
+		DailyInterval:     true,
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		MaxRecords:        4,
+	}
+	r, err := h.RunResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a is complete, the limit stops b, c is left for the next run
+	if !reflect.DeepEqual(r.Sets, []string{"a"}) || !r.Stopped || r.Records != 4 {
+		t.Errorf("got sets %v, stopped %v, %d records, want [a], true, 4", r.Sets, r.Stopped, r.Records)
+	}
+	c := &Harvest{BaseURL: srv.URL, Format: "oai_dc", Set: "c"}
+	if len(c.Files()) > 0 {
+		t.Errorf("set c harvested after the limit was reached")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package metha
+
+import (
+	"os"
+	"syscall"
+)
+
+// interruptSignals stop a harvest gracefully.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
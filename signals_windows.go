@@ -0,0 +1,7 @@
+package metha
+
+import "os"
+
+// interruptSignals stop a harvest gracefully. On windows, only Ctrl-C and
+// Ctrl-Break are delivered, as os.Interrupt.
+var interruptSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,188 @@
+package metha
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Exchange is a single recorded request and its outcome.
+type Exchange struct {
+	Started    time.Time     `json:"started"`
+	Method     string        `json:"method"`
+	URL        string        `json:"url"`
+	StatusCode int           `json:"status,omitempty"`
+	Header     http.Header   `json:"header,omitempty"`
+	Body       []byte        `json:"body,omitempty"`
+	Latency    time.Duration `json:"latency"`
+	Err        string        `json:"err,omitempty"`
+}
+
+// RecordingTransport passes requests to an underlying transport and writes
+// each exchange as a line of JSON, so an endpoints behaviour can be replayed
+// later with a SimulatedTransport.
+type RecordingTransport struct {
+	Transport http.RoundTripper
+	// SkipBody does not record response bodies, only timing and errors.
+	SkipBody bool
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecordingTransport records exchanges to w.
+func NewRecordingTransport(t http.RoundTripper, w io.Writer) *RecordingTransport {
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &RecordingTransport{Transport: t, enc: json.NewEncoder(w)}
+}
+
+// RoundTrip executes and records a request.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := Exchange{Started: time.Now(), Method: req.Method, URL: req.URL.String()}
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		ex.Latency = time.Since(ex.Started)
+		ex.Err = err.Error()
+		return nil, t.record(ex, err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	ex.Latency = time.Since(ex.Started)
+	if err != nil {
+		ex.Err = err.Error()
+		return nil, t.record(ex, err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	ex.StatusCode = resp.StatusCode
+	ex.Header = resp.Header
+	if !t.SkipBody {
+		ex.Body = b
+	}
+	return resp, t.record(ex, nil)
+}
+
+// record writes the exchange and passes through the original error.
+func (t *RecordingTransport) record(ex Exchange, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e := t.enc.Encode(ex); e != nil && err == nil {
+		return e
+	}
+	return err
+}
+
+// Profile is a recorded sequence of exchanges with an endpoint.
+type Profile struct {
+	Exchanges []Exchange
+}
+
+// LoadProfile reads exchanges written by a RecordingTransport.
+func LoadProfile(r io.Reader) (*Profile, error) {
+	var p Profile
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	for {
+		var ex Exchange
+		if err := dec.Decode(&ex); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		p.Exchanges = append(p.Exchanges, ex)
+	}
+	return &p, nil
+}
+
+// ErrNotRecorded is returned by a simulation for unknown requests.
+var ErrNotRecorded = errors.New("request not recorded")
+
+// SimulatedTransport replays a profile. Requests are matched by method and
+// URL, repeated requests (e.g. retries) get the recorded exchanges in order,
+// the last one is repeated, once they are used up. Requests, that have not
+// been recorded verbatim (e.g. because the harvest end date differs) get the
+// recorded exchanges in sequence. Latencies are divided by Speed, so a speed
+// of 10 replays ten times faster.
+type SimulatedTransport struct {
+	Profile *Profile
+	Speed   float64
+
+	mu   sync.Mutex
+	used map[string]int
+	pos  int
+}
+
+// NewSimulatedTransport creates a transport replaying a profile at a speed.
+func NewSimulatedTransport(p *Profile, speed float64) *SimulatedTransport {
+	return &SimulatedTransport{Profile: p, Speed: speed, used: make(map[string]int)}
+}
+
+// next returns the next exchange for a request.
+func (t *SimulatedTransport) next(req *http.Request) (Exchange, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := req.Method + " " + req.URL.String()
+	var matches []Exchange
+	for _, ex := range t.Profile.Exchanges {
+		if ex.Method+" "+ex.URL == key {
+			matches = append(matches, ex)
+		}
+	}
+	if len(matches) == 0 {
+		if t.pos >= len(t.Profile.Exchanges) {
+			return Exchange{}, false
+		}
+		t.pos++
+		return t.Profile.Exchanges[t.pos-1], true
+	}
+	i := t.used[key]
+	if i >= len(matches) {
+		i = len(matches) - 1
+	}
+	t.used[key]++
+	return matches[i], true
+}
+
+// RoundTrip replays the recorded exchange for a request, after waiting for
+// the scaled latency.
+func (t *SimulatedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex, ok := t.next(req)
+	if !ok {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL, ErrNotRecorded)
+	}
+	delay := ex.Latency
+	if t.Speed > 0 {
+		delay = time.Duration(float64(delay) / t.Speed)
+	}
+	select {
+	case <-time.After(delay):
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if ex.Err != "" {
+		return nil, errors.New(ex.Err)
+	}
+	header := ex.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", ex.StatusCode, http.StatusText(ex.StatusCode)),
+		StatusCode:    ex.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(ex.Body)),
+		ContentLength: int64(len(ex.Body)),
+		Request:       req,
+	}, nil
+}
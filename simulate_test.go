@@ -0,0 +1,148 @@
+package metha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulatedTransport(t *testing.T) {
+	p := &Profile{Exchanges: []Exchange{
+		{Method: "GET", URL: "http://x/a", StatusCode: http.StatusServiceUnavailable},
+		{Method: "GET", URL: "http://x/a", StatusCode: http.StatusOK, Body: []byte("a")},
+		{Method: "GET", URL: "http://x/b", Err: "connection reset"},
+		{Method: "GET", URL: "http://x/c", StatusCode: http.StatusOK, Body: []byte("c")},
+	}}
+	st := NewSimulatedTransport(p, 0)
+	var cases = []struct {
+		url    string
+		status int
+		body   string
+		err    string
+	}{
+		// retries get the recorded exchanges in order, then the last one
+		{"http://x/a", http.StatusServiceUnavailable, "", ""},
+		{"http://x/a", http.StatusOK, "a", ""},
+		{"http://x/a", http.StatusOK, "a", ""},
+		{"http://x/b", 0, "", "connection reset"},
+		// unknown requests get the exchanges in sequence
+		{"http://x/other", http.StatusServiceUnavailable, "", ""},
+		{"http://x/other", http.StatusOK, "a", ""},
+		{"http://x/other", 0, "", "connection reset"},
+		{"http://x/other", http.StatusOK, "c", ""},
+		{"http://x/other", 0, "", ErrNotRecorded.Error()},
+	}
+	for i, c := range cases {
+		req, _ := http.NewRequest("GET", c.url, nil)
+		resp, err := st.RoundTrip(req)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%d: got %v, want %s", i, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != c.status || string(b) != c.body {
+			t.Errorf("%d: got %d %q, want %d %q", i, resp.StatusCode, b, c.status, c.body)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-simulate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var failed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case q.Get("resumptionToken") == "" && !failed:
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case q.Get("resumptionToken") == "":
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record><resumptionToken>t1</resumptionToken></ListRecords></OAI-PMH>`)
+		default:
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>2</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	harvest := func(base string, transport http.RoundTripper) (*Harvest, error) {
+		BaseDir = filepath.Join(dir, base)
+		h := &Harvest{
+			BaseURL:           srv.URL,
+			Format:            "oai_dc",
+			From:              time.Now().UTC().AddDate(0, 0, -2).Format("2006-01-02"),
+			DailyInterval:     true,
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			Transport:         transport,
+			RetryPolicy:       RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, RetryOn: []int{http.StatusServiceUnavailable}},
+		}
+		return h, h.RunContext(context.Background())
+	}
+	var log bytes.Buffer
+	recorded, err := harvest("recorded", NewRecordingTransport(nil, &log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := LoadProfile(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Identify, the failed and the retried request, the resumption, then
+	// two requests for the second day
+	if n := len(profile.Exchanges); n != 6 || profile.Exchanges[1].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %d exchanges, want 6 with a failed second request", n)
+	}
+	srv.Close()
+
+	var cases = []struct {
+		name    string
+		profile *Profile
+		err     string
+	}{
+		{"replayed", profile, ""},
+		// retries get the last recorded exchange again
+		{"only the failure recorded", &Profile{Exchanges: profile.Exchanges[:2]}, "Service Unavailable"},
+	}
+	for _, c := range cases {
+		h, err := harvest(c.name, NewSimulatedTransport(c.profile, 100))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: got %v, want %s", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		want, got := recorded.Files(), h.Files()
+		if len(got) != len(want) || len(got) == 0 {
+			t.Fatalf("%s: got %d files, want %d", c.name, len(got), len(want))
+		}
+		for i := range got {
+			a, _ := ioutil.ReadFile(want[i])
+			b, _ := ioutil.ReadFile(got[i])
+			if filepath.Base(got[i]) != filepath.Base(want[i]) || !bytes.Equal(a, b) {
+				t.Errorf("%s: %s differs from %s", c.name, got[i], want[i])
+			}
+		}
+	}
+}
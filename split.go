@@ -0,0 +1,74 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/jinzhu/now"
+)
+
+// oversized reports, whether an error looks like the server could not cope
+// with the size of an interval: timeouts, server errors after all retries
+// and badArgument or InternalException errors.
+func oversized(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	var he HTTPError
+	if errors.As(err, &he) && he.StatusCode >= http.StatusInternalServerError {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrBadArgument) ||
+		errors.Is(err, ErrInternalException)
+}
+
+// bisect splits an interval into two intervals of whole days. It returns
+// false, if the interval covers a single day only.
+func (iv Interval) bisect() (Interval, Interval, bool) {
+	first := now.New(iv.Begin).BeginningOfDay()
+	last := now.New(iv.End).BeginningOfDay()
+	var days int
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		days++
+	}
+	if days < 2 {
+		return Interval{}, Interval{}, false
+	}
+	mid := first.AddDate(0, 0, days/2)
+	return Interval{Begin: iv.Begin, End: now.New(mid.AddDate(0, 0, -1)).EndOfDay()},
+		Interval{Begin: mid, End: iv.End}, true
+}
+
+// splitInterval harvests the halves of an interval, that failed with err,
+// one after another and splits them further, if needed. Without
+// AdaptiveIntervals or for other errors, err is returned.
+func (h *Harvest) splitInterval(ctx context.Context, iv Interval, err error) error {
+	if !h.AdaptiveIntervals || h.DisableSelectiveHarvesting || ctx.Err() != nil || !oversized(err) {
+		return err
+	}
+	local := Interval{Begin: iv.Begin.In(h.location()), End: iv.End.In(h.location())}
+	a, b, ok := local.bisect()
+	if !ok {
+		return err
+	}
+	log.Printf("%s failed with %s, splitting into %s and %s", iv, err, a, b)
+	for _, half := range []Interval{a, b} {
+		f, err := h.fetchInterval(ctx, h.progress.interval, half)
+		if err != nil {
+			if err := h.splitInterval(ctx, half, err); err != nil {
+				return err
+			}
+		} else if err := h.commitInterval(f); err != nil {
+			return err
+		}
+		if h.progress.stopped {
+			break
+		}
+	}
+	return nil
+}
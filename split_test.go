@@ -0,0 +1,81 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveIntervals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-split-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	// fails on intervals longer than a week
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity><earliestDatestamp>2016-01-01</earliestDatestamp></Identify></OAI-PMH>`)
+			return
+		}
+		from, _ := time.Parse("2006-01-02", q.Get("from"))
+		until, _ := time.Parse("2006-01-02", q.Get("until"))
+		if until.Sub(from) > 7*24*time.Hour {
+			switch r.URL.Path {
+			case "/status":
+				w.WriteHeader(http.StatusServiceUnavailable)
+			default:
+				fmt.Fprint(w, `<OAI-PMH><error code="badArgument">too many records</error></OAI-PMH>`)
+			}
+			return
+		}
+		fmt.Fprintf(w, `<OAI-PMH><ListRecords><record><header><identifier>%s</identifier><datestamp>%s</datestamp></header></record></ListRecords></OAI-PMH>`,
+			q.Get("from"), q.Get("from"))
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		path     string
+		adaptive bool
+		parallel int
+		files    int
+		err      bool
+	}{
+		{"/oai", false, 0, 0, true},
+		{"/oai", true, 0, 4, false},
+		{"/status", true, 0, 4, false},
+		{"/parallel", true, 2, 4, false},
+	}
+	for _, c := range cases {
+		h := &Harvest{
+			BaseURL:           srv.URL + c.path,
+			Format:            "oai_dc",
+			From:              "2016-01-01",
+			Until:             "2016-01-31",
+			MaxRequests:       10,
+			MaxEmptyResponses: 10,
+			RetryPolicy:       RetryPolicy{MaxAttempts: 1},
+			AdaptiveIntervals: c.adaptive,
+			Parallel:          c.parallel,
+		}
+		err := h.RunContext(context.Background())
+		if (err != nil) != c.err {
+			t.Errorf("%s %v: got %v", c.path, c.adaptive, err)
+		}
+		if got := len(h.Files()); got != c.files {
+			t.Errorf("%s %v: got %d files, want %d", c.path, c.adaptive, got, c.files)
+		}
+		if tmp, _ := filepath.Glob(filepath.Join(h.Dir(), "*-tmp-*")); len(tmp) > 0 {
+			t.Errorf("%s %v: temporary files left: %v", c.path, c.adaptive, tmp)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrStalled is returned, if no bytes were received for StallTimeout.
+var ErrStalled = errors.New("request stalled")
+
+// stallTransport cancels a request, if no bytes arrive for the given
+// duration, while waiting for the response or reading the body. Endpoints
+// sometimes keep connections open and trickle bytes for a long time.
+type stallTransport struct {
+	Transport http.RoundTripper
+	Timeout   time.Duration
+}
+
+// RoundTrip executes the request and watches the response body.
+func (t stallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	w := &stallWatch{timeout: t.Timeout, cancel: cancel, url: req.URL.String()}
+	w.timer = time.AfterFunc(t.Timeout, w.fire)
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		w.stop()
+		return nil, w.wrap(err)
+	}
+	w.reset()
+	resp.Body = &stallReader{ReadCloser: resp.Body, w: w}
+	return resp, nil
+}
+
+// stallWatch cancels a request after a timeout, unless it is reset.
+type stallWatch struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	url     string
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	stalled bool
+}
+
+// fire cancels the request.
+func (w *stallWatch) fire() {
+	w.mu.Lock()
+	w.stalled = true
+	w.mu.Unlock()
+	log.Printf("no bytes received for %s, cancelling %s", w.timeout, w.url)
+	w.cancel()
+}
+
+// reset restarts the timeout, e.g. after bytes arrived.
+func (w *stallWatch) reset() {
+	w.timer.Reset(w.timeout)
+}
+
+// stop releases the timer and the request context.
+func (w *stallWatch) stop() {
+	w.timer.Stop()
+	w.cancel()
+}
+
+// wrap returns ErrStalled for errors caused by a stall.
+func (w *stallWatch) wrap(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stalled {
+		return fmt.Errorf("%w: no bytes for %s: %v", ErrStalled, w.timeout, err)
+	}
+	return err
+}
+
+// stallReader resets the watch on each read, that returns bytes.
+type stallReader struct {
+	io.ReadCloser
+	w *stallWatch
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.w.reset()
+	}
+	if err != nil && err != io.EOF {
+		err = r.w.wrap(err)
+	}
+	return n, err
+}
+
+// Close stops the watch.
+func (r *stallReader) Close() error {
+	r.w.stop()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,98 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStallTimeout(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// first attempt sends a bit and then stalls
+			fmt.Fprint(w, `<OAI-PMH><Identify>`)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		fmt.Fprint(w, `<OAI-PMH><Identify><repositoryName>x</repositoryName></Identify></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	policy := DefaultRetryPolicy
+	policy.BaseDelay = time.Millisecond
+	h := &Harvest{BaseURL: srv.URL, StallTimeout: 100 * time.Millisecond}
+	c := h.client(time.Minute, policy)
+	started := time.Now()
+	resp, err := c.DoContext(context.Background(), &Request{BaseURL: srv.URL, Verb: "Identify"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Identify.RepositoryName != "x" {
+		t.Errorf("got %+v", resp.Identify)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("stall not detected, took %s", elapsed)
+	}
+
+	// without retries, the stall is reported
+	atomic.StoreInt32(&requests, 0)
+	policy.MaxAttempts = 1
+	c = h.client(time.Minute, policy)
+	if _, err := c.DoContext(context.Background(), &Request{BaseURL: srv.URL, Verb: "Identify"}); !errors.Is(err, ErrStalled) {
+		t.Errorf("got %v, want %v", err, ErrStalled)
+	}
+}
+
+func TestStallTimeoutHTTPCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-stall-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<OAI-PMH><ListRecords>`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		From:              time.Now().UTC().AddDate(0, 0, -2).Format("2006-01-02"),
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		DailyInterval:     true,
+		StallTimeout:      100 * time.Millisecond,
+		HTTPCache:         true,
+		RetryPolicy:       RetryPolicy{MaxAttempts: 1},
+		Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+	}
+	started := time.Now()
+	if err := h.RunContext(context.Background()); !errors.Is(err, ErrStalled) {
+		t.Errorf("got %v, want %v", err, ErrStalled)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("stall not detected with http cache, took %s", elapsed)
+	}
+}
@@ -0,0 +1,163 @@
+package metha
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StatsFilename is the name of the statistics cache in a harvest directory.
+// It holds counts per file, so only new or changed files are read again.
+const StatsFilename = "stats.json"
+
+// Stats summarizes the cache of a harvest. Records and Deleted count record
+// versions, a record updated twice is counted twice. Identifiers is the
+// number of distinct records, if there is a header index.
+type Stats struct {
+	Records        int            `json:"records"`
+	Deleted        int            `json:"deleted"`
+	Identifiers    int            `json:"identifiers,omitempty"`
+	PerMonth       map[string]int `json:"per_month"`
+	Files          int            `json:"files"`
+	Bytes          int64          `json:"bytes"`
+	FirstDateStamp string         `json:"first_datestamp,omitempty"`
+	LastDateStamp  string         `json:"last_datestamp,omitempty"`
+	LastSync       time.Time      `json:"last_sync"`
+}
+
+// fileStats are the counts of a single cached file, valid as long as size
+// and modification time do not change.
+type fileStats struct {
+	Size     int64          `json:"size"`
+	ModTime  time.Time      `json:"mtime"`
+	Records  int            `json:"records"`
+	Deleted  int            `json:"deleted"`
+	PerMonth map[string]int `json:"per_month,omitempty"`
+	First    string         `json:"first,omitempty"`
+	Last     string         `json:"last,omitempty"`
+}
+
+// statsPath returns the path to the statistics cache.
+func (h *Harvest) statsPath() string {
+	return filepath.Join(h.Dir(), StatsFilename)
+}
+
+// loadFileStats reads the statistics cache, a missing or broken cache is
+// empty.
+func (h *Harvest) loadFileStats() map[string]fileStats {
+	cached := make(map[string]fileStats)
+	if b, err := ioutil.ReadFile(h.statsPath()); err == nil {
+		if err := json.Unmarshal(b, &cached); err != nil {
+			return make(map[string]fileStats)
+		}
+	}
+	return cached
+}
+
+// countFile reads the records of a single file.
+func countFile(filename string) (fileStats, error) {
+	fs := fileStats{PerMonth: make(map[string]int)}
+	err := eachRecordPosition(filename, func(_ int, rec Record) error {
+		fs.Records++
+		if rec.Header.Status == "deleted" {
+			fs.Deleted++
+		}
+		ds := rec.Header.DateStamp
+		if len(ds) >= 7 {
+			fs.PerMonth[ds[:7]]++
+		}
+		if ds != "" && (fs.First == "" || ds < fs.First) {
+			fs.First = ds
+		}
+		if ds > fs.Last {
+			fs.Last = ds
+		}
+		return nil
+	})
+	return fs, err
+}
+
+// Stats returns statistics about the cached records. Counts are cached per
+// file, so after the first call, only files added since are decompressed.
+func (h *Harvest) Stats() (*Stats, error) {
+	cached := h.loadFileStats()
+	current := make(map[string]fileStats)
+	changed := false
+	stats := &Stats{PerMonth: make(map[string]int)}
+
+	for _, filename := range h.cacheFiles() {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(h.Dir(), strings.TrimSuffix(filename, StubSuffix))
+		if err != nil {
+			return nil, err
+		}
+		fs, ok := cached[rel]
+		if !ok || fs.Size != fi.Size() || !fs.ModTime.Equal(fi.ModTime()) {
+			if fs, err = countFile(filename); err != nil {
+				return nil, err
+			}
+			fs.Size, fs.ModTime = fi.Size(), fi.ModTime()
+			changed = true
+		}
+		current[rel] = fs
+
+		stats.Files++
+		stats.Bytes += fs.Size
+		stats.Records += fs.Records
+		stats.Deleted += fs.Deleted
+		for month, n := range fs.PerMonth {
+			stats.PerMonth[month] += n
+		}
+		if fs.First != "" && (stats.FirstDateStamp == "" || fs.First < stats.FirstDateStamp) {
+			stats.FirstDateStamp = fs.First
+		}
+		if fs.Last > stats.LastDateStamp {
+			stats.LastDateStamp = fs.Last
+		}
+		if fi.ModTime().After(stats.LastSync) {
+			stats.LastSync = fi.ModTime()
+		}
+	}
+	if len(current) != len(cached) {
+		changed = true
+	}
+	if runs, err := h.Runs(); err != nil {
+		return nil, err
+	} else if len(runs) > 0 {
+		stats.LastSync = runs[len(runs)-1].Finished
+	}
+	if _, err := os.Stat(h.headerIndexPath()); err == nil {
+		idx, err := h.OpenHeaderIndex()
+		if err != nil {
+			return nil, err
+		}
+		stats.Identifiers = idx.Len()
+	}
+	if changed {
+		if err := h.saveFileStats(current); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// saveFileStats replaces the statistics cache. Concurrent callers, e.g.
+// API requests, write to their own temporary file, the last rename wins.
+func (h *Harvest) saveFileStats(files map[string]fileStats) error {
+	b, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	tmp := h.statsPath() + newTempSuffix("-tmp-")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, h.statsPath())
+}
@@ -0,0 +1,102 @@
+package metha
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-stats-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	record := `<record><header%s><identifier>%s</identifier><datestamp>%s</datestamp></header></record>`
+	write := func(name, records string) {
+		doc := `<OAI-PMH><ListRecords>` + records + `</ListRecords></OAI-PMH>`
+		if err := ioutil.WriteFile(filepath.Join(h.Dir(), name), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("2016-01-31-00000000.xml", fmt.Sprintf(record+record,
+		"", "a", "2016-01-10",
+		"", "b", "2016-01-20T10:00:00Z"))
+
+	stats, err := h.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 2 || stats.Files != 1 || stats.FirstDateStamp != "2016-01-10" || stats.PerMonth["2016-01"] != 2 {
+		t.Errorf("got %+v", stats)
+	}
+
+	// cached counts are used for unchanged files
+	b, err := ioutil.ReadFile(filepath.Join(h.Dir(), StatsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cached map[string]fileStats
+	if err := json.Unmarshal(b, &cached); err != nil {
+		t.Fatal(err)
+	}
+	fs := cached["2016-01-31-00000000.xml"]
+	fs.Records = 100
+	cached["2016-01-31-00000000.xml"] = fs
+	if err := h.saveFileStats(cached); err != nil {
+		t.Fatal(err)
+	}
+
+	write("2016-02-29-00000000.xml", fmt.Sprintf(record+record,
+		"", "b", "2016-02-05",
+		` status="deleted"`, "c", "2016-02-10"))
+	if stats, err = h.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Records != 102 || stats.Deleted != 1 || stats.Files != 2 || stats.LastDateStamp != "2016-02-10" || stats.PerMonth["2016-02"] != 2 {
+		t.Errorf("got %+v", stats)
+	}
+}
+
+func TestSaveFileStatsConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-stats-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	// like parallel API requests
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			files := map[string]fileStats{fmt.Sprintf("f%d", i): {}}
+			for j := 0; j < 20; j++ {
+				if err := h.saveFileStats(files); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if files, _ := filepath.Glob(h.statsPath() + "-*"); len(files) > 0 {
+		t.Errorf("left over files: %v", files)
+	}
+}
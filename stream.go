@@ -0,0 +1,236 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// recordingReader keeps all bytes read from the underlying reader, that have
+// not been released yet. Offsets are absolute positions in the stream.
+type recordingReader struct {
+	r    io.Reader
+	buf  []byte
+	base int64
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf = append(rr.buf, p[:n]...)
+	return n, err
+}
+
+// slice returns the bytes between two offsets.
+func (rr *recordingReader) slice(from, to int64) []byte {
+	return rr.buf[from-rr.base : to-rr.base]
+}
+
+// release drops all bytes before the given offset.
+func (rr *recordingReader) release(to int64) {
+	n := copy(rr.buf, rr.buf[to-rr.base:])
+	rr.buf = rr.buf[:n]
+	rr.base = to
+}
+
+// StreamResult summarizes a streamed response.
+type StreamResult struct {
+	// Response contains the error, resumption token and the record headers,
+	// but no metadata.
+	Response *Response
+	// Skipped lists identifiers of records, that exceeded the size limit.
+	Skipped []string
+	// Filtered is the number of records dropped by a filter.
+	Filtered int
+	// Bytes written.
+	Written int64
+}
+
+// StreamResponse copies an OAI response from r to w, byte by byte, without
+// holding more than a single record in memory. If maxRecordSize is positive,
+// records larger than that are left out. Without a limit, records are not
+// buffered at all. Error, resumption token and record headers are collected
+// along the way.
+func StreamResponse(r io.Reader, w io.Writer, maxRecordSize int64) (*StreamResult, error) {
+	return StreamResponseFilter(r, w, maxRecordSize, nil)
+}
+
+// StreamResponseFilter is like StreamResponse, but records, for which filter
+// returns false, are left out as well. With a filter, each record is
+// buffered and decoded.
+func StreamResponseFilter(r io.Reader, w io.Writer, maxRecordSize int64, filter RecordFilter) (*StreamResult, error) {
+	rr := &recordingReader{r: r}
+	dec := xml.NewDecoder(rr)
+	dec.Strict = false
+
+	result := &StreamResult{Response: &Response{}}
+	resp := result.Response
+
+	var (
+		flushed     int64 // all bytes before this offset are written or dropped
+		recordStart int64 = -1
+		skipping    bool
+		path        []string
+		record      Record
+		text        bytes.Buffer
+		collect     bool // only keep text of small, interesting elements
+	)
+
+	flush := func(to int64) error {
+		if to <= flushed {
+			return nil
+		}
+		n, err := w.Write(rr.slice(flushed, to))
+		result.Written += int64(n)
+		rr.release(to)
+		flushed = to
+		return err
+	}
+	drop := func(to int64) {
+		rr.release(to)
+		flushed = to
+	}
+	parent := func() string {
+		if len(path) < 2 {
+			return ""
+		}
+		return path[len(path)-2]
+	}
+	// oaiRecord is true for the record element of the response, not for
+	// elements named record inside metadata, e.g. in MARCXML
+	oaiRecord := func() bool {
+		p := parent()
+		return p == "ListRecords" || p == "GetRecord"
+	}
+
+	for {
+		before := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		after := dec.InputOffset()
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			text.Reset()
+			switch t.Name.Local {
+			case "identifier", "datestamp", "setSpec", "error", "resumptionToken":
+				collect = true
+			default:
+				collect = false
+			}
+			switch t.Name.Local {
+			case "record":
+				if recordStart < 0 && oaiRecord() {
+					if err := flush(before); err != nil {
+						return result, err
+					}
+					recordStart = before
+					record = Record{}
+				}
+			case "header":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "status" {
+						record.Header.Status = attr.Value
+					}
+				}
+			case "error":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "code" {
+						resp.Error.Code = attr.Value
+					}
+				}
+			case "resumptionToken":
+				info := &resp.ListRecords.TokenInfo
+				for _, attr := range t.Attr {
+					switch attr.Name.Local {
+					case "completeListSize":
+						info.CompleteListSize = attr.Value
+					case "cursor":
+						info.Cursor = attr.Value
+					case "expirationDate":
+						info.ExpirationDate = attr.Value
+					}
+				}
+			}
+		case xml.CharData:
+			if collect {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			switch t.Name.Local {
+			case "identifier":
+				if parent() == "header" {
+					record.Header.Identifier = value
+				}
+			case "datestamp":
+				if parent() == "header" {
+					record.Header.DateStamp = value
+				}
+			case "setSpec":
+				if parent() == "header" {
+					record.Header.SetSpec = append(record.Header.SetSpec, value)
+				}
+			case "error":
+				resp.Error.Message = value
+			case "resumptionToken":
+				resp.ListRecords.TokenInfo.Value = value
+				resp.ListRecords.ResumptionToken = value
+			case "record":
+				if recordStart >= 0 && oaiRecord() {
+					keep := true
+					if !skipping && filter != nil {
+						var full Record
+						rd := xml.NewDecoder(bytes.NewReader(rr.slice(recordStart, after)))
+						rd.Strict = false
+						if err := rd.Decode(&full); err != nil {
+							return result, err
+						}
+						keep = filter(full)
+					}
+					switch {
+					case skipping:
+						result.Skipped = append(result.Skipped, record.Header.Identifier)
+						drop(after)
+					case !keep:
+						result.Filtered++
+						drop(after)
+					default:
+						resp.ListRecords.Records = append(resp.ListRecords.Records, record)
+						if err := flush(after); err != nil {
+							return result, err
+						}
+					}
+					recordStart, skipping = -1, false
+				}
+			}
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+			text.Reset()
+			collect = false
+		}
+
+		switch {
+		case recordStart < 0 || (maxRecordSize <= 0 && filter == nil):
+			if err := flush(after); err != nil {
+				return result, err
+			}
+		case skipping:
+			drop(after)
+		case maxRecordSize > 0 && after-recordStart > maxRecordSize:
+			skipping = true
+			drop(after)
+		}
+	}
+	if err := flush(dec.InputOffset()); err != nil {
+		return result, err
+	}
+	return result, nil
+}
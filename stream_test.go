@@ -0,0 +1,129 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestStreamResponse(t *testing.T) {
+	var doc = `<OAI-PMH><ListRecords>` +
+		`<record><header><identifier>oai:x:1</identifier></header><metadata>small</metadata></record>` +
+		`<record><header><identifier>oai:x:2</identifier></header><metadata>` +
+		strings.Repeat("large ", 100) + `</metadata></record>` +
+		`<resumptionToken cursor="0">abc</resumptionToken>` +
+		`</ListRecords></OAI-PMH>`
+	var cases = []struct {
+		max     int64
+		skipped int
+	}{
+		{0, 0},
+		{150, 1},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		result, err := StreamResponse(strings.NewReader(doc), &buf, c.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(result.Skipped); got != c.skipped {
+			t.Errorf("max %d: got %d skipped, want %d", c.max, got, c.skipped)
+		}
+		if got := len(result.Response.ListRecords.Records); got != 2-c.skipped {
+			t.Errorf("max %d: got %d records, want %d", c.max, got, 2-c.skipped)
+		}
+		if got := result.Response.GetResumptionToken(); got != "abc" {
+			t.Errorf("max %d: got token %q, want abc", c.max, got)
+		}
+		if c.skipped == 0 && buf.String() != doc {
+			t.Errorf("max %d: output differs from input", c.max)
+		}
+		if c.skipped > 0 && strings.Contains(buf.String(), "oai:x:2") {
+			t.Errorf("max %d: skipped record in output", c.max)
+		}
+	}
+}
+
+func TestStreamResponseFilter(t *testing.T) {
+	var doc = `<OAI-PMH><ListRecords>` +
+		`<record><header><identifier>oai:x:1</identifier></header><metadata>a</metadata></record>` +
+		`<record><header status="deleted"><identifier>oai:x:2</identifier></header></record>` +
+		`<record><header><identifier>oai:y:3</identifier></header><metadata>b</metadata></record>` +
+		`</ListRecords></OAI-PMH>`
+	var cases = []struct {
+		filter RecordFilter
+		ids    string
+	}{
+		{nil, "oai:x:1 oai:x:2 oai:y:3"},
+		{NotDeleted, "oai:x:1 oai:y:3"},
+		{AllFilters(HasMetadata, IdentifierMatches(regexp.MustCompile(`^oai:x:`))), "oai:x:1"},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		result, err := StreamResponseFilter(strings.NewReader(doc), &buf, 0, c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, r := range result.Response.ListRecords.Records {
+			ids = append(ids, r.Header.Identifier)
+		}
+		if got := strings.Join(ids, " "); got != c.ids {
+			t.Errorf("%d: got %s, want %s", i, got, c.ids)
+		}
+		// the written response must contain the same records
+		var resp Response
+		if err := xml.Unmarshal(buf.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.ListRecords.Records) != len(ids) {
+			t.Errorf("%d: got %d records written, want %d", i, len(resp.ListRecords.Records), len(ids))
+		}
+	}
+}
+
+func TestStreamResponseNestedRecord(t *testing.T) {
+	marc := func(id, data string) string {
+		return `<record><header><identifier>` + id + `</identifier></header><metadata>` +
+			`<marc:record xmlns:marc="http://www.loc.gov/MARC21/slim"><marc:datafield tag="245">` + data +
+			`</marc:datafield></marc:record></metadata></record>`
+	}
+	var doc = `<OAI-PMH><ListRecords>` + marc("oai:x:1", "small") +
+		marc("oai:x:2", strings.Repeat("large ", 100)) + marc("oai:y:3", "small") +
+		`</ListRecords></OAI-PMH>`
+	var cases = []struct {
+		max     int64
+		filter  RecordFilter
+		ids     string
+		skipped int
+	}{
+		{0, nil, "oai:x:1 oai:x:2 oai:y:3", 0},
+		{300, nil, "oai:x:1 oai:y:3", 1},
+		{0, IdentifierMatches(regexp.MustCompile(`^oai:x:`)), "oai:x:1 oai:x:2", 0},
+		{300, HasMetadata, "oai:x:1 oai:y:3", 1},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		result, err := StreamResponseFilter(strings.NewReader(doc), &buf, c.max, c.filter)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		var ids []string
+		for _, r := range result.Response.ListRecords.Records {
+			ids = append(ids, r.Header.Identifier)
+		}
+		if got := strings.Join(ids, " "); got != c.ids || len(result.Skipped) != c.skipped {
+			t.Errorf("%d: got %s and %d skipped, want %s and %d", i, got, len(result.Skipped), c.ids, c.skipped)
+		}
+		// the written response must be well-formed and hold the same records
+		var resp Response
+		if err := xml.Unmarshal(buf.Bytes(), &resp); err != nil {
+			t.Fatalf("%d: %v: %s", i, err, buf.String())
+		}
+		if len(resp.ListRecords.Records) != len(ids) {
+			t.Errorf("%d: got %d records written, want %d", i, len(resp.ListRecords.Records), len(ids))
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StubSuffix marks a file, that has been moved to cold storage. The stub
+// contains the location of the data.
+const StubSuffix = ".cold"
+
+// ColdStore is a place for rarely accessed files, e.g. a slow disk or an
+// object store. Put stores data under a key and returns a location URL, which
+// Open can resolve again.
+type ColdStore interface {
+	Put(key string, r io.Reader) (string, error)
+	Open(location string) (io.ReadCloser, error)
+}
+
+var (
+	coldStoresMu sync.Mutex
+	coldStores   = map[string]ColdStore{"file": DirColdStore{}}
+)
+
+// RegisterColdStore makes a store available for resolving locations with the
+// given URL scheme, e.g. "s3".
+func RegisterColdStore(scheme string, s ColdStore) {
+	coldStoresMu.Lock()
+	defer coldStoresMu.Unlock()
+	coldStores[scheme] = s
+}
+
+// DirColdStore keeps files below a directory, e.g. on a different disk.
+type DirColdStore struct {
+	Dir string
+}
+
+// Put copies data into the directory, key is a relative path.
+func (s DirColdStore) Put(key string, r io.Reader) (string, error) {
+	dst := filepath.Join(s.Dir, key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	tmp := dst + "-tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+	// open files cannot be renamed on windows
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(dst)
+	if err != nil {
+		return "", err
+	}
+	return fileURL(abs), nil
+}
+
+// Open opens a file URL.
+func (s DirColdStore) Open(location string) (io.ReadCloser, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(fromFileURL(u))
+}
+
+// fileURL returns a file URL for an absolute path. Windows paths get a
+// leading slash, e.g. file:///C:/metha/x.xml.gz.
+func fileURL(abs string) string {
+	p := filepath.ToSlash(abs)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// fromFileURL returns the local path of a file URL.
+func fromFileURL(u *url.URL) string {
+	p := u.Path
+	// /C:/x -> C:/x
+	if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// OpenChunk opens a cached file for reading, regardless of whether it is
+// local or has been moved to cold storage and only a stub is left.
+func OpenChunk(filename string) (io.ReadCloser, error) {
+	if !strings.HasSuffix(filename, StubSuffix) {
+		return os.Open(filename)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	location := strings.TrimSpace(string(b))
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	coldStoresMu.Lock()
+	s, ok := coldStores[u.Scheme]
+	coldStoresMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no cold store registered for %s", location)
+	}
+	return s.Open(location)
+}
+
+// ColdFiles returns the stubs of all files moved to cold storage.
+func (h *Harvest) ColdFiles() []string {
+	var files []string
+	for _, ext := range chunkExtensions {
+		files = append(files, h.glob("*"+ext+StubSuffix)...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Tier moves files with a date older than the given number of months into
+// cold storage and leaves a stub in place, so the last harvested date is
+// retained. Returns the list of stubs written. Like a run, tiering holds the
+// lock of the harvest directory.
+func (h *Harvest) Tier(store ColdStore, months int) ([]string, error) {
+	l, err := h.lock(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer l.unlock()
+	if err := h.recover(); err != nil {
+		return nil, err
+	}
+	h.Lock()
+	defer h.Unlock()
+
+	cutoff := time.Now().AddDate(0, -months, 0).Format("2006-01-02")
+
+	var stubs []string
+	for _, fn := range h.Files() {
+		groups := chunkPattern.FindStringSubmatch(filepath.Base(fn))
+		if len(groups) < 2 || groups[1] >= cutoff {
+			continue
+		}
+		stub, err := h.tierFile(store, fn)
+		if err != nil {
+			return stubs, err
+		}
+		stubs = append(stubs, stub)
+	}
+	if len(stubs) > 0 {
+		log.Printf("moved %d files to cold storage", len(stubs))
+	}
+	return stubs, nil
+}
+
+// tierFile copies a file to the store, writes the stub and removes the file.
+// The key is the path relative to BaseDir. The stub is only in place, once
+// the data is stored, a file left next to its stub is removed by recoverTier.
+func (h *Harvest) tierFile(store ColdStore, filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	rel, err := filepath.Rel(h.Dir(), filename)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.Join(filepath.Base(h.Dir()), rel)
+	location, err := store.Put(key, f)
+	if err != nil {
+		return "", err
+	}
+	stub := filename + StubSuffix
+	if err := ioutil.WriteFile(stub+"-tmp", []byte(location+"\n"), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(stub+"-tmp", stub); err != nil {
+		return "", err
+	}
+	f.Close()
+	return stub, os.Remove(filename)
+}
+
+// recoverTier removes files, that were moved to cold storage, but are still
+// local, because tiering was interrupted. Otherwise both the file and its
+// stub would be read.
+func (h *Harvest) recoverTier() error {
+	for _, tmp := range h.glob("*" + StubSuffix + "-tmp") {
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var removed int
+	for _, stub := range h.ColdFiles() {
+		err := os.Remove(strings.TrimSuffix(stub, StubSuffix))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("removed %d files of an interrupted move to cold storage", removed)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package metha
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileURL(t *testing.T) {
+	var cases = []struct {
+		path string
+		url  string
+		back string
+	}{
+		{"/var/metha/a.xml.gz", "file:///var/metha/a.xml.gz", "/var/metha/a.xml.gz"},
+		{`C:/metha/a b.xml.gz`, "file:///C:/metha/a%20b.xml.gz", "C:/metha/a b.xml.gz"},
+	}
+	for _, c := range cases {
+		s := fileURL(c.path)
+		if s != c.url {
+			t.Errorf("got %s, want %s", s, c.url)
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fromFileURL(u); got != filepath.FromSlash(c.back) {
+			t.Errorf("got %s, want %s", got, filepath.FromSlash(c.back))
+		}
+	}
+}
+
+// memColdStore keeps data in memory, resolving test: locations.
+type memColdStore map[string][]byte
+
+func (s memColdStore) Put(key string, r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	location := "test:" + filepath.ToSlash(key)
+	s[location] = b
+	return location, nil
+}
+
+func (s memColdStore) Open(location string) (io.ReadCloser, error) {
+	b, ok := s[location]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func TestTier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-tier-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = filepath.Join(dir, "cache")
+
+	mem := make(memColdStore)
+	RegisterColdStore("test", mem)
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc", From: "2010-01-01", Started: time.Now()}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	recent := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	dates := []string{"2010-01-31", "2010-02-28", recent}
+	for _, date := range dates {
+		doc := `<OAI-PMH><ListRecords><record><header><identifier>` + date +
+			`</identifier><datestamp>` + date + `</datestamp></header></record></ListRecords></OAI-PMH>`
+		if err := ioutil.WriteFile(filepath.Join(h.Dir(), date+"-00000000.xml"), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// cases run in order, each moves more files
+	var cases = []struct {
+		store  ColdStore
+		months int
+		stubs  int
+		cold   int
+	}{
+		{DirColdStore{Dir: filepath.Join(dir, "cold")}, 1200, 0, 0},
+		{DirColdStore{Dir: filepath.Join(dir, "cold")}, 12, 2, 2},
+		{DirColdStore{Dir: filepath.Join(dir, "cold")}, 12, 0, 2},
+		{mem, 0, 1, 3},
+	}
+	for _, c := range cases {
+		stubs, err := h.Tier(c.store, c.months)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stubs) != c.stubs || len(h.ColdFiles()) != c.cold || len(h.Files()) != len(dates)-c.cold {
+			t.Errorf("months %d: got %d stubs, %d cold and %d local files, want %d, %d, %d", c.months,
+				len(stubs), len(h.ColdFiles()), len(h.Files()), c.stubs, c.cold, len(dates)-c.cold)
+		}
+		for _, stub := range stubs {
+			if !strings.HasSuffix(stub, StubSuffix) {
+				t.Errorf("months %d: got stub %s", c.months, stub)
+			}
+			if _, err := os.Stat(strings.TrimSuffix(stub, StubSuffix)); !os.IsNotExist(err) {
+				t.Errorf("months %d: %s still local", c.months, stub)
+			}
+		}
+		// records stay readable through the stubs
+		var ids []string
+		if err := h.EachRecord(func(rec Record) error {
+			ids = append(ids, rec.Header.Identifier)
+			return nil
+		}); err != nil {
+			t.Fatalf("months %d: %v", c.months, err)
+		}
+		if strings.Join(ids, " ") != strings.Join(dates, " ") {
+			t.Errorf("months %d: got records %v, want %v", c.months, ids, dates)
+		}
+		// the last date is kept, even if it is cold
+		if _, err := h.defaultInterval(); err != ErrAlreadySynced {
+			t.Errorf("months %d: got %v, want %v", c.months, err, ErrAlreadySynced)
+		}
+	}
+	if len(mem) != 1 {
+		t.Errorf("got %d files in memory store, want 1", len(mem))
+	}
+	if _, err := OpenChunk(filepath.Join(h.Dir(), "2010-01-31-00000000.xml"+StubSuffix)); err != nil {
+		t.Errorf("cannot open stub: %v", err)
+	}
+}
+
+func TestTierRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-tier-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = filepath.Join(dir, "cache")
+
+	h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	store := DirColdStore{Dir: filepath.Join(dir, "cold")}
+	for _, date := range []string{"2010-01-31", "2010-02-28"} {
+		doc := `<OAI-PMH><ListRecords><record><header><identifier>` + date +
+			`</identifier><datestamp>` + date + `</datestamp></header></record></ListRecords></OAI-PMH>`
+		if err := ioutil.WriteFile(filepath.Join(h.Dir(), date+"-00000000.xml"), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// interrupted after the stub of the first file was written and while
+	// writing the stub of the second
+	fn := filepath.Join(h.Dir(), "2010-01-31-00000000.xml")
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err := store.Put("x/2010-01-31-00000000.xml", f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fn+StubSuffix, []byte(location+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	partial := filepath.Join(h.Dir(), "2010-02-28-00000000.xml"+StubSuffix+"-tmp")
+	if err := ioutil.WriteFile(partial, []byte("file:"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.recover(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Errorf("%s still local", fn)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("%s left over", partial)
+	}
+	// every record is read once
+	var ids []string
+	if err := h.EachRecord(func(rec Record) error {
+		ids = append(ids, rec.Header.Identifier)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ids, " "); got != "2010-01-31 2010-02-28" {
+		t.Errorf("got records %v", ids)
+	}
+}
@@ -0,0 +1,39 @@
+package metha
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// RequestIDHeader carries a unique identifier of each request, which is also
+// logged, so requests can be traced with the help of endpoint admins.
+const RequestIDHeader = "X-Request-ID"
+
+// DefaultUserAgent is sent, if no other user agent is given.
+var DefaultUserAgent = UserAgent("")
+
+// UserAgent returns a user agent with the version of metha and an optional
+// contact, e.g. an URL or an email address of the operator, so endpoint
+// admins know whom to ask about the traffic.
+func UserAgent(contact string) string {
+	comment := []string{"+https://github.com/miku/metha"}
+	switch {
+	case contact == "":
+	case strings.Contains(contact, "@") && !strings.HasPrefix(contact, "mailto:"):
+		comment = append(comment, "mailto:"+contact)
+	default:
+		comment = append(comment, contact)
+	}
+	return fmt.Sprintf("metha/%s (%s)", Version, strings.Join(comment, "; "))
+}
+
+// newRequestID returns a random identifier for a request.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
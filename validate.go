@@ -0,0 +1,281 @@
+package metha
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ValidationAction is what happens to a response, that fails validation.
+type ValidationAction string
+
+const (
+	// ValidateFlag keeps the response and records the problems in the
+	// validation report.
+	ValidateFlag ValidationAction = "flag"
+	// ValidateQuarantine moves the response out of the cache into the
+	// quarantine directory and records the problems.
+	ValidateQuarantine ValidationAction = "quarantine"
+	// ValidateReject records the problems and stops the harvest.
+	ValidateReject ValidationAction = "reject"
+)
+
+// ParseValidationAction returns the action for a name, the empty string
+// means no validation.
+func ParseValidationAction(s string) (ValidationAction, error) {
+	switch a := ValidationAction(strings.TrimSpace(s)); a {
+	case "", ValidateFlag, ValidateQuarantine, ValidateReject:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown validation action: %s", s)
+}
+
+const (
+	// ValidationReportFilename is the name of the validation report in the
+	// harvest directory, one JSON line per invalid response.
+	ValidationReportFilename = "validation.jsonl"
+	// QuarantineDir is the directory below the harvest directory, that
+	// holds quarantined responses.
+	QuarantineDir = "quarantine"
+)
+
+// ValidationError is returned, if a response is rejected.
+type ValidationError struct {
+	File     string
+	Problems []string
+}
+
+// Error lists the problems.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid response %s: %s", e.File, strings.Join(e.Problems, "; "))
+}
+
+// PayloadRules check the metadata of a record in a given format and return
+// any problems. Rules for more formats can be added.
+var PayloadRules = map[string]func(body []byte) []string{
+	"oai_dc": validateOAIDC,
+}
+
+var (
+	// setSpecPattern is the setSpecType of the OAI-PMH schema.
+	setSpecPattern = regexp.MustCompile(`^[A-Za-z0-9\-_.!~*'()]+(:[A-Za-z0-9\-_.!~*'()]+)*$`)
+	// dcElements are the elements of the oai_dc schema.
+	dcElements = map[string]bool{
+		"title": true, "creator": true, "subject": true, "description": true,
+		"publisher": true, "contributor": true, "date": true, "type": true,
+		"format": true, "identifier": true, "source": true, "language": true,
+		"relation": true, "coverage": true, "rights": true,
+	}
+)
+
+const (
+	oaiDCNamespace = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	dcNamespace    = "http://purl.org/dc/elements/1.1/"
+)
+
+// ValidateResponse checks a response against the rules of the OAI-PMH schema
+// and, if there are rules for the format, the payload of each record. Dates
+// are checked against layout, if given. There is no XSD processor involved,
+// the rules are taken from the schemas.
+func ValidateResponse(resp *Response, format, layout string) []string {
+	var problems []string
+	problem := func(s string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(s, args...))
+	}
+	if _, err := time.Parse("2006-01-02T15:04:05Z", strings.TrimSpace(resp.ResponseDate)); err != nil {
+		problem("responseDate %q is not a UTC datetime", resp.ResponseDate)
+	}
+	rule := PayloadRules[format]
+	for i, rec := range resp.ListRecords.Records {
+		h := rec.Header
+		if strings.TrimSpace(h.Identifier) == "" {
+			problem("record %d: missing identifier", i)
+		}
+		if layout == "" {
+			if _, err := time.Parse("2006-01-02", h.DateStamp); err != nil {
+				if _, err := time.Parse("2006-01-02T15:04:05Z", h.DateStamp); err != nil {
+					problem("record %d: invalid datestamp %q", i, h.DateStamp)
+				}
+			}
+		} else if _, err := time.Parse(layout, h.DateStamp); err != nil {
+			problem("record %d: datestamp %q does not match granularity", i, h.DateStamp)
+		}
+		for _, spec := range h.SetSpec {
+			if !setSpecPattern.MatchString(spec) {
+				problem("record %d: invalid setSpec %q", i, spec)
+			}
+		}
+		switch h.Status {
+		case "":
+			if len(bytes.TrimSpace(rec.Metadata.Body)) == 0 {
+				problem("record %d: missing metadata", i)
+				continue
+			}
+			if n, err := rootElements(rec.Metadata.Body); err != nil {
+				problem("record %d: %s", i, err)
+				continue
+			} else if n != 1 {
+				problem("record %d: metadata has %d root elements, want 1", i, n)
+				continue
+			}
+			if rule != nil {
+				for _, p := range rule(rec.Metadata.Body) {
+					problem("record %d: %s", i, p)
+				}
+			}
+		case "deleted":
+			if len(bytes.TrimSpace(rec.Metadata.Body)) > 0 {
+				problem("record %d: deleted record with metadata", i)
+			}
+		default:
+			problem("record %d: invalid status %q", i, h.Status)
+		}
+	}
+	return problems
+}
+
+// rootElements returns the number of top level elements of a fragment.
+func rootElements(body []byte) (int, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	var depth, n int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				n++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && len(bytes.TrimSpace(t)) > 0 {
+				return n, fmt.Errorf("text outside of metadata element")
+			}
+		}
+	}
+}
+
+// inNamespace reports, whether an element is in a namespace. Prefixes
+// declared outside of the metadata cannot be resolved, so the usual prefix
+// is accepted as well.
+func inNamespace(name xml.Name, space, prefix string) bool {
+	return name.Space == space || name.Space == prefix
+}
+
+// validateOAIDC checks the rules of oai_dc.xsd: a single oai_dc:dc element,
+// containing only the fifteen Dublin Core elements with text content.
+func validateOAIDC(body []byte) []string {
+	var problems []string
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	var depth int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return append(problems, err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 1:
+				if t.Name.Local != "dc" || !inNamespace(t.Name, oaiDCNamespace, "oai_dc") {
+					problems = append(problems, fmt.Sprintf("oai_dc: root element %s:%s, want oai_dc:dc", t.Name.Space, t.Name.Local))
+				}
+			case 2:
+				if !dcElements[t.Name.Local] || !inNamespace(t.Name, dcNamespace, "dc") {
+					problems = append(problems, fmt.Sprintf("oai_dc: unexpected element %s:%s", t.Name.Space, t.Name.Local))
+				}
+			default:
+				problems = append(problems, fmt.Sprintf("oai_dc: nested element %s", t.Name.Local))
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return problems
+}
+
+// validationEntry is a line of the validation report.
+type validationEntry struct {
+	Time     time.Time        `json:"time"`
+	File     string           `json:"file"`
+	Action   ValidationAction `json:"action"`
+	Problems []string         `json:"problems"`
+}
+
+// validateFile checks a temporary file and applies the validation action.
+// It returns false, if the file was quarantined.
+func (h *Harvest) validateFile(filename string) (bool, error) {
+	var problems []string
+	layout := ""
+	if h.Identify != nil {
+		layout = h.DateLayout()
+	}
+	err := eachResponse(filename, func(_ string, resp *Response) error {
+		problems = append(problems, ValidateResponse(resp, h.Format, layout)...)
+		return nil
+	})
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) == 0 {
+		return true, nil
+	}
+	name := filepath.Base(filename)
+	if i := strings.Index(name, "-tmp-"); i > 0 {
+		name = name[:i]
+	}
+	log.Printf("%s: %d validation problems, e.g. %s", name, len(problems), problems[0])
+	if err := h.appendValidationReport(validationEntry{
+		Time: time.Now(), File: name, Action: h.Validate, Problems: problems,
+	}); err != nil {
+		return false, err
+	}
+	switch h.Validate {
+	case ValidateReject:
+		return false, &ValidationError{File: name, Problems: problems}
+	case ValidateQuarantine:
+		dir := filepath.Join(h.Dir(), QuarantineDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return false, err
+		}
+		dst := filepath.Join(dir, fmt.Sprintf("%s-%d", name, time.Now().UnixNano()))
+		return false, os.Rename(filename, dst)
+	}
+	return true, nil
+}
+
+// appendValidationReport adds an entry to the validation report.
+func (h *Harvest) appendValidationReport(e validationEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(h.Dir(), ValidationReportFilename), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,103 @@
+package metha
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValidateResponse(t *testing.T) {
+	const dc = `<oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/" xmlns:dc="http://purl.org/dc/elements/1.1/">%s</oai_dc:dc>`
+	var cases = []struct {
+		about  string
+		record string
+		want   int
+	}{
+		{"valid", `<header><identifier>1</identifier><datestamp>2016-01-01</datestamp><setSpec>a:b</setSpec></header><metadata>` + fmt.Sprintf(dc, `<dc:title>T</dc:title>`) + `</metadata>`, 0},
+		{"deleted", `<header status="deleted"><identifier>1</identifier><datestamp>2016-01-01</datestamp></header>`, 0},
+		{"no identifier", `<header><datestamp>2016-01-01</datestamp></header><metadata>` + fmt.Sprintf(dc, "") + `</metadata>`, 1},
+		{"granularity", `<header><identifier>1</identifier><datestamp>2016-01-01T10:00:00Z</datestamp></header><metadata>` + fmt.Sprintf(dc, "") + `</metadata>`, 1},
+		{"set spec", `<header><identifier>1</identifier><datestamp>2016-01-01</datestamp><setSpec>a b</setSpec></header><metadata>` + fmt.Sprintf(dc, "") + `</metadata>`, 1},
+		{"status", `<header status="gone"><identifier>1</identifier><datestamp>2016-01-01</datestamp></header>`, 1},
+		{"no metadata", `<header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header>`, 1},
+		{"two roots", `<header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header><metadata><a/><b/></metadata>`, 1},
+		{"unknown element", `<header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header><metadata>` + fmt.Sprintf(dc, `<dc:author>A</dc:author>`) + `</metadata>`, 1},
+		{"nested element", `<header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header><metadata>` + fmt.Sprintf(dc, `<dc:title><b>T</b></dc:title>`) + `</metadata>`, 1},
+		{"wrong root", `<header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header><metadata><mods/></metadata>`, 1},
+	}
+	for _, c := range cases {
+		var resp Response
+		doc := `<OAI-PMH><responseDate>2016-01-02T00:00:00Z</responseDate><ListRecords><record>` + c.record + `</record></ListRecords></OAI-PMH>`
+		if err := xml.Unmarshal([]byte(doc), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if got := ValidateResponse(&resp, "oai_dc", "2006-01-02"); len(got) != c.want {
+			t.Errorf("%s: got %v, want %d problems", c.about, got, c.want)
+		}
+	}
+}
+
+func TestValidateHarvest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-validate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity><earliestDatestamp>2016-01-01</earliestDatestamp></Identify></OAI-PMH>`)
+			return
+		}
+		// missing metadata
+		fmt.Fprint(w, `<OAI-PMH><responseDate>2016-01-02T00:00:00Z</responseDate><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+	}))
+	defer srv.Close()
+
+	var cases = []struct {
+		action      ValidationAction
+		files       int
+		quarantined int
+		rejected    bool
+	}{
+		{ValidateFlag, 1, 0, false},
+		{ValidateQuarantine, 0, 1, false},
+		{ValidateReject, 0, 0, true},
+	}
+	for _, c := range cases {
+		h := &Harvest{
+			BaseURL:                    srv.URL + "/" + string(c.action),
+			Format:                     "oai_dc",
+			From:                       time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02"),
+			MaxRequests:                10,
+			MaxEmptyResponses:          10,
+			DisableSelectiveHarvesting: true,
+			RetryPolicy:                RetryPolicy{MaxAttempts: 1},
+			Validate:                   c.action,
+		}
+		err := h.RunContext(context.Background())
+		var verr *ValidationError
+		if rejected := errors.As(err, &verr); rejected != c.rejected {
+			t.Errorf("%s: got %v", c.action, err)
+		}
+		if got := len(h.Files()); got != c.files {
+			t.Errorf("%s: got %d files, want %d", c.action, got, c.files)
+		}
+		quarantined, _ := filepath.Glob(filepath.Join(h.Dir(), QuarantineDir, "*"))
+		if len(quarantined) != c.quarantined {
+			t.Errorf("%s: got %d quarantined, want %d", c.action, len(quarantined), c.quarantined)
+		}
+		if _, err := os.Stat(filepath.Join(h.Dir(), ValidationReportFilename)); err != nil {
+			t.Errorf("%s: report missing: %v", c.action, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package metha
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// chunkPattern extracts date and serial number from a cached filename.
+var chunkPattern = regexp.MustCompile("^([0-9]{4}-[0-9]{2}-[0-9]{2})-([0-9]{8,})[.]xml")
+
+// FileError records a problem with a single cached file.
+type FileError struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	Err  string `json:"err"`
+}
+
+// Gap is a period, that was not harvested, see Verify. Serial numbers count
+// requests, not files: retried requests, restarts after a badResumptionToken
+// and rejected responses leave holes in them, so missing serial numbers are
+// no gap.
+type Gap struct {
+	After  string `json:"after"`
+	Before string `json:"before"`
+	Reason string `json:"reason"`
+}
+
+// VerifyResult summarizes the state of the cache.
+type VerifyResult struct {
+	Dir    string      `json:"dir"`
+	Files  int         `json:"files"`
+	Errors []FileError `json:"errors,omitempty"`
+	Gaps   []Gap       `json:"gaps,omitempty"`
+}
+
+// OK returns true, if no problems were found.
+func (r *VerifyResult) OK() bool {
+	return len(r.Errors) == 0 && len(r.Gaps) == 0
+}
+
+// Verify checks every cached file for compression integrity and XML
+// well-formedness and looks for gaps in the harvested intervals. Intervals
+// are taken from the run log, so intervals without files, e.g. because
+// nothing changed since the last run or validation rejected every response,
+// are no gap. Caches without intervals in the run log, e.g. imported ones,
+// are checked by their files, whole months without any file are reported.
+func (h *Harvest) Verify() (*VerifyResult, error) {
+	result := &VerifyResult{Dir: h.Dir()}
+	files := h.Files()
+	sort.Strings(files)
+	result.Files = len(files)
+
+	for _, fn := range files {
+		if fe := verifyFile(fn); fe != nil {
+			result.Errors = append(result.Errors, *fe)
+		}
+	}
+	runs, err := h.Runs()
+	if err != nil {
+		return nil, err
+	}
+	if covered := coveredIntervals(runs); len(covered) > 0 {
+		result.Gaps = intervalGaps(covered)
+	} else {
+		result.Gaps = fileGaps(files)
+	}
+	return result, nil
+}
+
+// verifyFile decompresses a file completely and tokenizes the XML.
+func verifyFile(filename string) *FileError {
+	r, err := NewChunkReader(filename)
+	if err != nil {
+		return &FileError{Path: filename, Kind: "compression", Err: err.Error()}
+	}
+	defer r.Close()
+
+	dec := xml.NewDecoder(r)
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*xml.SyntaxError); ok {
+				return &FileError{Path: filename, Kind: "xml", Err: err.Error()}
+			}
+			return &FileError{Path: filename, Kind: "compression", Err: err.Error()}
+		}
+	}
+	return nil
+}
+
+// coveredIntervals returns the intervals harvested completely by all runs,
+// sorted by begin.
+func coveredIntervals(runs []RunManifest) []Interval {
+	var covered []Interval
+	for _, run := range runs {
+		for _, iv := range run.Intervals {
+			// a harvest without intervals
+			if iv.Begin.IsZero() || iv.End.IsZero() {
+				continue
+			}
+			covered = append(covered, iv)
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool {
+		return covered[i].Begin.Before(covered[j].Begin)
+	})
+	return covered
+}
+
+// intervalGaps reports periods between sorted intervals, that none of them
+// covers, e.g. a few days within a month between two daily harvests.
+func intervalGaps(covered []Interval) []Gap {
+	var gaps []Gap
+	end := covered[0].End
+	for _, iv := range covered[1:] {
+		// intervals end a moment before the next day
+		if iv.Begin.After(end.Add(time.Second)) {
+			days := int(iv.Begin.Sub(end).Hours()/24 + 0.5)
+			gaps = append(gaps, Gap{
+				After:  end.Format("2006-01-02"),
+				Before: iv.Begin.Format("2006-01-02"),
+				Reason: fmt.Sprintf("%d days not harvested", days),
+			})
+		}
+		if iv.End.After(end) {
+			end = iv.End
+		}
+	}
+	return gaps
+}
+
+// fileGaps reports months without files between two harvested dates.
+func fileGaps(files []string) []Gap {
+	seen := make(map[string]bool)
+	var dates []string
+	for _, fn := range files {
+		groups := chunkPattern.FindStringSubmatch(filepath.Base(fn))
+		if len(groups) < 3 || seen[groups[1]] {
+			continue
+		}
+		seen[groups[1]] = true
+		dates = append(dates, groups[1])
+	}
+	sort.Strings(dates)
+
+	var gaps []Gap
+	for i, date := range dates {
+		if i == 0 {
+			continue
+		}
+		prev, err := time.Parse("2006-01-02", dates[i-1])
+		if err != nil {
+			continue
+		}
+		cur, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			continue
+		}
+		months := (cur.Year()-prev.Year())*12 + int(cur.Month()) - int(prev.Month())
+		if months > 1 {
+			gaps = append(gaps, Gap{
+				After:  dates[i-1],
+				Before: date,
+				Reason: fmt.Sprintf("%d months without files", months-1),
+			})
+		}
+	}
+	return gaps
+}
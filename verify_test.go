@@ -0,0 +1,224 @@
+package metha
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileGaps(t *testing.T) {
+	var cases = []struct {
+		files []string
+		gaps  int
+	}{
+		{files: nil, gaps: 0},
+		{files: []string{"2016-01-31-00000000.xml.gz", "2016-01-31-00000001.xml.gz", "2016-02-29-00000000.xml.gz"}, gaps: 0},
+		// serial numbers count requests, retries leave holes
+		{files: []string{"2016-01-31-00000000.xml.gz", "2016-01-31-00000002.xml.gz"}, gaps: 0},
+		{files: []string{"2016-01-31-00000000.xml.gz", "2016-04-30-00000000.xml.gz"}, gaps: 1},
+		{files: []string{"2016-01-31-00000001.xml.gz", "2016-04-30-00000000.xml.gz"}, gaps: 1},
+	}
+	for _, c := range cases {
+		if got := fileGaps(c.files); len(got) != c.gaps {
+			t.Errorf("fileGaps(%v) got %v, want %d gaps", c.files, got, c.gaps)
+		}
+	}
+}
+
+func TestVerifyAfterRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-verify-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	var failed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("verb") == "Identify":
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+		case q.Get("resumptionToken") == "" && !failed:
+			// the first request of the run fails once
+			failed = true
+			fmt.Fprint(w, `<OAI-PMH><error code="InternalException">Could not send Message.</error></OAI-PMH>`)
+		case q.Get("resumptionToken") == "":
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>1</identifier><datestamp>2016-01-01</datestamp></header></record><resumptionToken>next</resumptionToken></ListRecords></OAI-PMH>`)
+		default:
+			fmt.Fprint(w, `<OAI-PMH><ListRecords><record><header><identifier>2</identifier><datestamp>2016-01-01</datestamp></header></record></ListRecords></OAI-PMH>`)
+		}
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		From:              time.Now().UTC().AddDate(0, 0, -2).Format("2006-01-02"),
+		DailyInterval:     true,
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		RetryPolicy:       RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	}
+	if err := h.RunContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the retried request leaves serial 00000000 of the first day unused
+	files := h.Files()
+	if !failed || len(files) != 4 || !strings.HasSuffix(files[0], "-00000001.xml.gz") {
+		t.Fatalf("got files %v, want 4 starting at serial 1", files)
+	}
+	result, err := h.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() {
+		t.Errorf("got gaps %v and errors %v, want none", result.Gaps, result.Errors)
+	}
+}
+
+func TestVerifyGaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-verify-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	// iv returns the interval of whole days from begin to end
+	iv := func(begin, end string) Interval {
+		return Interval{Begin: day(begin), End: day(end).AddDate(0, 0, 1).Add(-time.Nanosecond)}
+	}
+	var cases = []struct {
+		about string
+		files []string
+		// covered are the intervals of each run
+		covered [][]Interval
+		gaps    []Gap
+	}{
+		{
+			about:   "days missing within a month",
+			files:   []string{"2016-01-10-00000000.xml.gz", "2016-01-31-00000000.xml.gz"},
+			covered: [][]Interval{{iv("2016-01-01", "2016-01-10")}, {iv("2016-01-20", "2016-01-31")}},
+			gaps:    []Gap{{After: "2016-01-10", Before: "2016-01-20", Reason: "9 days not harvested"}},
+		},
+		{
+			about: "month without changes or accepted responses",
+			files: []string{"2016-01-31-00000000.xml.gz", "2016-03-31-00000000.xml.gz"},
+			covered: [][]Interval{
+				{iv("2016-01-01", "2016-01-31"), iv("2016-02-01", "2016-02-29")},
+				{iv("2016-03-01", "2016-03-31")},
+			},
+		},
+		{
+			about:   "overlapping runs",
+			files:   []string{"2016-01-31-00000000.xml.gz"},
+			covered: [][]Interval{{iv("2016-01-01", "2016-01-31")}, {iv("2016-01-15", "2016-01-20")}},
+		},
+		{
+			about: "no intervals in the run log",
+			files: []string{"2016-01-31-00000000.xml.gz", "2016-04-30-00000000.xml.gz"},
+			gaps:  []Gap{{After: "2016-01-31", Before: "2016-04-30", Reason: "2 months without files"}},
+		},
+	}
+	for _, c := range cases {
+		os.RemoveAll(dir)
+		h := &Harvest{BaseURL: "http://example.com/oai", Format: "oai_dc"}
+		if err := h.MkdirAll(); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range c.files {
+			src := filepath.Join(dir, "src.xml")
+			doc := `<OAI-PMH><ListRecords><record><header><identifier>` + name + `</identifier><datestamp>` +
+				name[:10] + `</datestamp></header></record></ListRecords></OAI-PMH>`
+			if err := ioutil.WriteFile(src, []byte(doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := MoveAndCompress(src, filepath.Join(h.Dir(), name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, covered := range c.covered {
+			h.progress.covered = covered
+			if err := h.writeManifest(RunHarvest, time.Now(), nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		result, err := h.Verify()
+		if err != nil {
+			t.Fatalf("%s: %v", c.about, err)
+		}
+		if len(result.Errors) > 0 {
+			t.Errorf("%s: got errors %v", c.about, result.Errors)
+		}
+		if fmt.Sprint(result.Gaps) != fmt.Sprint(c.gaps) {
+			t.Errorf("%s: got gaps %v, want %v", c.about, result.Gaps, c.gaps)
+		}
+	}
+}
+
+func TestVerifyRejectedMonths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-verify-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	from := time.Now().UTC().AddDate(0, -3, 0)
+	last := time.Now().UTC().AddDate(0, 0, -1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("verb") == "Identify" {
+			fmt.Fprint(w, `<OAI-PMH><Identify><granularity>YYYY-MM-DD</granularity></Identify></OAI-PMH>`)
+			return
+		}
+		month := q.Get("from")[:7]
+		// records of the months in between have no metadata
+		metadata := ""
+		if month == from.Format("2006-01") || month == last.Format("2006-01") {
+			metadata = "<metadata><x/></metadata>"
+		}
+		fmt.Fprintf(w, `<OAI-PMH><responseDate>2016-01-01T00:00:00Z</responseDate><ListRecords><record><header>`+
+			`<identifier>%s</identifier><datestamp>%s</datestamp></header>%s</record></ListRecords></OAI-PMH>`,
+			month, q.Get("from"), metadata)
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "x",
+		From:              from.Format("2006-01-02"),
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		Validate:          ValidateQuarantine,
+	}
+	if err := h.RunContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.Files()); n != 2 {
+		t.Fatalf("got %d files, want 2", n)
+	}
+	// the months in between were harvested, there is no gap
+	result, err := h.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK() {
+		t.Errorf("got gaps %v and errors %v, want none", result.Gaps, result.Errors)
+	}
+}
@@ -0,0 +1,361 @@
+package metha
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// VersionsDir is the name of the version store in a harvest directory.
+	VersionsDir = "versions"
+	// VersionChainFilename is the name of the version chain in VersionsDir.
+	// It is a tab separated file with one line per record version and is
+	// only ever appended to, except on RebuildVersions.
+	VersionChainFilename = "chain.tsv"
+)
+
+// ErrNoVersion is returned, if there is no version of a record at a time.
+var ErrNoVersion = errors.New("no version")
+
+// RecordVersion is a version of a record in the version store. Hash is the
+// SHA-256 of the metadata, which is stored once per hash; deleted records
+// have no metadata and an empty hash.
+type RecordVersion struct {
+	Identifier string
+	DateStamp  string
+	Hash       string
+	Sets       []string
+	Deleted    bool
+}
+
+// String formats a version as a line of the chain, without newline.
+func (v RecordVersion) String() string {
+	var deleted = "0"
+	if v.Deleted {
+		deleted = "1"
+	}
+	return strings.Join([]string{v.Identifier, v.DateStamp, v.Hash,
+		strings.Join(v.Sets, " "), deleted}, "\t")
+}
+
+// sameContent returns true, if two versions differ in datestamp only.
+func (v RecordVersion) sameContent(o RecordVersion) bool {
+	return v.Hash == o.Hash && v.Deleted == o.Deleted &&
+		strings.Join(v.Sets, " ") == strings.Join(o.Sets, " ")
+}
+
+// parseRecordVersion parses a line of the chain.
+func parseRecordVersion(line string) (RecordVersion, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 5 {
+		return RecordVersion{}, fmt.Errorf("invalid version chain line: %s", line)
+	}
+	return RecordVersion{
+		Identifier: fields[0],
+		DateStamp:  fields[1],
+		Hash:       fields[2],
+		Sets:       strings.Fields(fields[3]),
+		Deleted:    fields[4] == "1",
+	}, nil
+}
+
+// metadataHash returns the address of the metadata of a record.
+func metadataHash(rec Record) string {
+	if rec.Header.Status == "deleted" || len(rec.Metadata.Body) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(rec.Metadata.Body)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseDateStamp parses a datestamp in day or second granularity.
+func parseDateStamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02T15:04:05Z", s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// versionsDir returns the directory of the version store.
+func (h *Harvest) versionsDir() string {
+	return filepath.Join(h.Dir(), VersionsDir)
+}
+
+// versionChainPath returns the path of the version chain.
+func (h *Harvest) versionChainPath() string {
+	return filepath.Join(h.versionsDir(), VersionChainFilename)
+}
+
+// hasVersionStore returns true, if the store is enabled or already exists.
+func (h *Harvest) hasVersionStore() bool {
+	if h.VersionStore {
+		return true
+	}
+	_, err := os.Stat(h.versionChainPath())
+	return err == nil
+}
+
+// objectPath returns the path of the metadata with a hash, sharded by the
+// first two characters of the hash. Objects have no extension, so they are
+// never taken for cached files.
+func (h *Harvest) objectPath(hash string) string {
+	return filepath.Join(h.versionsDir(), hash[:2], hash)
+}
+
+// writeObject stores metadata under its hash, unless it is already there.
+func (h *Harvest) writeObject(hash string, body []byte) error {
+	dst := h.objectPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + newTempSuffix("-tmp-")
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// readVersionChain calls fn for each line of the chain, in file order.
+func (h *Harvest) readVersionChain(fn func(RecordVersion)) error {
+	f, err := os.Open(h.versionChainPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewScanner(f)
+	br.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for br.Scan() {
+		v, err := parseRecordVersion(br.Text())
+		if err != nil {
+			return err
+		}
+		fn(v)
+	}
+	return br.Err()
+}
+
+// fileVersions returns the versions of the records of a cached file and
+// stores their metadata.
+func (h *Harvest) fileVersions(filename string) ([]RecordVersion, error) {
+	var versions []RecordVersion
+	err := eachRecordPosition(filename, func(_ int, rec Record) error {
+		v := RecordVersion{
+			Identifier: strings.TrimSpace(rec.Header.Identifier),
+			DateStamp:  strings.TrimSpace(rec.Header.DateStamp),
+			Hash:       metadataHash(rec),
+			Sets:       rec.Header.SetSpec,
+			Deleted:    rec.Header.Status == "deleted",
+		}
+		if v.Hash != "" {
+			if err := h.writeObject(v.Hash, rec.Metadata.Body); err != nil {
+				return err
+			}
+		}
+		versions = append(versions, v)
+		return nil
+	})
+	return versions, err
+}
+
+// appendVersions adds the records of files to the version store. Versions,
+// that differ from the latest known version in datestamp only, are skipped.
+func (h *Harvest) appendVersions(files []string) error {
+	if h.versionsLatest == nil {
+		h.versionsLatest = make(map[string]RecordVersion)
+		err := h.readVersionChain(func(v RecordVersion) {
+			h.versionsLatest[v.Identifier] = v
+		})
+		if err != nil {
+			h.versionsLatest = nil
+			return err
+		}
+	}
+	if err := os.MkdirAll(h.versionsDir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.versionChainPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, filename := range files {
+		versions, err := h.fileVersions(filename)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		for _, v := range versions {
+			if latest, ok := h.versionsLatest[v.Identifier]; ok && latest.sameContent(v) {
+				continue
+			}
+			if _, err := fmt.Fprintln(bw, v); err != nil {
+				f.Close()
+				return err
+			}
+			h.versionsLatest[v.Identifier] = v
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// updateVersions adds finalized files to the version store, if there is
+// one. Unlike the header index, the store is kept on failure, since it may
+// hold versions, that are no longer cached; RebuildVersions fills gaps.
+func (h *Harvest) updateVersions(files []string) {
+	if len(files) == 0 || !h.hasVersionStore() {
+		return
+	}
+	if err := h.appendVersions(files); err != nil {
+		log.Printf("version store update failed, run metha-versions -rebuild: %s", err)
+		h.versionsLatest = nil
+	}
+}
+
+// RebuildVersions adds the versions of all cached files to the version
+// store, e.g. for an existing cache. Versions already in the store are kept,
+// even if they are no longer cached, e.g. after compaction.
+func (h *Harvest) RebuildVersions() error {
+	var versions []RecordVersion
+	if err := h.readVersionChain(func(v RecordVersion) {
+		versions = append(versions, v)
+	}); err != nil {
+		return err
+	}
+	for _, filename := range h.cacheFiles() {
+		vs, err := h.fileVersions(filename)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, vs...)
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Identifier != versions[j].Identifier {
+			return versions[i].Identifier < versions[j].Identifier
+		}
+		return versions[i].DateStamp < versions[j].DateStamp
+	})
+	if err := os.MkdirAll(h.versionsDir(), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(h.versionsDir(), VersionChainFilename+"-tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	bw := bufio.NewWriter(tmp)
+	for i, v := range versions {
+		if i > 0 && versions[i-1].Identifier == v.Identifier && versions[i-1].sameContent(v) {
+			continue
+		}
+		if _, err := fmt.Fprintln(bw, v); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	h.versionsLatest = nil
+	return os.Rename(tmp.Name(), h.versionChainPath())
+}
+
+// RecordVersions returns the versions of a record in the version store,
+// oldest first.
+func (h *Harvest) RecordVersions(identifier string) ([]RecordVersion, error) {
+	if !h.hasVersionStore() {
+		return nil, fmt.Errorf("no version store in %s", h.Dir())
+	}
+	var versions []RecordVersion
+	err := h.readVersionChain(func(v RecordVersion) {
+		if v.Identifier == identifier {
+			versions = append(versions, v)
+		}
+	})
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].DateStamp < versions[j].DateStamp
+	})
+	return versions, err
+}
+
+// RecordAt returns a record as it looked at a time, that is the version with
+// the latest datestamp not after t. Returns ErrNoVersion, if the record did
+// not exist yet.
+func (h *Harvest) RecordAt(identifier string, t time.Time) (*Record, error) {
+	versions, err := h.RecordVersions(identifier)
+	if err != nil {
+		return nil, err
+	}
+	var found *RecordVersion
+	for i, v := range versions {
+		ts, err := parseDateStamp(v.DateStamp)
+		if err != nil {
+			log.Printf("skipping version of %s with invalid datestamp: %s", identifier, v.DateStamp)
+			continue
+		}
+		if ts.After(t) {
+			break
+		}
+		found = &versions[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w of %s at %s", ErrNoVersion, identifier, t.Format(time.RFC3339))
+	}
+	return h.versionRecord(*found)
+}
+
+// versionRecord returns the record of a version, with the stored metadata.
+func (h *Harvest) versionRecord(v RecordVersion) (*Record, error) {
+	rec := &Record{Header: Header{Identifier: v.Identifier, DateStamp: v.DateStamp, SetSpec: v.Sets}}
+	if v.Deleted {
+		rec.Header.Status = "deleted"
+	}
+	if v.Hash == "" {
+		return rec, nil
+	}
+	b, err := ioutil.ReadFile(h.objectPath(v.Hash))
+	if err != nil {
+		return nil, err
+	}
+	rec.Metadata.Body = b
+	return rec, nil
+}
+
+// RecordOn returns a record as it looked at the end of a day, given in one of
+// the layouts of NormalizeDate, e.g. 2016-01-31.
+func (h *Harvest) RecordOn(identifier, date string) (*Record, error) {
+	s, err := NormalizeDate(date)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidDate, date, err)
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, err
+	}
+	return h.RecordAt(identifier, t.AddDate(0, 0, 1).Add(-time.Second))
+}
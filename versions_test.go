@@ -0,0 +1,104 @@
+package metha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVersionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metha-versions-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BaseDir = dir
+
+	record := `<record><header%s><identifier>%s</identifier><datestamp>%s</datestamp></header><metadata><dc>%s</dc></metadata></record>`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("from") {
+		case "2016-01-01":
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+record+`</ListRecords></OAI-PMH>`,
+				"", "1", "2016-01-10", "old", "", "2", "2016-01-20", "two")
+		case "2016-02-01":
+			// 2 changes in datestamp only
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+record+`</ListRecords></OAI-PMH>`,
+				"", "1", "2016-02-05", "new", "", "2", "2016-02-06", "two")
+		default:
+			fmt.Fprintf(w, `<OAI-PMH><ListRecords>`+record+`</ListRecords></OAI-PMH>`,
+				` status="deleted"`, "1", "2016-03-05", "")
+		}
+	}))
+	defer srv.Close()
+
+	h := &Harvest{
+		BaseURL:           srv.URL,
+		Format:            "oai_dc",
+		MaxRequests:       10,
+		MaxEmptyResponses: 10,
+		VersionStore:      true,
+		Identify:          &Identify{Granularity: "YYYY-MM-DD"},
+		Started:           time.Now(),
+	}
+	if err := h.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	iv := Interval{
+		Begin: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2016, 3, 31, 23, 59, 59, 0, time.UTC),
+	}
+	if err := h.runIntervals(context.Background(), iv); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(stage string) {
+		versions, err := h.RecordVersions("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 3 || !versions[2].Deleted || versions[0].Hash == versions[1].Hash {
+			t.Errorf("%s: got versions %+v", stage, versions)
+		}
+		if versions, _ := h.RecordVersions("2"); len(versions) != 1 {
+			t.Errorf("%s: got %d versions of 2, want 1", stage, len(versions))
+		}
+		var cases = []struct {
+			date string
+			body string
+			err  error
+		}{
+			{"2016-01-09", "", ErrNoVersion},
+			{"2016-01-10", "<dc>old</dc>", nil},
+			{"2016-02-04", "<dc>old</dc>", nil},
+			{"2016-02-05", "<dc>new</dc>", nil},
+			{"2016-03-10", "", nil},
+			{"not a date", "", ErrInvalidDate},
+		}
+		for _, c := range cases {
+			rec, err := h.RecordOn("1", c.date)
+			if !errors.Is(err, c.err) {
+				t.Errorf("%s: %s: got %v, want %v", stage, c.date, err, c.err)
+				continue
+			}
+			if err == nil && string(rec.Metadata.Body) != c.body {
+				t.Errorf("%s: %s: got %s, want %s", stage, c.date, rec.Metadata.Body, c.body)
+			}
+		}
+	}
+	check("harvest")
+
+	// versions survive compaction and a rebuild does not add duplicates
+	if _, err := h.Compact(CompactAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.RebuildVersions(); err != nil {
+		t.Fatal(err)
+	}
+	check("rebuild")
+}